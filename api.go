@@ -0,0 +1,768 @@
+package main
+
+// versioned json api under /api/v1, the dashboard is built on it so scripts and notebooks get the same numbers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	models "github.com/Ryang20718/robinhood-client/models"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/go-gota/gota/dataframe"
+	"github.com/shopspring/decimal"
+	"net/http"
+	"os"
+	"rh_metrics/m/src/rhwrapper"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// dimensions realized gains and income can be grouped by
+var (
+	realizedGroups = []string{"year", "month", "ticker", "tag", "term"}
+	incomeGroups   = []string{"year", "month", "ticker", "type"}
+)
+
+// amounts summed per value of the group by dimensions
+type groupedAmount struct {
+	Keys   []string
+	Amount decimal.Decimal
+}
+
+/*
+Sum the Amount column of a profit or income dataframe per value of the groupBy dimensions. Rows dated outside
+from..to (2006-01-02, inclusive, either may be empty) are left out
+*/
+func groupAmounts(df *dataframe.DataFrame, groupBy []string, from string, to string) []groupedAmount {
+	if df.Nrow() == 0 {
+		return []groupedAmount{}
+	}
+	dates := df.Col("Date").Records()
+	amounts := df.Col("Amount").Records()
+	columns := make([][]string, len(groupBy))
+	for i, dimension := range groupBy {
+		switch dimension {
+		case "year":
+			columns[i] = df.Col("Year").Records()
+		case "month":
+			columns[i] = make([]string, len(dates))
+			for row, date := range dates {
+				if len(date) >= len("2006-01") {
+					columns[i][row] = date[:len("2006-01")]
+				}
+			}
+		case "term":
+			columns[i] = df.Col("Lcap").Records()
+			for row, lcap := range columns[i] {
+				longTerm, _ := strconv.ParseBool(lcap)
+				columns[i][row] = rhwrapper.TermOf(longTerm)
+			}
+		default:
+			// ticker, tag and type are columns of their own
+			columns[i] = df.Col(strings.ToUpper(dimension[:1]) + dimension[1:]).Records()
+		}
+	}
+
+	sums := make(map[string]*groupedAmount)
+	for row, date := range dates {
+		day := date
+		if len(day) > len("2006-01-02") {
+			day = day[:len("2006-01-02")]
+		}
+		if (from != "" && day < from) || (to != "" && day > to) {
+			continue
+		}
+		keys := make([]string, len(groupBy))
+		for i := range groupBy {
+			keys[i] = columns[i][row]
+		}
+		key := strings.Join(keys, "\x00")
+		if sums[key] == nil {
+			sums[key] = &groupedAmount{Keys: keys}
+		}
+		amount, _ := decimal.NewFromString(amounts[row])
+		sums[key].Amount = sums[key].Amount.Add(amount)
+	}
+	grouped := []groupedAmount{}
+	for _, sum := range sums {
+		sum.Amount = sum.Amount.Round(2)
+		grouped = append(grouped, *sum)
+	}
+	sort.Slice(grouped, func(i, j int) bool {
+		for k := range groupBy {
+			if grouped[i].Keys[k] != grouped[j].Keys[k] {
+				return grouped[i].Keys[k] < grouped[j].Keys[k]
+			}
+		}
+		return false
+	})
+	return grouped
+}
+
+/*
+?group_by=year,tag, each dimension has to be one of allowed. year when empty
+*/
+func parseGroupBy(groupBy string, allowed []string) ([]string, error) {
+	if groupBy == "" {
+		return []string{"year"}, nil
+	}
+	dimensions := []string{}
+	for _, dimension := range strings.Split(groupBy, ",") {
+		dimension = strings.ToLower(strings.TrimSpace(dimension))
+		known := false
+		for _, name := range allowed {
+			known = known || dimension == name
+		}
+		if !known {
+			return nil, fmt.Errorf("can't group by %s, use %s", dimension, strings.Join(allowed, ", "))
+		}
+		dimensions = append(dimensions, dimension)
+	}
+	return dimensions, nil
+}
+
+/*
+?from=2023-01-01&to=2023-12-31, inclusive and both optional
+*/
+func parseDateRange(c *gin.Context) (string, string, error) {
+	from, to := c.Query("from"), c.Query("to")
+	for _, date := range []string{from, to} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			return "", "", fmt.Errorf("invalid date %s, use 2006-01-02", date)
+		}
+	}
+	return from, to, nil
+}
+
+// exact decimal as a json number
+func jsonNumber(d decimal.Decimal) json.Number {
+	return json.Number(d.String())
+}
+
+// market columns are null for lots without a quote
+func jsonPrice(priced bool, d decimal.Decimal) *json.Number {
+	if !priced {
+		return nil
+	}
+	number := jsonNumber(d)
+	return &number
+}
+
+/*
+Dataframe rows as json objects, Ticker becomes ticker
+*/
+func dfRows(df *dataframe.DataFrame) []map[string]interface{} {
+	rows := []map[string]interface{}{}
+	if df.Nrow() == 0 {
+		return rows
+	}
+	for _, record := range df.Maps() {
+		row := make(map[string]interface{})
+		for column, value := range record {
+			name := []rune(column)
+			name[0] = unicode.ToLower(name[0])
+			row[string(name)] = value
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// trades fetched for one session
+type trades struct {
+	source    rhwrapper.TransactionSource
+	stockMap  map[string][]models.Transaction
+	optionMap map[string][]models.OptionTransaction
+	income    []rhwrapper.IncomeEvent
+	transfers []rhwrapper.Transfer
+	fetchedAt time.Time
+}
+
+type cachedTrades struct {
+	sync.Mutex
+	trades
+	usedAt time.Time // guarded by tradeCache.mu
+}
+
+// tradeCache keeps each session's trades for ttl, the dashboard asks for a dozen views at once and they share a fetch
+type tradeCache struct {
+	mu     sync.Mutex
+	byHood map[*rhwrapper.Hood]*cachedTrades
+	ttl    time.Duration
+}
+
+func newTradeCache(ttl time.Duration) *tradeCache {
+	return &tradeCache{byHood: make(map[*rhwrapper.Hood]*cachedTrades), ttl: ttl}
+}
+
+func (t *tradeCache) get(ctx context.Context, hood *rhwrapper.Hood) (trades, error) {
+	t.mu.Lock()
+	entry, ok := t.byHood[hood]
+	if !ok {
+		entry = &cachedTrades{}
+		t.byHood[hood] = entry
+	}
+	entry.usedAt = time.Now()
+	for other, cached := range t.byHood {
+		// nobody asked for these in a while, their session is likely gone
+		if time.Since(cached.usedAt) > 10*t.ttl {
+			delete(t.byHood, other)
+		}
+	}
+	t.mu.Unlock()
+
+	entry.Lock()
+	defer entry.Unlock()
+	if time.Since(entry.fetchedAt) > t.ttl {
+		fetched, err := fetchTrades(ctx, tradeSource(hood))
+		if err != nil {
+			return trades{}, err
+		}
+		entry.trades = fetched
+	}
+	return entry.trades, nil
+}
+
+// last fetch of hood's trades, zero when there's none cached
+func (t *tradeCache) fetchedAt(hood *rhwrapper.Hood) time.Time {
+	t.mu.Lock()
+	entry, ok := t.byHood[hood]
+	t.mu.Unlock()
+	if !ok {
+		return time.Time{}
+	}
+	entry.Lock()
+	defer entry.Unlock()
+	return entry.fetchedAt
+}
+
+type api struct {
+	trades  *tradeCache
+	quotes  rhwrapper.QuoteProvider
+	prices  rhwrapper.DailyPriceSource // nil values positions at their last trade price
+	history *historyBook
+}
+
+func apiError(c *gin.Context, status int, err error) {
+	c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+}
+
+/*
+Middleware for the api, like isAuthenticated but answers 401 instead of redirecting to the login page
+*/
+func apiAuthenticated(clients *clientRegistry, hood *rhwrapper.Hood) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if offline() {
+			c.Set("hood", hood)
+			c.Next()
+			return
+		}
+		sessionID, _ := sessions.Default(c).Get("sid").(string)
+		client := clients.get(sessionID)
+		if client == nil {
+			apiError(c, http.StatusUnauthorized, fmt.Errorf("log in at / first"))
+			return
+		}
+		c.Set("hood", client)
+		c.Next()
+	}
+}
+
+/*
+Earnings report of the session for the request's cost basis params, answers the error itself when ok is false
+*/
+func (a *api) report(c *gin.Context) (report *rhwrapper.EarningsReport, costBasis rhwrapper.CostBasisSelector, ok bool) {
+	costBasis, err := costBasisFromRequest(c)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return nil, costBasis, false
+	}
+	trades, err := a.trades.get(context.Background(), sessionHood(c))
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return nil, costBasis, false
+	}
+	report, err = rhwrapper.CalculateRealizedEarnings(trades.source, trades.stockMap, trades.optionMap, trades.income, costBasis)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return nil, costBasis, false
+	}
+	return report, costBasis, true
+}
+
+// grouped amounts as json rows, {"year": "2023", "tag": "sell", "amount": 50}
+func groupedRows(groupBy []string, grouped []groupedAmount) []map[string]interface{} {
+	rows := []map[string]interface{}{}
+	for _, group := range grouped {
+		row := map[string]interface{}{"amount": jsonNumber(group.Amount)}
+		for i, dimension := range groupBy {
+			row[dimension] = group.Keys[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// GET /api/v1/realized?group_by=year,tag&from=2023-01-01&to=2023-12-31, net of disallowed wash sale losses
+func (a *api) realized(c *gin.Context) {
+	groupBy, err := parseGroupBy(c.Query("group_by"), realizedGroups)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+	report, costBasis, ok := a.report(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"costBasis": costBasis.String(),
+		"groupBy":   groupBy,
+		"from":      from,
+		"to":        to,
+		"rows":      groupedRows(groupBy, groupAmounts(report.Profit, groupBy, from, to)),
+	})
+}
+
+// GET /api/v1/income?group_by=year,type&from=2023-01-01&to=2023-12-31
+func (a *api) income(c *gin.Context) {
+	groupBy, err := parseGroupBy(c.Query("group_by"), incomeGroups)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+	report, _, ok := a.report(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"groupBy": groupBy,
+		"from":    from,
+		"to":      to,
+		"rows":    groupedRows(groupBy, groupAmounts(report.Income, groupBy, from, to)),
+	})
+}
+
+// GET /api/v1/cost-basis-comparison, realized gains per year for every cost basis method over the same trades
+func (a *api) costBasisComparison(c *gin.Context) {
+	costBasis, err := costBasisFromRequest(c)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+	trades, err := a.trades.get(context.Background(), sessionHood(c))
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+	rows := []map[string]interface{}{}
+	for _, method := range rhwrapper.CostBasisMethods {
+		comparisonSelector := rhwrapper.CostBasisSelector{
+			Default:      method,
+			SpecificLots: costBasis.SpecificLots,
+		}
+		report, err := rhwrapper.CalculateRealizedEarnings(trades.source, trades.stockMap, trades.optionMap, trades.income, comparisonSelector)
+		if err != nil {
+			apiError(c, http.StatusInternalServerError, err)
+			return
+		}
+		for _, group := range groupAmounts(report.Profit, []string{"year"}, "", "") {
+			rows = append(rows, map[string]interface{}{"method": method, "year": group.Keys[0], "amount": jsonNumber(group.Amount)})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"methods": rhwrapper.CostBasisMethods, "rows": rows})
+}
+
+// GET /api/v1/open-lots, market columns are null for lots without a quote
+func (a *api) openLots(c *gin.Context) {
+	report, costBasis, ok := a.report(c)
+	if !ok {
+		return
+	}
+	values, err := valueOpenLots(report, a.quotes, sessionHood(c))
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+	lots := []gin.H{}
+	for _, value := range values {
+		lots = append(lots, gin.H{
+			"ticker":      value.Ticker,
+			"acquired":    value.Acquired,
+			"qty":         jsonNumber(value.Qty),
+			"unitCost":    jsonNumber(value.UnitCost),
+			"basis":       jsonNumber(value.Basis),
+			"price":       jsonPrice(value.Priced, value.Price),
+			"marketValue": jsonPrice(value.Priced, value.MarketValue),
+			"gain":        jsonPrice(value.Priced, value.Gain),
+			"return":      jsonPrice(value.Priced, value.Return),
+			"term":        rhwrapper.TermOf(value.LongTerm),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"costBasis": costBasis.String(), "lots": lots})
+}
+
+// GET /api/v1/holdings, open lots summed per ticker
+func (a *api) holdings(c *gin.Context) {
+	report, costBasis, ok := a.report(c)
+	if !ok {
+		return
+	}
+	values, err := valueOpenLots(report, a.quotes, sessionHood(c))
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+	holdings := []gin.H{}
+	for _, holding := range rhwrapper.SummarizeHoldings(values) {
+		holdings = append(holdings, gin.H{
+			"ticker":        holding.Ticker,
+			"qty":           jsonNumber(holding.Qty),
+			"basis":         jsonNumber(holding.Basis),
+			"marketValue":   jsonPrice(holding.Priced, holding.MarketValue),
+			"gain":          jsonPrice(holding.Priced, holding.Gain),
+			"return":        jsonPrice(holding.Priced, holding.Return),
+			"shortTermGain": jsonPrice(holding.Priced, holding.ShortTermGain),
+			"longTermGain":  jsonPrice(holding.Priced, holding.LongTermGain),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"costBasis": costBasis.String(), "holdings": holdings})
+}
+
+// GET /api/v1/warnings, trades left out of realized earnings
+func (a *api) warnings(c *gin.Context) {
+	report, costBasis, ok := a.report(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"costBasis": costBasis.String(), "warnings": dfRows(report.Warnings)})
+}
+
+// GET /api/v1/wash-sales
+func (a *api) washSales(c *gin.Context) {
+	report, costBasis, ok := a.report(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"costBasis": costBasis.String(), "washSales": dfRows(report.WashSales)})
+}
+
+// period returns as json rows, xirr is null when the cash flows have no rate
+func returnRows(periods []rhwrapper.PeriodReturn) []gin.H {
+	rows := []gin.H{}
+	for _, period := range periods {
+		rows = append(rows, gin.H{
+			"period":     period.Period,
+			"ticker":     period.Ticker,
+			"start":      period.Start,
+			"end":        period.End,
+			"startValue": jsonNumber(period.StartValue),
+			"endValue":   jsonNumber(period.EndValue),
+			"netFlow":    jsonNumber(period.NetFlow),
+			"gain":       jsonNumber(period.Gain),
+			"twr":        jsonNumber(period.TWR),
+			"xirr":       jsonPrice(period.HasXIRR, period.XIRR),
+		})
+	}
+	return rows
+}
+
+// GET /api/v1/returns?ticker=AAPL, time weighted and money weighted returns per year of the account and each ticker
+func (a *api) returns(c *gin.Context) {
+	trades, err := a.trades.get(context.Background(), sessionHood(c))
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+	asOf := time.Now().Format("2006-01-02")
+	report, err := rhwrapper.CalculateReturns(trades.source, trades.stockMap, trades.optionMap, trades.income, trades.transfers, a.prices, asOf)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+	tickers := report.Tickers
+	if ticker := strings.ToUpper(c.Query("ticker")); ticker != "" {
+		tickers = []rhwrapper.PeriodReturn{}
+		for _, period := range report.Tickers {
+			if period.Ticker == ticker {
+				tickers = append(tickers, period)
+			}
+		}
+	}
+	daily := []gin.H{}
+	for _, day := range report.Daily {
+		daily = append(daily, gin.H{"date": day.Date, "value": jsonNumber(day.Value), "flow": jsonNumber(day.Flow)})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"asOf":     asOf,
+		"account":  returnRows(report.Account),
+		"tickers":  returnRows(tickers),
+		"unpriced": report.Unpriced,
+		"daily":    daily,
+	})
+}
+
+// a year, or "all", of the account next to the benchmark
+type benchmarkRow struct {
+	Period    string
+	Realized  decimal.Decimal
+	Account   *rhwrapper.PeriodReturn // nil when the account had nothing in the period
+	Benchmark *rhwrapper.PeriodReturn
+}
+
+/*
+Periods of comparison lined up with realized earnings grouped by year
+*/
+func benchmarkRows(comparison *rhwrapper.BenchmarkComparison, realized []groupedAmount) []benchmarkRow {
+	realizedByYear := make(map[string]decimal.Decimal)
+	totalRealized := decimal.Zero
+	for _, group := range realized {
+		realizedByYear[group.Keys[0]] = group.Amount
+		totalRealized = totalRealized.Add(group.Amount)
+	}
+	realizedByYear["all"] = totalRealized
+	benchmarkByPeriod := make(map[string]*rhwrapper.PeriodReturn)
+	for i := range comparison.Benchmark {
+		benchmarkByPeriod[comparison.Benchmark[i].Period] = &comparison.Benchmark[i]
+	}
+	rows := []benchmarkRow{}
+	for i := range comparison.Account {
+		period := comparison.Account[i].Period
+		rows = append(rows, benchmarkRow{
+			Period:    period,
+			Realized:  realizedByYear[period],
+			Account:   &comparison.Account[i],
+			Benchmark: benchmarkByPeriod[period],
+		})
+	}
+	return rows
+}
+
+// BENCHMARK or SPY unless the request asks for ?symbol
+func benchmarkSymbol(symbol string) string {
+	if symbol == "" {
+		symbol = os.Getenv("BENCHMARK")
+	}
+	if symbol == "" {
+		symbol = "SPY"
+	}
+	return strings.ToUpper(symbol)
+}
+
+// GET /api/v1/benchmark?symbol=QQQ, gains and returns per year next to the benchmark bought with the same cash flows
+func (a *api) benchmark(c *gin.Context) {
+	report, costBasis, ok := a.report(c)
+	if !ok {
+		return
+	}
+	trades, err := a.trades.get(context.Background(), sessionHood(c))
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+	returns, err := rhwrapper.CalculateReturns(trades.source, trades.stockMap, trades.optionMap, trades.income, trades.transfers, a.prices, time.Now().Format("2006-01-02"))
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+	comparison, err := rhwrapper.CompareToBenchmark(returns, benchmarkSymbol(c.Query("symbol")), a.prices)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+	rows := []gin.H{}
+	for _, row := range benchmarkRows(comparison, groupAmounts(report.Profit, []string{"year"}, "", "")) {
+		benchmark := row.Benchmark
+		if benchmark == nil {
+			benchmark = &rhwrapper.PeriodReturn{}
+		}
+		rows = append(rows, gin.H{
+			"period":        row.Period,
+			"realized":      jsonNumber(row.Realized),
+			"gain":          jsonNumber(row.Account.Gain),
+			"benchmarkGain": jsonPrice(row.Benchmark != nil, benchmark.Gain),
+			"twr":           jsonNumber(row.Account.TWR),
+			"benchmarkTwr":  jsonPrice(row.Benchmark != nil, benchmark.TWR),
+			"xirr":          jsonPrice(row.Account.HasXIRR, row.Account.XIRR),
+			"benchmarkXirr": jsonPrice(benchmark.HasXIRR, benchmark.XIRR),
+		})
+	}
+	daily := []gin.H{}
+	for i, day := range returns.Daily {
+		daily = append(daily, gin.H{"date": day.Date, "value": jsonNumber(day.Value), "benchmarkValue": jsonNumber(comparison.Daily[i].Value)})
+	}
+	c.JSON(http.StatusOK, gin.H{"symbol": comparison.Symbol, "costBasis": costBasis.String(), "years": rows, "daily": daily})
+}
+
+// empty dates are null
+func jsonDate(date string) *string {
+	if date == "" {
+		return nil
+	}
+	return &date
+}
+
+// GET /api/v1/history?date=2024-01-02, the account every day with its max drawdown and monthly returns. The holdings
+// of date are listed when it's given
+func (a *api) portfolioHistory(c *gin.Context) {
+	date := c.Query("date")
+	if date != "" {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			apiError(c, http.StatusBadRequest, fmt.Errorf("date %s isn't 2006-01-02", date))
+			return
+		}
+	}
+	history, err := a.history.get(context.Background(), sessionHood(c))
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+	daily := rhwrapper.DailyValues(history.snapshots)
+	days := []gin.H{}
+	var holdings []gin.H
+	for _, snapshot := range history.snapshots {
+		days = append(days, gin.H{
+			"date":  snapshot.Date,
+			"cash":  jsonNumber(snapshot.Cash),
+			"value": jsonNumber(snapshot.Value),
+			"flow":  jsonNumber(snapshot.Flow),
+		})
+		if snapshot.Date != date {
+			continue
+		}
+		holdings = []gin.H{}
+		for _, holding := range snapshot.Holdings {
+			holdings = append(holdings, gin.H{
+				"ticker": holding.Ticker,
+				"qty":    jsonNumber(holding.Qty),
+				"price":  jsonNumber(holding.Price),
+				"value":  jsonNumber(holding.Value),
+				"priced": holding.Priced,
+			})
+		}
+	}
+	months := []gin.H{}
+	for _, month := range rhwrapper.MonthlyReturns(daily) {
+		months = append(months, gin.H{
+			"month":   month.Period,
+			"twr":     jsonNumber(month.TWR),
+			"gain":    jsonNumber(month.Gain),
+			"netFlow": jsonNumber(month.NetFlow),
+		})
+	}
+	drawdown := rhwrapper.MaxDrawdown(daily)
+	c.JSON(http.StatusOK, gin.H{
+		"builtAt": history.builtAt,
+		"daily":   days,
+		"maxDrawdown": gin.H{
+			"percent":   jsonNumber(drawdown.Percent),
+			"peak":      jsonDate(drawdown.Peak),
+			"trough":    jsonDate(drawdown.Trough),
+			"recovered": jsonDate(drawdown.Recovered),
+		},
+		"monthly":  months,
+		"holdings": holdings,
+	})
+}
+
+// campaigns running during year, every campaign when year is empty
+func campaignsDuring(campaigns []rhwrapper.WheelCampaign, year string) []rhwrapper.WheelCampaign {
+	if year == "" {
+		return campaigns
+	}
+	during := []rhwrapper.WheelCampaign{}
+	for _, campaign := range campaigns {
+		if campaign.Start <= year+"-12-31" && (campaign.End == "" || campaign.End >= year+"-01-01") {
+			during = append(during, campaign)
+		}
+	}
+	return during
+}
+
+// GET /api/v1/wheel?ticker=KO&year=2023&status=open, sold puts, their assignments and covered calls as campaigns
+func (a *api) wheel(c *gin.Context) {
+	trades, err := a.trades.get(context.Background(), sessionHood(c))
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+	campaigns, err := rhwrapper.CalculateWheelCampaigns(trades.source, trades.stockMap, trades.optionMap, time.Now().Format("2006-01-02"))
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, err)
+		return
+	}
+	ticker, status := strings.ToUpper(c.Query("ticker")), strings.ToLower(c.Query("status"))
+	rows := []gin.H{}
+	premium, profit := decimal.Zero, decimal.Zero
+	for _, campaign := range campaignsDuring(campaigns, c.Query("year")) {
+		if (ticker != "" && campaign.Ticker != ticker) || (status != "" && campaign.Status != status) {
+			continue
+		}
+		premium, profit = premium.Add(campaign.Premium), profit.Add(campaign.Profit)
+		rows = append(rows, gin.H{
+			"ticker":     campaign.Ticker,
+			"start":      campaign.Start,
+			"end":        jsonDate(campaign.End),
+			"status":     campaign.Status,
+			"puts":       campaign.Puts,
+			"calls":      campaign.Calls,
+			"premium":    jsonNumber(campaign.Premium),
+			"shares":     jsonNumber(campaign.Shares),
+			"stockCost":  jsonNumber(campaign.StockCost),
+			"netBasis":   jsonPrice(campaign.Shares.IsPositive(), campaign.NetBasis),
+			"proceeds":   jsonNumber(campaign.Proceeds),
+			"held":       jsonNumber(campaign.Held),
+			"collateral": jsonNumber(campaign.Collateral),
+			"profit":     jsonNumber(campaign.Profit),
+			"daysHeld":   campaign.DaysHeld,
+			"annualized": jsonNumber(campaign.Annualized),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"campaigns": rows, "premium": jsonNumber(premium), "profit": jsonNumber(profit)})
+}
+
+// GET /api/v1/sync, when the session's trades were fetched and what its store holds
+func (a *api) syncStatus(c *gin.Context) {
+	hood := sessionHood(c)
+	status := gin.H{"offline": offline(), "fetchedAt": nil, "store": nil}
+	if fetchedAt := a.trades.fetchedAt(hood); !fetchedAt.IsZero() {
+		status["fetchedAt"] = fetchedAt
+	}
+	if hood.Store != nil {
+		status["store"] = hood.Store.Status()
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func registerAPI(router *gin.Engine, clients *clientRegistry, hood *rhwrapper.Hood, quotes rhwrapper.QuoteProvider, prices rhwrapper.DailyPriceSource) {
+	trades := newTradeCache(time.Minute)
+	a := &api{trades: trades, quotes: quotes, prices: prices, history: newHistoryBook(trades, prices)}
+	go a.history.run(clients, hood)
+	v1 := router.Group("/api/v1", apiAuthenticated(clients, hood))
+	v1.GET("/realized", a.realized)
+	v1.GET("/income", a.income)
+	v1.GET("/cost-basis-comparison", a.costBasisComparison)
+	v1.GET("/open-lots", a.openLots)
+	v1.GET("/holdings", a.holdings)
+	v1.GET("/warnings", a.warnings)
+	v1.GET("/wash-sales", a.washSales)
+	v1.GET("/returns", a.returns)
+	v1.GET("/benchmark", a.benchmark)
+	v1.GET("/history", a.portfolioHistory)
+	v1.GET("/wheel", a.wheel)
+	v1.GET("/sync", a.syncStatus)
+}
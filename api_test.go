@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// router with just the api, offline off the activity csv of offlineTestEnv unless online
+func testAPIRouter(t *testing.T, online bool) *gin.Engine {
+	t.Helper()
+	offlineTestEnv(t)
+	if online {
+		t.Setenv("ACTIVITY_CSV", "")
+	}
+	hood, err := newHood()
+	if err != nil {
+		t.Fatalf("failing to configure hood. ERR: %v", err)
+	}
+	quotes, err := quoteProvider()
+	if err != nil {
+		t.Fatalf("failing to configure quotes. ERR: %v", err)
+	}
+	prices, err := dailyPriceSource()
+	if err != nil {
+		t.Fatalf("failing to configure prices. ERR: %v", err)
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(sessions.Sessions("stateStorage", cookie.NewStore([]byte("secret"))))
+	registerAPI(router, newClientRegistry(), hood, quotes, prices)
+	return router
+}
+
+// GET path, the status and the json body
+func getAPI(t *testing.T, router *gin.Engine, path string) (int, map[string]interface{}) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("GET %s answered %q. ERR: %v", path, recorder.Body.String(), err)
+	}
+	return recorder.Code, body
+}
+
+// rows of a json list as "key=value key=value" lines, keys in the order given
+func formatRows(rows interface{}, keys ...string) string {
+	lines := []string{}
+	list, _ := rows.([]interface{})
+	for _, row := range list {
+		fields := []string{}
+		for _, key := range keys {
+			fields = append(fields, fmt.Sprintf("%s=%v", key, row.(map[string]interface{})[key]))
+		}
+		lines = append(lines, strings.Join(fields, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestAPI(t *testing.T) {
+	router := testAPIRouter(t, false)
+	tests := []struct {
+		path string
+		list string
+		keys []string
+		want string
+	}{
+		{
+			path: "/api/v1/realized",
+			list: "rows",
+			keys: []string{"year", "amount"},
+			want: "year=2022 amount=-50\nyear=2023 amount=200",
+		},
+		{
+			path: "/api/v1/realized?group_by=Ticker,term&from=2023-01-01&to=2023-12-31",
+			list: "rows",
+			keys: []string{"ticker", "term", "amount"},
+			want: "ticker=AAPL term=long term amount=200",
+		},
+		{
+			path: "/api/v1/realized?group_by=month&to=2022-12-31",
+			list: "rows",
+			keys: []string{"month", "amount"},
+			want: "month=2022-06 amount=-50",
+		},
+		{
+			path: "/api/v1/income?group_by=ticker,type",
+			list: "rows",
+			keys: []string{"ticker", "type", "amount"},
+			want: "ticker=AAPL type=qualified dividend amount=2.3",
+		},
+		{
+			path: "/api/v1/cost-basis-comparison",
+			list: "rows",
+			keys: []string{"method", "year", "amount"},
+			want: "method=FIFO year=2022 amount=-50\nmethod=FIFO year=2023 amount=200\n" +
+				"method=LIFO year=2022 amount=-50\nmethod=LIFO year=2023 amount=200\n" +
+				"method=HIFO year=2022 amount=-50\nmethod=HIFO year=2023 amount=200\n" +
+				"method=SpecificLot year=2022 amount=-50\nmethod=SpecificLot year=2023 amount=200",
+		},
+		{
+			path: "/api/v1/open-lots",
+			list: "lots",
+			keys: []string{"ticker", "acquired", "qty", "basis", "price", "gain"},
+			want: "ticker=AAPL acquired=2022-01-03 qty=6 basis=600 price=190 gain=540",
+		},
+		{
+			path: "/api/v1/holdings",
+			list: "holdings",
+			keys: []string{"ticker", "qty", "marketValue"},
+			want: "ticker=AAPL qty=6 marketValue=1140",
+		},
+		{
+			path: "/api/v1/returns?ticker=msft",
+			list: "tickers",
+			keys: []string{"ticker", "period", "start", "gain", "twr"},
+			want: "ticker=MSFT period=2022 start=2022-01-10 gain=-50 twr=-16.67\nticker=MSFT period=all start=2022-01-10 gain=-50 twr=-16.67",
+		},
+		{
+			path: "/api/v1/history?date=2023-03-01",
+			list: "holdings",
+			keys: []string{"ticker", "qty", "price", "priced"},
+			want: "ticker=AAPL qty=6 price=150 priced=false",
+		},
+		{
+			path: "/api/v1/wheel?ticker=aapl",
+			list: "campaigns",
+			want: "",
+		},
+		{
+			path: "/api/v1/wash-sales",
+			list: "washSales",
+			want: "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			status, body := getAPI(t, router, test.path)
+			if status != http.StatusOK {
+				t.Fatalf("status %d: %v", status, body)
+			}
+			if got := formatRows(body[test.list], test.keys...); got != test.want {
+				t.Errorf("%s\n%s\nwant\n%s", test.list, got, test.want)
+			}
+		})
+	}
+
+	status, body := getAPI(t, router, "/api/v1/sync")
+	if status != http.StatusOK || body["offline"] != true || body["fetchedAt"] == nil || body["store"] != nil {
+		t.Errorf("sync status %d %v, want the fetch time of the trades above", status, body)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	router := testAPIRouter(t, false)
+	tests := map[string]int{
+		"/api/v1/realized?group_by=year,week":   http.StatusBadRequest,
+		"/api/v1/income?group_by=tag":           http.StatusBadRequest,
+		"/api/v1/realized?from=2023-13-01":      http.StatusBadRequest,
+		"/api/v1/income?to=yesterday":           http.StatusBadRequest,
+		"/api/v1/holdings?method=AVG":           http.StatusBadRequest,
+		"/api/v1/benchmark?symbol=qqq":          http.StatusBadRequest,
+		"/api/v1/history?date=soon":             http.StatusBadRequest,
+		"/api/v1/cost-basis-comparison?method=": http.StatusOK,
+	}
+	for path, want := range tests {
+		status, body := getAPI(t, router, path)
+		if status != want {
+			t.Errorf("GET %s status %d, want %d: %v", path, status, want, body)
+		}
+		if want != http.StatusOK && body["error"] == nil {
+			t.Errorf("GET %s answered %v, want an error", path, body)
+		}
+	}
+
+	// online the api answers 401 without a session instead of redirecting to the login page
+	online := testAPIRouter(t, true)
+	status, body := getAPI(t, online, "/api/v1/realized")
+	if status != http.StatusUnauthorized || body["error"] == nil {
+		t.Errorf("status %d %v without a session, want 401 and an error", status, body)
+	}
+}
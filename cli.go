@@ -0,0 +1,451 @@
+package main
+
+// headless subcommands, the same reports as the web pages for scripts and cron
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/go-gota/gota/dataframe"
+	"github.com/go-gota/gota/series"
+	"io"
+	"os"
+	"rh_metrics/m/src/rhwrapper"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const cliUsage = `usage: rh_metrics <command> [flags]
+
+commands:
+  sync      fetch trades and income and save them to a snapshot file
+  realized  realized gains, --year 2023 --by year|ticker|tag|term
+  lots      open lots at market prices, --holdings for one row per ticker
+  returns   time weighted and money weighted returns, --by account|ticker and --prices daily closes
+  benchmark gains next to --symbol SPY|QQQ bought with the same deposits and withdrawals
+  wheel     put, assignment and covered call campaigns, those running during --year
+  export    form8949|realized|lots|income|washsales|warnings for --year
+
+trades come from --snapshot, --activity-csv (ACTIVITY_CSV) or robinhood with --username, --password
+and --mfa (RH_USERNAME, RH_PASSWORD, RH_MFA). Output is --format table, csv or json.
+Without a command the web server starts.
+`
+
+// flags every subcommand takes
+type cliOptions struct {
+	format      string
+	snapshot    string
+	activityCSV string
+	username    string
+	password    string
+	mfa         string
+	method      string
+	overrides   string
+	year        string
+}
+
+func cliFlags(name string, stderr io.Writer) (*flag.FlagSet, *cliOptions) {
+	options := &cliOptions{}
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&options.format, "format", "table", "output format, table, csv or json")
+	flags.StringVar(&options.snapshot, "snapshot", "", "read trades from a snapshot written by sync or a STORE_DIR store file")
+	flags.StringVar(&options.activityCSV, "activity-csv", os.Getenv("ACTIVITY_CSV"), "read trades from a robinhood account activity csv")
+	flags.StringVar(&options.username, "username", os.Getenv("RH_USERNAME"), "robinhood username")
+	flags.StringVar(&options.password, "password", os.Getenv("RH_PASSWORD"), "robinhood password")
+	flags.StringVar(&options.mfa, "mfa", os.Getenv("RH_MFA"), "robinhood mfa code")
+	flags.StringVar(&options.method, "method", "", "cost basis method, FIFO by default")
+	flags.StringVar(&options.overrides, "overrides", "", "per ticker cost basis methods e.g. TSLA:HIFO,AMZN:LIFO")
+	flags.StringVar(&options.year, "year", "", "tax year, every year when empty")
+	return flags, options
+}
+
+/*
+Run a subcommand, returns the exit code
+*/
+func runCLI(args []string, stdout io.Writer, stderr io.Writer) int {
+	command := args[0]
+	if command == "help" || command == "-h" || command == "--help" {
+		fmt.Fprint(stdout, cliUsage)
+		return 0
+	}
+	flags, options := cliFlags(command, stderr)
+	by := flags.String("by", "", "realized: group by year, month, ticker, tag or term. returns: account or ticker")
+	holdings := flags.Bool("holdings", false, "lots: one row per ticker")
+	out := flags.String("out", "rh_snapshot.json", "sync: snapshot file to write")
+	prices := flags.String("prices", os.Getenv("PRICES_FILE"), "returns, benchmark: csv of daily closes symbol,date,close, QUOTES_FILE when empty")
+	symbol := flags.String("symbol", "", "benchmark: index to compare with, BENCHMARK or SPY when empty")
+	args = args[1:]
+	target := ""
+	if command == "export" && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		// export form8949 --year 2023, flag stops parsing at the first argument otherwise
+		target, args = args[0], args[1:]
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if target == "" && flags.NArg() > 0 {
+		target = flags.Arg(0)
+	}
+
+	var err error
+	switch command {
+	case "sync":
+		err = cliSync(options, *out, stdout)
+	case "realized":
+		if *by == "" {
+			*by = "year"
+		}
+		err = cliRealized(options, *by, stdout)
+	case "returns":
+		if *by == "" {
+			*by = "account"
+		}
+		err = cliReturns(options, *by, *prices, stdout, stderr)
+	case "benchmark":
+		err = cliBenchmark(options, *symbol, *prices, stdout, stderr)
+	case "wheel":
+		err = cliWheel(options, stdout)
+	case "lots":
+		err = cliLots(options, *holdings, stdout)
+	case "export":
+		if target == "" {
+			err = fmt.Errorf("export needs one of form8949, realized, lots, income, washsales or warnings")
+			break
+		}
+		err = cliExport(options, target, stdout)
+	default:
+		fmt.Fprint(stderr, cliUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", command, err)
+		return 1
+	}
+	return 0
+}
+
+/*
+Trade source for the options, logging in to robinhood unless a snapshot or activity csv is given
+*/
+func cliSource(options *cliOptions) (rhwrapper.TransactionSource, *rhwrapper.Hood, error) {
+	hood, err := newHood()
+	if err != nil {
+		return nil, nil, err
+	}
+	if options.snapshot != "" {
+		return &rhwrapper.SnapshotSource{
+			Path:             options.snapshot,
+			Splits:           hood.Splits,
+			Symbols:          hood.Symbols,
+			CorporateActions: hood.CorporateActions,
+		}, hood, nil
+	}
+	if options.activityCSV != "" {
+		return &rhwrapper.ActivityCSVSource{
+			Path:             options.activityCSV,
+			Splits:           hood.Splits,
+			Symbols:          hood.Symbols,
+			CorporateActions: hood.CorporateActions,
+		}, hood, nil
+	}
+	cli, err := hood.Auth(options.username, options.password, options.mfa)
+	if err != nil {
+		return nil, nil, err
+	}
+	hood.Cli = cli
+	if err := useStore(hood, options.username); err != nil {
+		return nil, nil, err
+	}
+	return hood, hood, nil
+}
+
+func cliReport(options *cliOptions) (*rhwrapper.EarningsReport, *rhwrapper.Hood, error) {
+	costBasis, err := costBasisSelector(options.method, options.overrides)
+	if err != nil {
+		return nil, nil, err
+	}
+	source, hood, err := cliSource(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	trades, err := fetchTrades(context.Background(), source)
+	if err != nil {
+		return nil, nil, err
+	}
+	report, err := rhwrapper.CalculateRealizedEarnings(source, trades.stockMap, trades.optionMap, trades.income, costBasis)
+	if err != nil {
+		return nil, nil, err
+	}
+	return report, hood, nil
+}
+
+func cliSync(options *cliOptions, out string, stdout io.Writer) error {
+	source, _, err := cliSource(options)
+	if err != nil {
+		return err
+	}
+	snapshot, err := rhwrapper.TakeSnapshot(context.Background(), source)
+	if err != nil {
+		return err
+	}
+	if err := snapshot.Save(out); err != nil {
+		return err
+	}
+	trades := 0
+	for _, stocks := range snapshot.Stocks {
+		trades += len(stocks)
+	}
+	for _, options := range snapshot.Options {
+		trades += len(options)
+	}
+	fmt.Fprintf(stdout, "saved %d trades and %d income events to %s\n", trades, len(snapshot.Income), out)
+	return nil
+}
+
+func cliRealized(options *cliOptions, by string, stdout io.Writer) error {
+	groupBy := []string{"year"}
+	if by != "year" {
+		groupBy = append(groupBy, by)
+	}
+	if _, err := parseGroupBy(strings.Join(groupBy, ","), realizedGroups); err != nil {
+		return err
+	}
+	report, _, err := cliReport(options)
+	if err != nil {
+		return err
+	}
+	from, to := "", ""
+	if options.year != "" {
+		from, to = options.year+"-01-01", options.year+"-12-31"
+	}
+	return writeDf(stdout, groupedDf(groupBy, groupAmounts(report.Profit, groupBy, from, to)), options.format)
+}
+
+/*
+Grouped amounts as a dataframe with a column per dimension and Amount
+*/
+func groupedDf(groupBy []string, grouped []groupedAmount) *dataframe.DataFrame {
+	columns := []series.Series{}
+	for i, dimension := range groupBy {
+		keys := []string{}
+		for _, group := range grouped {
+			keys = append(keys, group.Keys[i])
+		}
+		columns = append(columns, series.New(keys, series.String, strings.ToUpper(dimension[:1])+dimension[1:]))
+	}
+	amounts := series.New([]float64{}, series.Float, "Amount")
+	for _, group := range grouped {
+		amounts.Append(group.Amount.InexactFloat64())
+	}
+	df := dataframe.New(append(columns, amounts)...)
+	return &df
+}
+
+func cliLots(options *cliOptions, holdings bool, stdout io.Writer) error {
+	report, hood, err := cliReport(options)
+	if err != nil {
+		return err
+	}
+	quotes, err := quoteProvider()
+	if err != nil {
+		return err
+	}
+	if quotes == nil && hood.Cli != nil {
+		quotes = &rhwrapper.RobinhoodQuoteProvider{Cli: hood.Cli}
+	}
+	openLots, err := rhwrapper.ValueOpenLots(report.OpenLots, quotes, time.Now().Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+	if holdings {
+		return writeDf(stdout, rhwrapper.ConvertHoldingDf(rhwrapper.SummarizeHoldings(openLots)), options.format)
+	}
+	return writeDf(stdout, rhwrapper.ConvertLotValueDf(openLots), options.format)
+}
+
+/*
+Returns of the trades from source, and the realized earnings report of the same trades for the cost basis options
+*/
+func cliReturnsReport(options *cliOptions, pricesFile string, stderr io.Writer) (*rhwrapper.ReturnsReport, *rhwrapper.EarningsReport, rhwrapper.DailyPriceSource, error) {
+	costBasis, err := costBasisSelector(options.method, options.overrides)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if pricesFile == "" {
+		pricesFile = os.Getenv("QUOTES_FILE")
+	}
+	var prices rhwrapper.DailyPriceSource
+	if pricesFile != "" {
+		file, err := rhwrapper.NewFileQuoteProvider(pricesFile)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		prices = file
+	}
+	source, _, err := cliSource(options)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	trades, err := fetchTrades(context.Background(), source)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	returns, err := rhwrapper.CalculateReturns(source, trades.stockMap, trades.optionMap, trades.income, trades.transfers, prices, time.Now().Format("2006-01-02"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(returns.Unpriced) > 0 {
+		fmt.Fprintf(stderr, "no closing prices for %s, valued at their last trade price\n", strings.Join(returns.Unpriced, ", "))
+	}
+	report, err := rhwrapper.CalculateRealizedEarnings(source, trades.stockMap, trades.optionMap, trades.income, costBasis)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return returns, report, prices, nil
+}
+
+func cliReturns(options *cliOptions, by string, pricesFile string, stdout io.Writer, stderr io.Writer) error {
+	if by != "account" && by != "ticker" {
+		return fmt.Errorf("can't group returns by %s, use account or ticker", by)
+	}
+	returns, _, _, err := cliReturnsReport(options, pricesFile, stderr)
+	if err != nil {
+		return err
+	}
+	periods := returns.Account
+	if by == "ticker" {
+		periods = returns.Tickers
+	}
+	if options.year != "" {
+		filtered := []rhwrapper.PeriodReturn{}
+		for _, period := range periods {
+			if period.Period == options.year {
+				filtered = append(filtered, period)
+			}
+		}
+		periods = filtered
+	}
+	return writeDf(stdout, rhwrapper.ConvertReturnDf(periods), options.format)
+}
+
+func cliBenchmark(options *cliOptions, symbol string, pricesFile string, stdout io.Writer, stderr io.Writer) error {
+	returns, report, prices, err := cliReturnsReport(options, pricesFile, stderr)
+	if err != nil {
+		return err
+	}
+	comparison, err := rhwrapper.CompareToBenchmark(returns, benchmarkSymbol(symbol), prices)
+	if err != nil {
+		return err
+	}
+	periods := series.New([]string{}, series.String, "Period")
+	realized := series.New([]float64{}, series.Float, "Realized")
+	gains := series.New([]float64{}, series.Float, "Gain")
+	benchmarkGains := series.New([]float64{}, series.Float, comparison.Symbol+"Gain")
+	twrs := series.New([]float64{}, series.Float, "TWR")
+	benchmarkTWRs := series.New([]float64{}, series.Float, comparison.Symbol+"TWR")
+	for _, row := range benchmarkRows(comparison, groupAmounts(report.Profit, []string{"year"}, "", "")) {
+		if options.year != "" && row.Period != options.year {
+			continue
+		}
+		benchmark := row.Benchmark
+		if benchmark == nil {
+			benchmark = &rhwrapper.PeriodReturn{}
+		}
+		periods.Append(row.Period)
+		realized.Append(row.Realized.InexactFloat64())
+		gains.Append(row.Account.Gain.InexactFloat64())
+		benchmarkGains.Append(benchmark.Gain.InexactFloat64())
+		twrs.Append(row.Account.TWR.InexactFloat64())
+		benchmarkTWRs.Append(benchmark.TWR.InexactFloat64())
+	}
+	df := dataframe.New(periods, realized, gains, benchmarkGains, twrs, benchmarkTWRs)
+	return writeDf(stdout, &df, options.format)
+}
+
+func cliWheel(options *cliOptions, stdout io.Writer) error {
+	source, _, err := cliSource(options)
+	if err != nil {
+		return err
+	}
+	trades, err := fetchTrades(context.Background(), source)
+	if err != nil {
+		return err
+	}
+	campaigns, err := rhwrapper.CalculateWheelCampaigns(source, trades.stockMap, trades.optionMap, time.Now().Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+	return writeDf(stdout, rhwrapper.ConvertWheelCampaignDf(campaignsDuring(campaigns, options.year)), options.format)
+}
+
+func cliExport(options *cliOptions, what string, stdout io.Writer) error {
+	if what == "lots" {
+		return cliLots(options, false, stdout)
+	}
+	report, _, err := cliReport(options)
+	if err != nil {
+		return err
+	}
+	switch what {
+	case "form8949":
+		year := options.year
+		if year == "" {
+			year = strconv.Itoa(time.Now().Year() - 1)
+		}
+		form := rhwrapper.NewForm8949(report.RealizedLots, year)
+		if options.format == "json" {
+			return writeJSON(stdout, form)
+		}
+		return form.WriteCSV(stdout)
+	case "realized":
+		return writeDf(stdout, filterYear(report.Profit, options.year), options.format)
+	case "income":
+		return writeDf(stdout, filterYear(report.Income, options.year), options.format)
+	case "washsales":
+		return writeDf(stdout, report.WashSales, options.format)
+	case "warnings":
+		return writeDf(stdout, report.Warnings, options.format)
+	}
+	return fmt.Errorf("can't export %s, use form8949, realized, lots, income, washsales or warnings", what)
+}
+
+func filterYear(df *dataframe.DataFrame, year string) *dataframe.DataFrame {
+	if year == "" {
+		return df
+	}
+	filtered := df.Filter(dataframe.F{
+		Colname:    "Year",
+		Comparator: series.Eq,
+		Comparando: year,
+	})
+	return &filtered
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+/*
+Write a dataframe as an aligned table, csv or json records
+*/
+func writeDf(w io.Writer, df *dataframe.DataFrame, format string) error {
+	switch format {
+	case "csv":
+		return df.WriteCSV(w)
+	case "json":
+		return df.WriteJSON(w)
+	case "table":
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, record := range df.Records() {
+			fmt.Fprintln(table, strings.Join(record, "\t"))
+		}
+		return table.Flush()
+	}
+	return fmt.Errorf("unknown format %s, use table, csv or json", format)
+}
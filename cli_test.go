@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testActivityCSV = `Activity Date,Process Date,Settle Date,Instrument,Description,Trans Code,Quantity,Price,Amount
+3/1/2023,3/1/2023,3/3/2023,AAPL,Apple CUSIP: 037833100,Sell,4,$150.00,$600.00
+2/16/2023,2/16/2023,2/16/2023,AAPL,Cash Div: R/D 2023-02-13 P/D 2023-02-16 - 10 shares at 0.23,CDIV,,,$2.30
+6/1/2022,6/1/2022,6/3/2022,MSFT,Microsoft CUSIP: 594918104,Sell,1,$250.00,$250.00
+1/10/2022,1/10/2022,1/12/2022,MSFT,Microsoft CUSIP: 594918104,Buy,1,$300.00,($300.00)
+1/3/2022,1/3/2022,1/5/2022,AAPL,Apple CUSIP: 037833100,Buy,10,$100.00,"($1,000.00)"
+`
+
+// points the environment at an activity csv, no splits, symbol changes or quotes but the files written to the dir returned
+func offlineTestEnv(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"activity.csv": testActivityCSV,
+		"splits.csv":   "symbol,date,numerator,denominator\n",
+		"quotes.csv":   "symbol,date,close\nAAPL,2024-05-31,190\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("ACTIVITY_CSV", filepath.Join(dir, "activity.csv"))
+	t.Setenv("SPLITS_FILE", filepath.Join(dir, "splits.csv"))
+	t.Setenv("SPLITS_OFFLINE", "true")
+	t.Setenv("QUOTES_FILE", filepath.Join(dir, "quotes.csv"))
+	return dir
+}
+
+// runs the cli offline, see offlineTestEnv
+func runTestCLI(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	dir := offlineTestEnv(t)
+	for i, arg := range args {
+		args[i] = strings.ReplaceAll(arg, "$DIR", dir)
+	}
+	var stdout, stderr strings.Builder
+	code := runCLI(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunCLI(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "realized by year",
+			args: []string{"realized", "--format", "csv"},
+			want: "Year,Amount\n2022,-50.000000\n2023,200.000000\n",
+		},
+		{
+			name: "realized by ticker for a year",
+			args: []string{"realized", "--by", "ticker", "--year", "2023", "--format", "csv"},
+			want: "Year,Ticker,Amount\n2023,AAPL,200.000000\n",
+		},
+		{
+			name: "holdings",
+			args: []string{"lots", "--holdings", "--format", "csv"},
+			want: "AAPL,6.000000,600.000000,1140.000000,540.000000",
+		},
+		{
+			name: "form 8949",
+			args: []string{"export", "form8949", "--year", "2022"},
+			want: "Short Term,1 sh MSFT,01/10/2022,06/01/2022,250.00,300.00,,,-50.00\n",
+		},
+		{
+			name: "sync",
+			args: []string{"sync", "--out", "$DIR/snapshot.json"},
+			want: "saved 4 trades and 1 income events to ",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, stdout, stderr := runTestCLI(t, test.args...)
+			if code != 0 {
+				t.Fatalf("exit code %d: %s", code, stderr)
+			}
+			if !strings.Contains(stdout, test.want) {
+				t.Errorf("output\n%s\nwant it to contain\n%s", stdout, test.want)
+			}
+		})
+	}
+}
+
+func TestRunCLISnapshot(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "snapshot.json")
+	if code, _, stderr := runTestCLI(t, "sync", "--out", snapshot); code != 0 {
+		t.Fatalf("sync exit code %d: %s", code, stderr)
+	}
+	// the activity csv is gone, the snapshot has the trades
+	code, stdout, stderr := runTestCLI(t, "realized", "--snapshot", snapshot, "--activity-csv", filepath.Join(dir, "missing.csv"), "--format", "csv")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if want := "Year,Amount\n2022,-50.000000\n2023,200.000000\n"; stdout != want {
+		t.Errorf("realized off the snapshot\n%s\nwant\n%s", stdout, want)
+	}
+}
+
+func TestRunCLIErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{name: "unknown command", args: []string{"trade"}, code: 2},
+		{name: "unknown flag", args: []string{"realized", "--verbose"}, code: 2},
+		{name: "unknown grouping", args: []string{"realized", "--by", "week"}, code: 1},
+		{name: "unknown format", args: []string{"realized", "--format", "xml"}, code: 1},
+		{name: "unknown method", args: []string{"realized", "--method", "AVG"}, code: 1},
+		{name: "export without a target", args: []string{"export"}, code: 1},
+		{name: "unknown export", args: []string{"export", "balances"}, code: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, stdout, stderr := runTestCLI(t, test.args...)
+			if code != test.code {
+				t.Errorf("exit code %d, want %d: %s%s", code, test.code, stdout, stderr)
+			}
+			if stderr == "" {
+				t.Errorf("nothing on stderr")
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+// logged in robinhood clients by server side session id, so every visitor sees their own account
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"rh_metrics/m/src/rhwrapper"
+	"sync"
+	"time"
+)
+
+type clientSession struct {
+	hood     *rhwrapper.Hood
+	username string
+	lastSeen time.Time
+}
+
+type clientRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*clientSession
+	ttl      time.Duration // idle time before a session expires
+}
+
+/*
+Sessions expire after SESSION_TTL (30m by default) without a request
+*/
+func newClientRegistry() *clientRegistry {
+	ttl := 30 * time.Minute
+	if interval, err := time.ParseDuration(os.Getenv("SESSION_TTL")); err == nil && interval > 0 {
+		ttl = interval
+	}
+	return &clientRegistry{sessions: make(map[string]*clientSession), ttl: ttl}
+}
+
+func newSessionID() (string, error) {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+/*
+Register a logged in client, returns the session id to keep in the cookie
+*/
+func (r *clientRegistry) login(hood *rhwrapper.Hood, username string) (string, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[id] = &clientSession{hood: hood, username: username, lastSeen: time.Now()}
+	return id, nil
+}
+
+/*
+Client of session id, nil when it's unknown or expired. Every lookup keeps the session alive
+*/
+func (r *clientRegistry) get(id string) *rhwrapper.Hood {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil
+	}
+	if time.Since(session.lastSeen) > r.ttl {
+		delete(r.sessions, id)
+		return nil
+	}
+	session.lastSeen = time.Now()
+	return session.hood
+}
+
+/*
+Most recently used client of username, nil when they have no live session
+*/
+func (r *clientRegistry) forUser(username string) *rhwrapper.Hood {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var latest *clientSession
+	for _, session := range r.sessions {
+		if session.username != username || time.Since(session.lastSeen) > r.ttl {
+			continue
+		}
+		if latest == nil || session.lastSeen.After(latest.lastSeen) {
+			latest = session
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+	return latest.hood
+}
+
+/*
+Clients of every live session
+*/
+func (r *clientRegistry) hoods() []*rhwrapper.Hood {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	hoods := []*rhwrapper.Hood{}
+	for _, session := range r.sessions {
+		if time.Since(session.lastSeen) <= r.ttl {
+			hoods = append(hoods, session.hood)
+		}
+	}
+	return hoods
+}
+
+func (r *clientRegistry) logout(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, id)
+}
+
+/*
+Drop expired sessions every interval, sessions nobody comes back to would otherwise stay in memory
+*/
+func (r *clientRegistry) expire(interval time.Duration) {
+	for range time.Tick(interval) {
+		r.mu.Lock()
+		for id, session := range r.sessions {
+			if time.Since(session.lastSeen) > r.ttl {
+				delete(r.sessions, id)
+			}
+		}
+		r.mu.Unlock()
+	}
+}
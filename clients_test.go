@@ -0,0 +1,100 @@
+package main
+
+import (
+	"rh_metrics/m/src/rhwrapper"
+	"testing"
+	"time"
+)
+
+func TestClientRegistry(t *testing.T) {
+	clients := &clientRegistry{sessions: make(map[string]*clientSession), ttl: time.Minute}
+	alice, bob := &rhwrapper.Hood{}, &rhwrapper.Hood{}
+	aliceID, err := clients.login(alice, "alice@example.com")
+	if err != nil {
+		t.Fatalf("failing to log in. ERR: %v", err)
+	}
+	bobID, err := clients.login(bob, "bob@example.com")
+	if err != nil {
+		t.Fatalf("failing to log in. ERR: %v", err)
+	}
+	if aliceID == bobID || len(aliceID) != 64 {
+		t.Fatalf("session ids %q and %q", aliceID, bobID)
+	}
+
+	if got := clients.get(aliceID); got != alice {
+		t.Errorf("alice's session has client %p, want %p", got, alice)
+	}
+	if got := clients.get(bobID); got != bob {
+		t.Errorf("bob's session has client %p, want %p", got, bob)
+	}
+	if got := clients.get("forged"); got != nil {
+		t.Errorf("unknown session has client %p", got)
+	}
+	if got := clients.forUser("alice@example.com"); got != alice {
+		t.Errorf("alice's client %p, want %p", got, alice)
+	}
+	if got := clients.forUser("carol@example.com"); got != nil {
+		t.Errorf("carol never logged in, got client %p", got)
+	}
+
+	clients.logout(aliceID)
+	if got := clients.get(aliceID); got != nil {
+		t.Errorf("alice's session outlived logout")
+	}
+	if got := clients.forUser("alice@example.com"); got != nil {
+		t.Errorf("alice's client outlived logout")
+	}
+	if got := clients.get(bobID); got != bob {
+		t.Errorf("alice's logout logged bob out")
+	}
+}
+
+func TestClientRegistryExpiry(t *testing.T) {
+	clients := &clientRegistry{sessions: make(map[string]*clientSession), ttl: time.Minute}
+	laptop, phone := &rhwrapper.Hood{}, &rhwrapper.Hood{}
+	laptopID, _ := clients.login(laptop, "alice@example.com")
+	phoneID, _ := clients.login(phone, "alice@example.com")
+
+	// the laptop was used last, the phone idled past the ttl
+	clients.sessions[laptopID].lastSeen = time.Now().Add(-30 * time.Second)
+	clients.sessions[phoneID].lastSeen = time.Now().Add(-2 * time.Minute)
+	if got := clients.forUser("alice@example.com"); got != laptop {
+		t.Errorf("alice's client %p, want the laptop's %p", got, laptop)
+	}
+	if got := clients.hoods(); len(got) != 1 || got[0] != laptop {
+		t.Errorf("live clients %v, want only the laptop's %p", got, laptop)
+	}
+	if got := clients.get(phoneID); got != nil {
+		t.Errorf("expired session has client %p", got)
+	}
+	if _, ok := clients.sessions[phoneID]; ok {
+		t.Errorf("expired session kept")
+	}
+
+	// every request keeps the session alive
+	if got := clients.get(laptopID); got != laptop {
+		t.Fatalf("laptop session has client %p", got)
+	}
+	if idle := time.Since(clients.sessions[laptopID].lastSeen); idle > time.Second {
+		t.Errorf("laptop session idle for %v after a request", idle)
+	}
+	clients.sessions[laptopID].lastSeen = time.Now().Add(-2 * time.Minute)
+	if got := clients.forUser("alice@example.com"); got != nil {
+		t.Errorf("alice's client %p after every session expired", got)
+	}
+}
+
+func TestNewClientRegistryTTL(t *testing.T) {
+	tests := map[string]time.Duration{
+		"":     30 * time.Minute,
+		"5m":   5 * time.Minute,
+		"soon": 30 * time.Minute,
+		"-1h":  30 * time.Minute,
+	}
+	for sessionTTL, want := range tests {
+		t.Setenv("SESSION_TTL", sessionTTL)
+		if got := newClientRegistry().ttl; got != want {
+			t.Errorf("SESSION_TTL=%q ttl %v, want %v", sessionTTL, got, want)
+		}
+	}
+}
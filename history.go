@@ -0,0 +1,107 @@
+package main
+
+// daily job rebuilding each session's portfolio history, kept in the session's store so it outlives restarts
+
+import (
+	"context"
+	"log"
+	"os"
+	"rh_metrics/m/src/rhwrapper"
+	"sync"
+	"time"
+)
+
+type portfolioHistory struct {
+	snapshots []rhwrapper.PortfolioSnapshot
+	builtAt   time.Time
+}
+
+// historyBook holds the latest portfolio history of every session
+type historyBook struct {
+	mu     sync.Mutex
+	byHood map[*rhwrapper.Hood]*portfolioHistory
+	trades *tradeCache
+	prices rhwrapper.DailyPriceSource // nil values positions at their last trade price
+}
+
+func newHistoryBook(trades *tradeCache, prices rhwrapper.DailyPriceSource) *historyBook {
+	return &historyBook{byHood: make(map[*rhwrapper.Hood]*portfolioHistory), trades: trades, prices: prices}
+}
+
+/*
+Rebuild hood's history through today from its trades, saved to its store when it has one
+*/
+func (b *historyBook) rebuild(ctx context.Context, hood *rhwrapper.Hood) (*portfolioHistory, error) {
+	trades, err := b.trades.get(ctx, hood)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := rhwrapper.BuildPortfolioHistory(trades.source, trades.stockMap, trades.optionMap, trades.income, trades.transfers, b.prices, time.Now().Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	history := &portfolioHistory{snapshots: snapshots, builtAt: time.Now()}
+	if hood.Store != nil {
+		if err := hood.Store.SaveHistory(snapshots, history.builtAt); err != nil {
+			return nil, err
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.byHood[hood] = history
+	return history, nil
+}
+
+/*
+History of hood built today, from memory or its store. A session the job hasn't come around to yet builds it now
+*/
+func (b *historyBook) get(ctx context.Context, hood *rhwrapper.Hood) (*portfolioHistory, error) {
+	b.mu.Lock()
+	history := b.byHood[hood]
+	b.mu.Unlock()
+	if history == nil && hood.Store != nil {
+		if snapshots, builtAt := hood.Store.History(); !builtAt.IsZero() {
+			history = &portfolioHistory{snapshots: snapshots, builtAt: builtAt}
+		}
+	}
+	if history == nil || history.builtAt.Format("2006-01-02") != time.Now().Format("2006-01-02") {
+		return b.rebuild(ctx, hood)
+	}
+	return history, nil
+}
+
+/*
+Rebuild the history of every live session, or the offline account, now and then every HISTORY_REFRESH (24h by
+default). Histories of sessions that are gone are dropped from memory, their stores keep them
+*/
+func (b *historyBook) run(clients *clientRegistry, hood *rhwrapper.Hood) {
+	interval := 24 * time.Hour
+	if refresh, err := time.ParseDuration(os.Getenv("HISTORY_REFRESH")); err == nil && refresh > 0 {
+		interval = refresh
+	}
+	b.rebuildAll(clients, hood)
+	for range time.Tick(interval) {
+		b.rebuildAll(clients, hood)
+	}
+}
+
+func (b *historyBook) rebuildAll(clients *clientRegistry, hood *rhwrapper.Hood) {
+	hoods := []*rhwrapper.Hood{hood}
+	if !offline() {
+		hoods = clients.hoods()
+	}
+	live := make(map[*rhwrapper.Hood]bool)
+	for _, client := range hoods {
+		live[client] = true
+		if _, err := b.rebuild(context.Background(), client); err != nil {
+			log.Printf("failing to rebuild portfolio history %v", err)
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for client := range b.byHood {
+		if !live[client] {
+			delete(b.byHood, client)
+		}
+	}
+}
@@ -3,41 +3,401 @@ package main
 import (
 	// "bufio"
 	"context"
-	// "fmt"
+	"crypto/rand"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"log"
 	"net/http"
-	// "os"
+	"os"
+	"strconv"
+	"sync"
+	"time"
 	// "strings"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
-	"github.com/go-gota/gota/dataframe"
-	"github.com/go-gota/gota/series"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"rh_metrics/m/src/rhwrapper"
 )
 
-func isAuthenticated(c *gin.Context) {
-	session := sessions.Default(c)
-	// Check if "authenticated" is set to true in the session
-	isAuthenticated := session.Get("authenticated") == true
-	if !isAuthenticated {
-		// If the user is not authenticated, redirect them to the login page
-		c.Redirect(http.StatusSeeOther, "/metrics")
-		c.Abort() // Prevent the handler from running
+// trades come from the ACTIVITY_CSV export instead of logging in to robinhood
+func offline() bool {
+	return os.Getenv("ACTIVITY_CSV") != ""
+}
+
+/*
+Address the server listens on. Offline there's no login in front of the account, so only this machine can reach it
+unless LISTEN_ADDR says otherwise
+*/
+func listenAddr() string {
+	if addr := os.Getenv("LISTEN_ADDR"); addr != "" {
+		return addr
+	}
+	if offline() {
+		return "127.0.0.1:8080"
+	}
+	return ":8080"
+}
+
+/*
+Middleware for pages that need a logged in robinhood client, the client of the session is stored under "hood".
+Offline every visitor shares hood, the server only listens on localhost then (see listenAddr)
+*/
+func isAuthenticated(clients *clientRegistry, hood *rhwrapper.Hood) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if offline() {
+			c.Set("hood", hood)
+			c.Next()
+			return
+		}
+		session := sessions.Default(c)
+		sessionID, _ := session.Get("sid").(string)
+		client := clients.get(sessionID)
+		if client == nil {
+			// not logged in or the session expired, back to the login page
+			c.Redirect(http.StatusSeeOther, "/")
+			c.Abort() // Prevent the handler from running
+			return
+		}
+		c.Set("hood", client)
+		c.Next() // If the user is authenticated, proceed to the handler
+	}
+}
+
+// robinhood client of the request, set by isAuthenticated
+func sessionHood(c *gin.Context) *rhwrapper.Hood {
+	return c.MustGet("hood").(*rhwrapper.Hood)
+}
+
+/*
+Cookie signing key from SESSION_SECRET, random otherwise. Sessions don't outlive the server anyway
+*/
+func sessionSecret() ([]byte, error) {
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		return []byte(secret), nil
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+/*
+Scrapes carry "Authorization: Bearer $PROMETHEUS_TOKEN", there's no session to check. Without a token every scrape is
+refused unless PROMETHEUS_PUBLIC=true opens the endpoint to anyone who can reach the port
+*/
+func isScrapeAuthorized(c *gin.Context) {
+	token := os.Getenv("PROMETHEUS_TOKEN")
+	if token == "" && os.Getenv("PROMETHEUS_PUBLIC") == "true" {
+		c.Next()
+		return
+	}
+	if token == "" || c.GetHeader("Authorization") != "Bearer "+token {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	c.Next()
+}
+
+/*
+Build the cost basis selector for a request
+
+?method=LIFO&overrides=TSLA:HIFO,AMZN:FIFO, specific lots are read from the SPECIFIC_LOTS json file
+*/
+func costBasisFromRequest(c *gin.Context) (rhwrapper.CostBasisSelector, error) {
+	return costBasisSelector(c.Query("method"), c.Query("overrides"))
+}
+
+func costBasisSelector(method string, overrides string) (rhwrapper.CostBasisSelector, error) {
+	defaultMethod, err := rhwrapper.ParseCostBasisMethod(method)
+	if err != nil {
+		return rhwrapper.CostBasisSelector{}, err
+	}
+	perTicker, err := rhwrapper.ParseCostBasisOverrides(overrides)
+	if err != nil {
+		return rhwrapper.CostBasisSelector{}, err
+	}
+	costBasis := rhwrapper.CostBasisSelector{
+		Default:   defaultMethod,
+		PerTicker: perTicker,
+	}
+	if lotsFile := os.Getenv("SPECIFIC_LOTS"); lotsFile != "" {
+		selections, err := rhwrapper.LoadLotSelections(lotsFile)
+		if err != nil {
+			return rhwrapper.CostBasisSelector{}, err
+		}
+		costBasis.SpecificLots = selections
+	}
+	return costBasis, nil
+}
+
+// the ACTIVITY_CSV export when set, robinhood otherwise
+func tradeSource(rhClient *rhwrapper.Hood) rhwrapper.TransactionSource {
+	if offline() {
+		return &rhwrapper.ActivityCSVSource{
+			Path:             os.Getenv("ACTIVITY_CSV"),
+			Splits:           rhClient.Splits,
+			Symbols:          rhClient.Symbols,
+			CorporateActions: rhClient.CorporateActions,
+		}
+	}
+	return rhClient
+}
+
+/*
+Ticker renames start from rhwrapper.DefaultSymbolChanges, with the SYMBOL_HISTORY file added on top when set.
+Symbols missing from both are looked up at SYMBOL_LOOKUP_URL when set
+*/
+func symbolRegistry() (*rhwrapper.SymbolRegistry, error) {
+	registry := rhwrapper.NewSymbolRegistry(rhwrapper.DefaultSymbolChanges...)
+	if symbolHistoryFile := os.Getenv("SYMBOL_HISTORY"); symbolHistoryFile != "" {
+		if err := registry.LoadFile(symbolHistoryFile); err != nil {
+			return nil, err
+		}
+	}
+	if lookupURL := os.Getenv("SYMBOL_LOOKUP_URL"); lookupURL != "" {
+		registry.Lookup = &rhwrapper.HTTPSymbolLookup{URL: lookupURL}
+	}
+	return registry, nil
+}
+
+/*
+Splits come from yahoo, with the SPLITS_FILE file asked first when set. SPLITS_OFFLINE=true only uses the file
+*/
+func splitProvider() (rhwrapper.SplitProvider, error) {
+	yahoo := &rhwrapper.YahooSplitProvider{}
+	splitsFile := os.Getenv("SPLITS_FILE")
+	if splitsFile == "" {
+		return yahoo, nil
+	}
+	file, err := rhwrapper.NewFileSplitProvider(splitsFile)
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv("SPLITS_OFFLINE") == "true" {
+		file.Complete = true
+		return file, nil
+	}
+	return &rhwrapper.ChainedSplitProvider{Providers: []rhwrapper.SplitProvider{file, yahoo}}, nil
+}
+
+/*
+Prices for the open lots from the QUOTES_FILE csv of closing prices or the QUOTES_URL json endpoint when set.
+nil otherwise, robinhood quotes are used once logged in
+*/
+func quoteProvider() (rhwrapper.QuoteProvider, error) {
+	if quotesFile := os.Getenv("QUOTES_FILE"); quotesFile != "" {
+		return rhwrapper.NewFileQuoteProvider(quotesFile)
+	}
+	if quotesURL := os.Getenv("QUOTES_URL"); quotesURL != "" {
+		return &rhwrapper.HTTPQuoteProvider{URL: quotesURL}, nil
+	}
+	return nil, nil
+}
+
+/*
+Daily closes for returns from the PRICES_FILE csv of closing prices, QUOTES_FILE when it's unset. nil when neither is
+set, positions are valued at the last price they traded at then
+*/
+func dailyPriceSource() (rhwrapper.DailyPriceSource, error) {
+	pricesFile := os.Getenv("PRICES_FILE")
+	if pricesFile == "" {
+		pricesFile = os.Getenv("QUOTES_FILE")
+	}
+	if pricesFile == "" {
+		return nil, nil
+	}
+	return rhwrapper.NewFileQuoteProvider(pricesFile)
+}
+
+/*
+Price the open lots of report, robinhood quotes are used when quotes is nil and we're online. A quote outage shouldn't
+take the page down, the open lots are shown at cost instead
+*/
+func valueOpenLots(report *rhwrapper.EarningsReport, quotes rhwrapper.QuoteProvider, rhClient *rhwrapper.Hood) ([]rhwrapper.LotValue, error) {
+	if quotes == nil && !offline() {
+		quotes = &rhwrapper.RobinhoodQuoteProvider{Cli: rhClient.Cli}
+	}
+	asOf := time.Now().Format("2006-01-02")
+	openLots, err := rhwrapper.ValueOpenLots(report.OpenLots, quotes, asOf)
+	if err != nil {
+		log.Printf("failing to price open lots %v", err)
+		return rhwrapper.ValueOpenLots(report.OpenLots, nil, asOf)
+	}
+	return openLots, nil
+}
+
+// last refresh of the prometheus gauges and the cost basis they were worked out with
+var portfolioSync struct {
+	sync.Mutex
+	last      time.Time
+	costBasis string
+}
+
+/*
+Refresh the prometheus gauges when they're older than PROMETHEUS_REFRESH (15m by default) or were worked out with
+another cost basis than the scrape asks for, every scrape would otherwise fetch the whole trade history again
+*/
+func syncPortfolioMetrics(portfolioMetrics *rhwrapper.PortfolioMetrics, costBasis rhwrapper.CostBasisSelector, quotes rhwrapper.QuoteProvider, rhClient *rhwrapper.Hood) {
+	portfolioSync.Lock()
+	defer portfolioSync.Unlock()
+	refresh := 15 * time.Minute
+	if interval, err := time.ParseDuration(os.Getenv("PROMETHEUS_REFRESH")); err == nil {
+		refresh = interval
+	}
+	if time.Since(portfolioSync.last) < refresh && portfolioSync.costBasis == costBasis.String() {
+		return
+	}
+	if !offline() && (rhClient == nil || rhClient.Cli == nil) {
+		// PROMETHEUS_USER isn't logged in
+		portfolioMetrics.SyncFailed()
+		return
+	}
+	portfolioSync.last = time.Now()
+	portfolioSync.costBasis = costBasis.String()
+
+	source := tradeSource(rhClient)
+	trades, err := fetchTrades(context.Background(), source)
+	if err != nil {
+		log.Printf("failing to sync portfolio metrics %v", err)
+		portfolioMetrics.SyncFailed()
 		return
 	}
-	c.Next() // If the user is authenticated, proceed to the handler
+	report, err := rhwrapper.CalculateRealizedEarnings(source, trades.stockMap, trades.optionMap, trades.income, costBasis)
+	if err != nil {
+		log.Printf("failing to sync portfolio metrics %v", err)
+		portfolioMetrics.SyncFailed()
+		return
+	}
+	openLots, err := valueOpenLots(report, quotes, rhClient)
+	if err != nil {
+		log.Printf("failing to sync portfolio metrics %v", err)
+		portfolioMetrics.SyncFailed()
+		return
+	}
+	portfolioMetrics.Update(report, openLots, portfolioSync.last)
+}
+
+/*
+Mergers, spinoffs, cash in lieu and worthless securities from the CORPORATE_ACTIONS file when set
+*/
+func corporateActions() ([]rhwrapper.CorporateAction, error) {
+	actionsFile := os.Getenv("CORPORATE_ACTIONS")
+	if actionsFile == "" {
+		return []rhwrapper.CorporateAction{}, nil
+	}
+	return rhwrapper.LoadCorporateActions(actionsFile)
+}
+
+/*
+Fetch stock and option trades, income and transfers, with the OPENING_LOTS file merged in when set. A robinhood
+client with a store writes what it synced to the store once they're all in
+*/
+func fetchTrades(ctx context.Context, source rhwrapper.TransactionSource) (trades, error) {
+	stockMap, err := source.FetchRegularTrades(ctx)
+	if err != nil {
+		return trades{}, err
+	}
+	optionMap, err := source.FetchOptionTrades(ctx)
+	if err != nil {
+		return trades{}, err
+	}
+	income, err := source.FetchIncome(ctx)
+	if err != nil {
+		return trades{}, err
+	}
+	transfers, err := source.FetchTransfers(ctx)
+	if err != nil {
+		return trades{}, err
+	}
+	if hood, ok := source.(*rhwrapper.Hood); ok && hood.Store != nil {
+		if err := hood.SaveStore(); err != nil {
+			return trades{}, err
+		}
+	}
+	if openingLotsFile := os.Getenv("OPENING_LOTS"); openingLotsFile != "" {
+		openingLots, err := rhwrapper.LoadOpeningLots(openingLotsFile)
+		if err != nil {
+			return trades{}, err
+		}
+		stockMap = rhwrapper.ApplyOpeningLots(stockMap, openingLots)
+	}
+	return trades{source: source, stockMap: stockMap, optionMap: optionMap, income: income, transfers: transfers, fetchedAt: time.Now()}, nil
+}
+
+/*
+Robinhood client with splits, symbol history and corporate actions configured from the environment, not logged in yet
+*/
+func newHood() (*rhwrapper.Hood, error) {
+	splits, err := splitProvider()
+	if err != nil {
+		return nil, err
+	}
+	symbols, err := symbolRegistry()
+	if err != nil {
+		return nil, err
+	}
+	actions, err := corporateActions()
+	if err != nil {
+		return nil, err
+	}
+	return &rhwrapper.Hood{Splits: splits, Symbols: symbols, CorporateActions: actions}, nil
+}
+
+/*
+Keep the trades of username in the STORE_DIR store when set, they survive restarts and later logins only fetch what's new
+*/
+func useStore(hood *rhwrapper.Hood, username string) error {
+	storeDir := os.Getenv("STORE_DIR")
+	if storeDir == "" {
+		return nil
+	}
+	store, err := rhwrapper.OpenStore(rhwrapper.StorePath(storeDir, username))
+	if err != nil {
+		return err
+	}
+	hood.UseStore(store)
+	return nil
 }
 
 func main() {
-	rhClient := rhwrapper.Hood{}
+	// subcommands run headless, see cli.go
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:], os.Stdout, os.Stderr))
+	}
+	hood, err := newHood()
+	if err != nil {
+		log.Fatalf("failing %v", err)
+	}
+	quotes, err := quoteProvider()
+	if err != nil {
+		log.Fatalf("failing %v", err)
+	}
+	prices, err := dailyPriceSource()
+	if err != nil {
+		log.Fatalf("failing %v", err)
+	}
+	clients := newClientRegistry()
+	go clients.expire(time.Minute)
+	authenticated := isAuthenticated(clients, hood)
+
+	secret, err := sessionSecret()
+	if err != nil {
+		log.Fatalf("failing %v", err)
+	}
 	router := gin.Default()
-	store := cookie.NewStore([]byte("secret"))
+	store := cookie.NewStore(secret)
+	store.Options(sessions.Options{Path: "/", MaxAge: int(clients.ttl.Seconds()), HttpOnly: true})
 	router.Use(sessions.Sessions("stateStorage", store))
 
 	router.LoadHTMLGlob("templates/*.tmpl")
 
 	router.GET("/", func(c *gin.Context) {
+		if offline() {
+			c.Redirect(http.StatusSeeOther, "/metrics")
+			return
+		}
 		c.HTML(http.StatusOK, "login.tmpl", nil)
 	})
 
@@ -53,84 +413,207 @@ func main() {
 		email := c.PostForm("email")
 		password := c.PostForm("password")
 		mfa := c.PostForm("mfa")
-		cli, err := rhClient.Auth(email, password, mfa)
+		cli, err := hood.Auth(email, password, mfa)
+		if err != nil {
+			c.Error(err) //nolint:errcheck
+			c.Redirect(http.StatusSeeOther, "/error")
+			return
+		}
+		// every login gets its own client and symbol history, the renames of one login's store stay out of the others
+		client := hood.WithClient(cli)
+		if err := useStore(client, email); err != nil {
+			c.Error(err) //nolint:errcheck
+			c.Redirect(http.StatusSeeOther, "/error")
+			return
+		}
+		sessionID, err := clients.login(client, email)
 		if err != nil {
 			c.Error(err) //nolint:errcheck
 			c.Redirect(http.StatusSeeOther, "/error")
 			return
 		}
 		session := sessions.Default(c)
+		session.Set("sid", sessionID)
 		if err := session.Save(); err != nil {
+			clients.logout(sessionID)
 			c.Error(err) //nolint:errcheck
 			c.Redirect(http.StatusSeeOther, "/error")
 			return
 		}
-		session.Set("authenticated", true)
-		c.Redirect(http.StatusMovedPermanently, "/metrics")
-		rhClient.Cli = cli
+		c.Redirect(http.StatusSeeOther, "/metrics")
 	})
 
-	router.GET("/metrics", isAuthenticated, func(c *gin.Context) {
+	router.GET("/logout", func(c *gin.Context) {
 		session := sessions.Default(c)
-		session.Set("authenticated", false)
-		ctx := context.Background()
-		profitDf, unrealizedProfitDf, err := rhClient.ProcessRealizedEarnings(ctx)
-		if err != nil {
-			log.Fatalf("failing %v", err)
+		if sessionID, ok := session.Get("sid").(string); ok {
+			clients.logout(sessionID)
 		}
+		session.Clear()
+		if err := session.Save(); err != nil {
+			c.Error(err) //nolint:errcheck
+		}
+		c.Redirect(http.StatusSeeOther, "/")
+	})
 
-		// see rhwrapper.go
-		aggregatedDf := profitDf.
-			GroupBy("Year").
-			Aggregation([]dataframe.AggregationType{dataframe.Aggregation_SUM}, []string{"Amount"})
-
-		aggregatedDf = aggregatedDf.Arrange(
-			dataframe.Sort("Year"),
-		)
-		years := aggregatedDf.Col("Year").Records()
-		ytdRealizedGains := aggregatedDf.Col("Amount_SUM").Records()
-
-		tagDf := profitDf.
-			GroupBy("Year", "Tag").
-			Aggregation([]dataframe.AggregationType{dataframe.Aggregation_SUM}, []string{"Amount"})
+	registry := prometheus.NewRegistry()
+	portfolioMetrics := rhwrapper.NewPortfolioMetrics(registry)
+	prometheusHandler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
 
-		tagDf = tagDf.Arrange(
-			dataframe.Sort("Year"),
-		)
+	// prometheus text format, the cost basis params of /metrics apply as well
+	router.GET("/prometheus", isScrapeAuthorized, func(c *gin.Context) {
+		costBasis, err := costBasisFromRequest(c)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		// online the gauges follow PROMETHEUS_USER's session, scrapes have none of their own
+		client := hood
+		if !offline() {
+			client = nil
+			if prometheusUser := os.Getenv("PROMETHEUS_USER"); prometheusUser != "" {
+				client = clients.forUser(prometheusUser)
+			}
+		}
+		syncPortfolioMetrics(portfolioMetrics, costBasis, quotes, client)
+		prometheusHandler.ServeHTTP(c.Writer, c.Request)
+	})
 
-		amount := tagDf.Col("Amount_SUM").Records()
-		yearsTag := tagDf.Col("Year").Records()
-		tag := tagDf.Col("Tag").Records()
+	registerAPI(router, clients, hood, quotes, prices)
 
-		earningsDfByTicker := profitDf.
-			Filter(dataframe.F{
-				Colname:    "Ticker",
-				Comparator: series.Neq,
-				Comparando: "",
-			}).
-			GroupBy("Year", "Ticker").
-			Aggregation([]dataframe.AggregationType{dataframe.Aggregation_SUM}, []string{"Amount"})
+	// the numbers come from /api/v1, the page passes its method and overrides params along
+	router.GET("/metrics", authenticated, func(c *gin.Context) {
+		costBasis, err := costBasisFromRequest(c)
+		if err != nil {
+			c.HTML(http.StatusBadRequest, "error.tmpl", gin.H{
+				"ErrorMessage": err.Error(),
+			})
+			return
+		}
+		c.HTML(http.StatusOK, "index.tmpl", gin.H{
+			"CostBasisMethod":  costBasis.String(),
+			"CostBasisMethods": rhwrapper.CostBasisMethods,
+			"Offline":          offline(),
+		})
+	})
 
-		earningsByTickerAmount := earningsDfByTicker.Col("Amount_SUM").Records()
-		earningsByTickerLabels := earningsDfByTicker.Col("Ticker").Records()
-		earningsByTickerYear := earningsDfByTicker.Col("Year").Records()
+	// ?year=2023&format=csv, printable html by default. Cost basis params are the same as /metrics
+	router.GET("/form8949", authenticated, func(c *gin.Context) {
+		ctx := context.Background()
+		source := tradeSource(sessionHood(c))
+		year := c.DefaultQuery("year", strconv.Itoa(time.Now().Year()-1))
+		if _, err := strconv.Atoi(year); err != nil {
+			c.HTML(http.StatusBadRequest, "error.tmpl", gin.H{
+				"ErrorMessage": "invalid tax year " + year,
+			})
+			return
+		}
+		costBasis, err := costBasisFromRequest(c)
+		if err != nil {
+			c.HTML(http.StatusBadRequest, "error.tmpl", gin.H{
+				"ErrorMessage": err.Error(),
+			})
+			return
+		}
+		trades, err := fetchTrades(ctx, source)
+		if err != nil {
+			c.HTML(http.StatusBadRequest, "error.tmpl", gin.H{
+				"ErrorMessage": err.Error(),
+			})
+			return
+		}
+		report, err := rhwrapper.CalculateRealizedEarnings(source, trades.stockMap, trades.optionMap, trades.income, costBasis)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "error.tmpl", gin.H{
+				"ErrorMessage": err.Error(),
+			})
+			return
+		}
+		form := rhwrapper.NewForm8949(report.RealizedLots, year)
+		if c.Query("format") == "csv" {
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=form8949-%s.csv", year))
+			c.Header("Content-Type", "text/csv")
+			if err := form.WriteCSV(c.Writer); err != nil {
+				c.Error(err) //nolint:errcheck
+			}
+			return
+		}
+		c.HTML(http.StatusOK, "form8949.tmpl", gin.H{
+			"Form":            form,
+			"CostBasisMethod": costBasis.String(),
+			"Method":          c.Query("method"),
+			"Overrides":       c.Query("overrides"),
+		})
+	})
 
-		labels := years
-		data := ytdRealizedGains
+	router.GET("/reconcile", authenticated, func(c *gin.Context) {
+		c.HTML(http.StatusOK, "reconcile.tmpl", gin.H{
+			"Method":    c.Query("method"),
+			"Overrides": c.Query("overrides"),
+		})
+	})
 
-		c.HTML(http.StatusOK, "index.tmpl", gin.H{
-			"LabelsTimeSeries":             labels,
-			"DataTimeSeries":               data,
-			"LabelsYearsTag":               yearsTag,
-			"LabelsTags":                   tag,
-			"DataAmount":                   amount,
-			"DataLabelsByTicker":           earningsByTickerLabels,
-			"DataValByTicker":              earningsByTickerAmount,
-			"DataValByTickerYear":          earningsByTickerYear,
-			"UnrealizedProfitTransactions": unrealizedProfitDf.Records(),
+	// upload the 1099-B csv, it's compared against the lots computed from the trade history
+	router.POST("/reconcile", authenticated, func(c *gin.Context) {
+		ctx := context.Background()
+		source := tradeSource(sessionHood(c))
+		upload, err := c.FormFile("form1099b")
+		if err != nil {
+			c.HTML(http.StatusBadRequest, "error.tmpl", gin.H{
+				"ErrorMessage": "upload the 1099-B csv. ERR: " + err.Error(),
+			})
+			return
+		}
+		file, err := upload.Open()
+		if err != nil {
+			c.HTML(http.StatusBadRequest, "error.tmpl", gin.H{
+				"ErrorMessage": err.Error(),
+			})
+			return
+		}
+		defer file.Close()
+		brokerLots, err := rhwrapper.ParseForm1099B(file)
+		if err != nil {
+			c.HTML(http.StatusBadRequest, "error.tmpl", gin.H{
+				"ErrorMessage": err.Error(),
+			})
+			return
+		}
+		costBasis, err := costBasisFromRequest(c)
+		if err != nil {
+			c.HTML(http.StatusBadRequest, "error.tmpl", gin.H{
+				"ErrorMessage": err.Error(),
+			})
+			return
+		}
+		trades, err := fetchTrades(ctx, source)
+		if err != nil {
+			c.HTML(http.StatusBadRequest, "error.tmpl", gin.H{
+				"ErrorMessage": err.Error(),
+			})
+			return
+		}
+		report, err := rhwrapper.CalculateRealizedEarnings(source, trades.stockMap, trades.optionMap, trades.income, costBasis)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "error.tmpl", gin.H{
+				"ErrorMessage": err.Error(),
+			})
+			return
+		}
+		lotDiffs := rhwrapper.Reconcile1099B(report.RealizedLots, brokerLots)
+		summary := make(map[string]int)
+		for _, diff := range lotDiffs {
+			summary[string(diff.Status)] += 1
+		}
+		c.HTML(http.StatusOK, "reconcile.tmpl", gin.H{
+			"Reconciled":      true,
+			"Summary":         summary,
+			"LotDiffs":        rhwrapper.ConvertLotDiffDf(lotDiffs).Records(),
+			"CostBasisMethod": costBasis.String(),
+			"Method":          c.Query("method"),
+			"Overrides":       c.Query("overrides"),
 		})
 	})
 
-	router.Run(":8080") //nolint:errcheck
+	router.Run(listenAddr()) //nolint:errcheck
 
 }
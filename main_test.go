@@ -0,0 +1,102 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"net/http/httptest"
+	"rh_metrics/m/src/rhwrapper"
+	"testing"
+	"time"
+)
+
+func TestIsScrapeAuthorized(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/prometheus", isScrapeAuthorized, func(c *gin.Context) {
+		c.String(http.StatusOK, "scraped")
+	})
+	tests := []struct {
+		name          string
+		token         string
+		public        string
+		authorization string
+		status        int
+	}{
+		{name: "no token set", status: http.StatusUnauthorized},
+		{name: "no token set and public", public: "true", status: http.StatusOK},
+		{name: "public only without a token", token: "secret", public: "true", status: http.StatusUnauthorized},
+		{name: "missing token", token: "secret", status: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", authorization: "Bearer guess", status: http.StatusUnauthorized},
+		{name: "not a bearer token", token: "secret", authorization: "secret", status: http.StatusUnauthorized},
+		{name: "token", token: "secret", authorization: "Bearer secret", status: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("PROMETHEUS_TOKEN", test.token)
+			t.Setenv("PROMETHEUS_PUBLIC", test.public)
+			request := httptest.NewRequest(http.MethodGet, "/prometheus", nil)
+			if test.authorization != "" {
+				request.Header.Set("Authorization", test.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("status %d, want %d", recorder.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestSyncPortfolioMetricsCostBasis(t *testing.T) {
+	offlineTestEnv(t)
+	t.Setenv("PROMETHEUS_REFRESH", "1h")
+	hood, err := newHood()
+	if err != nil {
+		t.Fatalf("failing to configure hood. ERR: %v", err)
+	}
+	quotes, err := quoteProvider()
+	if err != nil {
+		t.Fatalf("failing to configure quotes. ERR: %v", err)
+	}
+	portfolioSync.last, portfolioSync.costBasis = time.Time{}, ""
+	metrics := rhwrapper.NewPortfolioMetrics(prometheus.NewRegistry())
+
+	fifo := rhwrapper.CostBasisSelector{Default: rhwrapper.FIFO}
+	syncPortfolioMetrics(metrics, fifo, quotes, hood)
+	synced := portfolioSync.last
+	if synced.IsZero() || portfolioSync.costBasis != "FIFO" {
+		t.Fatalf("synced at %v with %q, want a FIFO sync", synced, portfolioSync.costBasis)
+	}
+	syncPortfolioMetrics(metrics, fifo, quotes, hood)
+	if portfolioSync.last != synced {
+		t.Errorf("synced again at %v within PROMETHEUS_REFRESH", portfolioSync.last)
+	}
+	// a scrape asking for another cost basis doesn't get the FIFO gauges
+	syncPortfolioMetrics(metrics, rhwrapper.CostBasisSelector{Default: rhwrapper.LIFO}, quotes, hood)
+	if !portfolioSync.last.After(synced) || portfolioSync.costBasis != "LIFO" {
+		t.Errorf("synced at %v with %q, want a LIFO sync after %v", portfolioSync.last, portfolioSync.costBasis, synced)
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	tests := []struct {
+		name        string
+		activityCSV string
+		listenAddr  string
+		want        string
+	}{
+		{name: "online", want: ":8080"},
+		{name: "offline", activityCSV: "activity.csv", want: "127.0.0.1:8080"},
+		{name: "offline on every interface", activityCSV: "activity.csv", listenAddr: ":9090", want: ":9090"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("ACTIVITY_CSV", test.activityCSV)
+			t.Setenv("LISTEN_ADDR", test.listenAddr)
+			if got := listenAddr(); got != test.want {
+				t.Errorf("listening on %s, want %s", got, test.want)
+			}
+		})
+	}
+}
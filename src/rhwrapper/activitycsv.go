@@ -0,0 +1,395 @@
+package rhwrapper
+
+// offline data source, parses the account activity csv robinhood exports into the client models
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	models "github.com/Ryang20718/robinhood-client/models"
+	"github.com/shopspring/decimal"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// e.g. "AAPL 1/20/2023 Call $150.00", expiration rows prefix it with "Option Expiration for"
+var activityOptionPattern = regexp.MustCompile(`(\S+) (\d{1,2}/\d{1,2}/\d{4}) (Call|Put) \$([\d,.]+)`)
+
+type activityRow struct {
+	date        string // 2006-01-02
+	instrument  string
+	description string
+	code        string
+	qty         float64
+	price       float64
+	amount      float64
+}
+
+// option contract an activity row refers to
+type activityContract struct {
+	ticker     string
+	expiration string // 2006-01-02
+	optionType string // call or put
+	strike     float64
+}
+
+func parseActivityNumber(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	negative := strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")")
+	// split and transfer rows suffix the qty e.g. "5S"
+	value = strings.TrimRight(strings.NewReplacer("$", "", ",", "", "(", "", ")", "").Replace(value), "SsLl")
+	if value == "" {
+		return 0, nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %s", value)
+	}
+	if negative {
+		number = -number
+	}
+	return number, nil
+}
+
+func parseActivityContract(description string) (activityContract, bool) {
+	match := activityOptionPattern.FindStringSubmatch(description)
+	if match == nil {
+		return activityContract{}, false
+	}
+	expiration, err := time.Parse("1/2/2006", match[2])
+	if err != nil {
+		return activityContract{}, false
+	}
+	strike, err := strconv.ParseFloat(strings.ReplaceAll(match[4], ",", ""), 64)
+	if err != nil {
+		return activityContract{}, false
+	}
+	return activityContract{
+		ticker:     strings.ToUpper(match[1]),
+		expiration: expiration.Format("2006-01-02"),
+		optionType: strings.ToLower(match[3]),
+		strike:     strike,
+	}, true
+}
+
+func readActivityRows(r io.Reader) ([]activityRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failing to parse activity csv. ERR: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("activity csv is empty")
+	}
+	columns := make(map[string]int)
+	for col, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = col
+	}
+	for _, name := range []string{"activity date", "instrument", "description", "trans code", "quantity", "price"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("activity csv is missing the %s column", name)
+		}
+	}
+	value := func(record []string, name string) string {
+		col, ok := columns[name]
+		if !ok || col >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[col])
+	}
+
+	rows := []activityRow{}
+	for i, record := range records[1:] {
+		activityDate, err := time.Parse("1/2/2006", value(record, "activity date"))
+		if err != nil {
+			// the export ends with a disclaimer instead of data
+			continue
+		}
+		qty, err := parseActivityNumber(value(record, "quantity"))
+		if err != nil {
+			return nil, fmt.Errorf("activity csv row %d: %v", i+2, err)
+		}
+		price, err := parseActivityNumber(value(record, "price"))
+		if err != nil {
+			return nil, fmt.Errorf("activity csv row %d: %v", i+2, err)
+		}
+		// only income rows need the amount, it's empty when the column is missing
+		amount, err := parseActivityNumber(value(record, "amount"))
+		if err != nil {
+			return nil, fmt.Errorf("activity csv row %d: %v", i+2, err)
+		}
+		rows = append(rows, activityRow{
+			date:        activityDate.Format("2006-01-02"),
+			instrument:  strings.ToUpper(value(record, "instrument")),
+			description: value(record, "description"),
+			code:        strings.ToUpper(value(record, "trans code")),
+			qty:         qty,
+			price:       price,
+			amount:      amount,
+		})
+	}
+	// the export lists the newest activity first
+	for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+		rows[i], rows[j] = rows[j], rows[i]
+	}
+	return rows, nil
+}
+
+/*
+Parse robinhood's account activity csv into the same shapes FetchRegularTrades and FetchOptionTrades return
+
+Buy/Sell rows become stock trades and BTO/STO/BTC/STC rows option trades. Opening legs of contracts that were
+assigned (OASGN) or exercised (OEXCS) are marked Assigned, and the stock row robinhood adds for the assignment
+is dropped since the engine books it from the option. Buys of reinvested dividends are tagged drip. Other activity
+(fees, journals) is ignored, dividends and interest are read by ParseActivityIncome and deposits by
+ParseActivityTransfers.
+*/
+func ParseActivityCSV(r io.Reader) (map[string][]models.Transaction, map[string][]models.OptionTransaction, error) {
+	rows, err := readActivityRows(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return activityTrades(rows)
+}
+
+func activityTrades(rows []activityRow) (map[string][]models.Transaction, map[string][]models.OptionTransaction, error) {
+	events, err := activityOptionEvents(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	// stock rows caused by an assignment or exercise, keyed by ticker, date, strike and shares
+	assignedStock := make(map[string]int)
+	for _, event := range events {
+		assignedStock[fmt.Sprintf("%s-%s-%.4f-%.6f", event.Ticker, event.Date, event.Strike, event.Qty.Mul(optionMultiplier).InexactFloat64())] += 1
+	}
+
+	stockMap := make(map[string][]models.Transaction)
+	optionMap := make(map[string][]models.OptionTransaction)
+	sequence := make(map[string]int) // the csv has no time of day, keep same day trades in order
+	createdAt := func(date string) time.Time {
+		day, _ := time.Parse("2006-01-02", date)
+		sequence[date] += 1
+		return day.Add(time.Duration(sequence[date]) * time.Second)
+	}
+	for _, row := range rows {
+		switch row.code {
+		case "BUY", "SELL":
+			key := fmt.Sprintf("%s-%s-%.4f-%.6f", row.instrument, row.date, row.price, row.qty)
+			if assignedStock[key] > 0 {
+				assignedStock[key] -= 1
+				continue
+			}
+			transactionType := strings.ToLower(row.code)
+			tag := transactionType
+			if transactionType == "buy" && strings.Contains(strings.ToLower(row.description), "reinvest") {
+				tag = "drip"
+			}
+			stockMap[row.instrument] = append(stockMap[row.instrument], models.Transaction{
+				Ticker:          row.instrument,
+				TransactionType: transactionType,
+				Qty:             row.qty,
+				UnitCost:        row.price,
+				CreatedAt:       createdAt(row.date).Format("2006-01-02 15:04:05"),
+				Tag:             tag,
+			})
+		case "BTO", "STO", "BTC", "STC":
+			contract, ok := parseActivityContract(row.description)
+			if !ok {
+				return nil, nil, fmt.Errorf("can't read the contract of %s on %s", row.description, row.date)
+			}
+			side := "buy"
+			if strings.HasPrefix(row.code, "S") {
+				side = "sell"
+			}
+			status := "Open"
+			if strings.HasSuffix(row.code, "TC") || contract.expiration < time.Now().Format("2006-01-02") {
+				status = "Expired"
+			}
+			optionMap[contract.ticker] = append(optionMap[contract.ticker], models.OptionTransaction{
+				Ticker:          contract.ticker,
+				TransactionType: row.code,
+				Qty:             row.qty,
+				StrikePrice:     contract.strike,
+				UnitCost:        row.price,
+				CreatedAt:       createdAt(row.date).Format(time.RFC3339),
+				ExpirationDate:  contract.expiration,
+				Status:          status,
+				Tag:             fmt.Sprintf("%s %s", side, contract.optionType),
+			})
+		}
+	}
+	markAssignedLegs(optionMap, events)
+	return stockMap, optionMap, nil
+}
+
+/*
+Parse the income rows of robinhood's account activity csv, CDIV dividends, SLIP stock lending and INT interest
+
+The export has no ex-dividend dates, dividends are classified by the holdings on the payment date. Reinvested dividends
+are regular Buy rows, ParseActivityCSV already turns them into lots
+*/
+func ParseActivityIncome(r io.Reader) ([]IncomeEvent, error) {
+	rows, err := readActivityRows(r)
+	if err != nil {
+		return nil, err
+	}
+	return activityIncome(rows), nil
+}
+
+func activityIncome(rows []activityRow) []IncomeEvent {
+	incomeTypes := map[string]IncomeType{
+		"CDIV": Dividend,
+		"SLIP": StockLending,
+		"INT":  Interest,
+	}
+	events := []IncomeEvent{}
+	for _, row := range rows {
+		incomeType, ok := incomeTypes[row.code]
+		if !ok {
+			continue
+		}
+		events = append(events, IncomeEvent{
+			Date:   row.date,
+			Ticker: row.instrument,
+			Type:   incomeType,
+			Amount: decimal.NewFromFloat(row.amount).Round(moneyPlaces),
+		})
+	}
+	return events
+}
+
+/*
+Load trades from an account activity csv file
+*/
+func LoadActivityCSV(path string) (map[string][]models.Transaction, map[string][]models.OptionTransaction, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failing to read activity csv. ERR: %v", err)
+	}
+	defer file.Close()
+	return ParseActivityCSV(file)
+}
+
+/*
+Parse the OASGN assignment and OEXCS exercise rows of robinhood's account activity csv
+*/
+func ParseActivityOptionEvents(r io.Reader) ([]OptionEvent, error) {
+	rows, err := readActivityRows(r)
+	if err != nil {
+		return nil, err
+	}
+	return activityOptionEvents(rows)
+}
+
+func activityOptionEvents(rows []activityRow) ([]OptionEvent, error) {
+	events := []OptionEvent{}
+	for _, row := range rows {
+		if row.code != "OASGN" && row.code != "OEXCS" {
+			continue
+		}
+		contract, ok := parseActivityContract(row.description)
+		if !ok {
+			return nil, fmt.Errorf("can't read the contract of %s on %s", row.description, row.date)
+		}
+		events = append(events, OptionEvent{
+			Ticker:     contract.ticker,
+			Strike:     contract.strike,
+			Expiration: contract.expiration,
+			Type:       contract.optionType,
+			Date:       row.date,
+			Qty:        QtyFromFloat(row.qty),
+		})
+	}
+	return events, nil
+}
+
+// ActivityCSVSource reads trades from an account activity csv
+type ActivityCSVSource struct {
+	Path             string
+	Splits           SplitProvider   // yahoo when nil
+	Symbols          *SymbolRegistry // DefaultSymbolChanges when nil
+	CorporateActions []CorporateAction
+	once             sync.Once
+	rows             []activityRow // the csv is read on the first fetch, every Fetch method works off its rows
+	err              error
+}
+
+var _ TransactionSource = &ActivityCSVSource{}
+
+func (a *ActivityCSVSource) readRows() ([]activityRow, error) {
+	a.once.Do(func() {
+		file, err := os.Open(a.Path)
+		if err != nil {
+			a.err = fmt.Errorf("failing to read activity csv. ERR: %v", err)
+			return
+		}
+		defer file.Close()
+		a.rows, a.err = readActivityRows(file)
+	})
+	return a.rows, a.err
+}
+
+func (a *ActivityCSVSource) FetchRegularTrades(ctx context.Context) (map[string][]models.Transaction, error) {
+	rows, err := a.readRows()
+	if err != nil {
+		return nil, err
+	}
+	stockMap, _, err := activityTrades(rows)
+	return stockMap, err
+}
+
+func (a *ActivityCSVSource) FetchOptionTrades(ctx context.Context) (map[string][]models.OptionTransaction, error) {
+	rows, err := a.readRows()
+	if err != nil {
+		return nil, err
+	}
+	_, optionMap, err := activityTrades(rows)
+	return optionMap, err
+}
+
+func (a *ActivityCSVSource) FetchIncome(ctx context.Context) ([]IncomeEvent, error) {
+	rows, err := a.readRows()
+	if err != nil {
+		return nil, err
+	}
+	return activityIncome(rows), nil
+}
+
+func (a *ActivityCSVSource) FetchTransfers(ctx context.Context) ([]Transfer, error) {
+	rows, err := a.readRows()
+	if err != nil {
+		return nil, err
+	}
+	return activityTransfers(rows), nil
+}
+
+// the export uses the symbol at the time of the trade
+func (a *ActivityCSVSource) FetchCurrentTickerSymbol(symbol string, date string) (string, error) {
+	if a.Symbols == nil {
+		a.Symbols = NewSymbolRegistry(DefaultSymbolChanges...)
+	}
+	return a.Symbols.CurrentSymbol(symbol, date), nil
+}
+
+func (a *ActivityCSVSource) FetchStockSplits(symbol string) ([]Split, error) {
+	return cachedSplits(a.Splits, symbol)
+}
+
+func (a *ActivityCSVSource) FetchCorporateActions() ([]CorporateAction, error) {
+	return a.CorporateActions, nil
+}
+
+func (a *ActivityCSVSource) FetchOptionEvents() ([]OptionEvent, error) {
+	rows, err := a.readRows()
+	if err != nil {
+		return nil, err
+	}
+	return activityOptionEvents(rows)
+}
@@ -0,0 +1,201 @@
+package rhwrapper
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testActivityHeader = "Activity Date,Process Date,Settle Date,Instrument,Description,Trans Code,Quantity,Price,Amount\n"
+
+func TestParseActivityCSV(t *testing.T) {
+	csv := testActivityHeader + `3/1/2023,3/1/2023,3/3/2023,AAPL,Apple CUSIP: 037833100,Sell,10,$130.55,"$1,305.50"
+2/10/2023,2/10/2023,2/10/2023,AAPL,Cash Div: R/D 2023-02-13 P/D 2023-02-16 - 10 shares at 0.23,CDIV,,,$2.30
+1/5/2023,1/5/2023,1/9/2023,XYZ,"XYZ 1/20/2023 Call $1,000.00",BTO,1,$3.00,($300.00)
+1/3/2023,1/3/2023,1/5/2023,AAPL,Apple CUSIP: 037833100,Buy,5,$100.00,($500.00)
+1/3/2023,1/3/2023,1/5/2023,AAPL,Apple CUSIP: 037833100,Buy,5,$101.00,($505.00)
+
+"The data provided is for informational purposes only."
+`
+	stocks, options, err := ParseActivityCSV(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("failing to parse activity csv. ERR: %v", err)
+	}
+	trades := []string{}
+	for _, trade := range stocks["AAPL"] {
+		trades = append(trades, fmt.Sprintf("%s %v@%v %s", trade.TransactionType, trade.Qty, trade.UnitCost, trade.CreatedAt))
+	}
+	// oldest first, same day trades kept in the order of the file
+	want := []string{"buy 5@101 2023-01-03 00:00:01", "buy 5@100 2023-01-03 00:00:02", "sell 10@130.55 2023-03-01 00:00:01"}
+	if fmt.Sprint(trades) != fmt.Sprint(want) {
+		t.Errorf("stock trades %v, want %v", trades, want)
+	}
+	if len(stocks) != 1 {
+		t.Errorf("stock trades %+v, want only AAPL", stocks)
+	}
+	if len(options["XYZ"]) != 1 {
+		t.Fatalf("option trades %+v", options)
+	}
+	leg := options["XYZ"][0]
+	if leg.TransactionType != "BTO" || leg.StrikePrice != 1000 || leg.ExpirationDate != "2023-01-20" || leg.UnitCost != 3 || leg.Status != "Expired" || leg.Tag != "buy call" {
+		t.Errorf("option leg %+v", leg)
+	}
+}
+
+func TestActivityCSVSource(t *testing.T) {
+	csv := testActivityHeader + `3/1/2023,3/1/2023,3/3/2023,AAPL,Apple CUSIP: 037833100,Sell,10,$130.55,"$1,305.50"
+2/10/2023,2/10/2023,2/10/2023,AAPL,Cash Div: R/D 2023-02-13 P/D 2023-02-16 - 10 shares at 0.23,CDIV,,,$2.30
+1/5/2023,1/5/2023,1/9/2023,XYZ,"XYZ 1/20/2023 Call $1,000.00",BTO,1,$3.00,($300.00)
+1/3/2023,1/3/2023,1/5/2023,AAPL,Apple CUSIP: 037833100,Buy,10,$100.00,"($1,000.00)"
+1/2/2023,1/2/2023,1/2/2023,,ACH Deposit,ACH,,,"$1,000.00"
+`
+	path := filepath.Join(t.TempDir(), "activity.csv")
+	if err := os.WriteFile(path, []byte(csv), 0600); err != nil {
+		t.Fatal(err)
+	}
+	source := &ActivityCSVSource{Path: path}
+	fetch := func() string {
+		ctx := context.Background()
+		stockMap, err := source.FetchRegularTrades(ctx)
+		if err != nil {
+			t.Fatalf("failing to fetch trades. ERR: %v", err)
+		}
+		optionMap, err := source.FetchOptionTrades(ctx)
+		if err != nil {
+			t.Fatalf("failing to fetch option trades. ERR: %v", err)
+		}
+		income, err := source.FetchIncome(ctx)
+		if err != nil {
+			t.Fatalf("failing to fetch income. ERR: %v", err)
+		}
+		transfers, err := source.FetchTransfers(ctx)
+		if err != nil {
+			t.Fatalf("failing to fetch transfers. ERR: %v", err)
+		}
+		events, err := source.FetchOptionEvents()
+		if err != nil {
+			t.Fatalf("failing to fetch option events. ERR: %v", err)
+		}
+		return fmt.Sprintf("%d stock %d option %d income %d transfers %d events", len(stockMap["AAPL"]), len(optionMap["XYZ"]), len(income), len(transfers), len(events))
+	}
+	want := "2 stock 1 option 1 income 1 transfers 0 events"
+	if got := fetch(); got != want {
+		t.Errorf("fetched %s, want %s", got, want)
+	}
+	// the csv is read once, later fetches don't go back to the file
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := fetch(); got != want {
+		t.Errorf("fetched %s once the csv was gone, want %s", got, want)
+	}
+
+	missing := &ActivityCSVSource{Path: path}
+	if _, err := missing.FetchIncome(context.Background()); err == nil {
+		t.Errorf("fetched income of a missing csv")
+	}
+}
+
+func TestParseActivityCSVErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+	}{
+		{name: "empty", csv: ""},
+		{name: "missing a column", csv: "Activity Date,Instrument,Description,Trans Code,Quantity\n1/3/2023,AAPL,Apple,Buy,5\n"},
+		{name: "invalid qty", csv: testActivityHeader + "1/3/2023,1/3/2023,1/5/2023,AAPL,Apple,Buy,five,$100.00,\n"},
+		{name: "unreadable contract", csv: testActivityHeader + "1/3/2023,1/3/2023,1/5/2023,XYZ,XYZ Call,STO,1,$1.00,$100.00\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := ParseActivityCSV(strings.NewReader(test.csv)); err == nil {
+				t.Error("parsed an invalid activity csv")
+			}
+		})
+	}
+}
+
+func TestParseActivityCSVAssignments(t *testing.T) {
+	tests := []struct {
+		name   string
+		csv    string
+		legs   []string // type, qty and status of the KO legs
+		stocks []string // KO stock trades left
+		events []string // date and qty of the OASGN rows
+	}{
+		{
+			name: "assignment of the opener still open",
+			csv: testActivityHeader + `2/17/2023,2/17/2023,2/21/2023,KO,KO 2/17/2023 Put $55.00,OASGN,1,,
+2/17/2023,2/17/2023,2/21/2023,KO,Coca-Cola CUSIP: 191216100,BUY,100,$55.00,"($5,500.00)"
+2/10/2023,2/10/2023,2/13/2023,KO,KO 2/17/2023 Put $55.00,STO,2,$0.80,$160.00
+1/10/2023,1/10/2023,1/11/2023,KO,KO 2/17/2023 Put $55.00,BTC,1,$0.20,($20.00)
+1/3/2023,1/3/2023,1/4/2023,KO,KO 2/17/2023 Put $55.00,STO,1,$1.00,$100.00
+`,
+			legs:   []string{"STO 1 Expired", "BTC 1 Expired", "STO 1 Expired", "STO 1 Assigned"},
+			stocks: []string{},
+			events: []string{"2023-02-17 1"},
+		},
+		{
+			name: "early assignment",
+			csv: testActivityHeader + `3/10/2023,3/10/2023,3/14/2023,KO,Coca-Cola CUSIP: 191216100,SELL,100,$62.00,"$6,200.00"
+2/8/2023,2/8/2023,2/10/2023,KO,KO 2/17/2023 Put $55.00,OASGN,1,,
+2/8/2023,2/8/2023,2/10/2023,KO,Coca-Cola CUSIP: 191216100,BUY,100,$55.00,"($5,500.00)"
+1/3/2023,1/3/2023,1/4/2023,KO,KO 2/17/2023 Put $55.00,STO,1,$1.00,$100.00
+`,
+			legs:   []string{"STO 1 Assigned"},
+			stocks: []string{"sell 100"},
+			events: []string{"2023-02-08 1"},
+		},
+		{
+			name: "exercise",
+			csv: testActivityHeader + `2/15/2023,2/15/2023,2/17/2023,KO,KO 2/17/2023 Call $55.00,OEXCS,1,,
+2/15/2023,2/15/2023,2/17/2023,KO,Coca-Cola CUSIP: 191216100,BUY,100,$55.00,"($5,500.00)"
+1/3/2023,1/3/2023,1/4/2023,KO,KO 2/17/2023 Call $55.00,BTO,1,$1.00,($100.00)
+`,
+			legs:   []string{"BTO 1 Assigned"},
+			stocks: []string{},
+			events: []string{"2023-02-15 1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stocks, options, err := ParseActivityCSV(strings.NewReader(test.csv))
+			if err != nil {
+				t.Fatalf("failing to parse activity csv. ERR: %v", err)
+			}
+			legs := []string{}
+			for _, leg := range options["KO"] {
+				legs = append(legs, fmt.Sprintf("%s %v %s", leg.TransactionType, leg.Qty, leg.Status))
+			}
+			if fmt.Sprint(legs) != fmt.Sprint(test.legs) {
+				t.Errorf("legs %v, want %v", legs, test.legs)
+			}
+			// the stock robinhood books for the assignment comes from the option
+			trades := []string{}
+			for _, trade := range stocks["KO"] {
+				trades = append(trades, fmt.Sprintf("%s %v", trade.TransactionType, trade.Qty))
+			}
+			if fmt.Sprint(trades) != fmt.Sprint(test.stocks) {
+				t.Errorf("stock trades %v, want %v", trades, test.stocks)
+			}
+
+			events, err := ParseActivityOptionEvents(strings.NewReader(test.csv))
+			if err != nil {
+				t.Fatalf("failing to parse activity csv events. ERR: %v", err)
+			}
+			got := []string{}
+			for _, event := range events {
+				if event.Ticker != "KO" || event.Strike != 55 || event.Expiration != "2023-02-17" {
+					t.Errorf("event %+v isn't on the KO 55 2023-02-17 contract", event)
+				}
+				got = append(got, fmt.Sprintf("%s %s", event.Date, event.Qty))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.events) {
+				t.Errorf("events %v, want %v", got, test.events)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package rhwrapper
+
+// what the account's deposits and withdrawals would have earned in an index instead
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
+// BenchmarkComparison puts the account next to a benchmark bought and sold with the same cash flows
+type BenchmarkComparison struct {
+	Symbol    string
+	Daily     []DailyValue   // the benchmark holding every day the account has a value
+	Account   []PeriodReturn // per year and all, as in ReturnsReport
+	Benchmark []PeriodReturn // per year and all, Ticker is the benchmark symbol
+}
+
+/*
+Buy symbol at the close with every dollar that went into the account and sell it for every dollar that came out
+
+The flows of returns.Daily are the deposits and withdrawals, including the cash the account spent beyond what it
+held. Fractional shares are bought, the benchmark pays no dividends beyond what its closes carry. A withdrawal larger
+than the benchmark holding sells all of it, the flow is what the shares fetched
+*/
+func CompareToBenchmark(returns *ReturnsReport, symbol string, prices DailyPriceSource) (*BenchmarkComparison, error) {
+	symbol = strings.ToUpper(symbol)
+	if prices == nil {
+		return nil, fmt.Errorf("comparing to %s needs daily closes, set PRICES_FILE", symbol)
+	}
+	comparison := &BenchmarkComparison{Symbol: symbol, Daily: []DailyValue{}, Account: returns.Account, Benchmark: []PeriodReturn{}}
+	shares := decimal.Zero
+	for _, day := range returns.Daily {
+		price, found, err := prices.Close(symbol, day.Date)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			if day.Flow.IsZero() && shares.IsZero() {
+				// nothing to buy yet
+				comparison.Daily = append(comparison.Daily, DailyValue{Date: day.Date})
+				continue
+			}
+			return nil, fmt.Errorf("no close for %s on or before %s", symbol, day.Date)
+		}
+		flow := day.Flow
+		if !flow.IsZero() {
+			bought := flow.DivRound(price, qtyPlaces)
+			if shares.Add(bought).IsNegative() {
+				// the withdrawal is more than the benchmark is worth, it's sold out and pays what it has
+				bought = shares.Neg()
+				flow = amountOf(shares, price).Neg()
+			}
+			shares = shares.Add(bought)
+		}
+		comparison.Daily = append(comparison.Daily, DailyValue{Date: day.Date, Value: amountOf(shares, price), Flow: flow})
+	}
+	comparison.Benchmark = periodReturns(symbol, comparison.Daily)
+	return comparison, nil
+}
@@ -0,0 +1,93 @@
+package rhwrapper
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCompareToBenchmark(t *testing.T) {
+	source := NewFakeSource()
+	source.AddTransfer("2023-01-02", 1000)
+	source.AddStockTrade("AAPL", "buy", 10, 100, "2023-01-03 10:00:00")
+	source.AddTransfer("2023-07-03", 1100)
+	prices := fakePrices{
+		"AAPL": {{date: "2023-01-03", price: dec("100")}, {date: "2023-12-29", price: dec("110")}},
+		"SPY":  {{date: "2022-12-30", price: dec("100")}, {date: "2023-06-30", price: dec("110")}, {date: "2023-12-29", price: dec("121")}},
+	}
+	returns, err := ProcessReturns(context.Background(), source, prices, "2023-12-31")
+	if err != nil {
+		t.Fatalf("failing to process returns. ERR: %v", err)
+	}
+
+	comparison, err := CompareToBenchmark(returns, "spy", prices)
+	if err != nil {
+		t.Fatalf("failing to compare to SPY. ERR: %v", err)
+	}
+	if comparison.Symbol != "SPY" {
+		t.Errorf("symbol %s, want SPY", comparison.Symbol)
+	}
+	if len(comparison.Daily) != len(returns.Daily) {
+		t.Errorf("%d benchmark days, %d account days", len(comparison.Daily), len(returns.Daily))
+	}
+	// 10 shares at 100 and 10 more at 110, both worth 121 at the end of the year. The account left the second
+	// deposit in cash
+	assertReturn(t, comparison.Benchmark, wantReturn{ticker: "SPY", period: "all", start: "2023-01-02", end: "2023-12-31", startValue: "0", endValue: "2420", netFlow: "2100", gain: "320", twr: "21", xirr: "21.15"})
+	assertReturn(t, comparison.Account, wantReturn{period: "all", start: "2023-01-02", end: "2023-12-31", startValue: "0", endValue: "2200", netFlow: "2100", gain: "100", twr: "4.76", xirr: "6.53"})
+}
+
+func TestCompareToBenchmarkOverdrawn(t *testing.T) {
+	source := NewFakeSource()
+	source.AddTransfer("2023-01-02", 1000)
+	source.AddTransfer("2023-06-01", -800)
+	source.AddTransfer("2023-09-01", 600)
+	prices := fakePrices{
+		"SPY": {{date: "2022-12-30", price: dec("100")}, {date: "2023-05-31", price: dec("50")}, {date: "2023-08-31", price: dec("60")}, {date: "2023-12-29", price: dec("66")}},
+	}
+	returns, err := ProcessReturns(context.Background(), source, prices, "2023-12-31")
+	if err != nil {
+		t.Fatalf("failing to process returns. ERR: %v", err)
+	}
+	comparison, err := CompareToBenchmark(returns, "SPY", prices)
+	if err != nil {
+		t.Fatalf("failing to compare to SPY. ERR: %v", err)
+	}
+	for _, day := range comparison.Daily {
+		if day.Value.IsNegative() {
+			t.Fatalf("benchmark worth %s on %s", day.Value, day.Date)
+		}
+		// the 10 shares bought with the first deposit fetch 500 of the 800 withdrawn
+		if day.Date == "2023-06-01" {
+			assertDecimal(t, "value", day.Value, "0")
+			assertDecimal(t, "flow", day.Flow, "-500")
+		}
+	}
+	// 10 shares bought again with the second deposit
+	last := comparison.Daily[len(comparison.Daily)-1]
+	assertDecimal(t, "value", last.Value, "660")
+	for _, period := range comparison.Benchmark {
+		if period.Period == "all" {
+			assertDecimal(t, "net flow", period.NetFlow, "1100")
+			assertDecimal(t, "gain", period.Gain, "-440")
+		}
+	}
+}
+
+func TestCompareToBenchmarkErrors(t *testing.T) {
+	source := NewFakeSource()
+	source.AddTransfer("2023-01-02", 1000)
+	prices := fakePrices{"SPY": {{date: "2023-06-30", price: dec("110")}}}
+	returns, err := ProcessReturns(context.Background(), source, prices, "2023-12-31")
+	if err != nil {
+		t.Fatalf("failing to process returns. ERR: %v", err)
+	}
+	if _, err := CompareToBenchmark(returns, "SPY", nil); err == nil {
+		t.Errorf("compared without daily closes")
+	}
+	// the deposit has no close to buy at
+	if _, err := CompareToBenchmark(returns, "SPY", prices); err == nil {
+		t.Errorf("compared to SPY without a close on the first deposit")
+	}
+	if _, err := CompareToBenchmark(returns, "QQQ", prices); err == nil {
+		t.Errorf("compared to a symbol without closes")
+	}
+}
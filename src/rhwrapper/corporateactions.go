@@ -0,0 +1,317 @@
+package rhwrapper
+
+// corporate actions besides splits, applied to the open lots in date order
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type CorporateActionType string
+
+const (
+	Merger     CorporateActionType = "merger"       // shares exchanged for NewTicker shares and/or cash
+	Spinoff    CorporateActionType = "spinoff"      // NewTicker shares distributed, part of the basis moves to them
+	CashInLieu CorporateActionType = "cash in lieu" // fractional shares paid out in cash, e.g. after a reverse split
+	Worthless  CorporateActionType = "worthless"    // delisted or bankrupt with nothing paid out
+)
+
+var CorporateActionTypes = []CorporateActionType{Merger, Spinoff, CashInLieu, Worthless}
+
+/*
+CorporateAction on Ticker (the current symbol), quantities are in today's split adjusted shares
+
+	merger        Ratio NewTicker shares and Cash per share. Cash only is a taxable sale, stock only carries the
+	              basis and holding period over, with both the gain is recognized up to the cash (Price is the
+	              NewTicker share price, needed to work out the gain)
+	spinoff       Ratio NewTicker shares per share, BasisPercent of the basis moves to them
+	cash in lieu  Qty shares sold at Cash per share, the fractional part of the position when Qty is 0
+	worthless     every lot disposed of for nothing on the last day of the year of Date, as the IRS treats it
+*/
+type CorporateAction struct {
+	Type         CorporateActionType `json:"type"`
+	Ticker       string              `json:"ticker"`
+	Date         string              `json:"date"` // 2006-01-02, first day the action is in effect
+	NewTicker    string              `json:"newTicker"`
+	Ratio        decimal.Decimal     `json:"ratio"`
+	Cash         decimal.Decimal     `json:"cash"`
+	Price        decimal.Decimal     `json:"price"`
+	BasisPercent decimal.Decimal     `json:"basisPercent"`
+	Qty          decimal.Decimal     `json:"qty"`
+}
+
+// date the action hits the lots
+func (a CorporateAction) effectiveDate() string {
+	if a.Type == Worthless && len(a.Date) >= 4 {
+		return a.Date[:4] + "-12-31"
+	}
+	return a.Date
+}
+
+func (a CorporateAction) validate() error {
+	if a.Ticker == "" {
+		return fmt.Errorf("%s needs a ticker", a.Type)
+	}
+	if _, err := time.Parse("2006-01-02", a.Date); err != nil {
+		return fmt.Errorf("%s of %s has invalid date %s", a.Type, a.Ticker, a.Date)
+	}
+	switch a.Type {
+	case Merger:
+		if a.Ratio.IsPositive() && a.NewTicker == "" {
+			return fmt.Errorf("merger of %s needs the newTicker its shares are exchanged for", a.Ticker)
+		}
+		if !a.Ratio.IsPositive() && !a.Cash.IsPositive() {
+			return fmt.Errorf("merger of %s needs a ratio, cash or both", a.Ticker)
+		}
+		if a.Ratio.IsPositive() && a.Cash.IsPositive() && !a.Price.IsPositive() {
+			return fmt.Errorf("merger of %s pays stock and cash, it needs the price of %s", a.Ticker, a.NewTicker)
+		}
+	case Spinoff:
+		if a.NewTicker == "" || !a.Ratio.IsPositive() {
+			return fmt.Errorf("spinoff of %s needs a newTicker and ratio", a.Ticker)
+		}
+		if a.BasisPercent.IsNegative() || a.BasisPercent.GreaterThan(decimal.NewFromInt(100)) {
+			return fmt.Errorf("spinoff of %s needs a basisPercent between 0 and 100", a.Ticker)
+		}
+	case CashInLieu:
+		if a.Qty.IsNegative() || a.Cash.IsNegative() {
+			return fmt.Errorf("cash in lieu of %s needs a positive qty and cash", a.Ticker)
+		}
+	case Worthless:
+	default:
+		return fmt.Errorf("unknown corporate action %s", a.Type)
+	}
+	return nil
+}
+
+func parseActionDecimal(value string) (decimal.Decimal, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return decimal.Zero, nil
+	}
+	return decimal.NewFromString(value)
+}
+
+/*
+Load corporate actions from a json or csv file
+
+json: [{"type": "merger", "ticker": "ATVI", "date": "2023-10-13", "cash": 95}]
+csv:  type,ticker,date,newTicker,ratio,cash,price,basisPercent,qty with a header row, unused columns left empty
+*/
+func LoadCorporateActions(path string) ([]CorporateAction, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failing to read corporate actions. ERR: %v", err)
+	}
+	actions := []CorporateAction{}
+	if strings.ToLower(filepath.Ext(path)) != ".csv" {
+		if err := json.Unmarshal(data, &actions); err != nil {
+			return nil, fmt.Errorf("failing to parse corporate actions. ERR: %v", err)
+		}
+	} else {
+		rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("failing to parse corporate actions. ERR: %v", err)
+		}
+		for i, row := range rows {
+			if i == 0 {
+				continue // header
+			}
+			if len(row) < 9 {
+				return nil, fmt.Errorf("corporate action row %d needs type,ticker,date,newTicker,ratio,cash,price,basisPercent,qty", i+1)
+			}
+			action := CorporateAction{
+				Type:      CorporateActionType(strings.ToLower(strings.TrimSpace(row[0]))),
+				Ticker:    strings.TrimSpace(row[1]),
+				Date:      strings.TrimSpace(row[2]),
+				NewTicker: strings.TrimSpace(row[3]),
+			}
+			for col, field := range []*decimal.Decimal{&action.Ratio, &action.Cash, &action.Price, &action.BasisPercent, &action.Qty} {
+				if *field, err = parseActionDecimal(row[col+4]); err != nil {
+					return nil, fmt.Errorf("corporate action row %d has invalid %s %s", i+1, rows[0][col+4], row[col+4])
+				}
+			}
+			actions = append(actions, action)
+		}
+	}
+	for i := range actions {
+		actions[i].Ticker = strings.ToUpper(actions[i].Ticker)
+		actions[i].NewTicker = strings.ToUpper(actions[i].NewTicker)
+		if err := actions[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	return actions, nil
+}
+
+/*
+Apply the corporate actions in effect on or before asOf (2006-01-02), trades dated asOf come after the action
+*/
+func (r *earningsRun) applyCorporateActions(asOf string) error {
+	sort.SliceStable(r.corporateActions, func(i, j int) bool {
+		return r.corporateActions[i].effectiveDate() < r.corporateActions[j].effectiveDate()
+	})
+	pending := []CorporateAction{}
+	for _, action := range r.corporateActions {
+		if action.effectiveDate() > asOf {
+			pending = append(pending, action)
+			continue
+		}
+		if err := r.applyCorporateAction(action); err != nil {
+			return err
+		}
+	}
+	r.corporateActions = pending
+	return nil
+}
+
+func (r *earningsRun) applyCorporateAction(action CorporateAction) error {
+	date := action.effectiveDate()
+	openQty := decimal.Zero
+	for _, lot := range r.profitsMap[action.Ticker] {
+		openQty = openQty.Add(lot.Qty)
+	}
+	if !openQty.IsPositive() {
+		return nil
+	}
+	tag := fmt.Sprintf("%s %s", action.Type, action.Ticker)
+	sale := func(qty decimal.Decimal, price decimal.Decimal) error {
+		return r.sell(action.Ticker, &Lot{
+			Ticker:          action.Ticker,
+			TransactionType: "sell",
+			Qty:             qty,
+			UnitCost:        price,
+			CreatedAt:       date + " 00:00:00",
+			Tag:             tag,
+		})
+	}
+
+	switch action.Type {
+	case Worthless:
+		return r.writeOff(action, tag)
+	case CashInLieu:
+		qty := action.Qty
+		if qty.IsZero() {
+			qty = openQty.Sub(openQty.Floor())
+		}
+		if !qty.IsPositive() {
+			return nil
+		}
+		return sale(decimal.Min(qty, openQty), action.Cash)
+	case Merger:
+		if !action.Ratio.IsPositive() {
+			return sale(openQty, action.Cash)
+		}
+		return r.exchangeLots(action, tag)
+	case Spinoff:
+		share := action.BasisPercent.Div(decimal.NewFromInt(100))
+		for _, lot := range r.profitsMap[action.Ticker] {
+			childQty := lot.Qty.Mul(action.Ratio).Round(qtyPlaces)
+			if !childQty.IsPositive() {
+				continue
+			}
+			// in cents like a merger, what the spun off shares take is what the lot loses
+			basis := amountOf(lot.Qty, lot.UnitCost)
+			childBasis := Cents(basis.Mul(share))
+			// holding period carries over to the spun off shares
+			r.profitsMap[action.NewTicker] = append(r.profitsMap[action.NewTicker], &Lot{
+				Ticker:          action.NewTicker,
+				TransactionType: "buy",
+				Qty:             childQty,
+				UnitCost:        childBasis.DivRound(childQty, pricePlaces),
+				CreatedAt:       lot.CreatedAt,
+				Tag:             tag,
+				HoldingFrom:     lot.HoldingFrom,
+			})
+			lot.UnitCost = basis.Sub(childBasis).DivRound(lot.Qty, pricePlaces)
+		}
+	}
+	return nil
+}
+
+/*
+Write every lot of a worthless security off for nothing. It's treated as sold on the last day of the year (§165(g)),
+not a sale the wash sale rules apply to, so the loss isn't recorded with the wash sale tracker like a sell's
+*/
+func (r *earningsRun) writeOff(action CorporateAction, tag string) error {
+	date := action.effectiveDate()
+	for _, lot := range r.profitsMap[action.Ticker] {
+		r.dividendSale(lot, lot.Qty, date)
+		longTerm, err := IsLongTerm(holdingStart(lot), date)
+		if err != nil {
+			return err
+		}
+		r.bookCorporateActionGain(action.Ticker, lot, date, lot.Qty, decimal.Zero, amountOf(lot.Qty, lot.UnitCost), longTerm, tag)
+	}
+	r.profitsMap[action.Ticker] = []*Lot{}
+	return nil
+}
+
+/*
+Exchange every lot of a merger for NewTicker shares, with any cash recognized as gain up to the lot's gain
+*/
+func (r *earningsRun) exchangeLots(action CorporateAction, tag string) error {
+	date := action.effectiveDate()
+	for _, lot := range r.profitsMap[action.Ticker] {
+		newQty := lot.Qty.Mul(action.Ratio).Round(qtyPlaces)
+		basis := amountOf(lot.Qty, lot.UnitCost)
+		cash := amountOf(lot.Qty, action.Cash)
+		if cash.IsPositive() {
+			realized := cash.Add(amountOf(newQty, action.Price)).Sub(basis)
+			recognized := decimal.Min(decimal.Max(realized, decimal.Zero), cash)
+			longTerm, err := IsLongTerm(holdingStart(lot), date)
+			if err != nil {
+				return err
+			}
+			// the cash not taxed now is a return of basis
+			r.bookCorporateActionGain(action.Ticker, lot, date, lot.Qty, cash, cash.Sub(recognized), longTerm, tag)
+			basis = basis.Sub(cash).Add(recognized)
+		}
+		if !newQty.IsPositive() {
+			continue
+		}
+		r.profitsMap[action.NewTicker] = append(r.profitsMap[action.NewTicker], &Lot{
+			Ticker:          action.NewTicker,
+			TransactionType: "buy",
+			Qty:             newQty,
+			UnitCost:        basis.DivRound(newQty, pricePlaces),
+			CreatedAt:       lot.CreatedAt,
+			Tag:             tag,
+			HoldingFrom:     lot.HoldingFrom,
+		})
+	}
+	r.profitsMap[action.Ticker] = []*Lot{}
+	return nil
+}
+
+func (r *earningsRun) bookCorporateActionGain(ticker string, lot *Lot, date string, qty decimal.Decimal, proceeds decimal.Decimal, basis decimal.Decimal, longTerm bool, tag string) {
+	profitIdx := -1
+	if gain := proceeds.Sub(basis); !gain.IsZero() {
+		r.profitList = append(r.profitList, Profit{
+			Date:   date,
+			Amount: gain,
+			Lcap:   longTerm,
+			Ticker: ticker,
+			Tag:    tag,
+			Method: r.costBasis.MethodFor(ticker),
+		})
+		profitIdx = len(r.profitList) - 1
+	}
+	r.realize(RealizedLot{
+		Description: fmt.Sprintf("%s sh %s", qty, ticker),
+		Ticker:      ticker,
+		Qty:         qty,
+		Acquired:    holdingStart(lot),
+		Sold:        date,
+		Proceeds:    proceeds,
+		Basis:       basis,
+		LongTerm:    longTerm,
+	}, profitIdx)
+}
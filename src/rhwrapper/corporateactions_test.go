@@ -0,0 +1,211 @@
+package rhwrapper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCorporateActions(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		err  bool
+	}{
+		{name: "json", file: "actions.json", data: `[{"type": "merger", "ticker": "atvi", "date": "2023-10-13", "cash": 95}]`},
+		{name: "csv", file: "actions.csv", data: "type,ticker,date,newTicker,ratio,cash,price,basisPercent,qty\nMerger,ATVI,2023-10-13,,,95,,,\n"},
+		{name: "csv missing a column", file: "actions.csv", data: "type,ticker,date\nmerger,ATVI,2023-10-13\n", err: true},
+		{name: "csv invalid cash", file: "actions.csv", data: "type,ticker,date,newTicker,ratio,cash,price,basisPercent,qty\nmerger,ATVI,2023-10-13,,,lots,,,\n", err: true},
+		{name: "unknown type", file: "actions.json", data: `[{"type": "tender", "ticker": "ATVI", "date": "2023-10-13"}]`, err: true},
+		{name: "invalid date", file: "actions.json", data: `[{"type": "worthless", "ticker": "BBBY", "date": "05/03/2023"}]`, err: true},
+		{name: "merger without terms", file: "actions.json", data: `[{"type": "merger", "ticker": "ATVI", "date": "2023-10-13"}]`, err: true},
+		{name: "stock and cash merger without a price", file: "actions.json", data: `[{"type": "merger", "ticker": "ATVI", "date": "2023-10-13", "newTicker": "MSFT", "ratio": 0.5, "cash": 20}]`, err: true},
+		{name: "spinoff over 100 percent", file: "actions.json", data: `[{"type": "spinoff", "ticker": "IBM", "date": "2021-11-04", "newTicker": "KD", "ratio": 0.2, "basisPercent": 120}]`, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.file)
+			if err := os.WriteFile(path, []byte(test.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			actions, err := LoadCorporateActions(path)
+			if test.err {
+				if err == nil {
+					t.Errorf("loaded %+v", actions)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failing to load corporate actions. ERR: %v", err)
+			}
+			if len(actions) != 1 || actions[0].Type != Merger || actions[0].Ticker != "ATVI" || actions[0].Date != "2023-10-13" {
+				t.Fatalf("actions %+v", actions)
+			}
+			assertDecimal(t, "cash", actions[0].Cash, "95")
+		})
+	}
+}
+
+func TestProcessRealizedEarningsCorporateActions(t *testing.T) {
+	tests := []struct {
+		name     string
+		buy      [2]float64 // qty and unit cost of OLD bought on 2022-01-03
+		action   CorporateAction
+		after    func(source *FakeSource) // trades after the action
+		realized []wantLot
+		open     map[string][]wantOpenLot
+		profit   string
+	}{
+		{
+			name:   "cash merger",
+			buy:    [2]float64{10, 80},
+			action: CorporateAction{Type: Merger, Ticker: "OLD", Date: "2023-10-13", Cash: dec("95")},
+			realized: []wantLot{
+				{acquired: "2022-01-03", sold: "2023-10-13", qty: "10", proceeds: "950", basis: "800", longTerm: true},
+			},
+			open:   map[string][]wantOpenLot{"OLD": {}},
+			profit: "150",
+		},
+		{
+			name:     "stock merger carries basis and holding period over",
+			buy:      [2]float64{10, 100},
+			action:   CorporateAction{Type: Merger, Ticker: "OLD", Date: "2023-02-14", NewTicker: "NEW", Ratio: dec("2")},
+			realized: []wantLot{},
+			open: map[string][]wantOpenLot{
+				"OLD": {},
+				"NEW": {{acquired: "2022-01-03", qty: "20", unitCost: "50"}},
+			},
+			profit: "0",
+		},
+		{
+			name:   "stock and cash merger recognizes the gain up to the cash",
+			buy:    [2]float64{10, 100},
+			action: CorporateAction{Type: Merger, Ticker: "OLD", Date: "2023-06-01", NewTicker: "NEW", Ratio: dec("0.5"), Cash: dec("20"), Price: dec("250")},
+			realized: []wantLot{
+				{acquired: "2022-01-03", sold: "2023-06-01", qty: "10", proceeds: "200", basis: "0", longTerm: true},
+			},
+			open: map[string][]wantOpenLot{
+				"OLD": {},
+				"NEW": {{acquired: "2022-01-03", qty: "5", unitCost: "200"}},
+			},
+			profit: "200",
+		},
+		{
+			name:   "stock and cash merger at a loss returns basis",
+			buy:    [2]float64{10, 100},
+			action: CorporateAction{Type: Merger, Ticker: "OLD", Date: "2023-06-01", NewTicker: "NEW", Ratio: dec("0.5"), Cash: dec("20"), Price: dec("150")},
+			realized: []wantLot{
+				{acquired: "2022-01-03", sold: "2023-06-01", qty: "10", proceeds: "200", basis: "200", longTerm: true},
+			},
+			open: map[string][]wantOpenLot{
+				"OLD": {},
+				"NEW": {{acquired: "2022-01-03", qty: "5", unitCost: "160"}},
+			},
+			profit: "0",
+		},
+		{
+			name:     "spinoff moves part of the basis",
+			buy:      [2]float64{10, 100},
+			action:   CorporateAction{Type: Spinoff, Ticker: "OLD", Date: "2022-11-04", NewTicker: "NEW", Ratio: dec("0.5"), BasisPercent: dec("20")},
+			realized: []wantLot{},
+			open: map[string][]wantOpenLot{
+				"OLD": {{acquired: "2022-01-03", qty: "10", unitCost: "80"}},
+				"NEW": {{acquired: "2022-01-03", qty: "5", unitCost: "40"}},
+			},
+			profit: "0",
+		},
+		{
+			name:   "spinoff basis in cents",
+			buy:    [2]float64{7, 14.29},
+			action: CorporateAction{Type: Spinoff, Ticker: "OLD", Date: "2022-11-04", NewTicker: "NEW", Ratio: dec("1"), BasisPercent: dec("50")},
+			after: func(source *FakeSource) {
+				source.AddStockTrade("OLD", "sell", 7, 8, "2023-03-01 10:00:00")
+				source.AddStockTrade("NEW", "sell", 7, 8, "2023-03-01 10:00:00")
+			},
+			// the two halves of the 100.03 basis add back up to it
+			realized: []wantLot{
+				{acquired: "2022-01-03", sold: "2023-03-01", qty: "7", proceeds: "56", basis: "50.01", longTerm: true},
+				{acquired: "2022-01-03", sold: "2023-03-01", qty: "7", proceeds: "56", basis: "50.02", longTerm: true},
+			},
+			open:   map[string][]wantOpenLot{"OLD": {}, "NEW": {}},
+			profit: "11.97",
+		},
+		{
+			name:   "cash in lieu of the fractional share",
+			buy:    [2]float64{10.4, 10},
+			action: CorporateAction{Type: CashInLieu, Ticker: "OLD", Date: "2022-05-02", Cash: dec("12")},
+			realized: []wantLot{
+				{acquired: "2022-01-03", sold: "2022-05-02", qty: "0.4", proceeds: "4.8", basis: "4"},
+			},
+			open:   map[string][]wantOpenLot{"OLD": {{acquired: "2022-01-03", qty: "10", unitCost: "10"}}},
+			profit: "0.8",
+		},
+		{
+			name:   "delisted as worthless on the last day of the year",
+			buy:    [2]float64{10, 5},
+			action: CorporateAction{Type: Worthless, Ticker: "OLD", Date: "2022-08-15"},
+			realized: []wantLot{
+				{acquired: "2022-01-03", sold: "2022-12-31", qty: "10", proceeds: "0", basis: "50"},
+			},
+			open:   map[string][]wantOpenLot{"OLD": {}},
+			profit: "-50",
+		},
+		{
+			name:   "worthless loss isn't washed by a buy back",
+			buy:    [2]float64{10, 5},
+			action: CorporateAction{Type: Worthless, Ticker: "OLD", Date: "2022-08-15"},
+			after: func(source *FakeSource) {
+				source.AddStockTrade("OLD", "buy", 10, 1, "2023-01-10 10:00:00")
+			},
+			realized: []wantLot{
+				{acquired: "2022-01-03", sold: "2022-12-31", qty: "10", proceeds: "0", basis: "50"},
+			},
+			open:   map[string][]wantOpenLot{"OLD": {{acquired: "2023-01-10", qty: "10", unitCost: "1"}}},
+			profit: "-50",
+		},
+		{
+			name:     "not in effect yet",
+			buy:      [2]float64{10, 80},
+			action:   CorporateAction{Type: Merger, Ticker: "OLD", Date: "2099-01-05", Cash: dec("95")},
+			realized: []wantLot{},
+			open:     map[string][]wantOpenLot{"OLD": {{acquired: "2022-01-03", qty: "10", unitCost: "80"}}},
+			profit:   "0",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := NewFakeSource()
+			source.AddStockTrade("OLD", "buy", test.buy[0], test.buy[1], "2022-01-03 10:00:00")
+			source.Actions = []CorporateAction{test.action}
+			if test.after != nil {
+				test.after(source)
+			}
+
+			report := realizedEarnings(t, source, CostBasisSelector{})
+			assertNoWarnings(t, report)
+			assertRealizedLots(t, report.RealizedLots, test.realized)
+			for ticker, open := range test.open {
+				assertOpenLots(t, report, ticker, open)
+			}
+			assertDecimal(t, "profit", amountTotal(t, report.Profit), test.profit)
+		})
+	}
+}
+
+func TestProcessRealizedEarningsCorporateActionOrder(t *testing.T) {
+	// shares bought the day of the merger are already the new ones, the merged position is sold later
+	source := NewFakeSource()
+	source.AddStockTrade("OLD", "buy", 10, 100, "2022-01-03 10:00:00")
+	source.AddStockTrade("NEW", "buy", 5, 60, "2023-02-14 10:00:00")
+	source.AddStockTrade("NEW", "sell", 25, 70, "2023-03-01 10:00:00")
+	source.Actions = []CorporateAction{{Type: Merger, Ticker: "OLD", Date: "2023-02-14", NewTicker: "NEW", Ratio: dec("2")}}
+
+	report := realizedEarnings(t, source, CostBasisSelector{Default: FIFO})
+	assertNoWarnings(t, report)
+	assertRealizedLots(t, report.RealizedLots, []wantLot{
+		{acquired: "2022-01-03", sold: "2023-03-01", qty: "20", proceeds: "1400", basis: "1000", longTerm: true},
+		{acquired: "2023-02-14", sold: "2023-03-01", qty: "5", proceeds: "350", basis: "300"},
+	})
+	assertDecimal(t, "profit", amountTotal(t, report.Profit), "450")
+}
@@ -0,0 +1,193 @@
+package rhwrapper
+
+// cost basis methods used to match sells against open lots
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"os"
+	"sort"
+	"strings"
+)
+
+type CostBasisMethod string
+
+const (
+	FIFO        CostBasisMethod = "FIFO"        // first in, first out
+	LIFO        CostBasisMethod = "LIFO"        // last in, first out
+	HIFO        CostBasisMethod = "HIFO"        // highest cost in, first out
+	SpecificLot CostBasisMethod = "SpecificLot" // user chosen lots, falls back to FIFO
+)
+
+var CostBasisMethods = []CostBasisMethod{FIFO, LIFO, HIFO, SpecificLot}
+
+func ParseCostBasisMethod(method string) (CostBasisMethod, error) {
+	if method == "" {
+		return FIFO, nil
+	}
+	for _, m := range CostBasisMethods {
+		if strings.EqualFold(string(m), method) {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown cost basis method %s", method)
+}
+
+// LotSelection picks the lots (by acquisition date) a sell should consume
+type LotSelection struct {
+	Ticker   string   `json:"ticker"`
+	SellDate string   `json:"sellDate"` // 2006-01-02
+	Lots     []string `json:"lots"`     // acquisition dates 2006-01-02, consumed in order
+}
+
+// CostBasisSelector decides which method is used for each ticker in a run
+type CostBasisSelector struct {
+	Default      CostBasisMethod
+	PerTicker    map[string]CostBasisMethod
+	SpecificLots []LotSelection
+}
+
+func (s CostBasisSelector) MethodFor(ticker string) CostBasisMethod {
+	if method, ok := s.PerTicker[ticker]; ok {
+		return method
+	}
+	if s.Default == "" {
+		return FIFO
+	}
+	return s.Default
+}
+
+func (s CostBasisSelector) LotsFor(ticker string, sellDate string) []string {
+	for _, selection := range s.SpecificLots {
+		if selection.Ticker == ticker && selection.SellDate == sellDate {
+			return selection.Lots
+		}
+	}
+	return nil
+}
+
+func (s CostBasisSelector) String() string {
+	desc := string(s.MethodFor(""))
+	overrides := []string{}
+	for ticker, method := range s.PerTicker {
+		overrides = append(overrides, fmt.Sprintf("%s=%s", ticker, method))
+	}
+	sort.Strings(overrides)
+	if len(overrides) > 0 {
+		desc += " (" + strings.Join(overrides, ", ") + ")"
+	}
+	return desc
+}
+
+/*
+Parse per ticker overrides in the form TSLA:HIFO,AMZN:LIFO
+*/
+func ParseCostBasisOverrides(overrides string) (map[string]CostBasisMethod, error) {
+	perTicker := make(map[string]CostBasisMethod)
+	for _, override := range strings.Split(overrides, ",") {
+		override = strings.TrimSpace(override)
+		if override == "" {
+			continue
+		}
+		parts := strings.Split(override, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid cost basis override %s, expected TICKER:METHOD", override)
+		}
+		method, err := ParseCostBasisMethod(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		perTicker[strings.ToUpper(strings.TrimSpace(parts[0]))] = method
+	}
+	return perTicker, nil
+}
+
+/*
+Load specific lot selections from a json file
+
+[{"ticker": "TSLA", "sellDate": "2023-05-01", "lots": ["2021-01-04", "2022-03-10"]}]
+*/
+func LoadLotSelections(path string) ([]LotSelection, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failing to read lot selections. ERR: %v", err)
+	}
+	var selections []LotSelection
+	if err := json.Unmarshal(data, &selections); err != nil {
+		return nil, fmt.Errorf("failing to parse lot selections. ERR: %v", err)
+	}
+	return selections, nil
+}
+
+// LotMatch is the portion of an open lot consumed by a sell
+type LotMatch struct {
+	Lot *Lot
+	Qty decimal.Decimal
+}
+
+/*
+Returns the order in which open lots are consumed for the given method
+*/
+func lotOrder(lots []*Lot, method CostBasisMethod, chosenLots []string) []int {
+	order := make([]int, len(lots))
+	for i := range lots {
+		order[i] = i
+	}
+	// lots aren't always appended chronologically (assignments use the expiration date)
+	sort.SliceStable(order, func(i, j int) bool {
+		return lots[order[i]].CreatedAt < lots[order[j]].CreatedAt
+	})
+	switch method {
+	case LIFO:
+		sort.SliceStable(order, func(i, j int) bool {
+			return lots[order[i]].CreatedAt > lots[order[j]].CreatedAt
+		})
+	case HIFO:
+		sort.SliceStable(order, func(i, j int) bool {
+			return lots[order[i]].UnitCost.GreaterThan(lots[order[j]].UnitCost)
+		})
+	case SpecificLot:
+		rank := func(idx int) int {
+			for r, date := range chosenLots {
+				if lotDate(lots[idx]) == date {
+					return r
+				}
+			}
+			return len(chosenLots)
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return rank(order[i]) < rank(order[j])
+		})
+	}
+	return order
+}
+
+/*
+Match a sell of qty shares against open lots
+
+Returns the matched portions, the lots still open and the qty that couldn't be matched
+*/
+func MatchLots(lots []*Lot, qty decimal.Decimal, method CostBasisMethod, chosenLots []string) ([]LotMatch, []*Lot, decimal.Decimal) {
+	matches := []LotMatch{}
+	for _, idx := range lotOrder(lots, method, chosenLots) {
+		if !qty.IsPositive() {
+			break
+		}
+		lot := lots[idx]
+		if !lot.Qty.IsPositive() {
+			continue
+		}
+		matchedQty := decimal.Min(lot.Qty, qty)
+		matches = append(matches, LotMatch{Lot: lot, Qty: matchedQty})
+		lot.Qty = lot.Qty.Sub(matchedQty)
+		qty = qty.Sub(matchedQty)
+	}
+	remaining := []*Lot{}
+	for _, lot := range lots {
+		if lot.Qty.IsPositive() {
+			remaining = append(remaining, lot)
+		}
+	}
+	return matches, remaining, qty
+}
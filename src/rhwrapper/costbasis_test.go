@@ -0,0 +1,128 @@
+package rhwrapper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// AAPL bought at 100, 150 and 120
+func testLots() []*Lot {
+	return []*Lot{
+		{Ticker: "AAPL", Qty: dec("10"), UnitCost: dec("100"), CreatedAt: "2022-01-03 10:00:00"},
+		{Ticker: "AAPL", Qty: dec("10"), UnitCost: dec("150"), CreatedAt: "2022-06-01 10:00:00"},
+		{Ticker: "AAPL", Qty: dec("10"), UnitCost: dec("120"), CreatedAt: "2023-03-01 10:00:00"},
+	}
+}
+
+func TestMatchLots(t *testing.T) {
+	tests := []struct {
+		method     CostBasisMethod
+		chosenLots []string
+		matches    []string // unit cost x qty in the order the lots are consumed
+		remaining  []string // unit cost x qty of the lots left open
+	}{
+		{method: FIFO, matches: []string{"100x10", "150x5"}, remaining: []string{"150x5", "120x10"}},
+		{method: LIFO, matches: []string{"120x10", "150x5"}, remaining: []string{"100x10", "150x5"}},
+		{method: HIFO, matches: []string{"150x10", "120x5"}, remaining: []string{"100x10", "120x5"}},
+		{method: SpecificLot, chosenLots: []string{"2023-03-01", "2022-01-03"}, matches: []string{"120x10", "100x5"}, remaining: []string{"100x5", "150x10"}},
+		// lots nobody chose go first in, first out
+		{method: SpecificLot, chosenLots: []string{"2022-06-01"}, matches: []string{"150x10", "100x5"}, remaining: []string{"100x5", "120x10"}},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s %v", test.method, test.chosenLots), func(t *testing.T) {
+			matches, remaining, unmatched := MatchLots(testLots(), dec("15"), test.method, test.chosenLots)
+			if !unmatched.IsZero() {
+				t.Errorf("unmatched %s, want 0", unmatched)
+			}
+			got := []string{}
+			for _, match := range matches {
+				got = append(got, fmt.Sprintf("%vx%v", match.Lot.UnitCost, match.Qty))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.matches) {
+				t.Errorf("matches %v, want %v", got, test.matches)
+			}
+			left := []string{}
+			for _, lot := range remaining {
+				left = append(left, fmt.Sprintf("%vx%v", lot.UnitCost, lot.Qty))
+			}
+			if fmt.Sprint(left) != fmt.Sprint(test.remaining) {
+				t.Errorf("remaining %v, want %v", left, test.remaining)
+			}
+		})
+	}
+}
+
+func TestMatchLotsOversold(t *testing.T) {
+	matches, remaining, unmatched := MatchLots(testLots(), dec("35"), FIFO, nil)
+	if len(matches) != 3 || len(remaining) != 0 || !unmatched.Equal(dec("5")) {
+		t.Errorf("%d matches %d remaining %s unmatched, want 3 0 5", len(matches), len(remaining), unmatched)
+	}
+}
+
+func TestParseCostBasisMethod(t *testing.T) {
+	tests := []struct {
+		method string
+		want   CostBasisMethod
+		err    bool
+	}{
+		{method: "", want: FIFO},
+		{method: "lifo", want: LIFO},
+		{method: "HIFO", want: HIFO},
+		{method: "specificlot", want: SpecificLot},
+		{method: "average", err: true},
+	}
+	for _, test := range tests {
+		got, err := ParseCostBasisMethod(test.method)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("ParseCostBasisMethod(%q) = %q %v, want %q error %v", test.method, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestParseCostBasisOverrides(t *testing.T) {
+	perTicker, err := ParseCostBasisOverrides(" tsla:hifo, AMZN:LIFO,")
+	if err != nil {
+		t.Fatalf("failing to parse overrides. ERR: %v", err)
+	}
+	selector := CostBasisSelector{Default: LIFO, PerTicker: perTicker}
+	if selector.MethodFor("TSLA") != HIFO || selector.MethodFor("AMZN") != LIFO || selector.MethodFor("AAPL") != LIFO {
+		t.Errorf("methods %s %s %s, want HIFO LIFO LIFO", selector.MethodFor("TSLA"), selector.MethodFor("AMZN"), selector.MethodFor("AAPL"))
+	}
+	if selector.String() != "LIFO (AMZN=LIFO, TSLA=HIFO)" {
+		t.Errorf("selector %q", selector.String())
+	}
+	if (CostBasisSelector{}).MethodFor("AAPL") != FIFO {
+		t.Error("an empty selector doesn't default to FIFO")
+	}
+
+	for _, overrides := range []string{"TSLA", "TSLA:HIFO:LIFO", "TSLA:AVERAGE"} {
+		if _, err := ParseCostBasisOverrides(overrides); err == nil {
+			t.Errorf("parsed invalid overrides %q", overrides)
+		}
+	}
+}
+
+func TestLoadLotSelections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lots.json")
+	data := `[{"ticker": "AAPL", "sellDate": "2023-06-15", "lots": ["2023-03-01", "2022-01-03"]}]`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	selections, err := LoadLotSelections(path)
+	if err != nil {
+		t.Fatalf("failing to load lot selections. ERR: %v", err)
+	}
+	selector := CostBasisSelector{Default: SpecificLot, SpecificLots: selections}
+	if lots := selector.LotsFor("AAPL", "2023-06-15"); fmt.Sprint(lots) != "[2023-03-01 2022-01-03]" {
+		t.Errorf("lots %v", lots)
+	}
+	if lots := selector.LotsFor("AAPL", "2023-06-16"); lots != nil {
+		t.Errorf("lots %v for a sell nobody chose lots for", lots)
+	}
+
+	if _, err := LoadLotSelections(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loaded a missing file")
+	}
+}
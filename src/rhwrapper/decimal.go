@@ -0,0 +1,78 @@
+package rhwrapper
+
+// exact money and quantity arithmetic, the robinhood client hands us float64
+
+import (
+	models "github.com/Ryang20718/robinhood-client/models"
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
+const (
+	qtyPlaces   = 6  // robinhood trades fractional shares down to 1e-6
+	pricePlaces = 10 // per share prices after splits and wash sale adjustments
+	moneyPlaces = 2
+)
+
+var optionMultiplier = decimal.NewFromInt(100) // shares per option contract
+
+// quantity from the client, exact to 1e-6
+func QtyFromFloat(qty float64) decimal.Decimal {
+	return decimal.NewFromFloat(qty).Round(qtyPlaces)
+}
+
+// per share price from the client
+func PriceFromFloat(price float64) decimal.Decimal {
+	return decimal.NewFromFloat(price)
+}
+
+/*
+Round to cents the way tax forms expect, half away from zero (2.345 -> 2.35, -2.345 -> -2.35)
+*/
+func Cents(amount decimal.Decimal) decimal.Decimal {
+	return amount.Round(moneyPlaces)
+}
+
+// qty x price rounded to cents
+func amountOf(qty decimal.Decimal, price decimal.Decimal) decimal.Decimal {
+	return Cents(qty.Mul(price))
+}
+
+// Lot is an open stock position, what's left of a buy after sells consumed part of it
+type Lot struct {
+	Ticker          string
+	TransactionType string
+	Qty             decimal.Decimal
+	UnitCost        decimal.Decimal
+	CreatedAt       string
+	Tag             string
+	HoldingFrom     string // 2006-01-02 the holding period counts from when a wash sale tacked on the sold shares' period
+}
+
+func NewLot(transaction models.Transaction) *Lot {
+	return &Lot{
+		Ticker:          transaction.Ticker,
+		TransactionType: transaction.TransactionType,
+		Qty:             QtyFromFloat(transaction.Qty),
+		UnitCost:        PriceFromFloat(transaction.UnitCost),
+		CreatedAt:       transaction.CreatedAt,
+		Tag:             transaction.Tag,
+	}
+}
+
+func lotDate(lot *Lot) string {
+	return tradeDate(lot.CreatedAt)
+}
+
+// 2006-01-02 of a stock (2006-01-02 15:04:05) or option (RFC3339) created at
+func tradeDate(createdAt string) string {
+	return strings.Split(strings.Split(createdAt, " ")[0], "T")[0]
+}
+
+// 2006-01-02 the holding period of lot starts from, for long term and Form 8949. lotDate unless a wash sale moved it
+func holdingStart(lot *Lot) string {
+	if lot.HoldingFrom != "" {
+		return lot.HoldingFrom
+	}
+	return lotDate(lot)
+}
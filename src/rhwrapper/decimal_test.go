@@ -0,0 +1,57 @@
+package rhwrapper
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func dec(value string) decimal.Decimal {
+	return decimal.RequireFromString(value)
+}
+
+func assertDecimal(t *testing.T, name string, got decimal.Decimal, want string) {
+	t.Helper()
+	if !got.Equal(dec(want)) {
+		t.Errorf("%s = %s, want %s", name, got, want)
+	}
+}
+
+func TestCents(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   string
+	}{
+		{amount: "2.345", want: "2.35"},
+		{amount: "-2.345", want: "-2.35"},
+		{amount: "2.344999", want: "2.34"},
+		{amount: "0.005", want: "0.01"},
+		{amount: "100", want: "100"},
+	}
+	for _, test := range tests {
+		assertDecimal(t, "Cents("+test.amount+")", Cents(dec(test.amount)), test.want)
+	}
+}
+
+func TestAmountOf(t *testing.T) {
+	assertDecimal(t, "10 x 130.555", amountOf(dec("10"), dec("130.555")), "1305.55")
+	assertDecimal(t, "0.333333 x 150", amountOf(dec("0.333333"), dec("150")), "50")
+	assertDecimal(t, "3 x 0.1", amountOf(dec("3"), dec("0.1")), "0.3")
+}
+
+func TestQtyFromFloat(t *testing.T) {
+	assertDecimal(t, "0.1 + 0.2", QtyFromFloat(0.1+0.2), "0.3")
+	assertDecimal(t, "fractional share", QtyFromFloat(0.1234567), "0.123457")
+	assertDecimal(t, "price", PriceFromFloat(130.555), "130.555")
+}
+
+func TestMatchLotsFractionalShares(t *testing.T) {
+	// in float64 0.1 + 0.2 shares left dust behind that never matched
+	lots := []*Lot{
+		{Ticker: "AAPL", Qty: QtyFromFloat(0.1), UnitCost: dec("100"), CreatedAt: "2023-01-03 10:00:00"},
+		{Ticker: "AAPL", Qty: QtyFromFloat(0.2), UnitCost: dec("100"), CreatedAt: "2023-01-04 10:00:00"},
+	}
+	matches, remaining, unmatched := MatchLots(lots, QtyFromFloat(0.3), FIFO, nil)
+	if len(matches) != 2 || len(remaining) != 0 || !unmatched.IsZero() {
+		t.Errorf("%d matches %d remaining %s unmatched, want 2 0 0", len(matches), len(remaining), unmatched)
+	}
+}
@@ -0,0 +1,295 @@
+package rhwrapper
+
+// lot bookkeeping shared by stock trades, option assignments and exercises
+
+import (
+	"fmt"
+	models "github.com/Ryang20718/robinhood-client/models"
+	"github.com/shopspring/decimal"
+	"sort"
+	"strings"
+)
+
+// assignment or exercise waiting for its date to come around
+type optionAssignment struct {
+	ticker   string
+	contract OptionContract
+	option   models.OptionTransaction
+	date     string // 2006-01-02 the shares change hands
+}
+
+// contracts of an assigned or exercised leg that changed hands on date
+type assignmentPart struct {
+	date string
+	qty  decimal.Decimal
+}
+
+// assignment and exercise events of a source, handed out to the assigned legs of their contract
+type assignmentDates struct {
+	events map[string][]*OptionEvent // by contract key, oldest first
+}
+
+func newAssignmentDates(events []OptionEvent) *assignmentDates {
+	dates := &assignmentDates{events: make(map[string][]*OptionEvent)}
+	for _, event := range events {
+		event := event
+		key := event.contract().key()
+		dates.events[key] = append(dates.events[key], &event)
+	}
+	for _, contractEvents := range dates.events {
+		sort.SliceStable(contractEvents, func(i, j int) bool {
+			return contractEvents[i].Date < contractEvents[j].Date
+		})
+	}
+	return dates
+}
+
+/*
+Split an assigned opening leg (as traded, before split adjustments) by the events of its contract, oldest first.
+Contracts no event is left for change hands on the expiration date. adjustedQty is the leg's quantity after splits,
+the parts share it out
+*/
+func (d *assignmentDates) take(option models.OptionTransaction, adjustedQty decimal.Decimal) []assignmentPart {
+	traded := QtyFromFloat(option.Qty)
+	if !traded.IsPositive() {
+		return []assignmentPart{{date: option.ExpirationDate, qty: adjustedQty}}
+	}
+	parts := []assignmentPart{}
+	left, adjustedLeft := traded, adjustedQty
+	for _, event := range d.events[ContractFor(option.Ticker, option).key()] {
+		if !left.IsPositive() {
+			break
+		}
+		taken := decimal.Min(event.Qty, left)
+		if !taken.IsPositive() {
+			continue
+		}
+		event.Qty = event.Qty.Sub(taken)
+		left = left.Sub(taken)
+		qty := adjustedLeft
+		if left.IsPositive() {
+			qty = adjustedQty.Mul(taken).DivRound(traded, qtyPlaces)
+		}
+		adjustedLeft = adjustedLeft.Sub(qty)
+		parts = append(parts, assignmentPart{date: event.Date, qty: qty})
+	}
+	if left.IsPositive() {
+		parts = append(parts, assignmentPart{date: option.ExpirationDate, qty: adjustedLeft})
+	}
+	return parts
+}
+
+// state threaded through a realized earnings run
+type earningsRun struct {
+	costBasis    CostBasisSelector
+	profitList   []Profit
+	profitsMap   map[string][]*Lot // keep track of buy/sell
+	washSales    *washSaleTracker
+	optionLedger *OptionLedger
+	assignments  []optionAssignment
+	warnings     []ReconciliationWarning
+	realized     []RealizedLot
+	// corporate actions not applied yet
+	corporateActions []CorporateAction
+	// income events not booked yet
+	income     []*pendingIncome
+	incomeList []Income
+}
+
+func newEarningsRun(costBasis CostBasisSelector, corporateActions []CorporateAction) *earningsRun {
+	return &earningsRun{
+		costBasis:        costBasis,
+		corporateActions: append([]CorporateAction{}, corporateActions...),
+		profitList:       []Profit{},
+		profitsMap:       make(map[string][]*Lot),
+		washSales:        newWashSaleTracker(),
+		optionLedger:     NewOptionLedger(),
+	}
+}
+
+/*
+Add a bought lot to the open lots of ticker
+*/
+func (r *earningsRun) buy(ticker string, lot *Lot) {
+	if lot.Qty.IsNegative() {
+		r.warn(NegativeBalance, ticker, lotDate(lot), lot.Qty, lot.UnitCost, "bought a negative quantity, lot ignored")
+		return
+	}
+	if r.profitsMap[ticker] == nil {
+		r.profitsMap[ticker] = []*Lot{}
+	}
+	r.profitsMap[ticker] = append(r.profitsMap[ticker], r.washSales.stockPurchase(r.profitList, ticker, lot)...)
+}
+
+/*
+Match a sell against the open lots of ticker and book the realized gain
+*/
+func (r *earningsRun) sell(ticker string, stock *Lot) error {
+	createdDate := strings.Split(stock.CreatedAt, " ")[0]
+	method := r.costBasis.MethodFor(ticker)
+	matches, remainingLots, unmatchedQty := MatchLots(r.profitsMap[ticker], stock.Qty, method, r.costBasis.LotsFor(ticker, createdDate))
+	r.profitsMap[ticker] = remainingLots
+	lcapGain, scapGain := decimal.Zero, decimal.Zero
+	lcapLots, scapLots := []RealizedLot{}, []RealizedLot{}
+	// every lot sold at a loss with its holding period, a replacement bought for a wash sale takes over its lot's period
+	lcapPortions, scapPortions := []washPortion{}, []washPortion{}
+	for _, match := range matches {
+		r.dividendSale(match.Lot, match.Qty, createdDate)
+		// proceeds and basis are rounded to cents on their own, like a 1099-B row
+		proceeds := amountOf(match.Qty, stock.UnitCost)
+		basis := amountOf(match.Qty, match.Lot.UnitCost)
+		gain := proceeds.Sub(basis)
+		acquired := holdingStart(match.Lot)
+		longTerm, err := IsLongTerm(acquired, stock.CreatedAt)
+		if err != nil {
+			return err
+		}
+		realized := RealizedLot{
+			Description: fmt.Sprintf("%s sh %s", match.Qty, ticker),
+			Ticker:      ticker,
+			Qty:         match.Qty,
+			Acquired:    acquired,
+			Sold:        createdDate,
+			Proceeds:    proceeds,
+			Basis:       basis,
+			LongTerm:    longTerm,
+		}
+		portion := washPortion{qty: match.Qty, holdingDays: daysBetween(acquired, stock.CreatedAt), loss: gain.Neg()}
+		if longTerm {
+			lcapLots = append(lcapLots, realized)
+			if gain.IsNegative() {
+				lcapPortions = append(lcapPortions, portion)
+			}
+			lcapGain = lcapGain.Add(gain)
+		} else {
+			scapLots = append(scapLots, realized)
+			if gain.IsNegative() {
+				scapPortions = append(scapPortions, portion)
+			}
+			scapGain = scapGain.Add(gain)
+		}
+	}
+	if unmatchedQty.IsPositive() {
+		// history is incomplete, the proceeds aren't profit until the opening lot is known
+		if len(matches) == 0 {
+			r.warn(OrphanSell, ticker, createdDate, unmatchedQty, stock.UnitCost,
+				fmt.Sprintf("sold %s shares with no open lots, add the opening lot to OPENING_LOTS", unmatchedQty))
+		} else {
+			r.warn(Oversold, ticker, createdDate, unmatchedQty, stock.UnitCost,
+				fmt.Sprintf("sold %s more shares than the open lots hold, add the opening lot to OPENING_LOTS", unmatchedQty))
+		}
+	}
+	// a sale at break even still goes on the form, it just has no profit row unless a losing lot in it can be washed
+	lcapIdx, scapIdx := -1, -1
+	if !lcapGain.IsZero() || len(lcapPortions) > 0 {
+		profit := Profit{
+			Date:   createdDate,
+			Amount: lcapGain,
+			Lcap:   true,
+			Ticker: ticker,
+			Tag:    stock.Tag,
+			Method: method,
+		}
+		r.profitList = append(r.profitList, profit)
+		lcapIdx = len(r.profitList) - 1
+		r.profitsMap[ticker] = r.washSales.recordLoss(r.profitList, ticker, lcapIdx, createdDate, lcapPortions, r.profitsMap[ticker], matches)
+	}
+	if !scapGain.IsZero() || len(scapPortions) > 0 {
+		profit := Profit{
+			Date:   createdDate,
+			Amount: scapGain,
+			Lcap:   false,
+			Ticker: ticker,
+			Tag:    stock.Tag,
+			Method: method,
+		}
+		r.profitList = append(r.profitList, profit)
+		scapIdx = len(r.profitList) - 1
+		r.profitsMap[ticker] = r.washSales.recordLoss(r.profitList, ticker, scapIdx, createdDate, scapPortions, r.profitsMap[ticker], matches)
+	}
+	for _, realized := range lcapLots {
+		r.realize(realized, lcapIdx)
+	}
+	for _, realized := range scapLots {
+		r.realize(realized, scapIdx)
+	}
+	return nil
+}
+
+/*
+Turn an assigned or exercised option into the stock trade it causes, on the date it was assigned or exercised
+
+	short put assigned   buy 100 x qty at strike, basis lowered by the premium received
+	long call exercised  buy 100 x qty at strike, basis raised by the premium paid
+	short call assigned  sell 100 x qty from open lots at strike, proceeds raised by the premium received
+	long put exercised   sell 100 x qty from open lots at strike, proceeds lowered by the premium paid
+*/
+func (r *earningsRun) assign(assignment optionAssignment) error {
+	option := assignment.option
+	short := option.TransactionType == "STO"
+	premium := PriceFromFloat(option.UnitCost)
+	if !short {
+		premium = premium.Neg()
+	}
+	event := "exercised"
+	if short {
+		event = "assigned"
+	}
+	strike := PriceFromFloat(assignment.contract.Strike)
+	stock := &Lot{
+		Ticker:    assignment.ticker,
+		Qty:       optionMultiplier.Mul(QtyFromFloat(option.Qty)),
+		CreatedAt: assignment.date,
+		Tag:       fmt.Sprintf("%s %s %s", option.TransactionType, assignment.contract.Type, event),
+	}
+	switch assignment.contract.Type {
+	case "put":
+		if short {
+			stock.TransactionType = "buy"
+			stock.UnitCost = strike.Sub(premium)
+			r.buy(assignment.ticker, stock)
+			return nil
+		}
+		stock.TransactionType = "sell"
+		stock.UnitCost = strike.Add(premium)
+		return r.sell(assignment.ticker, stock)
+	case "call":
+		if short {
+			stock.TransactionType = "sell"
+			stock.UnitCost = strike.Add(premium)
+			return r.sell(assignment.ticker, stock)
+		}
+		stock.TransactionType = "buy"
+		stock.UnitCost = strike.Sub(premium)
+		r.buy(assignment.ticker, stock)
+		return nil
+	}
+	return fmt.Errorf("unknown option type %s for %s", assignment.contract.Type, assignment.contract)
+}
+
+/*
+Apply expirations, assignments and exercises dated before asOf (2006-01-02)
+*/
+func (r *earningsRun) settleOptions(asOf string) error {
+	expired, err := r.optionLedger.Expire(asOf)
+	if err != nil {
+		return err
+	}
+	r.bookOptionCloses(expired)
+
+	sort.SliceStable(r.assignments, func(i, j int) bool {
+		return r.assignments[i].date < r.assignments[j].date
+	})
+	pending := []optionAssignment{}
+	for _, assignment := range r.assignments {
+		if assignment.date >= asOf {
+			pending = append(pending, assignment)
+			continue
+		}
+		if err := r.assign(assignment); err != nil {
+			return err
+		}
+	}
+	r.assignments = pending
+	return nil
+}
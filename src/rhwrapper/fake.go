@@ -0,0 +1,149 @@
+package rhwrapper
+
+// in memory transaction source for scripting trade histories in tests
+
+import (
+	"context"
+	"fmt"
+	models "github.com/Ryang20718/robinhood-client/models"
+	"github.com/shopspring/decimal"
+)
+
+// FakeSource serves trades, symbol changes and splits from memory, nothing touches the network
+type FakeSource struct {
+	Stocks    map[string][]models.Transaction
+	Options   map[string][]models.OptionTransaction
+	Symbols   map[string]string  // original symbol --> current symbol whatever the trade date, unlisted symbols are unchanged
+	Splits    map[string][]Split // unlisted symbols never split
+	Income    []IncomeEvent
+	Transfers []Transfer
+	Actions   []CorporateAction
+	Events    []OptionEvent
+	Err       error // returned by every call when set
+}
+
+func NewFakeSource() *FakeSource {
+	return &FakeSource{
+		Stocks:  make(map[string][]models.Transaction),
+		Options: make(map[string][]models.OptionTransaction),
+		Symbols: make(map[string]string),
+		Splits:  make(map[string][]Split),
+	}
+}
+
+var _ TransactionSource = &FakeSource{}
+
+func (f *FakeSource) FetchRegularTrades(ctx context.Context) (map[string][]models.Transaction, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return f.Stocks, nil
+}
+
+func (f *FakeSource) FetchOptionTrades(ctx context.Context) (map[string][]models.OptionTransaction, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return f.Options, nil
+}
+
+func (f *FakeSource) FetchCurrentTickerSymbol(symbol string, date string) (string, error) {
+	if f.Err != nil {
+		return "", f.Err
+	}
+	if current, ok := f.Symbols[symbol]; ok {
+		return current, nil
+	}
+	return symbol, nil
+}
+
+func (f *FakeSource) FetchStockSplits(symbol string) ([]Split, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return f.Splits[symbol], nil
+}
+
+func (f *FakeSource) FetchIncome(ctx context.Context) ([]IncomeEvent, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return f.Income, nil
+}
+
+func (f *FakeSource) FetchTransfers(ctx context.Context) ([]Transfer, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return f.Transfers, nil
+}
+
+func (f *FakeSource) FetchCorporateActions() ([]CorporateAction, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return f.Actions, nil
+}
+
+func (f *FakeSource) FetchOptionEvents() ([]OptionEvent, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return f.Events, nil
+}
+
+/*
+Script a stock trade, createdAt is 2006-01-02 15:04:05
+*/
+func (f *FakeSource) AddStockTrade(ticker string, transactionType string, qty float64, unitCost float64, createdAt string) {
+	f.Stocks[ticker] = append(f.Stocks[ticker], models.Transaction{
+		Ticker:          ticker,
+		TransactionType: transactionType,
+		Qty:             qty,
+		UnitCost:        unitCost,
+		CreatedAt:       createdAt,
+		Tag:             transactionType,
+	})
+}
+
+/*
+Script an option leg, transactionType is BTO/STO/BTC/STC, optionType call or put and createdAt RFC3339
+*/
+func (f *FakeSource) AddOptionTrade(ticker string, transactionType string, optionType string, qty float64, strike float64, unitCost float64, createdAt string, expiration string, status string) {
+	side := "buy"
+	if transactionType == "STO" || transactionType == "STC" {
+		side = "sell"
+	}
+	f.Options[ticker] = append(f.Options[ticker], models.OptionTransaction{
+		Ticker:          ticker,
+		TransactionType: transactionType,
+		Qty:             qty,
+		StrikePrice:     strike,
+		UnitCost:        unitCost,
+		CreatedAt:       createdAt,
+		ExpirationDate:  expiration,
+		Status:          status,
+		Tag:             fmt.Sprintf("%s %s", side, optionType),
+	})
+}
+
+/*
+Script the assignment or exercise of qty contracts on date 2006-01-02
+*/
+func (f *FakeSource) AddOptionEvent(ticker string, optionType string, strike float64, expiration string, date string, qty float64) {
+	f.Events = append(f.Events, OptionEvent{
+		Ticker:     ticker,
+		Strike:     strike,
+		Expiration: expiration,
+		Type:       optionType,
+		Date:       date,
+		Qty:        decimal.NewFromFloat(qty),
+	})
+}
+
+/*
+Script a deposit (positive amount) or withdrawal (negative amount) on date 2006-01-02
+*/
+func (f *FakeSource) AddTransfer(date string, amount float64) {
+	f.Transfers = append(f.Transfers, Transfer{Date: date, Amount: decimal.NewFromFloat(amount)})
+}
@@ -0,0 +1,587 @@
+package rhwrapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-gota/gota/dataframe"
+	"github.com/shopspring/decimal"
+	"strings"
+	"testing"
+)
+
+// realized lot the way a test expects it, adjustment is empty when there's none
+type wantLot struct {
+	acquired   string
+	sold       string
+	qty        string
+	proceeds   string
+	basis      string
+	adjustment string
+	longTerm   bool
+}
+
+func assertRealizedLots(t *testing.T, got []RealizedLot, want []wantLot) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d realized lots, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		lot := got[i]
+		name := fmt.Sprintf("realized lot %d", i)
+		if lot.Acquired != w.acquired || lot.Sold != w.sold || lot.LongTerm != w.longTerm {
+			t.Errorf("%s acquired %s sold %s long term %v, want %s %s %v", name, lot.Acquired, lot.Sold, lot.LongTerm, w.acquired, w.sold, w.longTerm)
+		}
+		adjustment, code := w.adjustment, washSaleCode
+		if adjustment == "" {
+			adjustment, code = "0", ""
+		}
+		if lot.AdjustmentCode != code {
+			t.Errorf("%s adjustment code %q, want %q", name, lot.AdjustmentCode, code)
+		}
+		assertDecimal(t, name+" qty", lot.Qty, w.qty)
+		assertDecimal(t, name+" proceeds", lot.Proceeds, w.proceeds)
+		assertDecimal(t, name+" basis", lot.Basis, w.basis)
+		assertDecimal(t, name+" adjustment", lot.Adjustment, adjustment)
+		assertDecimal(t, name+" gain", lot.Gain, dec(w.proceeds).Sub(dec(w.basis)).Add(dec(adjustment)).String())
+	}
+}
+
+// open lot the way a test expects it
+type wantOpenLot struct {
+	acquired string // holding period start
+	qty      string
+	unitCost string
+}
+
+func assertOpenLots(t *testing.T, report *EarningsReport, ticker string, want []wantOpenLot) {
+	t.Helper()
+	got := []wantOpenLot{}
+	for _, lot := range report.OpenLots[ticker] {
+		got = append(got, wantOpenLot{acquired: holdingStart(lot), qty: lot.Qty.String(), unitCost: lot.UnitCost.String()})
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d open lots, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		name := fmt.Sprintf("open lot %d", i)
+		if got[i].acquired != w.acquired {
+			t.Errorf("%s acquired %s, want %s", name, got[i].acquired, w.acquired)
+		}
+		assertDecimal(t, name+" qty", dec(got[i].qty), w.qty)
+		assertDecimal(t, name+" unit cost", dec(got[i].unitCost), w.unitCost)
+	}
+}
+
+func realizedEarnings(t *testing.T, source *FakeSource, costBasis CostBasisSelector) *EarningsReport {
+	t.Helper()
+	report, err := ProcessRealizedEarnings(context.Background(), source, costBasis)
+	if err != nil {
+		t.Fatalf("failing to process realized earnings. ERR: %v", err)
+	}
+	return report
+}
+
+// sum of the Amount column of a report table, amounts are cents so the floats convert back exactly
+func amountTotal(t *testing.T, df *dataframe.DataFrame) decimal.Decimal {
+	t.Helper()
+	total := decimal.Zero
+	for _, amount := range df.Col("Amount").Float() {
+		total = total.Add(decimal.NewFromFloat(amount))
+	}
+	return total
+}
+
+func assertNoWarnings(t *testing.T, report *EarningsReport) {
+	t.Helper()
+	if report.Warnings.Nrow() > 0 {
+		t.Errorf("unexpected warnings %v", report.Warnings.Maps())
+	}
+}
+
+// wash sale adjustment the way a test expects it
+type wantWashSale struct {
+	saleDate        string
+	replacementDate string
+	replacement     string
+	qty             string
+	disallowed      string
+	holdingDays     int
+}
+
+func assertWashSales(t *testing.T, report *EarningsReport, want []wantWashSale) {
+	t.Helper()
+	df := report.WashSales
+	if df.Nrow() != len(want) {
+		t.Fatalf("got %d wash sales, want %d: %v", df.Nrow(), len(want), df.Maps())
+	}
+	saleDates, replacementDates, replacements := df.Col("SaleDate").Records(), df.Col("ReplacementDate").Records(), df.Col("Replacement").Records()
+	qtys, disallowed := df.Col("Qty").Float(), df.Col("DisallowedLoss").Float()
+	holdingDays, _ := df.Col("HoldingDays").Int()
+	for i, w := range want {
+		name := fmt.Sprintf("wash sale %d", i)
+		if saleDates[i] != w.saleDate || replacementDates[i] != w.replacementDate || replacements[i] != w.replacement || holdingDays[i] != w.holdingDays {
+			t.Errorf("%s sold %s replaced %s by %s holding %d days, want %s %s %s %d", name, saleDates[i], replacementDates[i], replacements[i], holdingDays[i],
+				w.saleDate, w.replacementDate, w.replacement, w.holdingDays)
+		}
+		assertDecimal(t, name+" qty", decimal.NewFromFloat(qtys[i]), w.qty)
+		assertDecimal(t, name+" disallowed", decimal.NewFromFloat(disallowed[i]), w.disallowed)
+	}
+}
+
+func TestFakeSourceErr(t *testing.T) {
+	source := NewFakeSource()
+	source.AddStockTrade("AAPL", "buy", 10, 100, "2023-01-03 10:00:00")
+	source.Err = errors.New("offline")
+
+	if _, err := ProcessRealizedEarnings(context.Background(), source, CostBasisSelector{}); err != source.Err {
+		t.Errorf("ProcessRealizedEarnings err = %v, want %v", err, source.Err)
+	}
+	if _, err := ProcessReturns(context.Background(), source, nil, "2024-01-02"); err != source.Err {
+		t.Errorf("ProcessReturns err = %v, want %v", err, source.Err)
+	}
+	if _, err := ProcessWheelCampaigns(context.Background(), source, "2024-01-02"); err != source.Err {
+		t.Errorf("ProcessWheelCampaigns err = %v, want %v", err, source.Err)
+	}
+}
+
+func TestFakeSourceSymbolsAndSplits(t *testing.T) {
+	source := NewFakeSource()
+	source.AddStockTrade("FB", "buy", 10, 300, "2021-01-04 10:00:00")
+	source.AddStockTrade("META", "sell", 5, 200, "2023-01-04 10:00:00")
+	source.Symbols["FB"] = "META"
+	// a 2 for 1 split after the buy, looked up by the current symbol
+	source.Splits["META"] = []Split{{Date: "2022-06-01", Numerator: 2, Denominator: 1}}
+
+	report := realizedEarnings(t, source, CostBasisSelector{})
+	assertNoWarnings(t, report)
+	assertRealizedLots(t, report.RealizedLots, []wantLot{
+		{acquired: "2021-01-04", sold: "2023-01-04", qty: "5", proceeds: "1000", basis: "750", longTerm: true},
+	})
+	assertOpenLots(t, report, "META", []wantOpenLot{
+		{acquired: "2021-01-04", qty: "15", unitCost: "150"},
+	})
+}
+
+func TestProcessRealizedEarningsCostBasis(t *testing.T) {
+	tests := []struct {
+		name      string
+		costBasis CostBasisSelector
+		realized  []wantLot
+		open      []wantOpenLot
+		profit    string
+	}{
+		{
+			name:      "FIFO",
+			costBasis: CostBasisSelector{Default: FIFO},
+			realized: []wantLot{
+				{acquired: "2022-01-03", sold: "2023-06-15", qty: "10", proceeds: "1300", basis: "1000", longTerm: true},
+				{acquired: "2022-06-01", sold: "2023-06-15", qty: "5", proceeds: "650", basis: "750", longTerm: true},
+			},
+			open: []wantOpenLot{
+				{acquired: "2022-06-01", qty: "5", unitCost: "150"},
+				{acquired: "2023-03-01", qty: "10", unitCost: "120"},
+			},
+			profit: "200",
+		},
+		{
+			name:      "LIFO",
+			costBasis: CostBasisSelector{Default: LIFO},
+			realized: []wantLot{
+				{acquired: "2022-06-01", sold: "2023-06-15", qty: "5", proceeds: "650", basis: "750", longTerm: true},
+				{acquired: "2023-03-01", sold: "2023-06-15", qty: "10", proceeds: "1300", basis: "1200"},
+			},
+			open: []wantOpenLot{
+				{acquired: "2022-01-03", qty: "10", unitCost: "100"},
+				{acquired: "2022-06-01", qty: "5", unitCost: "150"},
+			},
+			profit: "0",
+		},
+		{
+			name:      "HIFO",
+			costBasis: CostBasisSelector{Default: HIFO},
+			realized: []wantLot{
+				{acquired: "2022-06-01", sold: "2023-06-15", qty: "10", proceeds: "1300", basis: "1500", longTerm: true},
+				{acquired: "2023-03-01", sold: "2023-06-15", qty: "5", proceeds: "650", basis: "600"},
+			},
+			open: []wantOpenLot{
+				{acquired: "2022-01-03", qty: "10", unitCost: "100"},
+				{acquired: "2023-03-01", qty: "5", unitCost: "120"},
+			},
+			profit: "-150",
+		},
+		{
+			name: "SpecificLot",
+			costBasis: CostBasisSelector{
+				Default:      SpecificLot,
+				SpecificLots: []LotSelection{{Ticker: "AAPL", SellDate: "2023-06-15", Lots: []string{"2023-03-01", "2022-01-03"}}},
+			},
+			realized: []wantLot{
+				{acquired: "2022-01-03", sold: "2023-06-15", qty: "5", proceeds: "650", basis: "500", longTerm: true},
+				{acquired: "2023-03-01", sold: "2023-06-15", qty: "10", proceeds: "1300", basis: "1200"},
+			},
+			open: []wantOpenLot{
+				{acquired: "2022-01-03", qty: "5", unitCost: "100"},
+				{acquired: "2022-06-01", qty: "10", unitCost: "150"},
+			},
+			profit: "250",
+		},
+		{
+			name:      "per ticker override",
+			costBasis: CostBasisSelector{Default: FIFO, PerTicker: map[string]CostBasisMethod{"AAPL": HIFO}},
+			realized: []wantLot{
+				{acquired: "2022-06-01", sold: "2023-06-15", qty: "10", proceeds: "1300", basis: "1500", longTerm: true},
+				{acquired: "2023-03-01", sold: "2023-06-15", qty: "5", proceeds: "650", basis: "600"},
+			},
+			open: []wantOpenLot{
+				{acquired: "2022-01-03", qty: "10", unitCost: "100"},
+				{acquired: "2023-03-01", qty: "5", unitCost: "120"},
+			},
+			profit: "-150",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := NewFakeSource()
+			source.AddStockTrade("AAPL", "buy", 10, 100, "2022-01-03 10:00:00")
+			source.AddStockTrade("AAPL", "buy", 10, 150, "2022-06-01 10:00:00")
+			source.AddStockTrade("AAPL", "buy", 10, 120, "2023-03-01 10:00:00")
+			source.AddStockTrade("AAPL", "sell", 15, 130, "2023-06-15 10:00:00")
+
+			report := realizedEarnings(t, source, test.costBasis)
+			assertNoWarnings(t, report)
+			assertRealizedLots(t, report.RealizedLots, test.realized)
+			assertOpenLots(t, report, "AAPL", test.open)
+			assertDecimal(t, "profit", amountTotal(t, report.Profit), test.profit)
+		})
+	}
+}
+
+func TestProcessRealizedEarningsOversold(t *testing.T) {
+	source := NewFakeSource()
+	source.AddStockTrade("MSFT", "buy", 2.5, 200, "2023-01-03 10:00:00")
+	source.AddStockTrade("MSFT", "sell", 4, 210.1, "2023-02-01 10:00:00")
+
+	report := realizedEarnings(t, source, CostBasisSelector{})
+	assertRealizedLots(t, report.RealizedLots, []wantLot{
+		{acquired: "2023-01-03", sold: "2023-02-01", qty: "2.5", proceeds: "525.25", basis: "500"},
+	})
+	assertOpenLots(t, report, "MSFT", []wantOpenLot{})
+	warnings := report.Warnings.Maps()
+	if len(warnings) != 1 || warnings[0]["Kind"] != string(Oversold) {
+		t.Fatalf("warnings %v, want one %s", warnings, Oversold)
+	}
+}
+
+func TestProcessRealizedEarningsWashSales(t *testing.T) {
+	tests := []struct {
+		name      string
+		script    func(source *FakeSource)
+		realized  []wantLot
+		open      []wantOpenLot
+		washSales []wantWashSale
+		profit    string
+	}{
+		{
+			name: "bought back after the loss",
+			script: func(source *FakeSource) {
+				source.AddStockTrade("XYZ", "buy", 10, 100, "2023-01-03 10:00:00")
+				source.AddStockTrade("XYZ", "sell", 10, 80, "2023-02-01 10:00:00")
+				source.AddStockTrade("XYZ", "buy", 10, 85, "2023-02-15 10:00:00")
+				source.AddStockTrade("XYZ", "sell", 10, 90, "2023-03-01 10:00:00")
+			},
+			realized: []wantLot{
+				{acquired: "2023-01-03", sold: "2023-02-01", qty: "10", proceeds: "800", basis: "1000", adjustment: "200"},
+				// the replacement carries the disallowed loss and the 29 days the sold shares were held
+				{acquired: "2023-01-17", sold: "2023-03-01", qty: "10", proceeds: "900", basis: "1050"},
+			},
+			open: []wantOpenLot{},
+			washSales: []wantWashSale{
+				{saleDate: "2023-02-01", replacementDate: "2023-02-15", replacement: "stock", qty: "10", disallowed: "200", holdingDays: 29},
+			},
+			profit: "-150",
+		},
+		{
+			name: "part of the shares bought back",
+			script: func(source *FakeSource) {
+				source.AddStockTrade("XYZ", "buy", 10, 100, "2023-01-03 10:00:00")
+				source.AddStockTrade("XYZ", "sell", 10, 80, "2023-02-01 10:00:00")
+				source.AddStockTrade("XYZ", "buy", 4, 85, "2023-02-10 10:00:00")
+			},
+			realized: []wantLot{
+				{acquired: "2023-01-03", sold: "2023-02-01", qty: "10", proceeds: "800", basis: "1000", adjustment: "80"},
+			},
+			open: []wantOpenLot{
+				{acquired: "2023-01-12", qty: "4", unitCost: "105"},
+			},
+			washSales: []wantWashSale{
+				{saleDate: "2023-02-01", replacementDate: "2023-02-10", replacement: "stock", qty: "4", disallowed: "80", holdingDays: 29},
+			},
+			profit: "-120",
+		},
+		{
+			name: "bought before the loss",
+			script: func(source *FakeSource) {
+				source.AddStockTrade("XYZ", "buy", 10, 100, "2023-01-03 10:00:00")
+				source.AddStockTrade("XYZ", "buy", 5, 90, "2023-01-20 10:00:00")
+				source.AddStockTrade("XYZ", "sell", 10, 80, "2023-01-25 10:00:00")
+			},
+			realized: []wantLot{
+				{acquired: "2023-01-03", sold: "2023-01-25", qty: "10", proceeds: "800", basis: "1000", adjustment: "100"},
+			},
+			open: []wantOpenLot{
+				{acquired: "2022-12-29", qty: "5", unitCost: "110"},
+			},
+			washSales: []wantWashSale{
+				{saleDate: "2023-01-25", replacementDate: "2023-01-20", replacement: "stock", qty: "5", disallowed: "100", holdingDays: 22},
+			},
+			profit: "-100",
+		},
+		{
+			name: "sold lots held for different periods",
+			script: func(source *FakeSource) {
+				source.AddStockTrade("XYZ", "buy", 5, 100, "2023-01-03 10:00:00")
+				source.AddStockTrade("XYZ", "buy", 5, 100, "2023-01-17 10:00:00")
+				source.AddStockTrade("XYZ", "sell", 10, 80, "2023-02-01 10:00:00")
+				source.AddStockTrade("XYZ", "buy", 10, 85, "2023-02-10 10:00:00")
+			},
+			realized: []wantLot{
+				{acquired: "2023-01-03", sold: "2023-02-01", qty: "5", proceeds: "400", basis: "500", adjustment: "100"},
+				{acquired: "2023-01-17", sold: "2023-02-01", qty: "5", proceeds: "400", basis: "500", adjustment: "100"},
+			},
+			// the replacement is split by the sold lot whose holding period it takes over
+			open: []wantOpenLot{
+				{acquired: "2023-01-26", qty: "5", unitCost: "105"},
+				{acquired: "2023-01-12", qty: "5", unitCost: "105"},
+			},
+			washSales: []wantWashSale{
+				{saleDate: "2023-02-01", replacementDate: "2023-02-10", replacement: "stock", qty: "5", disallowed: "100", holdingDays: 29},
+				{saleDate: "2023-02-01", replacementDate: "2023-02-10", replacement: "stock", qty: "5", disallowed: "100", holdingDays: 15},
+			},
+			profit: "0",
+		},
+		{
+			name: "losing lot sold with a larger winning one",
+			script: func(source *FakeSource) {
+				source.AddStockTrade("XYZ", "buy", 10, 100, "2023-01-03 10:00:00")
+				source.AddStockTrade("XYZ", "buy", 10, 50, "2023-01-04 10:00:00")
+				source.AddStockTrade("XYZ", "sell", 20, 80, "2023-02-01 10:00:00")
+				source.AddStockTrade("XYZ", "buy", 10, 85, "2023-02-10 10:00:00")
+			},
+			// the sale nets a 100 gain, the loss on the first lot is still washed
+			realized: []wantLot{
+				{acquired: "2023-01-03", sold: "2023-02-01", qty: "10", proceeds: "800", basis: "1000", adjustment: "200"},
+				{acquired: "2023-01-04", sold: "2023-02-01", qty: "10", proceeds: "800", basis: "500"},
+			},
+			open: []wantOpenLot{
+				{acquired: "2023-01-12", qty: "10", unitCost: "105"},
+			},
+			washSales: []wantWashSale{
+				{saleDate: "2023-02-01", replacementDate: "2023-02-10", replacement: "stock", qty: "10", disallowed: "200", holdingDays: 29},
+			},
+			profit: "300",
+		},
+		{
+			name: "call bought after the loss",
+			script: func(source *FakeSource) {
+				source.AddStockTrade("XYZ", "buy", 100, 50, "2023-01-03 10:00:00")
+				source.AddStockTrade("XYZ", "sell", 100, 45, "2023-02-01 10:00:00")
+				source.AddOptionTrade("XYZ", "BTO", "call", 1, 50, 2, "2023-02-10T15:00:00Z", "2023-03-17", "Open")
+				source.AddOptionTrade("XYZ", "STC", "call", 1, 50, 3, "2023-03-01T15:00:00Z", "2023-03-17", "Open")
+			},
+			realized: []wantLot{
+				{acquired: "2023-01-03", sold: "2023-02-01", qty: "100", proceeds: "4500", basis: "5000", adjustment: "500"},
+				// the deferred loss is taken when the call is sold
+				{acquired: "2023-02-10", sold: "2023-03-01", qty: "1", proceeds: "300", basis: "700"},
+			},
+			open: []wantOpenLot{},
+			washSales: []wantWashSale{
+				{saleDate: "2023-02-01", replacementDate: "2023-02-10", replacement: "buy call", qty: "100", disallowed: "500", holdingDays: 29},
+			},
+			profit: "-400",
+		},
+		{
+			name: "bought back after the window",
+			script: func(source *FakeSource) {
+				source.AddStockTrade("XYZ", "buy", 10, 100, "2023-01-03 10:00:00")
+				source.AddStockTrade("XYZ", "sell", 10, 80, "2023-02-01 10:00:00")
+				source.AddStockTrade("XYZ", "buy", 10, 85, "2023-03-04 10:00:00")
+			},
+			realized: []wantLot{
+				{acquired: "2023-01-03", sold: "2023-02-01", qty: "10", proceeds: "800", basis: "1000"},
+			},
+			open: []wantOpenLot{
+				{acquired: "2023-03-04", qty: "10", unitCost: "85"},
+			},
+			washSales: []wantWashSale{},
+			profit:    "-200",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := NewFakeSource()
+			test.script(source)
+
+			report := realizedEarnings(t, source, CostBasisSelector{Default: FIFO})
+			assertNoWarnings(t, report)
+			assertRealizedLots(t, report.RealizedLots, test.realized)
+			assertOpenLots(t, report, "XYZ", test.open)
+			assertWashSales(t, report, test.washSales)
+			assertDecimal(t, "profit", amountTotal(t, report.Profit), test.profit)
+		})
+	}
+}
+
+func TestProcessRealizedEarningsOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   func(source *FakeSource)
+		realized []wantLot
+		open     []wantOpenLot
+		profit   string
+		warnings []WarningKind
+	}{
+		{
+			name: "short put expires",
+			script: func(source *FakeSource) {
+				source.AddOptionTrade("XYZ", "STO", "put", 1, 50, 2, "2023-01-10T15:00:00Z", "2023-02-17", "Expired")
+			},
+			realized: []wantLot{
+				{acquired: "2023-01-10", sold: "2023-02-17", qty: "1", proceeds: "200", basis: "0"},
+			},
+			open:   []wantOpenLot{},
+			profit: "200",
+		},
+		{
+			name: "short put bought back",
+			script: func(source *FakeSource) {
+				source.AddOptionTrade("XYZ", "STO", "put", 2, 50, 3, "2023-01-10T15:00:00Z", "2023-02-17", "Open")
+				source.AddOptionTrade("XYZ", "BTC", "put", 2, 50, 1, "2023-01-20T15:00:00Z", "2023-02-17", "Open")
+			},
+			realized: []wantLot{
+				{acquired: "2023-01-10", sold: "2023-01-20", qty: "2", proceeds: "600", basis: "200"},
+			},
+			open:   []wantOpenLot{},
+			profit: "400",
+		},
+		{
+			name: "put assigned on the expiration date",
+			script: func(source *FakeSource) {
+				source.AddOptionTrade("XYZ", "STO", "put", 1, 50, 2, "2023-01-10T15:00:00Z", "2023-02-17", "Assigned")
+			},
+			realized: []wantLot{},
+			open: []wantOpenLot{
+				{acquired: "2023-02-17", qty: "100", unitCost: "48"},
+			},
+			profit: "0",
+		},
+		{
+			name: "put assigned early and the shares sold",
+			script: func(source *FakeSource) {
+				source.AddOptionTrade("XYZ", "STO", "put", 1, 50, 2, "2023-01-10T15:00:00Z", "2023-02-17", "Assigned")
+				source.AddOptionEvent("XYZ", "put", 50, "2023-02-17", "2023-02-08", 1)
+				source.AddStockTrade("XYZ", "sell", 100, 55, "2023-03-01 10:00:00")
+			},
+			realized: []wantLot{
+				{acquired: "2023-02-08", sold: "2023-03-01", qty: "100", proceeds: "5500", basis: "4800"},
+			},
+			open:   []wantOpenLot{},
+			profit: "700",
+		},
+		{
+			name: "part of the puts assigned early",
+			script: func(source *FakeSource) {
+				source.AddOptionTrade("XYZ", "STO", "put", 2, 50, 2, "2023-01-10T15:00:00Z", "2023-02-17", "Assigned")
+				source.AddOptionEvent("XYZ", "put", 50, "2023-02-17", "2023-02-08", 1)
+			},
+			realized: []wantLot{},
+			open: []wantOpenLot{
+				{acquired: "2023-02-08", qty: "100", unitCost: "48"},
+				{acquired: "2023-02-17", qty: "100", unitCost: "48"},
+			},
+			profit: "0",
+		},
+		{
+			name: "covered call assigned",
+			script: func(source *FakeSource) {
+				source.AddStockTrade("XYZ", "buy", 100, 50, "2023-01-03 10:00:00")
+				source.AddOptionTrade("XYZ", "STO", "call", 1, 55, 1, "2023-01-10T15:00:00Z", "2023-02-17", "Assigned")
+			},
+			realized: []wantLot{
+				{acquired: "2023-01-03", sold: "2023-02-17", qty: "100", proceeds: "5600", basis: "5000"},
+			},
+			open:   []wantOpenLot{},
+			profit: "600",
+		},
+		{
+			name: "long call exercised",
+			script: func(source *FakeSource) {
+				source.AddOptionTrade("XYZ", "BTO", "call", 1, 40, 3, "2023-01-10T15:00:00Z", "2023-02-17", "Assigned")
+			},
+			realized: []wantLot{},
+			open: []wantOpenLot{
+				{acquired: "2023-02-17", qty: "100", unitCost: "43"},
+			},
+			profit: "0",
+		},
+		{
+			name: "assignment dated after today",
+			script: func(source *FakeSource) {
+				source.AddOptionTrade("XYZ", "STO", "put", 1, 50, 2, "2023-01-10T15:00:00Z", "2099-01-16", "Assigned")
+			},
+			realized: []wantLot{},
+			open:     []wantOpenLot{},
+			profit:   "0",
+		},
+		{
+			name: "closed with no opening leg",
+			script: func(source *FakeSource) {
+				source.AddOptionTrade("XYZ", "BTC", "put", 1, 50, 1, "2023-01-20T15:00:00Z", "2023-02-17", "Open")
+			},
+			realized: []wantLot{},
+			open:     []wantOpenLot{},
+			profit:   "0",
+			warnings: []WarningKind{OrphanOptionClose},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := NewFakeSource()
+			test.script(source)
+
+			report := realizedEarnings(t, source, CostBasisSelector{Default: FIFO})
+			assertRealizedLots(t, report.RealizedLots, test.realized)
+			assertOpenLots(t, report, "XYZ", test.open)
+			assertDecimal(t, "profit", amountTotal(t, report.Profit), test.profit)
+			kinds := report.Warnings.Col("Kind").Records()
+			if fmt.Sprint(kinds) != fmt.Sprint(test.warnings) {
+				t.Errorf("warnings %v, want %v", kinds, test.warnings)
+			}
+		})
+	}
+}
+
+func TestProcessRealizedEarningsActivityCSV(t *testing.T) {
+	csv := testActivityHeader + `3/10/2023,3/10/2023,3/14/2023,KO,Coca-Cola CUSIP: 191216100,SELL,100,$62.00,"$6,200.00"
+2/8/2023,2/8/2023,2/10/2023,KO,KO 2/17/2023 Put $55.00,OASGN,1,,
+2/8/2023,2/8/2023,2/10/2023,KO,Coca-Cola CUSIP: 191216100,BUY,100,$55.00,"($5,500.00)"
+1/3/2023,1/3/2023,1/4/2023,KO,KO 2/17/2023 Put $55.00,STO,1,$1.00,$100.00
+`
+	source := NewFakeSource()
+	var err error
+	if source.Stocks, source.Options, err = ParseActivityCSV(strings.NewReader(csv)); err != nil {
+		t.Fatalf("failing to parse activity csv. ERR: %v", err)
+	}
+	if source.Events, err = ParseActivityOptionEvents(strings.NewReader(csv)); err != nil {
+		t.Fatalf("failing to parse activity csv events. ERR: %v", err)
+	}
+
+	// the early assignment is booked on its date, not the expiration
+	report := realizedEarnings(t, source, CostBasisSelector{})
+	assertNoWarnings(t, report)
+	assertRealizedLots(t, report.RealizedLots, []wantLot{
+		{acquired: "2023-02-08", sold: "2023-03-10", qty: "100", proceeds: "6200", basis: "5400"},
+	})
+	assertOpenLots(t, report, "KO", []wantOpenLot{})
+	assertDecimal(t, "profit", amountTotal(t, report.Profit), "800")
+}
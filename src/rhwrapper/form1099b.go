@@ -0,0 +1,346 @@
+package rhwrapper
+
+// reconcile the realized lots of a run against the 1099-B csv robinhood provides
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/go-gota/gota/dataframe"
+	"github.com/go-gota/gota/series"
+	"github.com/shopspring/decimal"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BrokerLot is one row of the 1099-B
+type BrokerLot struct {
+	Description string
+	Ticker      string
+	Qty         decimal.Decimal
+	Acquired    string // 2006-01-02, empty when the 1099-B says VARIOUS
+	Sold        string // 2006-01-02
+	Proceeds    decimal.Decimal
+	Basis       decimal.Decimal
+	WashSale    decimal.Decimal // wash sale loss disallowed
+}
+
+type LotDiffStatus string
+
+const (
+	LotMatched           LotDiffStatus = "matched"
+	LotMissingFromBroker LotDiffStatus = "missing from 1099-B"   // computed but not on the 1099-B
+	LotMissingFromRun    LotDiffStatus = "missing from computed" // on the 1099-B but not computed
+	LotProceedsMismatch  LotDiffStatus = "proceeds mismatch"
+	LotBasisMismatch     LotDiffStatus = "basis mismatch"
+	LotWashSaleMismatch  LotDiffStatus = "wash sale mismatch"
+)
+
+// LotDiff compares a 1099-B row with the lots computed for it
+type LotDiff struct {
+	Status           LotDiffStatus
+	Ticker           string
+	Acquired         string
+	Sold             string
+	Qty              decimal.Decimal
+	BrokerProceeds   decimal.Decimal
+	ComputedProceeds decimal.Decimal
+	BrokerBasis      decimal.Decimal
+	ComputedBasis    decimal.Decimal
+	BrokerWashSale   decimal.Decimal
+	ComputedWashSale decimal.Decimal
+}
+
+// header names used by the robinhood csv and the common tax software exports
+var form1099BColumns = map[string][]string{
+	"description": {"description", "description of property", "security description", "1a- description of property"},
+	"ticker":      {"symbol", "ticker", "stock symbol"},
+	"qty":         {"quantity", "qty", "shares", "quantity sold"},
+	"acquired":    {"date acquired", "acquired", "1b- date acquired", "dateacquired"},
+	"sold":        {"date sold", "sold", "date sold or disposed", "1c- date sold or disposed", "datesold"},
+	"proceeds":    {"proceeds", "sales price", "1d- proceeds", "gross proceeds"},
+	"basis":       {"cost basis", "cost", "cost or other basis", "1e- cost or other basis", "costbasis"},
+	"washSale":    {"wash sale loss disallowed", "wash sale", "1g- wash sale loss disallowed", "washsalelossdisallowed"},
+}
+
+var form1099BDateLayouts = []string{"01/02/2006", "1/2/2006", "01/02/06", "2006-01-02"}
+
+func parseForm1099BDate(date string) (string, error) {
+	date = strings.TrimSpace(date)
+	if date == "" || strings.EqualFold(date, "various") {
+		return "", nil
+	}
+	for _, layout := range form1099BDateLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t.Format("2006-01-02"), nil
+		}
+	}
+	return "", fmt.Errorf("unknown 1099-B date format %s", date)
+}
+
+// amounts come as 1,234.56 or $1,234.56 and losses sometimes in parentheses
+func parseForm1099BAmount(amount string) (decimal.Decimal, error) {
+	amount = strings.TrimSpace(amount)
+	negative := strings.HasPrefix(amount, "(") && strings.HasSuffix(amount, ")")
+	amount = strings.NewReplacer("$", "", ",", "", "(", "", ")", "").Replace(amount)
+	if amount == "" || amount == "..." {
+		return decimal.Zero, nil
+	}
+	value, err := decimal.NewFromString(amount)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("invalid 1099-B amount %s", amount)
+	}
+	if negative {
+		value = value.Neg()
+	}
+	return value, nil
+}
+
+// the ticker is the first word of the symbol, option symbols look like "AAPL 01/20/2023 CALL $150.00"
+func form1099BTicker(symbol string, description string) string {
+	if fields := strings.Fields(symbol); len(fields) > 0 {
+		return strings.ToUpper(fields[0])
+	}
+	// descriptions like "10 sh AAPL" or "AAPL 01/20/2023 CALL"
+	fields := strings.Fields(description)
+	for i, field := range fields {
+		if strings.EqualFold(field, "sh") && i+1 < len(fields) {
+			return strings.ToUpper(fields[i+1])
+		}
+	}
+	if len(fields) > 0 {
+		return strings.ToUpper(fields[0])
+	}
+	return ""
+}
+
+/*
+Parse the 1099-B csv, header names are matched case insensitively. Rows without a sale date (section titles,
+totals) are skipped
+*/
+func ParseForm1099B(r io.Reader) ([]BrokerLot, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failing to parse 1099-B. ERR: %v", err)
+	}
+	// robinhood puts a few lines of account details above the header
+	headerIdx := -1
+	columns := make(map[string]int)
+	for i, record := range records {
+		found := make(map[string]int)
+		for col, name := range record {
+			name = strings.ToLower(strings.TrimSpace(name))
+			for field, aliases := range form1099BColumns {
+				for _, alias := range aliases {
+					if name == alias {
+						found[field] = col
+					}
+				}
+			}
+		}
+		_, hasSold := found["sold"]
+		_, hasProceeds := found["proceeds"]
+		_, hasBasis := found["basis"]
+		if hasSold && hasProceeds && hasBasis {
+			headerIdx = i
+			columns = found
+			break
+		}
+	}
+	if headerIdx == -1 {
+		return nil, fmt.Errorf("1099-B needs a header with date sold, proceeds and cost basis columns")
+	}
+	value := func(record []string, field string) string {
+		col, ok := columns[field]
+		if !ok || col >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[col])
+	}
+
+	lots := []BrokerLot{}
+	for i, record := range records[headerIdx+1:] {
+		row := headerIdx + i + 2
+		sold, err := parseForm1099BDate(value(record, "sold"))
+		if err != nil || sold == "" {
+			continue
+		}
+		acquired, err := parseForm1099BDate(value(record, "acquired"))
+		if err != nil {
+			return nil, fmt.Errorf("1099-B row %d: %v", row, err)
+		}
+		lot := BrokerLot{
+			Description: value(record, "description"),
+			Ticker:      form1099BTicker(value(record, "ticker"), value(record, "description")),
+			Acquired:    acquired,
+			Sold:        sold,
+		}
+		if lot.Qty, err = parseForm1099BAmount(value(record, "qty")); err != nil {
+			return nil, fmt.Errorf("1099-B row %d: %v", row, err)
+		}
+		if lot.Proceeds, err = parseForm1099BAmount(value(record, "proceeds")); err != nil {
+			return nil, fmt.Errorf("1099-B row %d: %v", row, err)
+		}
+		if lot.Basis, err = parseForm1099BAmount(value(record, "basis")); err != nil {
+			return nil, fmt.Errorf("1099-B row %d: %v", row, err)
+		}
+		if lot.WashSale, err = parseForm1099BAmount(value(record, "washSale")); err != nil {
+			return nil, fmt.Errorf("1099-B row %d: %v", row, err)
+		}
+		lots = append(lots, lot)
+	}
+	return lots, nil
+}
+
+func diffLots(broker BrokerLot, computed []RealizedLot) LotDiff {
+	diff := LotDiff{
+		Status:         LotMatched,
+		Ticker:         broker.Ticker,
+		Acquired:       broker.Acquired,
+		Sold:           broker.Sold,
+		Qty:            broker.Qty,
+		BrokerProceeds: broker.Proceeds,
+		BrokerBasis:    broker.Basis,
+		BrokerWashSale: broker.WashSale,
+	}
+	for _, lot := range computed {
+		diff.ComputedProceeds = diff.ComputedProceeds.Add(lot.Proceeds)
+		diff.ComputedBasis = diff.ComputedBasis.Add(lot.Basis)
+		diff.ComputedWashSale = diff.ComputedWashSale.Add(lot.Adjustment)
+	}
+	// proceeds first, a proceeds difference usually explains the rest
+	if !diff.BrokerProceeds.Equal(diff.ComputedProceeds) {
+		diff.Status = LotProceedsMismatch
+	} else if !diff.BrokerBasis.Equal(diff.ComputedBasis) {
+		diff.Status = LotBasisMismatch
+	} else if !diff.BrokerWashSale.Equal(diff.ComputedWashSale) {
+		diff.Status = LotWashSaleMismatch
+	}
+	return diff
+}
+
+/*
+Reconcile computed lots against the 1099-B lot by lot
+
+Lots are matched on ticker, sale date and acquisition date, preferring the same qty. A 1099-B row dated VARIOUS
+takes every computed lot of the ticker sold that day. Only computed lots sold in a year the 1099-B covers are compared.
+*/
+func Reconcile1099B(computed []RealizedLot, broker []BrokerLot) []LotDiff {
+	years := make(map[string]bool)
+	for _, lot := range broker {
+		years[strings.Split(lot.Sold, "-")[0]] = true
+	}
+	unmatched := []*RealizedLot{}
+	for i := range computed {
+		if years[strings.Split(computed[i].Sold, "-")[0]] {
+			unmatched = append(unmatched, &computed[i])
+		}
+	}
+	take := func(match func(*RealizedLot) bool, limit int) []RealizedLot {
+		taken := []RealizedLot{}
+		remaining := []*RealizedLot{}
+		for _, lot := range unmatched {
+			if (limit < 0 || len(taken) < limit) && match(lot) {
+				taken = append(taken, *lot)
+				continue
+			}
+			remaining = append(remaining, lot)
+		}
+		unmatched = remaining
+		return taken
+	}
+
+	diffs := []LotDiff{}
+	for _, lot := range broker {
+		sameSale := func(computedLot *RealizedLot) bool {
+			return computedLot.Ticker == lot.Ticker && computedLot.Sold == lot.Sold &&
+				(lot.Acquired == "" || computedLot.Acquired == lot.Acquired)
+		}
+		var matched []RealizedLot
+		if lot.Acquired == "" {
+			matched = take(sameSale, -1)
+		} else {
+			matched = take(func(computedLot *RealizedLot) bool {
+				return sameSale(computedLot) && computedLot.Qty.Equal(lot.Qty)
+			}, 1)
+			if len(matched) == 0 {
+				matched = take(sameSale, 1)
+			}
+		}
+		if len(matched) == 0 {
+			diff := diffLots(lot, nil)
+			diff.Status = LotMissingFromRun
+			diffs = append(diffs, diff)
+			continue
+		}
+		diffs = append(diffs, diffLots(lot, matched))
+	}
+	for _, lot := range unmatched {
+		diffs = append(diffs, LotDiff{
+			Status:           LotMissingFromBroker,
+			Ticker:           lot.Ticker,
+			Acquired:         lot.Acquired,
+			Sold:             lot.Sold,
+			Qty:              lot.Qty,
+			ComputedProceeds: lot.Proceeds,
+			ComputedBasis:    lot.Basis,
+			ComputedWashSale: lot.Adjustment,
+		})
+	}
+	sort.SliceStable(diffs, func(i, j int) bool {
+		if diffs[i].Sold != diffs[j].Sold {
+			return diffs[i].Sold < diffs[j].Sold
+		}
+		return diffs[i].Ticker < diffs[j].Ticker
+	})
+	return diffs
+}
+
+/*
+convert 1099-B lot diffs to dataframe
+*/
+func ConvertLotDiffDf(diffs []LotDiff) *dataframe.DataFrame {
+	statuses := series.New([]string{}, series.String, "Status")
+	tickers := series.New([]string{}, series.String, "Ticker")
+	acquired := series.New([]string{}, series.String, "Acquired")
+	sold := series.New([]string{}, series.String, "Sold")
+	qtys := series.New([]float64{}, series.Float, "Qty")
+	brokerProceeds := series.New([]float64{}, series.Float, "BrokerProceeds")
+	computedProceeds := series.New([]float64{}, series.Float, "ComputedProceeds")
+	brokerBasis := series.New([]float64{}, series.Float, "BrokerBasis")
+	computedBasis := series.New([]float64{}, series.Float, "ComputedBasis")
+	brokerWashSale := series.New([]float64{}, series.Float, "BrokerWashSale")
+	computedWashSale := series.New([]float64{}, series.Float, "ComputedWashSale")
+
+	for _, diff := range diffs {
+		statuses.Append(string(diff.Status))
+		tickers.Append(diff.Ticker)
+		acquired.Append(diff.Acquired)
+		sold.Append(diff.Sold)
+		qtys.Append(diff.Qty.InexactFloat64())
+		brokerProceeds.Append(diff.BrokerProceeds.InexactFloat64())
+		computedProceeds.Append(diff.ComputedProceeds.InexactFloat64())
+		brokerBasis.Append(diff.BrokerBasis.InexactFloat64())
+		computedBasis.Append(diff.ComputedBasis.InexactFloat64())
+		brokerWashSale.Append(diff.BrokerWashSale.InexactFloat64())
+		computedWashSale.Append(diff.ComputedWashSale.InexactFloat64())
+	}
+
+	df := dataframe.New(
+		statuses,
+		tickers,
+		acquired,
+		sold,
+		qtys,
+		brokerProceeds,
+		computedProceeds,
+		brokerBasis,
+		computedBasis,
+		brokerWashSale,
+		computedWashSale,
+	)
+	return &df
+}
@@ -0,0 +1,115 @@
+package rhwrapper
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testForm1099B = `Robinhood Securities LLC
+Account 123456789
+Description,Symbol,Quantity,Date Acquired,Date Sold,Proceeds,Cost Basis,Wash Sale Loss Disallowed
+10 sh XYZ,XYZ,10,01/03/2023,02/01/2023,800.00,"1,000.00",200.00
+10 sh AAPL,AAPL,10,01/03/2022,03/01/2023,"$1,305.55","$1,010.00",...
+5 sh TSLA,TSLA,5,VARIOUS,05/01/2023,900.00,(50.00),
+Total,,,,,"3,005.55","1,960.00",200.00
+`
+
+func TestParseForm1099B(t *testing.T) {
+	lots, err := ParseForm1099B(strings.NewReader(testForm1099B))
+	if err != nil {
+		t.Fatalf("failing to parse 1099-B. ERR: %v", err)
+	}
+	want := []struct {
+		ticker   string
+		acquired string
+		sold     string
+		qty      string
+		proceeds string
+		basis    string
+		washSale string
+	}{
+		{ticker: "XYZ", acquired: "2023-01-03", sold: "2023-02-01", qty: "10", proceeds: "800", basis: "1000", washSale: "200"},
+		{ticker: "AAPL", acquired: "2022-01-03", sold: "2023-03-01", qty: "10", proceeds: "1305.55", basis: "1010", washSale: "0"},
+		{ticker: "TSLA", acquired: "", sold: "2023-05-01", qty: "5", proceeds: "900", basis: "-50", washSale: "0"},
+	}
+	if len(lots) != len(want) {
+		t.Fatalf("got %d lots, want %d: %+v", len(lots), len(want), lots)
+	}
+	for i, w := range want {
+		lot := lots[i]
+		name := fmt.Sprintf("lot %d", i)
+		if lot.Ticker != w.ticker || lot.Acquired != w.acquired || lot.Sold != w.sold {
+			t.Errorf("%s %s acquired %q sold %s, want %s %q %s", name, lot.Ticker, lot.Acquired, lot.Sold, w.ticker, w.acquired, w.sold)
+		}
+		assertDecimal(t, name+" qty", lot.Qty, w.qty)
+		assertDecimal(t, name+" proceeds", lot.Proceeds, w.proceeds)
+		assertDecimal(t, name+" basis", lot.Basis, w.basis)
+		assertDecimal(t, name+" wash sale", lot.WashSale, w.washSale)
+	}
+}
+
+func TestParseForm1099BNoHeader(t *testing.T) {
+	if _, err := ParseForm1099B(strings.NewReader("Symbol,Quantity\nXYZ,10\n")); err == nil {
+		t.Error("parsed a 1099-B without date sold, proceeds and cost basis columns")
+	}
+}
+
+func TestParseForm1099BAmount(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   string
+	}{
+		{amount: "$1,305.55", want: "1305.55"},
+		{amount: "(50.00)", want: "-50"},
+		{amount: "...", want: "0"},
+		{amount: "", want: "0"},
+		{amount: "-12.5", want: "-12.5"},
+	}
+	for _, test := range tests {
+		got, err := parseForm1099BAmount(test.amount)
+		if err != nil {
+			t.Errorf("failing to parse %q. ERR: %v", test.amount, err)
+			continue
+		}
+		assertDecimal(t, test.amount, got, test.want)
+	}
+	if _, err := parseForm1099BAmount("ten"); err == nil {
+		t.Error("parsed ten as an amount")
+	}
+}
+
+func TestReconcile1099B(t *testing.T) {
+	broker, err := ParseForm1099B(strings.NewReader(testForm1099B))
+	if err != nil {
+		t.Fatalf("failing to parse 1099-B. ERR: %v", err)
+	}
+
+	diffs := Reconcile1099B(testRealizedLots(), broker)
+	want := []struct {
+		status        LotDiffStatus
+		ticker        string
+		sold          string
+		brokerBasis   string
+		computedBasis string
+	}{
+		{status: LotMatched, ticker: "XYZ", sold: "2023-02-01", brokerBasis: "1000", computedBasis: "1000"},
+		{status: LotBasisMismatch, ticker: "AAPL", sold: "2023-03-01", brokerBasis: "1010", computedBasis: "1000"},
+		{status: LotMissingFromBroker, ticker: "XYZ", sold: "2023-03-01", brokerBasis: "0", computedBasis: "1050"},
+		{status: LotMissingFromRun, ticker: "TSLA", sold: "2023-05-01", brokerBasis: "-50", computedBasis: "0"},
+	}
+	if len(diffs) != len(want) {
+		t.Fatalf("got %d diffs, want %d: %+v", len(diffs), len(want), diffs)
+	}
+	for i, w := range want {
+		diff := diffs[i]
+		name := fmt.Sprintf("diff %d", i)
+		if diff.Status != w.status || diff.Ticker != w.ticker || diff.Sold != w.sold {
+			t.Errorf("%s %s %s %s, want %s %s %s", name, diff.Status, diff.Ticker, diff.Sold, w.status, w.ticker, w.sold)
+		}
+		assertDecimal(t, name+" broker basis", diff.BrokerBasis, w.brokerBasis)
+		assertDecimal(t, name+" computed basis", diff.ComputedBasis, w.computedBasis)
+	}
+	// the 2022 sale isn't on this 1099-B and the matched lot carries its wash sale through
+	assertDecimal(t, "computed wash sale", diffs[0].ComputedWashSale, "200")
+}
@@ -0,0 +1,213 @@
+package rhwrapper
+
+// IRS Form 8949 rows and Schedule D totals built from the realized lots of a run
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"io"
+	"sort"
+	"strings"
+)
+
+const washSaleCode = "W"
+
+// RealizedLot is one disposal the way a 1099-B reports it, a single lot sold or an option closed
+type RealizedLot struct {
+	Description    string // e.g. "10 sh AAPL"
+	Ticker         string
+	Qty            decimal.Decimal // shares, or contracts for options
+	Acquired       string          // 2006-01-02
+	Sold           string          // 2006-01-02
+	Proceeds       decimal.Decimal
+	Basis          decimal.Decimal
+	AdjustmentCode string // W for a wash sale
+	Adjustment     decimal.Decimal
+	Gain           decimal.Decimal // Proceeds - Basis + Adjustment
+	LongTerm       bool
+	profitIdx      int // Profit row the lot was booked into
+}
+
+/*
+Record a realized lot against the Profit row at profitIdx
+*/
+func (r *earningsRun) realize(lot RealizedLot, profitIdx int) {
+	lot.profitIdx = profitIdx
+	lot.Gain = lot.Proceeds.Sub(lot.Basis)
+	r.realized = append(r.realized, lot)
+}
+
+/*
+Book option closes as profit, one row per closed lot
+*/
+func (r *earningsRun) bookOptionCloses(closes []OptionClose) {
+	for i, profit := range optionCloseProfits(closes, r.costBasis) {
+		closed := closes[i]
+		r.profitList = append(r.profitList, profit)
+		r.realize(RealizedLot{
+			Description: fmt.Sprintf("%s %s", closed.Qty, closed.Contract),
+			Ticker:      closed.Contract.Underlying,
+			Qty:         closed.Qty,
+			Acquired:    strings.Split(closed.OpenedAt, "T")[0],
+			Sold:        profit.Date,
+			Proceeds:    closed.Proceeds,
+			Basis:       closed.Basis,
+			LongTerm:    closed.LongTerm,
+		}, len(r.profitList)-1)
+	}
+}
+
+/*
+Realized lots with the wash sale adjustments of their Profit row
+
+A disallowed loss is booked per Profit row, it is spread over the losing lots of the row in the order they were sold
+*/
+func (r *earningsRun) realizedLots() []RealizedLot {
+	lots := make([]RealizedLot, len(r.realized))
+	copy(lots, r.realized)
+	remaining := make(map[int]decimal.Decimal)
+	for i, profit := range r.profitList {
+		if profit.Disallowed.IsPositive() {
+			remaining[i] = profit.Disallowed
+		}
+	}
+	for i := range lots {
+		left, ok := remaining[lots[i].profitIdx]
+		if !ok || !left.IsPositive() || !lots[i].Gain.IsNegative() {
+			continue
+		}
+		adjustment := decimal.Min(left, lots[i].Gain.Neg())
+		remaining[lots[i].profitIdx] = left.Sub(adjustment)
+		lots[i].AdjustmentCode = washSaleCode
+		lots[i].Adjustment = adjustment
+		lots[i].Gain = lots[i].Gain.Add(adjustment)
+	}
+	return lots
+}
+
+// Form8949Totals are the column totals of one part of Form 8949, carried to Schedule D
+type Form8949Totals struct {
+	Proceeds   decimal.Decimal
+	Basis      decimal.Decimal
+	Adjustment decimal.Decimal
+	Gain       decimal.Decimal
+}
+
+func (t *Form8949Totals) add(lot RealizedLot) {
+	t.Proceeds = t.Proceeds.Add(lot.Proceeds)
+	t.Basis = t.Basis.Add(lot.Basis)
+	t.Adjustment = t.Adjustment.Add(lot.Adjustment)
+	t.Gain = t.Gain.Add(lot.Gain)
+}
+
+// Form8949 holds the rows of a tax year, Part I is short term and Part II long term
+type Form8949 struct {
+	Year           string
+	ShortTerm      []RealizedLot
+	LongTerm       []RealizedLot
+	ShortTermTotal Form8949Totals  // Schedule D line 7 before carryovers
+	LongTermTotal  Form8949Totals  // Schedule D line 15 before carryovers
+	NetGain        decimal.Decimal // Schedule D line 16
+}
+
+/*
+Build Form 8949 for the lots sold in year (2006)
+*/
+func NewForm8949(lots []RealizedLot, year string) Form8949 {
+	form := Form8949{
+		Year:      year,
+		ShortTerm: []RealizedLot{},
+		LongTerm:  []RealizedLot{},
+	}
+	for _, lot := range lots {
+		if !strings.HasPrefix(lot.Sold, year+"-") {
+			continue
+		}
+		if lot.LongTerm {
+			form.LongTerm = append(form.LongTerm, lot)
+			form.LongTermTotal.add(lot)
+		} else {
+			form.ShortTerm = append(form.ShortTerm, lot)
+			form.ShortTermTotal.add(lot)
+		}
+	}
+	for _, part := range [][]RealizedLot{form.ShortTerm, form.LongTerm} {
+		sort.SliceStable(part, func(i, j int) bool {
+			if part[i].Sold != part[j].Sold {
+				return part[i].Sold < part[j].Sold
+			}
+			return part[i].Description < part[j].Description
+		})
+	}
+	form.NetGain = form.ShortTermTotal.Gain.Add(form.LongTermTotal.Gain)
+	return form
+}
+
+// dates on the form are MM/DD/YYYY
+func formDate(date string) string {
+	t, err := ParseTradeDate(date)
+	if err != nil {
+		return date
+	}
+	return t.Format("01/02/2006")
+}
+
+/*
+Write the form as csv, the rows of both parts followed by the Schedule D totals
+*/
+func (f Form8949) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	header := []string{"Part", "Description", "Date Acquired", "Date Sold", "Proceeds", "Cost Basis", "Adjustment Code", "Adjustment Amount", "Gain or Loss"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	parts := []struct {
+		name  string
+		lots  []RealizedLot
+		total Form8949Totals
+	}{
+		{"Short Term", f.ShortTerm, f.ShortTermTotal},
+		{"Long Term", f.LongTerm, f.LongTermTotal},
+	}
+	for _, part := range parts {
+		for _, lot := range part.lots {
+			adjustment := ""
+			if !lot.Adjustment.IsZero() {
+				adjustment = lot.Adjustment.StringFixed(moneyPlaces)
+			}
+			row := []string{
+				part.name,
+				lot.Description,
+				formDate(lot.Acquired),
+				formDate(lot.Sold),
+				lot.Proceeds.StringFixed(moneyPlaces),
+				lot.Basis.StringFixed(moneyPlaces),
+				lot.AdjustmentCode,
+				adjustment,
+				lot.Gain.StringFixed(moneyPlaces),
+			}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	for _, part := range parts {
+		row := []string{
+			"Schedule D " + part.name + " Total", "", "", "",
+			part.total.Proceeds.StringFixed(moneyPlaces),
+			part.total.Basis.StringFixed(moneyPlaces),
+			"",
+			part.total.Adjustment.StringFixed(moneyPlaces),
+			part.total.Gain.StringFixed(moneyPlaces),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	if err := writer.Write([]string{"Schedule D Net Gain or Loss", "", "", "", "", "", "", "", f.NetGain.StringFixed(moneyPlaces)}); err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,128 @@
+package rhwrapper
+
+import (
+	"strings"
+	"testing"
+)
+
+// a loss in 2022, a washed loss, its replacement sold and a long term sale in 2023
+func testRealizedLots() []RealizedLot {
+	return []RealizedLot{
+		{Description: "1 sh MSFT", Ticker: "MSFT", Acquired: "2022-01-10", Sold: "2022-06-01", Proceeds: dec("250"), Basis: dec("300"), Gain: dec("-50")},
+		{Description: "10 sh XYZ", Ticker: "XYZ", Acquired: "2023-01-17", Sold: "2023-03-01", Proceeds: dec("900"), Basis: dec("1050"), Gain: dec("-150")},
+		{Description: "10 sh AAPL", Ticker: "AAPL", Acquired: "2022-01-03", Sold: "2023-03-01", Proceeds: dec("1305.55"), Basis: dec("1000"), Gain: dec("305.55"), LongTerm: true},
+		{Description: "10 sh XYZ", Ticker: "XYZ", Acquired: "2023-01-03", Sold: "2023-02-01", Proceeds: dec("800"), Basis: dec("1000"), AdjustmentCode: washSaleCode, Adjustment: dec("200"), Gain: dec("0")},
+	}
+}
+
+func TestRealizedLotsWashAdjustment(t *testing.T) {
+	run := newEarningsRun(CostBasisSelector{}, nil)
+	// one Profit row of two lots sold together, 150 of the net 100 loss disallowed
+	run.profitList = append(run.profitList, Profit{Date: "2023-02-01", Ticker: "XYZ", Amount: dec("-100"), Disallowed: dec("150"), WashSale: true})
+	run.realize(RealizedLot{Description: "5 sh XYZ", Sold: "2023-02-01", Proceeds: dec("400"), Basis: dec("500")}, 0)
+	run.realize(RealizedLot{Description: "5 sh XYZ", Sold: "2023-02-01", Proceeds: dec("400"), Basis: dec("300")}, 0)
+	run.realize(RealizedLot{Description: "5 sh XYZ", Sold: "2023-02-01", Proceeds: dec("400"), Basis: dec("500")}, 0)
+
+	lots := run.realizedLots()
+	wants := []struct {
+		code       string
+		adjustment string
+		gain       string
+	}{
+		{code: washSaleCode, adjustment: "100", gain: "0"},
+		{adjustment: "0", gain: "100"},
+		{code: washSaleCode, adjustment: "50", gain: "-50"},
+	}
+	for i, want := range wants {
+		if lots[i].AdjustmentCode != want.code {
+			t.Errorf("lot %d code %q, want %q", i, lots[i].AdjustmentCode, want.code)
+		}
+		assertDecimal(t, "adjustment", lots[i].Adjustment, want.adjustment)
+		assertDecimal(t, "gain", lots[i].Gain, want.gain)
+	}
+	// the run keeps the unadjusted lots
+	assertDecimal(t, "realized gain", run.realized[0].Gain, "-100")
+}
+
+func TestNewForm8949(t *testing.T) {
+	tests := []struct {
+		year      string
+		shortTerm []string // descriptions in form order
+		longTerm  []string
+		// proceeds, basis, adjustment and gain
+		shortTermTotal [4]string
+		longTermTotal  [4]string
+		netGain        string
+	}{
+		{
+			year:           "2022",
+			shortTerm:      []string{"1 sh MSFT"},
+			longTerm:       []string{},
+			shortTermTotal: [4]string{"250", "300", "0", "-50"},
+			longTermTotal:  [4]string{"0", "0", "0", "0"},
+			netGain:        "-50",
+		},
+		{
+			year:           "2023",
+			shortTerm:      []string{"10 sh XYZ", "10 sh XYZ"},
+			longTerm:       []string{"10 sh AAPL"},
+			shortTermTotal: [4]string{"1700", "2050", "200", "-150"},
+			longTermTotal:  [4]string{"1305.55", "1000", "0", "305.55"},
+			netGain:        "155.55",
+		},
+		{
+			year:           "2024",
+			shortTerm:      []string{},
+			longTerm:       []string{},
+			shortTermTotal: [4]string{"0", "0", "0", "0"},
+			longTermTotal:  [4]string{"0", "0", "0", "0"},
+			netGain:        "0",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.year, func(t *testing.T) {
+			form := NewForm8949(testRealizedLots(), test.year)
+			for _, part := range []struct {
+				name  string
+				lots  []RealizedLot
+				want  []string
+				total Form8949Totals
+				sums  [4]string
+			}{
+				{"short term", form.ShortTerm, test.shortTerm, form.ShortTermTotal, test.shortTermTotal},
+				{"long term", form.LongTerm, test.longTerm, form.LongTermTotal, test.longTermTotal},
+			} {
+				descriptions := []string{}
+				for _, lot := range part.lots {
+					descriptions = append(descriptions, lot.Description)
+				}
+				if strings.Join(descriptions, ",") != strings.Join(part.want, ",") {
+					t.Errorf("%s rows %v, want %v", part.name, descriptions, part.want)
+				}
+				assertDecimal(t, part.name+" proceeds", part.total.Proceeds, part.sums[0])
+				assertDecimal(t, part.name+" basis", part.total.Basis, part.sums[1])
+				assertDecimal(t, part.name+" adjustment", part.total.Adjustment, part.sums[2])
+				assertDecimal(t, part.name+" gain", part.total.Gain, part.sums[3])
+			}
+			assertDecimal(t, "net gain", form.NetGain, test.netGain)
+		})
+	}
+}
+
+func TestForm8949WriteCSV(t *testing.T) {
+	var out strings.Builder
+	if err := NewForm8949(testRealizedLots(), "2023").WriteCSV(&out); err != nil {
+		t.Fatalf("failing to write form 8949. ERR: %v", err)
+	}
+	want := `Part,Description,Date Acquired,Date Sold,Proceeds,Cost Basis,Adjustment Code,Adjustment Amount,Gain or Loss
+Short Term,10 sh XYZ,01/03/2023,02/01/2023,800.00,1000.00,W,200.00,0.00
+Short Term,10 sh XYZ,01/17/2023,03/01/2023,900.00,1050.00,,,-150.00
+Long Term,10 sh AAPL,01/03/2022,03/01/2023,1305.55,1000.00,,,305.55
+Schedule D Short Term Total,,,,1700.00,2050.00,,200.00,-150.00
+Schedule D Long Term Total,,,,1305.55,1000.00,,0.00,305.55
+Schedule D Net Gain or Loss,,,,,,,,155.55
+`
+	if out.String() != want {
+		t.Errorf("form 8949 csv\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,206 @@
+package rhwrapper
+
+// the account rebuilt day by day from the trade history and valued at the close, for the equity curve
+
+import (
+	"fmt"
+	models "github.com/Ryang20718/robinhood-client/models"
+	"github.com/go-gota/gota/dataframe"
+	"github.com/go-gota/gota/series"
+	"github.com/shopspring/decimal"
+	"sort"
+	"time"
+)
+
+// HoldingSnapshot is a position at the close of a day
+type HoldingSnapshot struct {
+	Ticker string
+	Qty    decimal.Decimal // split adjusted to today
+	Price  decimal.Decimal
+	Value  decimal.Decimal
+	Flow   decimal.Decimal // bought that day less sold and income, see DailyValue
+	Priced bool            // false when valued at the last price it traded at
+}
+
+// PortfolioSnapshot is the account at the close of a day
+type PortfolioSnapshot struct {
+	Date     string // 2006-01-02
+	Cash     decimal.Decimal
+	Value    decimal.Decimal // cash and holdings
+	Flow     decimal.Decimal // deposits less withdrawals that day
+	Holdings []HoldingSnapshot
+}
+
+/*
+Rebuild the account every day from its first transfer or trade through asOf (2006-01-02) and value it at the close
+
+Holdings on a day are the shares left from every buy, sale, assignment and reinvested dividend up to it, sorted by
+ticker. Positions without a close from prices, or every position when prices is nil, are valued at the last price
+they traded at. A ticker is listed on the days it's held or has a cash flow
+*/
+func BuildPortfolioHistory(source TransactionSource, stockMap map[string][]models.Transaction, optionMap map[string][]models.OptionTransaction, income []IncomeEvent, transfers []Transfer, prices DailyPriceSource, asOf string) ([]PortfolioSnapshot, error) {
+	entries, err := returnsLedger(source, stockMap, optionMap, income, transfers)
+	if err != nil {
+		return nil, err
+	}
+	history := []PortfolioSnapshot{}
+	if len(entries) == 0 || entries[0].date > asOf {
+		return history, nil
+	}
+	start, err := time.Parse("2006-01-02", entries[0].date)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trade date %s", entries[0].date)
+	}
+
+	cash := decimal.Zero
+	positions := make(map[string]decimal.Decimal)
+	lastPrices := make(map[string]decimal.Decimal)
+	entryIdx := 0
+	for day := start; day.Format("2006-01-02") <= asOf; day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		snapshot := PortfolioSnapshot{Date: date, Holdings: []HoldingSnapshot{}}
+		tickerFlows := make(map[string]decimal.Decimal)
+		for ; entryIdx < len(entries) && entries[entryIdx].date == date; entryIdx++ {
+			entry := entries[entryIdx]
+			cash = cash.Add(entry.cash)
+			if entry.transfer {
+				snapshot.Flow = snapshot.Flow.Add(entry.cash)
+			}
+			if entry.ticker == "" {
+				continue
+			}
+			tickerFlows[entry.ticker] = tickerFlows[entry.ticker].Sub(entry.cash)
+			positions[entry.ticker] = positions[entry.ticker].Add(entry.qty)
+			if !entry.price.IsZero() {
+				lastPrices[entry.ticker] = entry.price
+			}
+		}
+
+		// a history without its deposits, or from the middle of the account's life, would buy with money that isn't
+		// there. Cash going below zero is taken as money put in that day
+		if cash.IsNegative() {
+			snapshot.Flow = snapshot.Flow.Sub(cash)
+			cash = decimal.Zero
+		}
+		snapshot.Cash = cash
+		snapshot.Value = cash
+		for ticker, qty := range positions {
+			flow, traded := tickerFlows[ticker]
+			if qty.IsZero() && !traded {
+				continue
+			}
+			holding := HoldingSnapshot{Ticker: ticker, Qty: qty, Price: lastPrices[ticker], Flow: Cents(flow)}
+			if prices != nil {
+				close, found, err := prices.Close(ticker, date)
+				if err != nil {
+					return nil, err
+				}
+				if found {
+					holding.Price, holding.Priced = close, true
+				}
+			}
+			holding.Value = amountOf(qty, holding.Price)
+			snapshot.Value = snapshot.Value.Add(holding.Value)
+			snapshot.Holdings = append(snapshot.Holdings, holding)
+		}
+		sort.Slice(snapshot.Holdings, func(i, j int) bool {
+			return snapshot.Holdings[i].Ticker < snapshot.Holdings[j].Ticker
+		})
+		snapshot.Cash, snapshot.Value, snapshot.Flow = Cents(snapshot.Cash), Cents(snapshot.Value), Cents(snapshot.Flow)
+		history = append(history, snapshot)
+	}
+	return history, nil
+}
+
+// the account value and flows of every day of history
+func DailyValues(history []PortfolioSnapshot) []DailyValue {
+	daily := []DailyValue{}
+	for _, snapshot := range history {
+		daily = append(daily, DailyValue{Date: snapshot.Date, Value: snapshot.Value, Flow: snapshot.Flow})
+	}
+	return daily
+}
+
+// Drawdown is the deepest fall of the account from a high, measured on its time weighted growth so deposits and
+// withdrawals don't count as gains or losses
+type Drawdown struct {
+	Percent   decimal.Decimal // how far it fell from Peak to Trough, 12.5 for a 12.5% fall
+	Peak      string          // 2006-01-02, empty when it never fell
+	Trough    string
+	Recovered string // first day back at the Peak's level, empty while it's still below
+}
+
+/*
+Largest peak to trough fall of daily
+*/
+func MaxDrawdown(daily []DailyValue) Drawdown {
+	drawdown := Drawdown{}
+	growth, peakGrowth, deepest := 1.0, 1.0, 0.0
+	peak := ""
+	if len(daily) > 0 {
+		peak = daily[0].Date
+	}
+	previous := decimal.Zero
+	for _, day := range daily {
+		in, out := decimal.Zero, decimal.Zero
+		if day.Flow.IsPositive() {
+			in = day.Flow
+		} else {
+			out = day.Flow.Neg()
+		}
+		if invested := previous.Add(in); invested.IsPositive() {
+			growth *= day.Value.Add(out).Div(invested).InexactFloat64()
+		}
+		previous = day.Value
+		if growth >= peakGrowth {
+			// a new high is past the peak of every fall so far
+			if drawdown.Trough != "" && drawdown.Recovered == "" {
+				drawdown.Recovered = day.Date
+			}
+			if growth > peakGrowth {
+				peakGrowth, peak = growth, day.Date
+			}
+			continue
+		}
+		if fall := 1 - growth/peakGrowth; fall > deepest {
+			deepest = fall
+			drawdown = Drawdown{Percent: percentFromRate(fall), Peak: peak, Trough: day.Date}
+		}
+	}
+	return drawdown
+}
+
+/*
+Returns of daily for every month, months with nothing held and no flows are left out
+*/
+func MonthlyReturns(daily []DailyValue) []PeriodReturn {
+	return splitPeriods("", daily, len("2006-01"))
+}
+
+/*
+convert a portfolio history to dataframe, a row per day
+*/
+func ConvertPortfolioHistoryDf(history []PortfolioSnapshot) *dataframe.DataFrame {
+	dates := series.New([]string{}, series.String, "Date")
+	cash := series.New([]float64{}, series.Float, "Cash")
+	values := series.New([]float64{}, series.Float, "Value")
+	flows := series.New([]float64{}, series.Float, "Flow")
+	holdings := series.New([]int{}, series.Int, "Holdings")
+
+	for _, snapshot := range history {
+		dates.Append(snapshot.Date)
+		cash.Append(snapshot.Cash.InexactFloat64())
+		values.Append(snapshot.Value.InexactFloat64())
+		flows.Append(snapshot.Flow.InexactFloat64())
+		holdings.Append(len(snapshot.Holdings))
+	}
+
+	df := dataframe.New(
+		dates,
+		cash,
+		values,
+		flows,
+		holdings,
+	)
+	return &df
+}
@@ -0,0 +1,177 @@
+package rhwrapper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// the snapshots of history as "date cash value flow TICKER qty@price=value" lines, unpriced holdings marked with a *
+func formatHistory(history []PortfolioSnapshot) string {
+	lines := []string{}
+	for _, snapshot := range history {
+		line := fmt.Sprintf("%s %s %s %s", snapshot.Date, snapshot.Cash, snapshot.Value, snapshot.Flow)
+		for _, holding := range snapshot.Holdings {
+			line += fmt.Sprintf(" %s %s@%s=%s", holding.Ticker, holding.Qty, holding.Price, holding.Value)
+			if !holding.Priced {
+				line += "*"
+			}
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func buildHistory(t *testing.T, source *FakeSource, prices DailyPriceSource, asOf string) []PortfolioSnapshot {
+	t.Helper()
+	stockMap, _ := source.FetchRegularTrades(context.Background())
+	optionMap, _ := source.FetchOptionTrades(context.Background())
+	history, err := BuildPortfolioHistory(source, stockMap, optionMap, source.Income, source.Transfers, prices, asOf)
+	if err != nil {
+		t.Fatalf("failing to build portfolio history. ERR: %v", err)
+	}
+	return history
+}
+
+func TestBuildPortfolioHistory(t *testing.T) {
+	tests := []struct {
+		name   string
+		script func(source *FakeSource)
+		prices DailyPriceSource
+		asOf   string
+		want   []string
+	}{
+		{
+			name: "weekend",
+			script: func(source *FakeSource) {
+				source.AddTransfer("2023-01-05", 1000)
+				source.AddStockTrade("AAPL", "buy", 5, 100, "2023-01-06 10:00:00")
+			},
+			// no closes on the weekend, friday's carries over
+			prices: fakePrices{"AAPL": {{date: "2023-01-06", price: dec("102")}, {date: "2023-01-09", price: dec("105")}}},
+			asOf:   "2023-01-09",
+			want: []string{
+				"2023-01-05 1000 1000 1000",
+				"2023-01-06 500 1010 0 AAPL 5@102=510",
+				"2023-01-07 500 1010 0 AAPL 5@102=510",
+				"2023-01-08 500 1010 0 AAPL 5@102=510",
+				"2023-01-09 500 1025 0 AAPL 5@105=525",
+			},
+		},
+		{
+			name: "split",
+			script: func(source *FakeSource) {
+				source.AddTransfer("2023-01-02", 1000)
+				source.AddStockTrade("AAPL", "buy", 10, 100, "2023-01-03 10:00:00")
+				source.Splits["AAPL"] = []Split{{Date: "2023-01-05", Numerator: 2, Denominator: 1}}
+			},
+			// the shares are split adjusted from the buy, so is the price they traded at
+			prices: fakePrices{"AAPL": {{date: "2023-01-05", price: dec("51")}}},
+			asOf:   "2023-01-05",
+			want: []string{
+				"2023-01-02 1000 1000 1000",
+				"2023-01-03 0 1000 0 AAPL 20@50=1000*",
+				"2023-01-04 0 1000 0 AAPL 20@50=1000*",
+				"2023-01-05 0 1020 0 AAPL 20@51=1020",
+			},
+		},
+		{
+			name: "transfers",
+			script: func(source *FakeSource) {
+				source.AddTransfer("2023-01-02", 1000)
+				source.AddStockTrade("AAPL", "buy", 10, 100, "2023-01-03 10:00:00")
+				source.AddStockTrade("AAPL", "sell", 10, 110, "2023-01-04 10:00:00")
+				source.AddTransfer("2023-01-05", -600)
+				// more than the account holds, the missing cash is taken as put in that day
+				source.AddStockTrade("MSFT", "buy", 2, 300, "2023-01-06 10:00:00")
+			},
+			asOf: "2023-01-06",
+			want: []string{
+				"2023-01-02 1000 1000 1000",
+				"2023-01-03 0 1000 0 AAPL 10@100=1000*",
+				// sold, listed for the day of the sale
+				"2023-01-04 1100 1100 0 AAPL 0@110=0*",
+				"2023-01-05 500 500 -600",
+				"2023-01-06 0 600 100 MSFT 2@300=600*",
+			},
+		},
+		{
+			name:   "nothing traded",
+			script: func(source *FakeSource) {},
+			asOf:   "2023-01-06",
+		},
+		{
+			name: "first trade after asOf",
+			script: func(source *FakeSource) {
+				source.AddTransfer("2023-01-07", 1000)
+			},
+			asOf: "2023-01-06",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := NewFakeSource()
+			test.script(source)
+			history := buildHistory(t, source, test.prices, test.asOf)
+			if got, want := formatHistory(history), strings.Join(test.want, "\n"); got != want {
+				t.Errorf("history\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestReturnsFromHistory(t *testing.T) {
+	source := NewFakeSource()
+	source.AddTransfer("2023-01-02", 1000)
+	source.AddStockTrade("AAPL", "buy", 10, 100, "2023-01-03 10:00:00")
+	source.AddStockTrade("AAPL", "sell", 10, 110, "2023-01-04 10:00:00")
+	source.AddStockTrade("AAPL", "buy", 10, 100, "2023-01-06 10:00:00")
+	history := buildHistory(t, source, nil, "2023-01-06")
+
+	report := ReturnsFromHistory(history)
+	if got := len(report.Daily); got != len(history) {
+		t.Errorf("%d days, want %d", got, len(history))
+	}
+	// AAPL isn't held on the 5th, its days still line up with the account's
+	assertReturn(t, report.Tickers, wantReturn{ticker: "AAPL", period: "all", start: "2023-01-03", end: "2023-01-06", startValue: "0", endValue: "1000", netFlow: "900", gain: "100", twr: "10", xirr: ""})
+	if want := "AAPL"; strings.Join(report.Unpriced, ",") != want {
+		t.Errorf("unpriced %v, want %s", report.Unpriced, want)
+	}
+}
+
+func TestMaxDrawdown(t *testing.T) {
+	daily := []DailyValue{
+		{Date: "2023-01-02", Value: dec("1000"), Flow: dec("1000")},
+		{Date: "2023-01-03", Value: dec("1200")},
+		{Date: "2023-01-04", Value: dec("900")},
+		// a deposit isn't a recovery
+		{Date: "2023-01-05", Value: dec("1900"), Flow: dec("1000")},
+		{Date: "2023-01-06", Value: dec("2534"), Flow: dec("0")},
+	}
+	drawdown := MaxDrawdown(daily)
+	assertDecimal(t, "drawdown", drawdown.Percent, "25")
+	if drawdown.Peak != "2023-01-03" || drawdown.Trough != "2023-01-04" || drawdown.Recovered != "2023-01-06" {
+		t.Errorf("drawdown %+v, want from 2023-01-03 to 2023-01-04 recovered 2023-01-06", drawdown)
+	}
+	if drawdown := MaxDrawdown(daily[:2]); drawdown.Trough != "" || !drawdown.Percent.IsZero() {
+		t.Errorf("drawdown %+v of a rising account", drawdown)
+	}
+}
+
+func TestMonthlyReturns(t *testing.T) {
+	daily := []DailyValue{
+		{Date: "2023-01-30", Value: dec("1000"), Flow: dec("1000")},
+		{Date: "2023-01-31", Value: dec("1100")},
+		{Date: "2023-02-01", Value: dec("1100")},
+		{Date: "2023-02-28", Value: dec("990")},
+	}
+	months := MonthlyReturns(daily)
+	periods := []string{}
+	for _, month := range months {
+		periods = append(periods, month.Period+" "+month.TWR.String())
+	}
+	if want := "2023-01 10,2023-02 -10"; strings.Join(periods, ",") != want {
+		t.Errorf("monthly returns %v, want %s", periods, want)
+	}
+}
@@ -0,0 +1,451 @@
+package rhwrapper
+
+// dividend, interest and stock lending income, reinvested dividends feed the lot ledger
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	robinhood "github.com/Ryang20718/robinhood-client/client"
+	"github.com/go-gota/gota/dataframe"
+	"github.com/go-gota/gota/series"
+	"github.com/shopspring/decimal"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type IncomeType string
+
+const (
+	Dividend          IncomeType = "dividend" // qualified or not is worked out from the holding period
+	QualifiedDividend IncomeType = "qualified dividend"
+	OrdinaryDividend  IncomeType = "ordinary dividend"
+	StockLending      IncomeType = "stock lending"
+	Interest          IncomeType = "interest"
+)
+
+// shares have to be held more than this many days of the 121 day window that starts this many days before the
+// ex-dividend date for their part of a dividend to be qualified
+const qualifiedHoldingDays = 60
+
+// IncomeEvent is a payment as the broker reports it
+type IncomeEvent struct {
+	Date   string // 2006-01-02, paid
+	ExDate string // 2006-01-02, Date when unknown
+	Ticker string // empty for interest
+	Type   IncomeType
+	Amount decimal.Decimal
+	// dividend reinvestment, the shares bought with it become a lot
+	ReinvestedQty   decimal.Decimal
+	ReinvestedPrice decimal.Decimal
+}
+
+func (e IncomeEvent) exDate() string {
+	if e.ExDate == "" {
+		return e.Date
+	}
+	return e.ExDate
+}
+
+// last day of the qualified dividend holding window
+func (e IncomeEvent) windowEnd() string {
+	exDate, _ := time.Parse("2006-01-02", e.exDate())
+	return exDate.AddDate(0, 0, qualifiedHoldingDays).Format("2006-01-02")
+}
+
+/*
+Days of the holding window of the dividend shares acquired on acquired and held through through (both 2006-01-02) are
+held for, the day they're acquired doesn't count and the day they're sold does
+*/
+func (e IncomeEvent) windowDays(acquired string, through string) int {
+	exDate, _ := time.Parse("2006-01-02", e.exDate())
+	first := exDate.AddDate(0, 0, -qualifiedHoldingDays)
+	if day, err := tradeDay(acquired); err == nil && !day.AddDate(0, 0, 1).Before(first) {
+		first = day.AddDate(0, 0, 1)
+	}
+	last, err := tradeDay(through)
+	if err != nil || last.After(exDate.AddDate(0, 0, qualifiedHoldingDays)) {
+		last = exDate.AddDate(0, 0, qualifiedHoldingDays)
+	}
+	if last.Before(first) {
+		return 0
+	}
+	return int(last.Sub(first).Hours()/24) + 1
+}
+
+// Income is booked income, dividends split into their qualified and ordinary parts
+type Income struct {
+	Date   string
+	Ticker string
+	Type   IncomeType
+	Amount decimal.Decimal
+}
+
+// shares of a lot held going into the ex-dividend date and not sold yet
+type dividendShares struct {
+	lot      *Lot
+	acquired string
+	qty      decimal.Decimal
+}
+
+// income event making its way through the run
+type pendingIncome struct {
+	event        IncomeEvent
+	heldQty      decimal.Decimal // shares held going into the ex-dividend date
+	held         []*dividendShares
+	qualifiedQty decimal.Decimal // of those, sold after more than qualifiedHoldingDays of the window
+	snapshot     bool
+	reinvested   bool
+}
+
+/*
+Apply the income events in effect on or before asOf (2006-01-02)
+
+Holdings are looked at going into the ex-dividend date. Dividends are booked once the holding window is over, a share
+held more than 60 days of it makes its part of the dividend qualified. Reinvested shares are bought on the payment date
+*/
+func (r *earningsRun) applyIncome(asOf string) {
+	pending := []*pendingIncome{}
+	for _, income := range r.income {
+		event := income.event
+		if !income.snapshot && event.exDate() <= asOf {
+			income.snapshot = true
+			if event.Type == Dividend {
+				for _, lot := range r.profitsMap[event.Ticker] {
+					income.heldQty = income.heldQty.Add(lot.Qty)
+					income.held = append(income.held, &dividendShares{lot: lot, acquired: lotDate(lot), qty: lot.Qty})
+				}
+			}
+		}
+		if !income.snapshot || event.Date > asOf {
+			pending = append(pending, income)
+			continue
+		}
+		r.reinvest(income)
+		if event.Type == Dividend && event.windowEnd() >= asOf {
+			pending = append(pending, income)
+			continue
+		}
+		r.bookIncome(income)
+	}
+	r.income = pending
+}
+
+/*
+Book the income paid on or before asOf whose holding window is still open, the shares still held count as held to
+its end
+*/
+func (r *earningsRun) closeIncome(asOf string) {
+	pending := []*pendingIncome{}
+	for _, income := range r.income {
+		if !income.snapshot || income.event.Date > asOf {
+			pending = append(pending, income)
+			continue
+		}
+		r.bookIncome(income)
+	}
+	r.income = pending
+	sort.SliceStable(r.incomeList, func(i, j int) bool {
+		return r.incomeList[i].Date < r.incomeList[j].Date
+	})
+}
+
+/*
+Shares of lot sold on date, the ones held going into an ex-dividend date count towards its qualified part when they
+were held long enough
+*/
+func (r *earningsRun) dividendSale(lot *Lot, qty decimal.Decimal, date string) {
+	for _, income := range r.income {
+		for _, shares := range income.held {
+			if shares.lot != lot || !shares.qty.IsPositive() {
+				continue
+			}
+			sold := decimal.Min(shares.qty, qty)
+			shares.qty = shares.qty.Sub(sold)
+			if income.event.windowDays(shares.acquired, date) > qualifiedHoldingDays {
+				income.qualifiedQty = income.qualifiedQty.Add(sold)
+			}
+		}
+	}
+}
+
+// buy the shares of a reinvested dividend on its payment date
+func (r *earningsRun) reinvest(income *pendingIncome) {
+	event := income.event
+	if !event.ReinvestedQty.IsPositive() || income.reinvested {
+		return
+	}
+	income.reinvested = true
+	r.buy(event.Ticker, &Lot{
+		Ticker:          event.Ticker,
+		TransactionType: "buy",
+		Qty:             event.ReinvestedQty,
+		UnitCost:        event.ReinvestedPrice,
+		CreatedAt:       event.Date + " 00:00:00",
+		Tag:             "drip",
+	})
+}
+
+func (r *earningsRun) bookIncome(income *pendingIncome) {
+	event := income.event
+	r.reinvest(income)
+	if event.Type != Dividend {
+		r.incomeList = append(r.incomeList, Income{Date: event.Date, Ticker: event.Ticker, Type: event.Type, Amount: event.Amount})
+		return
+	}
+	qualifiedQty := income.qualifiedQty
+	for _, shares := range income.held {
+		if shares.qty.IsPositive() && event.windowDays(shares.acquired, event.windowEnd()) > qualifiedHoldingDays {
+			qualifiedQty = qualifiedQty.Add(shares.qty)
+		}
+	}
+	qualified := decimal.Zero
+	if income.heldQty.IsPositive() {
+		qualified = event.Amount.Mul(qualifiedQty).DivRound(income.heldQty, moneyPlaces)
+	}
+	if !qualified.IsZero() {
+		r.incomeList = append(r.incomeList, Income{Date: event.Date, Ticker: event.Ticker, Type: QualifiedDividend, Amount: qualified})
+	}
+	if ordinary := event.Amount.Sub(qualified); !ordinary.IsZero() {
+		r.incomeList = append(r.incomeList, Income{Date: event.Date, Ticker: event.Ticker, Type: OrdinaryDividend, Amount: ordinary})
+	}
+}
+
+/*
+convert income to dataframe
+*/
+func ConvertIncomeDf(incomeList []Income) *dataframe.DataFrame {
+	years := series.New([]string{}, series.String, "Year")
+	dates := series.New([]string{}, series.String, "Date")
+	tickers := series.New([]string{}, series.String, "Ticker")
+	types := series.New([]string{}, series.String, "Type")
+	amounts := series.New([]float64{}, series.Float, "Amount")
+
+	for _, income := range incomeList {
+		years.Append(strings.Split(income.Date, "-")[0])
+		dates.Append(income.Date)
+		tickers.Append(income.Ticker)
+		types.Append(string(income.Type))
+		amounts.Append(income.Amount.InexactFloat64())
+	}
+
+	df := dataframe.New(
+		years,
+		dates,
+		tickers,
+		types,
+		amounts,
+	)
+	return &df
+}
+
+// robinhood amounts come as {"amount": "1.23", "currency_code": "USD"}
+type rhMoney struct {
+	Amount string `json:"amount"`
+}
+
+type rhDividend struct {
+	Instrument              string  `json:"instrument"`
+	Amount                  string  `json:"amount"`
+	State                   string  `json:"state"` // paid, reinvested, pending, voided
+	RecordDate              string  `json:"record_date"`
+	ExDividendDate          string  `json:"ex_dividend_date"` // missing on older dividends
+	PayableDate             string  `json:"payable_date"`
+	DripOrderQuantity       string  `json:"drip_order_quantity"`
+	DripOrderExecutionPrice rhMoney `json:"drip_order_execution_price"`
+}
+
+type rhPayment struct {
+	Amount     rhMoney `json:"amount"`
+	PayDate    string  `json:"pay_date"`
+	Symbol     string  `json:"symbol"`
+	Instrument string  `json:"instrument"`
+	Direction  string  `json:"direction"` // credit or debit
+}
+
+type rhDividendPage struct {
+	Next    *string      `json:"next"`
+	Results []rhDividend `json:"results"`
+}
+
+type rhPaymentPage struct {
+	Next    *string     `json:"next"`
+	Results []rhPayment `json:"results"`
+}
+
+func (h *Hood) fetchPayments(url string) ([]rhPayment, error) {
+	payments := []rhPayment{}
+	for {
+		var page rhPaymentPage
+		if err := h.Cli.GetAndDecode(url, &page); err != nil {
+			return nil, err
+		}
+		for _, payment := range page.Results {
+			if payment.Direction != "debit" {
+				payments = append(payments, payment)
+			}
+		}
+		if page.Next == nil || *page.Next == "" {
+			return payments, nil
+		}
+		url = *page.Next
+	}
+}
+
+// first days trades settled two business days (T+2) and one business day (T+1) after the trade, three before
+const (
+	tPlusTwoSettlement = "2017-09-05"
+	tPlusOneSettlement = "2024-05-28"
+)
+
+/*
+Ex-dividend date of a robinhood dividend. When it isn't in the response it's worked out from the record date: the
+record date itself under T+1 settlement, one business day before it under T+2 and two under T+3. Market holidays
+aren't known, so an ex-date that falls around one may be a day late
+*/
+func exDividendDate(dividend rhDividend) string {
+	if dividend.ExDividendDate != "" {
+		return dividend.ExDividendDate
+	}
+	exDate, err := time.Parse("2006-01-02", dividend.RecordDate)
+	if err != nil {
+		return ""
+	}
+	businessDays := 0
+	if dividend.RecordDate < tPlusTwoSettlement {
+		businessDays = 2
+	} else if dividend.RecordDate < tPlusOneSettlement {
+		businessDays = 1
+	}
+	for businessDays > 0 {
+		exDate = exDate.AddDate(0, 0, -1)
+		if exDate.Weekday() != time.Saturday && exDate.Weekday() != time.Sunday {
+			businessDays -= 1
+		}
+	}
+	return exDate.Format("2006-01-02")
+}
+
+func parseMoney(value string) decimal.Decimal {
+	amount, err := decimal.NewFromString(strings.TrimSpace(value))
+	if err != nil {
+		return decimal.Zero
+	}
+	return amount
+}
+
+/*
+Returns dividends, stock lending payments and cash sweep interest sorted by date
+*/
+func (h *Hood) FetchIncome(ctx context.Context) ([]IncomeEvent, error) {
+	cachedFile := "/tmp/income.list"
+	if os.Getenv("DEV") != "" {
+		// use cache for development purposes
+		if dataFile, err := os.Open(cachedFile); err == nil {
+			defer dataFile.Close()
+			var events []IncomeEvent
+			if err := gob.NewDecoder(dataFile).Decode(&events); err != nil {
+				return nil, err
+			}
+			return events, nil
+		}
+	}
+
+	if h.Store != nil {
+		return h.syncIncome(ctx)
+	}
+	events, err := h.fetchIncome(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv("DEV") != "" {
+		if err := CacheAPICall(cachedFile, events); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// every dividend, stock lending payment and interest payment from robinhood
+func (h *Hood) fetchIncome(ctx context.Context) ([]IncomeEvent, error) {
+	symbols := make(map[string]string) // instrument url --> symbol
+	symbolFor := func(instrument string) (string, error) {
+		if symbol, ok := symbols[instrument]; ok {
+			return symbol, nil
+		}
+		instrumentData, err := h.Cli.GetInstrument(instrument)
+		if err != nil {
+			return "", err
+		}
+		if instrumentData.Symbol == nil {
+			return "", fmt.Errorf("instrument %s has no symbol", instrument)
+		}
+		symbols[instrument] = *instrumentData.Symbol
+		return symbols[instrument], nil
+	}
+
+	dividends := []rhDividend{}
+	url := robinhood.EPBase + "dividends/"
+	for {
+		var page rhDividendPage
+		if err := h.Cli.GetAndDecode(url, &page); err != nil {
+			return nil, fmt.Errorf("failing to fetch dividends. ERR: %v", err)
+		}
+		dividends = append(dividends, page.Results...)
+		if page.Next == nil || *page.Next == "" {
+			break
+		}
+		url = *page.Next
+	}
+	events := []IncomeEvent{}
+	for _, dividend := range dividends {
+		if dividend.State != "paid" && dividend.State != "reinvested" {
+			continue
+		}
+		ticker, err := symbolFor(dividend.Instrument)
+		if err != nil {
+			return nil, err
+		}
+		event := IncomeEvent{
+			Date:   dividend.PayableDate,
+			ExDate: exDividendDate(dividend),
+			Ticker: ticker,
+			Type:   Dividend,
+			Amount: parseMoney(dividend.Amount),
+		}
+		if dividend.State == "reinvested" {
+			event.ReinvestedQty = parseMoney(dividend.DripOrderQuantity)
+			event.ReinvestedPrice = parseMoney(dividend.DripOrderExecutionPrice.Amount)
+		}
+		events = append(events, event)
+	}
+
+	payments, err := h.fetchPayments(robinhood.EPAccounts + "stock_loan_payments/")
+	if err != nil {
+		return nil, fmt.Errorf("failing to fetch stock lending payments. ERR: %v", err)
+	}
+	for _, payment := range payments {
+		ticker := payment.Symbol
+		if ticker == "" && payment.Instrument != "" {
+			if ticker, err = symbolFor(payment.Instrument); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, IncomeEvent{Date: payment.PayDate, Ticker: ticker, Type: StockLending, Amount: parseMoney(payment.Amount.Amount)})
+	}
+
+	interest, err := h.fetchPayments(robinhood.EPAccounts + "sweeps/")
+	if err != nil {
+		return nil, fmt.Errorf("failing to fetch interest payments. ERR: %v", err)
+	}
+	for _, payment := range interest {
+		events = append(events, IncomeEvent{Date: payment.PayDate, Type: Interest, Amount: parseMoney(payment.Amount.Amount)})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Date < events[j].Date
+	})
+	return events, nil
+}
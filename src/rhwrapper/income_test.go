@@ -0,0 +1,171 @@
+package rhwrapper
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// booked income as "date ticker type amount"
+func incomeRows(t *testing.T, report *EarningsReport) []string {
+	t.Helper()
+	rows := []string{}
+	for _, row := range report.Income.Maps() {
+		rows = append(rows, fmt.Sprintf("%s %s %s %v", row["Date"], row["Ticker"], row["Type"], row["Amount"]))
+	}
+	return rows
+}
+
+func TestExDividendDate(t *testing.T) {
+	tests := []struct {
+		name     string
+		dividend rhDividend
+		want     string
+	}{
+		{name: "reported", dividend: rhDividend{ExDividendDate: "2024-08-12", RecordDate: "2024-08-12"}, want: "2024-08-12"},
+		{name: "T+1", dividend: rhDividend{RecordDate: "2024-08-12"}, want: "2024-08-12"},
+		{name: "T+2", dividend: rhDividend{RecordDate: "2023-05-15"}, want: "2023-05-12"}, // Monday, the Friday before
+		{name: "T+3", dividend: rhDividend{RecordDate: "2016-06-07"}, want: "2016-06-03"},
+		{name: "no dates", dividend: rhDividend{}, want: ""},
+	}
+	for _, test := range tests {
+		if got := exDividendDate(test.dividend); got != test.want {
+			t.Errorf("%s: ex-dividend date %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestIncomeEventWindowDays(t *testing.T) {
+	// the window runs from 2023-03-13 through 2023-07-11
+	event := IncomeEvent{Date: "2023-06-09", ExDate: "2023-05-12"}
+	tests := []struct {
+		acquired string
+		through  string
+		want     int
+	}{
+		{acquired: "2023-01-03 10:00:00", through: "2023-07-11", want: 121},
+		{acquired: "2023-01-03 10:00:00", through: "2023-12-29 10:00:00", want: 121},
+		{acquired: "2023-05-01 10:00:00", through: "2023-05-20 10:00:00", want: 19},
+		{acquired: "2023-05-11 10:00:00", through: "2023-07-11", want: 61},
+		{acquired: "2023-05-12 10:00:00", through: "2023-07-11", want: 60}, // bought on the ex-date
+		{acquired: "2023-01-03 10:00:00", through: "2023-03-01 10:00:00", want: 0},
+	}
+	for _, test := range tests {
+		if got := event.windowDays(test.acquired, test.through); got != test.want {
+			t.Errorf("windowDays(%s, %s) = %d, want %d", test.acquired, test.through, got, test.want)
+		}
+	}
+	if got := event.windowEnd(); got != "2023-07-11" {
+		t.Errorf("window end %s", got)
+	}
+}
+
+func TestProcessRealizedEarningsIncome(t *testing.T) {
+	dividend := IncomeEvent{Date: "2023-06-09", ExDate: "2023-05-12", Ticker: "XOM", Type: Dividend, Amount: dec("91")}
+	tests := []struct {
+		name      string
+		costBasis CostBasisSelector
+		trades    [][3]string // type, qty and date of XOM trades at 100
+		income    []IncomeEvent
+		want      []string
+	}{
+		{
+			name:   "held through the window",
+			trades: [][3]string{{"buy", "100", "2023-01-03 10:00:00"}},
+			income: []IncomeEvent{dividend},
+			want:   []string{"2023-06-09 XOM qualified dividend 91"},
+		},
+		{
+			name:   "bought for the dividend",
+			trades: [][3]string{{"buy", "100", "2023-05-01 10:00:00"}, {"sell", "100", "2023-05-20 10:00:00"}},
+			income: []IncomeEvent{dividend},
+			want:   []string{"2023-06-09 XOM ordinary dividend 91"},
+		},
+		{
+			name:      "split by the shares held long enough",
+			costBasis: CostBasisSelector{Default: LIFO},
+			trades:    [][3]string{{"buy", "100", "2023-01-03 10:00:00"}, {"buy", "100", "2023-05-01 10:00:00"}, {"sell", "100", "2023-05-20 10:00:00"}},
+			income:    []IncomeEvent{dividend},
+			want:      []string{"2023-06-09 XOM qualified dividend 45.5", "2023-06-09 XOM ordinary dividend 45.5"},
+		},
+		{
+			name:      "sold after 60 days of the window",
+			costBasis: CostBasisSelector{Default: LIFO},
+			trades:    [][3]string{{"buy", "100", "2023-04-03 10:00:00"}, {"sell", "100", "2023-06-03 10:00:00"}},
+			income:    []IncomeEvent{dividend},
+			want:      []string{"2023-06-09 XOM qualified dividend 91"},
+		},
+		{
+			name:   "nothing held",
+			income: []IncomeEvent{dividend},
+			want:   []string{"2023-06-09 XOM ordinary dividend 91"},
+		},
+		{
+			name: "stock lending and interest",
+			income: []IncomeEvent{
+				{Date: "2023-02-01", Type: Interest, Amount: dec("4.12")},
+				{Date: "2023-01-05", Ticker: "XOM", Type: StockLending, Amount: dec("0.37")},
+			},
+			want: []string{"2023-01-05 XOM stock lending 0.37", "2023-02-01  interest 4.12"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := NewFakeSource()
+			for _, trade := range test.trades {
+				var qty float64
+				fmt.Sscan(trade[1], &qty)
+				source.AddStockTrade("XOM", trade[0], qty, 100, trade[2])
+			}
+			source.Income = test.income
+
+			report := realizedEarnings(t, source, test.costBasis)
+			assertNoWarnings(t, report)
+			if got := incomeRows(t, report); strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("income %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestProcessRealizedEarningsReinvestedDividend(t *testing.T) {
+	source := NewFakeSource()
+	source.AddStockTrade("XOM", "buy", 100, 100, "2023-01-03 10:00:00")
+	source.Income = []IncomeEvent{{
+		Date: "2023-06-09", ExDate: "2023-05-12", Ticker: "XOM", Type: Dividend, Amount: dec("91"),
+		ReinvestedQty: dec("0.8"), ReinvestedPrice: dec("113.75"),
+	}}
+
+	report := realizedEarnings(t, source, CostBasisSelector{})
+	assertNoWarnings(t, report)
+	assertOpenLots(t, report, "XOM", []wantOpenLot{
+		{acquired: "2023-01-03", qty: "100", unitCost: "100"},
+		{acquired: "2023-06-09", qty: "0.8", unitCost: "113.75"},
+	})
+	// the reinvested shares weren't held going into the ex-date
+	if got := incomeRows(t, report); fmt.Sprint(got) != "[2023-06-09 XOM qualified dividend 91]" {
+		t.Errorf("income %q", got)
+	}
+	assertDecimal(t, "income", amountTotal(t, report.Income), "91")
+}
+
+func TestParseActivityIncome(t *testing.T) {
+	csv := testActivityHeader + `2/16/2023,2/16/2023,2/16/2023,AAPL,Cash Div: R/D 2023-02-13 P/D 2023-02-16 - 10 shares at 0.23,CDIV,,,$2.30
+2/16/2023,2/16/2023,2/16/2023,AAPL,Apple CUSIP: 037833100,Buy,0.0152,$151.32,($2.30)
+2/1/2023,2/1/2023,2/1/2023,,Interest Payment,INT,,,$4.12
+1/5/2023,1/5/2023,1/5/2023,TSLA,Stock Lending Income,SLIP,,,$0.37
+1/3/2023,1/3/2023,1/5/2023,AAPL,Apple CUSIP: 037833100,Buy,10,$125.00,"($1,250.00)"
+`
+	events, err := ParseActivityIncome(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("failing to parse activity income. ERR: %v", err)
+	}
+	got := []string{}
+	for _, event := range events {
+		got = append(got, fmt.Sprintf("%s %s %s %s", event.Date, event.Ticker, event.Type, event.Amount))
+	}
+	want := []string{"2023-01-05 TSLA stock lending 0.37", "2023-02-01  interest 4.12", "2023-02-16 AAPL dividend 2.3"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("income %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,445 @@
+package rhwrapper
+
+// options lot ledger, matches opening and closing legs per contract
+
+import (
+	"fmt"
+	models "github.com/Ryang20718/robinhood-client/models"
+	"github.com/shopspring/decimal"
+	"sort"
+	"strings"
+)
+
+type OptionContract struct {
+	Underlying string
+	Strike     float64
+	Expiration string // 2006-01-02
+	Type       string // call or put
+}
+
+func (c OptionContract) key() string {
+	return fmt.Sprintf("%s-%.4f-%s-%s", c.Underlying, c.Strike, c.Expiration, c.Type)
+}
+
+func (c OptionContract) String() string {
+	return fmt.Sprintf("%s %s %.2f %s", c.Underlying, c.Expiration, c.Strike, c.Type)
+}
+
+// OptionEvent is an assignment or exercise of Qty contracts on Date, the contract as it traded (symbol and strike before splits)
+type OptionEvent struct {
+	Ticker     string          `json:"ticker"`
+	Strike     float64         `json:"strike"`
+	Expiration string          `json:"expiration"` // 2006-01-02
+	Type       string          `json:"type"`       // call or put
+	Date       string          `json:"date"`       // 2006-01-02, the day the shares changed hands
+	Qty        decimal.Decimal `json:"qty"`
+}
+
+func (e OptionEvent) contract() OptionContract {
+	return OptionContract{Underlying: e.Ticker, Strike: e.Strike, Expiration: e.Expiration, Type: e.Type}
+}
+
+/*
+Contract an option leg trades, ticker is the current symbol of the underlying
+*/
+func ContractFor(ticker string, option models.OptionTransaction) OptionContract {
+	tagParts := strings.Fields(option.Tag) // e.g. "sell put"
+	optionType := ""
+	if len(tagParts) > 0 {
+		optionType = tagParts[len(tagParts)-1]
+	}
+	return OptionContract{
+		Underlying: ticker,
+		Strike:     option.StrikePrice,
+		Expiration: option.ExpirationDate,
+		Type:       optionType,
+	}
+}
+
+type OptionLot struct {
+	Contract OptionContract
+	Short    bool
+	Qty      decimal.Decimal // contracts
+	Premium  decimal.Decimal // per share
+	OpenedAt string
+	Tag      string
+	Deferred decimal.Decimal // wash sale loss deferred into this lot
+}
+
+// OptionClose is the realized result of closing or expiring part of an option lot
+type OptionClose struct {
+	Contract OptionContract
+	Short    bool
+	Qty      decimal.Decimal // contracts
+	OpenedAt string
+	ClosedAt string
+	Proceeds decimal.Decimal
+	Basis    decimal.Decimal // includes any deferred wash sale loss
+	Gain     decimal.Decimal
+	LongTerm bool
+	Expired  bool
+	Tag      string
+}
+
+type OptionLedger struct {
+	lots    map[string][]*OptionLot
+	offsets map[string]decimal.Decimal
+	pending map[string]models.OptionTransaction // leg that left an offset behind
+}
+
+func NewOptionLedger() *OptionLedger {
+	return &OptionLedger{
+		lots:    make(map[string][]*OptionLot),
+		offsets: make(map[string]decimal.Decimal),
+		pending: make(map[string]models.OptionTransaction),
+	}
+}
+
+func ledgerKey(contract OptionContract, short bool) string {
+	if short {
+		return contract.key() + "-short"
+	}
+	return contract.key() + "-long"
+}
+
+/*
+Open a lot for a BTO or STO leg, qty and price are already split adjusted
+
+The robinhood client splits partially closed openers into a leg with negative qty plus a copy holding the rest,
+the negative leg is netted against the other lots of the same contract so the ledger ends up with the real qty
+*/
+func (l *OptionLedger) Open(contract OptionContract, option models.OptionTransaction, qty decimal.Decimal, price decimal.Decimal) *OptionLot {
+	short := option.TransactionType == "STO"
+	key := ledgerKey(contract, short)
+	qty = qty.Add(l.offsets[key])
+	l.offsets[key] = decimal.Zero
+	if qty.IsNegative() {
+		lots := l.lots[key]
+		for i := len(lots) - 1; i >= 0 && qty.IsNegative(); i-- {
+			netted := decimal.Min(lots[i].Qty, qty.Neg())
+			lots[i].Qty = lots[i].Qty.Sub(netted)
+			qty = qty.Add(netted)
+		}
+		l.offsets[key] = qty
+		l.pending[key] = option
+		l.prune(key)
+		return nil
+	}
+	if qty.IsZero() {
+		return nil
+	}
+	lot := &OptionLot{
+		Contract: contract,
+		Short:    short,
+		Qty:      qty,
+		Premium:  price,
+		OpenedAt: option.CreatedAt,
+		Tag:      option.Tag,
+	}
+	l.lots[key] = append(l.lots[key], lot)
+	return lot
+}
+
+func (l *OptionLedger) prune(key string) {
+	open := []*OptionLot{}
+	for _, lot := range l.lots[key] {
+		if lot.Qty.IsPositive() {
+			open = append(open, lot)
+		}
+	}
+	l.lots[key] = open
+}
+
+func (l *OptionLedger) closeLot(lot *OptionLot, qty decimal.Decimal, price decimal.Decimal, closedAt string, expired bool) (OptionClose, error) {
+	shares := qty.Mul(optionMultiplier)
+	// a short lot receives the premium and pays to close
+	proceeds, basis := amountOf(shares, price), amountOf(shares, lot.Premium)
+	if lot.Short {
+		proceeds, basis = basis, proceeds
+	}
+	deferred := lot.Deferred
+	if qty.LessThan(lot.Qty) {
+		deferred = Cents(lot.Deferred.Mul(qty).DivRound(lot.Qty, pricePlaces))
+	}
+	basis = basis.Add(deferred)
+	lot.Deferred = lot.Deferred.Sub(deferred)
+	lot.Qty = lot.Qty.Sub(qty)
+
+	// written option premium is always short term
+	longTerm := false
+	if !lot.Short {
+		var err error
+		longTerm, err = IsLongTerm(lot.OpenedAt, closedAt)
+		if err != nil {
+			return OptionClose{}, err
+		}
+	}
+	return OptionClose{
+		Contract: lot.Contract,
+		Short:    lot.Short,
+		Qty:      qty,
+		OpenedAt: lot.OpenedAt,
+		ClosedAt: closedAt,
+		Proceeds: proceeds,
+		Basis:    basis,
+		Gain:     proceeds.Sub(basis),
+		LongTerm: longTerm,
+		Expired:  expired,
+		Tag:      lot.Tag,
+	}, nil
+}
+
+/*
+Close lots with a BTC or STC leg, in the order method takes them. chosenLots are the opening dates (2006-01-02) to
+close first for SpecificLot
+
+Returns the realized closes and the qty that had no open lot to close
+*/
+func (l *OptionLedger) Close(contract OptionContract, option models.OptionTransaction, qty decimal.Decimal, price decimal.Decimal, method CostBasisMethod, chosenLots []string) ([]OptionClose, decimal.Decimal, error) {
+	key := ledgerKey(contract, option.TransactionType == "BTC")
+	closes := []OptionClose{}
+	lots := l.lots[key]
+	for _, idx := range optionLotOrder(lots, method, chosenLots) {
+		if !qty.IsPositive() {
+			break
+		}
+		lot := lots[idx]
+		closedQty := decimal.Min(lot.Qty, qty)
+		closed, err := l.closeLot(lot, closedQty, price, option.CreatedAt, false)
+		if err != nil {
+			return nil, decimal.Zero, err
+		}
+		closes = append(closes, closed)
+		qty = qty.Sub(closedQty)
+	}
+	l.prune(key)
+	return closes, qty, nil
+}
+
+/*
+Returns the order in which open lots of one contract are closed for the given method, like lotOrder for shares. The
+costliest short lot to close is the one that received the least premium
+*/
+func optionLotOrder(lots []*OptionLot, method CostBasisMethod, chosenLots []string) []int {
+	order := make([]int, len(lots))
+	for i := range lots {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return lots[order[i]].OpenedAt < lots[order[j]].OpenedAt
+	})
+	switch method {
+	case LIFO:
+		sort.SliceStable(order, func(i, j int) bool {
+			return lots[order[i]].OpenedAt > lots[order[j]].OpenedAt
+		})
+	case HIFO:
+		sort.SliceStable(order, func(i, j int) bool {
+			if lots[order[i]].Short {
+				return lots[order[i]].Premium.LessThan(lots[order[j]].Premium)
+			}
+			return lots[order[i]].Premium.GreaterThan(lots[order[j]].Premium)
+		})
+	case SpecificLot:
+		rank := func(idx int) int {
+			for r, date := range chosenLots {
+				if strings.Split(lots[idx].OpenedAt, "T")[0] == date {
+					return r
+				}
+			}
+			return len(chosenLots)
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return rank(order[i]) < rank(order[j])
+		})
+	}
+	return order
+}
+
+/*
+Expire every lot whose expiration date is before asOf (2006-01-02)
+*/
+func (l *OptionLedger) Expire(asOf string) ([]OptionClose, error) {
+	keys := []string{}
+	for key := range l.lots {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	closes := []OptionClose{}
+	for _, key := range keys {
+		for _, lot := range l.lots[key] {
+			if lot.Contract.Expiration == "" || lot.Contract.Expiration >= asOf {
+				continue
+			}
+			closed, err := l.closeLot(lot, lot.Qty, decimal.Zero, lot.Contract.Expiration, true)
+			if err != nil {
+				return nil, err
+			}
+			closes = append(closes, closed)
+		}
+		l.prune(key)
+	}
+	sort.SliceStable(closes, func(i, j int) bool {
+		return closes[i].ClosedAt < closes[j].ClosedAt
+	})
+	return closes, nil
+}
+
+/*
+Lots still open, sorted by when they were opened
+*/
+func (l *OptionLedger) OpenLots() []*OptionLot {
+	open := []*OptionLot{}
+	for _, lots := range l.lots {
+		open = append(open, lots...)
+	}
+	sort.SliceStable(open, func(i, j int) bool {
+		return open[i].OpenedAt < open[j].OpenedAt
+	})
+	return open
+}
+
+/*
+Opening legs with a negative qty that nothing was left to net against
+*/
+func (l *OptionLedger) Imbalances() []OptionLot {
+	imbalances := []OptionLot{}
+	for key, qty := range l.offsets {
+		if !qty.IsNegative() {
+			continue
+		}
+		option := l.pending[key]
+		imbalances = append(imbalances, OptionLot{
+			Contract: ContractFor(option.Ticker, option),
+			Short:    option.TransactionType == "STO",
+			Qty:      qty,
+			Premium:  PriceFromFloat(option.UnitCost),
+			OpenedAt: option.CreatedAt,
+			Tag:      option.Tag,
+		})
+	}
+	sort.SliceStable(imbalances, func(i, j int) bool {
+		return imbalances[i].OpenedAt < imbalances[j].OpenedAt
+	})
+	return imbalances
+}
+
+/*
+convert option closes to profit, each with the method costBasis closed its underlying's lots by
+*/
+func optionCloseProfits(closes []OptionClose, costBasis CostBasisSelector) []Profit {
+	profits := []Profit{}
+	for _, closed := range closes {
+		profits = append(profits, Profit{
+			Date:   strings.Split(closed.ClosedAt, "T")[0],
+			Amount: closed.Gain,
+			Lcap:   closed.LongTerm,
+			Ticker: closed.Contract.Underlying,
+			Tag:    closed.Tag,
+			Method: costBasis.MethodFor(closed.Contract.Underlying),
+		})
+	}
+	return profits
+}
+
+// opening leg and how much of it is still open
+type legOpener struct {
+	index    int             // in the legs of its ticker
+	open     decimal.Decimal // contracts not closed, assigned or exercised yet
+	assigned decimal.Decimal
+}
+
+/*
+Take qty contracts off the oldest openers that still have them, assigned marks them assigned or exercised rather than
+closed. Returns the contracts none of the openers had left
+*/
+func takeOpeners(openers []*legOpener, qty decimal.Decimal, assigned bool) decimal.Decimal {
+	for _, opener := range openers {
+		if !qty.IsPositive() {
+			break
+		}
+		taken := decimal.Min(opener.open, qty)
+		opener.open = opener.open.Sub(taken)
+		if assigned {
+			opener.assigned = opener.assigned.Add(taken)
+		}
+		qty = qty.Sub(taken)
+	}
+	return qty
+}
+
+/*
+Mark the opening legs the events assigned or exercised, optionMap holds the legs as traded
+
+Legs and events are walked in date order, trades before events on the same day. Closing legs take contracts off the
+oldest openers of their contract and side that have some left, events off the oldest short ones and then the long ones.
+A leg only partly assigned is split, the assigned contracts become a leg of their own. The legs of every ticker end up
+sorted by created datetime
+*/
+func markAssignedLegs(optionMap map[string][]models.OptionTransaction, events []OptionEvent) {
+	tickerEvents := make(map[string][]OptionEvent)
+	for _, event := range events {
+		tickerEvents[event.Ticker] = append(tickerEvents[event.Ticker], event)
+	}
+	for ticker, legs := range optionMap {
+		sort.SliceStable(legs, func(i, j int) bool {
+			return legs[i].CreatedAt < legs[j].CreatedAt
+		})
+		events := tickerEvents[ticker]
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].Date < events[j].Date
+		})
+		// openers by contract and side, oldest first
+		openers := make(map[string][]*legOpener)
+		next := 0
+		// apply the events dated before date, all of them when date is empty
+		applyEvents := func(date string) {
+			for ; next < len(events) && (date == "" || events[next].Date < date); next++ {
+				key := events[next].contract().key()
+				left := takeOpeners(openers[key+"-short"], events[next].Qty, true)
+				takeOpeners(openers[key+"-long"], left, true)
+			}
+		}
+		for i, leg := range legs {
+			applyEvents(tradeDate(leg.CreatedAt))
+			key := ContractFor(ticker, leg).key() + "-long"
+			if leg.TransactionType == "STO" || leg.TransactionType == "BTC" {
+				key = ContractFor(ticker, leg).key() + "-short"
+			}
+			switch leg.TransactionType {
+			case "STO", "BTO":
+				openers[key] = append(openers[key], &legOpener{index: i, open: QtyFromFloat(leg.Qty)})
+			case "BTC", "STC":
+				takeOpeners(openers[key], QtyFromFloat(leg.Qty), false)
+			}
+		}
+		applyEvents("")
+
+		keys := []string{}
+		for key := range openers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			for _, opener := range openers[key] {
+				if !opener.assigned.IsPositive() {
+					continue
+				}
+				leg := &legs[opener.index]
+				if !opener.assigned.LessThan(QtyFromFloat(leg.Qty)) {
+					leg.Status = "Assigned"
+					continue
+				}
+				assignedLeg := *leg
+				assignedLeg.Qty = opener.assigned.InexactFloat64()
+				assignedLeg.Status = "Assigned"
+				leg.Qty = QtyFromFloat(leg.Qty).Sub(opener.assigned).InexactFloat64()
+				legs = append(legs, assignedLeg)
+			}
+		}
+		sort.SliceStable(legs, func(i, j int) bool {
+			return legs[i].CreatedAt < legs[j].CreatedAt
+		})
+		optionMap[ticker] = legs
+	}
+}
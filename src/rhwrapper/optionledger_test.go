@@ -0,0 +1,419 @@
+package rhwrapper
+
+import (
+	"fmt"
+	models "github.com/Ryang20718/robinhood-client/models"
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+var testPut = OptionContract{Underlying: "XYZ", Strike: 50, Expiration: "2023-01-20", Type: "put"}
+
+// leg on testPut
+func putLeg(transactionType string, qty float64, price float64, createdAt string) models.OptionTransaction {
+	tag := "sell put"
+	if transactionType == "BTO" || transactionType == "BTC" {
+		tag = "buy put"
+	}
+	return models.OptionTransaction{
+		Ticker:          "XYZ",
+		TransactionType: transactionType,
+		Qty:             qty,
+		UnitCost:        price,
+		StrikePrice:     50,
+		ExpirationDate:  "2023-01-20",
+		CreatedAt:       createdAt,
+		Tag:             tag,
+	}
+}
+
+func TestContractFor(t *testing.T) {
+	contract := ContractFor("XYZ", putLeg("STO", 1, 2, "2023-01-03T15:00:00Z"))
+	if contract != testPut {
+		t.Errorf("contract %+v, want %+v", contract, testPut)
+	}
+}
+
+func TestOptionLedgerClose(t *testing.T) {
+	tests := []struct {
+		name      string
+		legs      []models.OptionTransaction
+		gains     []string // of every close, oldest lot first
+		longTerm  []bool
+		unmatched string
+		open      string // contracts left
+	}{
+		{
+			name:      "sold to open, bought to close",
+			legs:      []models.OptionTransaction{putLeg("STO", 1, 2, "2023-01-03T15:00:00Z"), putLeg("BTC", 1, 0.5, "2023-01-10T15:00:00Z")},
+			gains:     []string{"150"},
+			longTerm:  []bool{false},
+			unmatched: "0",
+			open:      "0",
+		},
+		{
+			name:      "bought to open, part sold to close over a year later",
+			legs:      []models.OptionTransaction{putLeg("BTO", 2, 3, "2022-01-03T15:00:00Z"), putLeg("STC", 1, 5, "2023-01-04T15:00:00Z")},
+			gains:     []string{"200"},
+			longTerm:  []bool{true},
+			unmatched: "0",
+			open:      "1",
+		},
+		{
+			name: "close spans two lots",
+			legs: []models.OptionTransaction{
+				putLeg("STO", 1, 2, "2023-01-03T15:00:00Z"),
+				putLeg("STO", 1, 1, "2023-01-05T15:00:00Z"),
+				putLeg("BTC", 2, 1.5, "2023-01-10T15:00:00Z"),
+			},
+			gains:     []string{"50", "-50"},
+			longTerm:  []bool{false, false},
+			unmatched: "0",
+			open:      "0",
+		},
+		{
+			name:      "close with no opening leg",
+			legs:      []models.OptionTransaction{putLeg("BTC", 1, 0.5, "2023-01-10T15:00:00Z")},
+			unmatched: "1",
+			open:      "0",
+		},
+		{
+			name: "partially closed opener split into a negative leg",
+			legs: []models.OptionTransaction{
+				putLeg("STO", 2, 2, "2023-01-03T15:00:00Z"),
+				putLeg("STO", -1, 2, "2023-01-03T15:00:00Z"),
+				putLeg("BTC", 1, 0.5, "2023-01-10T15:00:00Z"),
+			},
+			gains:     []string{"150"},
+			longTerm:  []bool{false},
+			unmatched: "0",
+			open:      "0",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ledger := NewOptionLedger()
+			closes := []OptionClose{}
+			unmatched := decimal.Zero
+			for _, leg := range test.legs {
+				qty, price := QtyFromFloat(leg.Qty), PriceFromFloat(leg.UnitCost)
+				if leg.TransactionType == "STO" || leg.TransactionType == "BTO" {
+					ledger.Open(testPut, leg, qty, price)
+					continue
+				}
+				closed, unmatchedQty, err := ledger.Close(testPut, leg, qty, price, FIFO, nil)
+				if err != nil {
+					t.Fatalf("failing to close %+v. ERR: %v", leg, err)
+				}
+				closes = append(closes, closed...)
+				unmatched = unmatched.Add(unmatchedQty)
+			}
+			if len(closes) != len(test.gains) {
+				t.Fatalf("closes %+v, want gains %v", closes, test.gains)
+			}
+			for i, closed := range closes {
+				assertDecimal(t, fmt.Sprintf("close %d gain", i), closed.Gain, test.gains[i])
+				if closed.LongTerm != test.longTerm[i] {
+					t.Errorf("close %d long term %v, want %v", i, closed.LongTerm, test.longTerm[i])
+				}
+			}
+			assertDecimal(t, "unmatched", unmatched, test.unmatched)
+			open := decimal.Zero
+			for _, lot := range ledger.OpenLots() {
+				open = open.Add(lot.Qty)
+			}
+			assertDecimal(t, "contracts open", open, test.open)
+		})
+	}
+}
+
+func TestOptionLedgerExpire(t *testing.T) {
+	ledger := NewOptionLedger()
+	ledger.Open(testPut, putLeg("STO", 1, 2, "2023-01-03T15:00:00Z"), dec("1"), dec("2"))
+	bought := ledger.Open(testPut, putLeg("BTO", 1, 1, "2023-01-04T15:00:00Z"), dec("1"), dec("1"))
+	// a wash sale deferred part of a stock loss into the bought put
+	bought.Deferred = dec("30")
+
+	closes, err := ledger.Expire("2023-01-20")
+	if err != nil || len(closes) != 0 {
+		t.Fatalf("expired %+v %v on the expiration date", closes, err)
+	}
+	closes, err = ledger.Expire("2023-01-21")
+	if err != nil {
+		t.Fatalf("failing to expire. ERR: %v", err)
+	}
+	gains := map[bool]decimal.Decimal{}
+	for _, closed := range closes {
+		if !closed.Expired || closed.ClosedAt != "2023-01-20" {
+			t.Errorf("close %+v isn't an expiry on 2023-01-20", closed)
+		}
+		gains[closed.Short] = closed.Gain
+	}
+	if len(closes) != 2 || !gains[true].Equal(dec("200")) || !gains[false].Equal(dec("-130")) {
+		t.Errorf("expired %+v, want 200 on the short and -130 on the long put", closes)
+	}
+	if len(ledger.OpenLots()) != 0 {
+		t.Errorf("lots %+v left open", ledger.OpenLots())
+	}
+
+	profits := optionCloseProfits(closes, CostBasisSelector{})
+	if len(profits) != 2 || profits[0].Date != "2023-01-20" || profits[0].Ticker != "XYZ" {
+		t.Errorf("profits %+v", profits)
+	}
+}
+
+func TestAssignmentDatesTake(t *testing.T) {
+	event := func(date string, qty int64) OptionEvent {
+		return OptionEvent{Ticker: "XYZ", Strike: 50, Expiration: "2023-01-20", Type: "put", Date: date, Qty: decimal.NewFromInt(qty)}
+	}
+	tests := []struct {
+		name        string
+		events      []OptionEvent
+		legs        []float64  // traded qty of the assigned legs, oldest first
+		adjustedQty float64    // of every leg after splits, per traded contract
+		parts       [][]string // date x qty
+	}{
+		{
+			name:        "no event, assigned at expiration",
+			legs:        []float64{1},
+			adjustedQty: 1,
+			parts:       [][]string{{"2023-01-20x1"}},
+		},
+		{
+			name:        "early assignment",
+			events:      []OptionEvent{event("2023-01-12", 1)},
+			legs:        []float64{1},
+			adjustedQty: 1,
+			parts:       [][]string{{"2023-01-12x1"}},
+		},
+		{
+			name:        "part assigned early, the rest at expiration",
+			events:      []OptionEvent{event("2023-01-12", 1)},
+			legs:        []float64{2},
+			adjustedQty: 1,
+			parts:       [][]string{{"2023-01-12x1", "2023-01-20x1"}},
+		},
+		{
+			name:        "events go to the oldest leg first",
+			events:      []OptionEvent{event("2023-01-13", 1), event("2023-01-12", 1)},
+			legs:        []float64{1, 2},
+			adjustedQty: 1,
+			parts: [][]string{
+				{"2023-01-12x1"},
+				{"2023-01-13x1", "2023-01-20x1"},
+			},
+		},
+		{
+			name:        "split after the leg was opened",
+			events:      []OptionEvent{event("2023-01-12", 1)},
+			legs:        []float64{2},
+			adjustedQty: 2,
+			parts:       [][]string{{"2023-01-12x2", "2023-01-20x2"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dates := newAssignmentDates(test.events)
+			for i, qty := range test.legs {
+				got := []string{}
+				for _, part := range dates.take(putLeg("STO", qty, 2, "2023-01-03T15:00:00Z"), QtyFromFloat(qty*test.adjustedQty)) {
+					got = append(got, fmt.Sprintf("%sx%s", part.date, part.qty))
+				}
+				if fmt.Sprint(got) != fmt.Sprint(test.parts[i]) {
+					t.Errorf("leg %d parts %v, want %v", i, got, test.parts[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEarningsRunAssign(t *testing.T) {
+	tests := []struct {
+		name            string
+		transactionType string
+		optionType      string
+		lots            []*Lot   // open before the assignment
+		profit          string   // booked by the assignment
+		open            []string // qty x unit cost of the lots left
+	}{
+		{name: "short put assigned", transactionType: "STO", optionType: "put", profit: "0", open: []string{"100x48"}},
+		{name: "long call exercised", transactionType: "BTO", optionType: "call", profit: "0", open: []string{"100x52"}},
+		{
+			name:            "short call assigned",
+			transactionType: "STO",
+			optionType:      "call",
+			lots:            []*Lot{{Ticker: "XYZ", Qty: dec("100"), UnitCost: dec("40"), CreatedAt: "2023-01-03 10:00:00"}},
+			profit:          "1200",
+			open:            []string{},
+		},
+		{
+			name:            "long put exercised",
+			transactionType: "BTO",
+			optionType:      "put",
+			lots:            []*Lot{{Ticker: "XYZ", Qty: dec("150"), UnitCost: dec("40"), CreatedAt: "2023-01-03 10:00:00"}},
+			profit:          "800",
+			open:            []string{"50x40"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			run := newEarningsRun(CostBasisSelector{}, nil)
+			run.profitsMap["XYZ"] = test.lots
+			option := models.OptionTransaction{Ticker: "XYZ", TransactionType: test.transactionType, Qty: 1, UnitCost: 2, StrikePrice: 50, ExpirationDate: "2023-02-17"}
+			contract := OptionContract{Underlying: "XYZ", Strike: 50, Expiration: "2023-02-17", Type: test.optionType}
+			if err := run.assign(optionAssignment{ticker: "XYZ", contract: contract, option: option, date: "2023-02-10"}); err != nil {
+				t.Fatalf("failing to assign. ERR: %v", err)
+			}
+
+			profit := decimal.Zero
+			for _, p := range run.profitList {
+				profit = profit.Add(p.Amount)
+				if p.Date != "2023-02-10" {
+					t.Errorf("profit booked on %s, want the assignment date", p.Date)
+				}
+			}
+			assertDecimal(t, "profit", profit, test.profit)
+			open := []string{}
+			for _, lot := range run.profitsMap["XYZ"] {
+				open = append(open, fmt.Sprintf("%vx%v", lot.Qty, lot.UnitCost))
+				if lot.CreatedAt != "2023-02-10" && lot.CreatedAt != "2023-01-03 10:00:00" {
+					t.Errorf("lot acquired %s, want the assignment date", lot.CreatedAt)
+				}
+			}
+			if fmt.Sprint(open) != fmt.Sprint(test.open) {
+				t.Errorf("open lots %v, want %v", open, test.open)
+			}
+		})
+	}
+}
+
+func TestMarkAssignedLegs(t *testing.T) {
+	tests := []struct {
+		name   string
+		script func(source *FakeSource)
+		legs   []string // type, qty and status of the legs after marking
+	}{
+		{
+			name: "assignment takes the opener still open",
+			script: func(source *FakeSource) {
+				source.AddOptionTrade("KO", "STO", "put", 1, 55, 1, "2023-01-03T15:00:00Z", "2023-02-17", "Expired")
+				source.AddOptionTrade("KO", "BTC", "put", 1, 55, 0.2, "2023-01-10T15:00:00Z", "2023-02-17", "Expired")
+				source.AddOptionTrade("KO", "STO", "put", 2, 55, 0.8, "2023-02-10T15:00:00Z", "2023-02-17", "Expired")
+				source.AddOptionEvent("KO", "put", 55, "2023-02-17", "2023-02-17", 1)
+			},
+			legs: []string{"STO 1 Expired", "BTC 1 Expired", "STO 1 Expired", "STO 1 Assigned"},
+		},
+		{
+			name: "early assignment before a later opener",
+			script: func(source *FakeSource) {
+				source.AddOptionTrade("KO", "STO", "put", 1, 55, 1, "2023-01-03T15:00:00Z", "2023-02-17", "Expired")
+				source.AddOptionTrade("KO", "STO", "put", 1, 55, 0.8, "2023-02-10T15:00:00Z", "2023-02-17", "Expired")
+				source.AddOptionEvent("KO", "put", 55, "2023-02-17", "2023-01-20", 1)
+			},
+			legs: []string{"STO 1 Assigned", "STO 1 Expired"},
+		},
+		{
+			name: "short legs are assigned before long ones are exercised",
+			script: func(source *FakeSource) {
+				source.AddOptionTrade("KO", "BTO", "call", 1, 60, 0.5, "2023-01-03T15:00:00Z", "2023-02-17", "Expired")
+				source.AddOptionTrade("KO", "STO", "call", 1, 60, 0.7, "2023-01-04T15:00:00Z", "2023-02-17", "Expired")
+				source.AddOptionEvent("KO", "call", 60, "2023-02-17", "2023-02-17", 1)
+			},
+			legs: []string{"BTO 1 Expired", "STO 1 Assigned"},
+		},
+		{
+			name: "other contracts are left alone",
+			script: func(source *FakeSource) {
+				source.AddOptionTrade("KO", "STO", "put", 1, 55, 1, "2023-01-03T15:00:00Z", "2023-02-17", "Expired")
+				source.AddOptionEvent("KO", "put", 60, "2023-02-17", "2023-02-17", 1)
+				source.AddOptionEvent("KO", "call", 55, "2023-02-17", "2023-02-17", 1)
+			},
+			legs: []string{"STO 1 Expired"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := NewFakeSource()
+			test.script(source)
+
+			markAssignedLegs(source.Options, source.Events)
+			legs := []string{}
+			for _, leg := range source.Options["KO"] {
+				legs = append(legs, fmt.Sprintf("%s %v %s", leg.TransactionType, leg.Qty, leg.Status))
+			}
+			if fmt.Sprint(legs) != fmt.Sprint(test.legs) {
+				t.Errorf("legs %v, want %v", legs, test.legs)
+			}
+		})
+	}
+}
+
+func TestProcessRealizedEarningsOptionCostBasis(t *testing.T) {
+	tests := []struct {
+		method   CostBasisMethod
+		realized []wantLot // the close, then the expiry of the call left open
+	}{
+		{
+			method: FIFO,
+			realized: []wantLot{
+				{acquired: "2023-01-03", sold: "2023-01-17", qty: "1", proceeds: "300", basis: "200"},
+				{acquired: "2023-01-10", sold: "2023-03-17", qty: "1", proceeds: "0", basis: "400"},
+			},
+		},
+		{
+			method: LIFO,
+			realized: []wantLot{
+				{acquired: "2023-01-10", sold: "2023-01-17", qty: "1", proceeds: "300", basis: "400"},
+				{acquired: "2023-01-03", sold: "2023-03-17", qty: "1", proceeds: "0", basis: "200"},
+			},
+		},
+		{
+			method: HIFO,
+			realized: []wantLot{
+				{acquired: "2023-01-10", sold: "2023-01-17", qty: "1", proceeds: "300", basis: "400"},
+				{acquired: "2023-01-03", sold: "2023-03-17", qty: "1", proceeds: "0", basis: "200"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(string(test.method), func(t *testing.T) {
+			source := NewFakeSource()
+			source.AddOptionTrade("XYZ", "BTO", "call", 1, 50, 2, "2023-01-03T15:00:00Z", "2023-03-17", "Open")
+			source.AddOptionTrade("XYZ", "BTO", "call", 1, 50, 4, "2023-01-10T15:00:00Z", "2023-03-17", "Open")
+			source.AddOptionTrade("XYZ", "STC", "call", 1, 50, 3, "2023-01-17T15:00:00Z", "2023-03-17", "Open")
+
+			report := realizedEarnings(t, source, CostBasisSelector{Default: test.method})
+			assertNoWarnings(t, report)
+			assertRealizedLots(t, report.RealizedLots, test.realized)
+			for _, method := range report.Profit.Col("Method").Records() {
+				if method != string(test.method) {
+					t.Errorf("profit booked by %s, want %s", method, test.method)
+				}
+			}
+		})
+	}
+}
+
+func TestOptionLotOrder(t *testing.T) {
+	lots := []*OptionLot{
+		{Short: true, Premium: dec("2"), OpenedAt: "2023-01-03T15:00:00Z"},
+		{Short: true, Premium: dec("1"), OpenedAt: "2023-01-05T15:00:00Z"},
+		{Short: true, Premium: dec("3"), OpenedAt: "2023-01-04T15:00:00Z"},
+	}
+	tests := []struct {
+		method CostBasisMethod
+		chosen []string
+		want   []int
+	}{
+		{method: FIFO, want: []int{0, 2, 1}},
+		{method: LIFO, want: []int{1, 2, 0}},
+		// buying back the put sold for the least premium costs the most
+		{method: HIFO, want: []int{1, 0, 2}},
+		{method: SpecificLot, chosen: []string{"2023-01-04"}, want: []int{2, 0, 1}},
+	}
+	for _, test := range tests {
+		if got := optionLotOrder(lots, test.method, test.chosen); fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s order %v, want %v", test.method, got, test.want)
+		}
+	}
+}
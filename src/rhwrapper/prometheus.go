@@ -0,0 +1,116 @@
+package rhwrapper
+
+// portfolio gauges for prometheus, refreshed from an earnings report after every sync
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+	"time"
+)
+
+const metricsNamespace = "robinhood"
+
+type PortfolioMetrics struct {
+	realizedGain    *prometheus.GaugeVec
+	income          *prometheus.GaugeVec
+	shares          *prometheus.GaugeVec
+	costBasis       *prometheus.GaugeVec
+	marketValue     *prometheus.GaugeVec
+	unrealizedGain  *prometheus.GaugeVec
+	openLots        *prometheus.GaugeVec
+	lastSync        prometheus.Gauge
+	lastSyncSuccess prometheus.Gauge
+}
+
+/*
+Create the portfolio gauges and register them on registerer
+*/
+func NewPortfolioMetrics(registerer prometheus.Registerer) *PortfolioMetrics {
+	gaugeVec := func(name string, help string, labels ...string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: metricsNamespace, Name: name, Help: help}, labels)
+	}
+	m := &PortfolioMetrics{
+		realizedGain:   gaugeVec("realized_gain_dollars", "Realized gain, disallowed wash sale losses excluded.", "year", "ticker", "tag", "term"),
+		income:         gaugeVec("income_dollars", "Dividend, stock lending and interest income.", "year", "ticker", "type"),
+		shares:         gaugeVec("position_shares", "Shares held.", "ticker"),
+		costBasis:      gaugeVec("position_cost_basis_dollars", "Cost basis of the open lots.", "ticker"),
+		marketValue:    gaugeVec("position_market_value_dollars", "Market value of the open lots, only for priced positions.", "ticker"),
+		unrealizedGain: gaugeVec("position_unrealized_gain_dollars", "Unrealized gain of the open lots, only for priced positions.", "ticker", "term"),
+		openLots:       gaugeVec("open_lots", "Number of open lots.", "ticker", "term"),
+		lastSync: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_sync_timestamp_seconds",
+			Help:      "Unix time trades were last fetched and the gauges updated.",
+		}),
+		lastSyncSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_sync_success",
+			Help:      "1 when the last sync succeeded, 0 when it failed and the gauges are stale.",
+		}),
+	}
+	registerer.MustRegister(
+		m.realizedGain,
+		m.income,
+		m.shares,
+		m.costBasis,
+		m.marketValue,
+		m.unrealizedGain,
+		m.openLots,
+		m.lastSync,
+		m.lastSyncSuccess,
+	)
+	return m
+}
+
+/*
+Replace the gauges with the numbers of a fresh earnings report and its valued open lots
+*/
+func (m *PortfolioMetrics) Update(report *EarningsReport, openLots []LotValue, syncedAt time.Time) {
+	m.realizedGain.Reset()
+	years := report.Profit.Col("Year").Records()
+	amounts := report.Profit.Col("Amount").Float()
+	lcaps := report.Profit.Col("Lcap").Records()
+	tickers := report.Profit.Col("Ticker").Records()
+	tags := report.Profit.Col("Tag").Records()
+	for i := range years {
+		lcap, _ := strconv.ParseBool(lcaps[i])
+		m.realizedGain.WithLabelValues(years[i], tickers[i], tags[i], TermOf(lcap)).Add(amounts[i])
+	}
+
+	m.income.Reset()
+	if report.Income != nil {
+		incomeYears := report.Income.Col("Year").Records()
+		incomeTickers := report.Income.Col("Ticker").Records()
+		incomeTypes := report.Income.Col("Type").Records()
+		incomeAmounts := report.Income.Col("Amount").Float()
+		for i := range incomeYears {
+			m.income.WithLabelValues(incomeYears[i], incomeTickers[i], incomeTypes[i]).Add(incomeAmounts[i])
+		}
+	}
+
+	m.shares.Reset()
+	m.costBasis.Reset()
+	m.marketValue.Reset()
+	m.unrealizedGain.Reset()
+	m.openLots.Reset()
+	for _, lot := range openLots {
+		term := TermOf(lot.LongTerm)
+		m.shares.WithLabelValues(lot.Ticker).Add(lot.Qty.InexactFloat64())
+		m.costBasis.WithLabelValues(lot.Ticker).Add(lot.Basis.InexactFloat64())
+		m.openLots.WithLabelValues(lot.Ticker, term).Inc()
+		if lot.Priced {
+			m.marketValue.WithLabelValues(lot.Ticker).Add(lot.MarketValue.InexactFloat64())
+			m.unrealizedGain.WithLabelValues(lot.Ticker, term).Add(lot.Gain.InexactFloat64())
+		}
+	}
+
+	m.lastSync.Set(float64(syncedAt.Unix()))
+	m.lastSyncSuccess.Set(1)
+}
+
+/*
+Flag the gauges as stale after a failed sync
+*/
+func (m *PortfolioMetrics) SyncFailed() {
+	m.lastSyncSuccess.Set(0)
+}
@@ -0,0 +1,94 @@
+package rhwrapper
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape registry, the samples of the robinhood gauges sorted
+func scrapeMetrics(t *testing.T, registry *prometheus.Registry) []string {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(recorder, httptest.NewRequest("GET", "/prometheus", nil))
+	samples := []string{}
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if strings.HasPrefix(line, metricsNamespace+"_") {
+			samples = append(samples, line)
+		}
+	}
+	sort.Strings(samples)
+	return samples
+}
+
+func TestPortfolioMetricsUpdate(t *testing.T) {
+	source := NewFakeSource()
+	source.AddStockTrade("AAPL", "buy", 10, 100, "2022-01-03 10:00:00")
+	source.AddStockTrade("AAPL", "buy", 10, 150, "2023-01-03 10:00:00")
+	source.AddStockTrade("AAPL", "sell", 10, 200, "2023-06-01 10:00:00")
+	source.AddStockTrade("TSLA", "buy", 2, 250, "2024-01-03 10:00:00")
+	source.Income = []IncomeEvent{{Date: "2023-02-01", Type: Interest, Amount: dec("4.12")}}
+	quotes := stubQuoteProvider{"AAPL": dec("190")}
+	syncedAt := time.Unix(1717200000, 0)
+
+	registry := prometheus.NewRegistry()
+	metrics := NewPortfolioMetrics(registry)
+	update := func(costBasis CostBasisSelector) {
+		report := realizedEarnings(t, source, costBasis)
+		openLots, err := ValueOpenLots(report.OpenLots, quotes, "2024-05-31")
+		if err != nil {
+			t.Fatalf("failing to value open lots. ERR: %v", err)
+		}
+		metrics.Update(report, openLots, syncedAt)
+	}
+
+	update(CostBasisSelector{Default: FIFO})
+	want := []string{
+		`robinhood_income_dollars{ticker="",type="interest",year="2023"} 4.12`,
+		`robinhood_last_sync_success 1`,
+		`robinhood_last_sync_timestamp_seconds 1.7172e+09`,
+		`robinhood_open_lots{term="long term",ticker="AAPL"} 1`,
+		`robinhood_open_lots{term="short term",ticker="TSLA"} 1`,
+		`robinhood_position_cost_basis_dollars{ticker="AAPL"} 1500`,
+		`robinhood_position_cost_basis_dollars{ticker="TSLA"} 500`,
+		`robinhood_position_market_value_dollars{ticker="AAPL"} 1900`,
+		`robinhood_position_shares{ticker="AAPL"} 10`,
+		`robinhood_position_shares{ticker="TSLA"} 2`,
+		`robinhood_position_unrealized_gain_dollars{term="long term",ticker="AAPL"} 400`,
+		`robinhood_realized_gain_dollars{tag="sell",term="long term",ticker="AAPL",year="2023"} 1000`,
+	}
+	if got := scrapeMetrics(t, registry); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("FIFO samples\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// another cost basis sells the other lot, the gauges of the first report don't linger
+	update(CostBasisSelector{Default: LIFO})
+	want = []string{
+		`robinhood_income_dollars{ticker="",type="interest",year="2023"} 4.12`,
+		`robinhood_last_sync_success 1`,
+		`robinhood_last_sync_timestamp_seconds 1.7172e+09`,
+		`robinhood_open_lots{term="long term",ticker="AAPL"} 1`,
+		`robinhood_open_lots{term="short term",ticker="TSLA"} 1`,
+		`robinhood_position_cost_basis_dollars{ticker="AAPL"} 1000`,
+		`robinhood_position_cost_basis_dollars{ticker="TSLA"} 500`,
+		`robinhood_position_market_value_dollars{ticker="AAPL"} 1900`,
+		`robinhood_position_shares{ticker="AAPL"} 10`,
+		`robinhood_position_shares{ticker="TSLA"} 2`,
+		`robinhood_position_unrealized_gain_dollars{term="long term",ticker="AAPL"} 900`,
+		`robinhood_realized_gain_dollars{tag="sell",term="short term",ticker="AAPL",year="2023"} 500`,
+	}
+	if got := scrapeMetrics(t, registry); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("LIFO samples\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// stale gauges are kept, flagged by the sync success
+	metrics.SyncFailed()
+	want[1] = `robinhood_last_sync_success 0`
+	if got := scrapeMetrics(t, registry); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("samples after a failed sync\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
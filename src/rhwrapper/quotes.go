@@ -0,0 +1,176 @@
+package rhwrapper
+
+// quote providers for valuing open lots, robinhood, a csv of closing prices and a json endpoint. The csv also serves
+// daily closes for returns
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	robinhood "github.com/Ryang20718/robinhood-client/client"
+	"github.com/shopspring/decimal"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QuoteProvider prices symbols, symbols it has no price for are left out of the result
+type QuoteProvider interface {
+	Quotes(symbols []string) (map[string]decimal.Decimal, error)
+}
+
+// RobinhoodQuoteProvider uses the last trade price from robinhood, needs a logged in client
+type RobinhoodQuoteProvider struct {
+	Cli *robinhood.Client
+}
+
+func (p *RobinhoodQuoteProvider) Quotes(symbols []string) (map[string]decimal.Decimal, error) {
+	prices := make(map[string]decimal.Decimal)
+	if p.Cli == nil {
+		return nil, fmt.Errorf("robinhood quotes need a logged in client")
+	}
+	if len(symbols) == 0 {
+		return prices, nil
+	}
+	quotes, err := p.Cli.GetQuote(symbols...)
+	if err != nil {
+		return nil, fmt.Errorf("failing to fetch quotes. ERR: %v", err)
+	}
+	for _, quote := range quotes {
+		if quote.Symbol == nil || quote.LastTradePrice == nil {
+			continue
+		}
+		price, err := decimal.NewFromString(*quote.LastTradePrice)
+		if err != nil {
+			continue
+		}
+		prices[*quote.Symbol] = price
+	}
+	return prices, nil
+}
+
+// DailyPriceSource prices symbols at the close of past days, for valuing the account through time. Closes are split
+// adjusted to today, like the quantities the engine works in
+type DailyPriceSource interface {
+	// close of symbol on date (2006-01-02) or the last trading day before it, false when there's none
+	Close(symbol string, date string) (decimal.Decimal, bool, error)
+}
+
+// closing price of a symbol on a date
+type closingPrice struct {
+	date  string
+	price decimal.Decimal
+}
+
+// FileQuoteProvider serves closing prices from a csv file
+type FileQuoteProvider struct {
+	closes map[string][]closingPrice
+	AsOf   string // 2006-01-02, latest close on or before it. Latest close in the file when empty
+}
+
+/*
+Load closing prices from a csv file
+
+csv: symbol,date,close with a header row
+*/
+func NewFileQuoteProvider(path string) (*FileQuoteProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failing to read closing prices. ERR: %v", err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failing to parse closing prices. ERR: %v", err)
+	}
+	provider := &FileQuoteProvider{closes: make(map[string][]closingPrice)}
+	for i, row := range rows {
+		if i == 0 {
+			continue // header
+		}
+		if len(row) < 3 {
+			return nil, fmt.Errorf("closing price row %d needs symbol,date,close", i+1)
+		}
+		date := strings.TrimSpace(row[1])
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return nil, fmt.Errorf("closing price row %d has invalid date %s", i+1, row[1])
+		}
+		price, err := decimal.NewFromString(strings.TrimSpace(row[2]))
+		if err != nil {
+			return nil, fmt.Errorf("closing price row %d has invalid close %s", i+1, row[2])
+		}
+		symbol := strings.ToUpper(strings.TrimSpace(row[0]))
+		provider.closes[symbol] = append(provider.closes[symbol], closingPrice{date: date, price: price})
+	}
+	for _, closes := range provider.closes {
+		sort.SliceStable(closes, func(i, j int) bool {
+			return closes[i].date < closes[j].date
+		})
+	}
+	return provider, nil
+}
+
+func (f *FileQuoteProvider) Quotes(symbols []string) (map[string]decimal.Decimal, error) {
+	prices := make(map[string]decimal.Decimal)
+	for _, symbol := range symbols {
+		latest := ""
+		for _, closing := range f.closes[strings.ToUpper(symbol)] {
+			if closing.date > latest && (f.AsOf == "" || closing.date <= f.AsOf) {
+				latest = closing.date
+				prices[symbol] = closing.price
+			}
+		}
+	}
+	return prices, nil
+}
+
+func (f *FileQuoteProvider) Close(symbol string, date string) (decimal.Decimal, bool, error) {
+	closes := f.closes[strings.ToUpper(symbol)]
+	after := sort.Search(len(closes), func(i int) bool {
+		return closes[i].date > date
+	})
+	if after == 0 {
+		return decimal.Zero, false, nil
+	}
+	return closes[after-1].price, true, nil
+}
+
+var _ DailyPriceSource = &FileQuoteProvider{}
+
+// HTTPQuoteProvider asks a json endpoint for prices, URL is a format string with a %s for the comma separated symbols
+// e.g. http://localhost:9000/quotes?symbols=%s returning [{"symbol": "AAPL", "price": 189.5}]
+type HTTPQuoteProvider struct {
+	URL    string
+	Client *http.Client // defaults to http.DefaultClient
+}
+
+func (p *HTTPQuoteProvider) Quotes(symbols []string) (map[string]decimal.Decimal, error) {
+	prices := make(map[string]decimal.Decimal)
+	if len(symbols) == 0 {
+		return prices, nil
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(fmt.Sprintf(p.URL, strings.Join(symbols, ",")))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("quotes for %s returned %s", strings.Join(symbols, ","), resp.Status)
+	}
+	quotes := []struct {
+		Symbol string          `json:"symbol"`
+		Price  decimal.Decimal `json:"price"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&quotes); err != nil {
+		return nil, err
+	}
+	for _, quote := range quotes {
+		prices[strings.ToUpper(quote.Symbol)] = quote.Price
+	}
+	return prices, nil
+}
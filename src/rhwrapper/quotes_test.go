@@ -0,0 +1,175 @@
+package rhwrapper
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// prices as "SYMBOL=price" in symbol order
+func formatQuotes(prices map[string]decimal.Decimal) string {
+	formatted := []string{}
+	for _, symbol := range []string{"AAPL", "MSFT", "TSLA"} {
+		if price, ok := prices[symbol]; ok {
+			formatted = append(formatted, symbol+"="+price.String())
+		}
+	}
+	return fmt.Sprint(formatted)
+}
+
+func TestFileQuoteProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "closes.csv")
+	data := "symbol,date,close\naapl,2024-05-30,190.29\nAAPL,2024-05-31,192.25\nAAPL,2024-05-29,190.\nMSFT, 2024-05-29 ,429.17\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewFileQuoteProvider(path)
+	if err != nil {
+		t.Fatalf("failing to load closing prices. ERR: %v", err)
+	}
+	tests := []struct {
+		asOf string
+		want string
+	}{
+		{asOf: "", want: "[AAPL=192.25 MSFT=429.17]"},
+		{asOf: "2024-05-30", want: "[AAPL=190.29 MSFT=429.17]"},
+		{asOf: "2024-05-28", want: "[]"},
+	}
+	for _, test := range tests {
+		provider.AsOf = test.asOf
+		prices, err := provider.Quotes([]string{"AAPL", "MSFT", "TSLA"})
+		if err != nil || formatQuotes(prices) != test.want {
+			t.Errorf("as of %q quotes %s err %v, want %s", test.asOf, formatQuotes(prices), err, test.want)
+		}
+	}
+}
+
+func TestFileQuoteProviderErrors(t *testing.T) {
+	tests := map[string]string{
+		"missing a column": "symbol,date,close\nAAPL,2024-05-31\n",
+		"invalid date":     "symbol,date,close\nAAPL,05/31/2024,192.25\n",
+		"invalid close":    "symbol,date,close\nAAPL,2024-05-31,n/a\n",
+	}
+	for name, data := range tests {
+		path := filepath.Join(t.TempDir(), "closes.csv")
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if provider, err := NewFileQuoteProvider(path); err == nil {
+			t.Errorf("%s: loaded %+v", name, provider)
+		}
+	}
+	if _, err := NewFileQuoteProvider(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Errorf("loaded a missing file")
+	}
+}
+
+func TestHTTPQuoteProvider(t *testing.T) {
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		symbols := r.URL.Query().Get("symbols")
+		requests = append(requests, symbols)
+		switch symbols {
+		case "AAPL,MSFT,TSLA":
+			fmt.Fprint(w, `[{"symbol": "aapl", "price": 192.25}, {"symbol": "MSFT", "price": "429.17"}]`)
+		case "DOWN":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			fmt.Fprint(w, `{"symbol": `)
+		}
+	}))
+	defer server.Close()
+	provider := &HTTPQuoteProvider{URL: server.URL + "/quotes?symbols=%s", Client: server.Client()}
+
+	prices, err := provider.Quotes([]string{"AAPL", "MSFT", "TSLA"})
+	if err != nil || formatQuotes(prices) != "[AAPL=192.25 MSFT=429.17]" {
+		t.Errorf("quotes %s err %v", formatQuotes(prices), err)
+	}
+	// nothing to ask for
+	if prices, err := provider.Quotes(nil); err != nil || len(prices) != 0 {
+		t.Errorf("quotes %v err %v of no symbols", prices, err)
+	}
+	for _, symbol := range []string{"DOWN", "JUNK"} {
+		if prices, err := provider.Quotes([]string{symbol}); err == nil {
+			t.Errorf("%s quotes %v, want an error", symbol, prices)
+		}
+	}
+	if fmt.Sprint(requests) != "[AAPL,MSFT,TSLA DOWN JUNK]" {
+		t.Errorf("requests %v", requests)
+	}
+}
+
+func TestRobinhoodQuoteProviderLoggedOut(t *testing.T) {
+	if prices, err := (&RobinhoodQuoteProvider{}).Quotes([]string{"AAPL"}); err == nil {
+		t.Errorf("quotes %v without a client", prices)
+	}
+}
+
+// serves fixed prices
+type stubQuoteProvider map[string]decimal.Decimal
+
+func (s stubQuoteProvider) Quotes(symbols []string) (map[string]decimal.Decimal, error) {
+	return s, nil
+}
+
+func TestValueOpenLots(t *testing.T) {
+	source := NewFakeSource()
+	source.AddStockTrade("AAPL", "buy", 10, 100, "2022-01-03 10:00:00")
+	source.AddStockTrade("AAPL", "buy", 5, 200, "2024-03-01 10:00:00")
+	source.AddStockTrade("TSLA", "buy", 2, 250, "2024-01-03 10:00:00")
+	source.AddStockTrade("MSFT", "buy", 1, 300, "2023-01-03 10:00:00")
+	source.AddStockTrade("MSFT", "sell", 1, 350, "2023-06-01 10:00:00")
+	report := realizedEarnings(t, source, CostBasisSelector{})
+
+	values, err := ValueOpenLots(report.OpenLots, stubQuoteProvider{"AAPL": dec("192.25")}, "2024-05-31")
+	if err != nil {
+		t.Fatalf("failing to value open lots. ERR: %v", err)
+	}
+	wants := []struct {
+		ticker   string
+		basis    string
+		value    string
+		gain     string
+		ret      string
+		priced   bool
+		longTerm bool
+	}{
+		{ticker: "AAPL", basis: "1000", value: "1922.5", gain: "922.5", ret: "92.25", priced: true, longTerm: true},
+		{ticker: "AAPL", basis: "1000", value: "961.25", gain: "-38.75", ret: "-3.88", priced: true},
+		{ticker: "TSLA", basis: "500", value: "0", gain: "0", ret: "0"}, // no quote, shown at cost
+	}
+	if len(values) != len(wants) {
+		t.Fatalf("valued %d lots, want %d: %+v", len(values), len(wants), values)
+	}
+	for i, want := range wants {
+		value := values[i]
+		if value.Ticker != want.ticker || value.Priced != want.priced || value.LongTerm != want.longTerm {
+			t.Errorf("lot %d %+v, want %+v", i, value, want)
+		}
+		assertDecimal(t, want.ticker+" basis", value.Basis, want.basis)
+		assertDecimal(t, want.ticker+" market value", value.MarketValue, want.value)
+		assertDecimal(t, want.ticker+" gain", value.Gain, want.gain)
+		assertDecimal(t, want.ticker+" return", value.Return, want.ret)
+	}
+
+	holdings := SummarizeHoldings(values)
+	if len(holdings) != 2 || holdings[0].Ticker != "AAPL" || !holdings[0].Priced || holdings[1].Ticker != "TSLA" || holdings[1].Priced {
+		t.Fatalf("holdings %+v", holdings)
+	}
+	assertDecimal(t, "AAPL qty", holdings[0].Qty, "15")
+	assertDecimal(t, "AAPL market value", holdings[0].MarketValue, "2883.75")
+	assertDecimal(t, "AAPL gain", holdings[0].Gain, "883.75")
+	assertDecimal(t, "AAPL return", holdings[0].Return, "44.19")
+	assertDecimal(t, "AAPL long term gain", holdings[0].LongTermGain, "922.5")
+	assertDecimal(t, "AAPL short term gain", holdings[0].ShortTermGain, "-38.75")
+
+	// without quotes every lot is at cost
+	values, err = ValueOpenLots(report.OpenLots, nil, "2024-05-31")
+	if err != nil || len(values) != 3 || values[0].Priced {
+		t.Errorf("values %+v err %v without quotes", values, err)
+	}
+}
@@ -0,0 +1,179 @@
+package rhwrapper
+
+// reconciliation warnings for trade history that doesn't add up, and user supplied opening lots to fix it
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	models "github.com/Ryang20718/robinhood-client/models"
+	"github.com/go-gota/gota/dataframe"
+	"github.com/go-gota/gota/series"
+	"github.com/shopspring/decimal"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type WarningKind string
+
+const (
+	OrphanSell        WarningKind = "orphan sell"         // sell with no open lots at all
+	Oversold          WarningKind = "oversold"            // sell larger than the open lots
+	OrphanOptionClose WarningKind = "orphan option close" // BTC/STC with no opening leg
+	NegativeBalance   WarningKind = "negative balance"    // lot or contract balance below zero
+)
+
+type ReconciliationWarning struct {
+	Kind    WarningKind
+	Ticker  string
+	Date    string
+	Qty     decimal.Decimal // qty that couldn't be reconciled
+	Price   decimal.Decimal
+	Message string
+}
+
+// OpeningLot is a lot missing from the robinhood history, e.g. transferred in via ACATS
+type OpeningLot struct {
+	Ticker   string          `json:"ticker"`
+	Date     string          `json:"date"` // 2006-01-02
+	Qty      decimal.Decimal `json:"qty"`
+	UnitCost decimal.Decimal `json:"unitCost"`
+}
+
+/*
+Load opening lots from a json or csv file
+
+json: [{"ticker": "AAPL", "date": "2019-06-03", "qty": 10, "unitCost": 43.5}]
+csv:  ticker,date,qty,unitCost with a header row
+*/
+func LoadOpeningLots(path string) ([]OpeningLot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failing to read opening lots. ERR: %v", err)
+	}
+	lots := []OpeningLot{}
+	if strings.ToLower(filepath.Ext(path)) != ".csv" {
+		if err := json.Unmarshal(data, &lots); err != nil {
+			return nil, fmt.Errorf("failing to parse opening lots. ERR: %v", err)
+		}
+		for i := range lots {
+			lots[i].Ticker = normalizeTicker(lots[i].Ticker)
+			lots[i].Date = strings.TrimSpace(lots[i].Date)
+		}
+		return lots, nil
+	}
+	records, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failing to parse opening lots. ERR: %v", err)
+	}
+	for i, record := range records {
+		if i == 0 {
+			continue // header
+		}
+		if len(record) < 4 {
+			return nil, fmt.Errorf("opening lot row %d needs ticker,date,qty,unitCost", i+1)
+		}
+		qty, err := decimal.NewFromString(strings.TrimSpace(record[2]))
+		if err != nil {
+			return nil, fmt.Errorf("opening lot row %d has invalid qty %s", i+1, record[2])
+		}
+		unitCost, err := decimal.NewFromString(strings.TrimSpace(record[3]))
+		if err != nil {
+			return nil, fmt.Errorf("opening lot row %d has invalid unitCost %s", i+1, record[3])
+		}
+		lots = append(lots, OpeningLot{
+			Ticker:   normalizeTicker(record[0]),
+			Date:     strings.TrimSpace(record[1]),
+			Qty:      qty,
+			UnitCost: unitCost,
+		})
+	}
+	return lots, nil
+}
+
+// tickers are matched against trades in upper case
+func normalizeTicker(ticker string) string {
+	return strings.ToUpper(strings.TrimSpace(ticker))
+}
+
+/*
+Add opening lots to fetched stock trades as buys on their date
+*/
+func ApplyOpeningLots(stockMap map[string][]models.Transaction, lots []OpeningLot) map[string][]models.Transaction {
+	merged := make(map[string][]models.Transaction)
+	for ticker, transactions := range stockMap {
+		merged[ticker] = append([]models.Transaction{}, transactions...)
+	}
+	for _, lot := range lots {
+		// the client models are float64, exact to 1e-6 survives the round trip
+		merged[lot.Ticker] = append(merged[lot.Ticker], models.Transaction{
+			Ticker:          lot.Ticker,
+			TransactionType: "buy",
+			Qty:             lot.Qty.InexactFloat64(),
+			UnitCost:        lot.UnitCost.InexactFloat64(),
+			CreatedAt:       lot.Date + " 00:00:00",
+			Tag:             "opening lot",
+		})
+	}
+	return merged
+}
+
+func (r *earningsRun) warn(kind WarningKind, ticker string, date string, qty decimal.Decimal, price decimal.Decimal, message string) {
+	r.warnings = append(r.warnings, ReconciliationWarning{
+		Kind:    kind,
+		Ticker:  ticker,
+		Date:    date,
+		Qty:     qty,
+		Price:   price,
+		Message: message,
+	})
+}
+
+/*
+Flag balances still below zero once every trade was processed
+*/
+func (r *earningsRun) checkBalances() {
+	for ticker, lots := range r.profitsMap {
+		for _, lot := range lots {
+			if lot.Qty.IsNegative() {
+				r.warn(NegativeBalance, ticker, lotDate(lot), lot.Qty, lot.UnitCost, "lot has a negative quantity")
+			}
+		}
+	}
+	for _, imbalance := range r.optionLedger.Imbalances() {
+		r.warn(NegativeBalance, imbalance.Contract.Underlying, strings.Split(imbalance.OpenedAt, "T")[0], imbalance.Qty, imbalance.Premium,
+			fmt.Sprintf("%s has more contracts closed than opened", imbalance.Contract))
+	}
+}
+
+/*
+convert reconciliation warnings to dataframe
+*/
+func ConvertWarningDf(warnings []ReconciliationWarning) *dataframe.DataFrame {
+	kinds := series.New([]string{}, series.String, "Kind")
+	tickers := series.New([]string{}, series.String, "Ticker")
+	dates := series.New([]string{}, series.String, "Date")
+	qtys := series.New([]float64{}, series.Float, "Qty")
+	prices := series.New([]float64{}, series.Float, "Price")
+	messages := series.New([]string{}, series.String, "Message")
+
+	for _, warning := range warnings {
+		kinds.Append(string(warning.Kind))
+		tickers.Append(warning.Ticker)
+		dates.Append(warning.Date)
+		qtys.Append(warning.Qty.InexactFloat64())
+		prices.Append(warning.Price.InexactFloat64())
+		messages.Append(warning.Message)
+	}
+
+	df := dataframe.New(
+		kinds,
+		tickers,
+		dates,
+		qtys,
+		prices,
+		messages,
+	)
+	return &df
+}
@@ -0,0 +1,123 @@
+package rhwrapper
+
+import (
+	models "github.com/Ryang20718/robinhood-client/models"
+	"github.com/shopspring/decimal"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadOpeningLots(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		err  bool
+	}{
+		{name: "csv", file: "lots.csv", data: "ticker,date,qty,unitCost\naapl, 2019-06-03 ,10,43.5\n"},
+		{name: "json", file: "lots.json", data: `[{"ticker": "AAPL", "date": "2019-06-03", "qty": 10, "unitCost": 43.5}]`},
+		{name: "json lower case", file: "lots.json", data: `[{"ticker": " aapl", "date": "2019-06-03 ", "qty": 10, "unitCost": 43.5}]`},
+		{name: "csv missing a column", file: "lots.csv", data: "ticker,date,qty,unitCost\nAAPL,2019-06-03,10\n", err: true},
+		{name: "csv invalid qty", file: "lots.csv", data: "ticker,date,qty,unitCost\nAAPL,2019-06-03,ten,43.5\n", err: true},
+		{name: "invalid json", file: "lots.json", data: `{"ticker": "AAPL"}`, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.file)
+			if err := os.WriteFile(path, []byte(test.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			lots, err := LoadOpeningLots(path)
+			if test.err {
+				if err == nil {
+					t.Errorf("loaded %+v", lots)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failing to load opening lots. ERR: %v", err)
+			}
+			if len(lots) != 1 || lots[0].Ticker != "AAPL" || lots[0].Date != "2019-06-03" || !lots[0].Qty.Equal(dec("10")) || !lots[0].UnitCost.Equal(dec("43.5")) {
+				t.Errorf("lots %+v, want 10 AAPL at 43.5 from 2019-06-03", lots)
+			}
+		})
+	}
+}
+
+func TestApplyOpeningLots(t *testing.T) {
+	stockMap := map[string][]models.Transaction{
+		"AAPL": {{Ticker: "AAPL", TransactionType: "sell", Qty: 10, UnitCost: 150, CreatedAt: "2023-01-03 10:00:00"}},
+	}
+	merged := ApplyOpeningLots(stockMap, []OpeningLot{{Ticker: "AAPL", Date: "2019-06-03", Qty: dec("10"), UnitCost: dec("43.5")}})
+	if len(stockMap["AAPL"]) != 1 {
+		t.Errorf("changed the fetched trades %+v", stockMap)
+	}
+	if len(merged["AAPL"]) != 2 {
+		t.Fatalf("merged %+v", merged)
+	}
+	opening := merged["AAPL"][1]
+	if opening.TransactionType != "buy" || opening.CreatedAt != "2019-06-03 00:00:00" || opening.Qty != 10 || opening.UnitCost != 43.5 {
+		t.Errorf("opening lot %+v", opening)
+	}
+}
+
+func TestEarningsRunWarnings(t *testing.T) {
+	tests := []struct {
+		name   string
+		lots   []*Lot // open before the sell
+		kind   WarningKind
+		qty    string
+		profit string // booked for the matched part
+	}{
+		{name: "no open lots", kind: OrphanSell, qty: "10", profit: "0"},
+		{
+			name:   "more sold than held",
+			lots:   []*Lot{{Ticker: "AAPL", Qty: dec("4"), UnitCost: dec("100"), CreatedAt: "2022-12-01 10:00:00"}},
+			kind:   Oversold,
+			qty:    "6",
+			profit: "200",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			run := newEarningsRun(CostBasisSelector{}, nil)
+			run.profitsMap["AAPL"] = test.lots
+			sell := &Lot{Ticker: "AAPL", TransactionType: "sell", Qty: dec("10"), UnitCost: dec("150"), CreatedAt: "2023-01-03 10:00:00"}
+			if err := run.sell("AAPL", sell); err != nil {
+				t.Fatalf("failing to sell. ERR: %v", err)
+			}
+			if len(run.warnings) != 1 || run.warnings[0].Kind != test.kind || !run.warnings[0].Qty.Equal(dec(test.qty)) || run.warnings[0].Date != "2023-01-03" {
+				t.Errorf("warnings %+v, want %s of %v", run.warnings, test.kind, test.qty)
+			}
+			// the unmatched proceeds aren't profit
+			profit := decimal.Zero
+			for _, p := range run.profitList {
+				profit = profit.Add(p.Amount)
+			}
+			assertDecimal(t, "profit", profit, test.profit)
+		})
+	}
+}
+
+func TestEarningsRunNegativeBalances(t *testing.T) {
+	run := newEarningsRun(CostBasisSelector{}, nil)
+	run.buy("AAPL", &Lot{Ticker: "AAPL", Qty: dec("-1"), UnitCost: dec("100"), CreatedAt: "2023-01-03 10:00:00"})
+	if len(run.profitsMap["AAPL"]) != 0 {
+		t.Errorf("negative lot %+v kept", run.profitsMap["AAPL"])
+	}
+	// robinhood's negative leg for a partially closed opener with nothing to net against
+	run.optionLedger.Open(testPut, putLeg("STO", -1, 2, "2023-01-05T15:00:00Z"), dec("-1"), dec("2"))
+	run.checkBalances()
+
+	kinds := []WarningKind{}
+	for _, warning := range run.warnings {
+		kinds = append(kinds, warning.Kind)
+	}
+	if len(kinds) != 2 || kinds[0] != NegativeBalance || kinds[1] != NegativeBalance {
+		t.Errorf("warnings %+v, want two negative balances", run.warnings)
+	}
+	if run.warnings[1].Ticker != "XYZ" || run.warnings[1].Date != "2023-01-05" || !run.warnings[1].Qty.Equal(dec("-1")) {
+		t.Errorf("option warning %+v", run.warnings[1])
+	}
+}
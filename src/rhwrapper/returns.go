@@ -0,0 +1,432 @@
+package rhwrapper
+
+// time weighted and money weighted (XIRR) returns of the account and each ticker, from a daily valuation
+
+import (
+	"context"
+	models "github.com/Ryang20718/robinhood-client/models"
+	"github.com/go-gota/gota/dataframe"
+	"github.com/go-gota/gota/series"
+	"github.com/shopspring/decimal"
+	"math"
+	"sort"
+	"time"
+)
+
+// DailyValue is the account or one ticker at the close of a day
+type DailyValue struct {
+	Date  string          // 2006-01-02
+	Value decimal.Decimal // cash and positions for the account, the position for a ticker
+	Flow  decimal.Decimal // money put in that day, negative when taken out. For a ticker buys less sales and income
+}
+
+// PeriodReturn is how the account or one ticker did over a year or the whole history
+type PeriodReturn struct {
+	Period     string // year, or "all"
+	Ticker     string // empty for the account
+	Start      string // first day of the period with history, 2006-01-02
+	End        string
+	StartValue decimal.Decimal // at the close of the day before Start
+	EndValue   decimal.Decimal
+	NetFlow    decimal.Decimal
+	Gain       decimal.Decimal // EndValue - StartValue - NetFlow
+	TWR        decimal.Decimal // percent, time weighted so deposits and withdrawals don't move it
+	XIRR       decimal.Decimal // percent a year, money weighted
+	HasXIRR    bool            // false when the cash flows have no rate of return
+}
+
+// ReturnsReport is the outcome of a returns run
+type ReturnsReport struct {
+	Daily    []DailyValue // the account every day from its first transfer or trade to asOf
+	Account  []PeriodReturn
+	Tickers  []PeriodReturn
+	Unpriced []string // tickers the price source had no close for, valued at the last price they traded at
+}
+
+// shares and cash moving on a day
+type ledgerEntry struct {
+	date     string
+	ticker   string          // current symbol, empty for transfers and interest
+	qty      decimal.Decimal // shares in, negative out. Split adjusted to today
+	cash     decimal.Decimal // cash in, negative out
+	price    decimal.Decimal // split adjusted price the shares traded at, zero when none did
+	transfer bool            // deposit or withdrawal, money from outside the account
+}
+
+/*
+Fetch trades, income and transfers from source and calculate returns through asOf (2006-01-02)
+*/
+func ProcessReturns(ctx context.Context, source TransactionSource, prices DailyPriceSource, asOf string) (*ReturnsReport, error) {
+	stockMap, err := source.FetchRegularTrades(ctx)
+	if err != nil {
+		return nil, err
+	}
+	optionMap, err := source.FetchOptionTrades(ctx)
+	if err != nil {
+		return nil, err
+	}
+	income, err := source.FetchIncome(ctx)
+	if err != nil {
+		return nil, err
+	}
+	transfers, err := source.FetchTransfers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return CalculateReturns(source, stockMap, optionMap, income, transfers, prices, asOf)
+}
+
+/*
+Calculate returns from already fetched trades, source resolves symbol changes and splits
+
+The account is valued every day as cash plus shares at the close from prices, prices may be nil to value every
+position at the last price it traded at. Deposits and withdrawals are the account's cash flows, spending more cash than
+the account holds counts as a deposit. A ticker's cash flows
+are its buys, sales, option premiums and income, so its return includes the premiums collected on it. Open options
+are worth nothing until they're closed or assigned and corporate actions aren't applied.
+
+The time weighted return chains daily returns, money coming in counts from the start of its day and money going
+out from the end. XIRR is the yearly rate that discounts the value before the period, the flows in it and the value
+at its end to zero
+*/
+func CalculateReturns(source TransactionSource, stockMap map[string][]models.Transaction, optionMap map[string][]models.OptionTransaction, income []IncomeEvent, transfers []Transfer, prices DailyPriceSource, asOf string) (*ReturnsReport, error) {
+	history, err := BuildPortfolioHistory(source, stockMap, optionMap, income, transfers, prices, asOf)
+	if err != nil {
+		return nil, err
+	}
+	return ReturnsFromHistory(history), nil
+}
+
+/*
+Returns of a portfolio history built by BuildPortfolioHistory
+*/
+func ReturnsFromHistory(history []PortfolioSnapshot) *ReturnsReport {
+	report := &ReturnsReport{Daily: DailyValues(history), Account: []PeriodReturn{}, Tickers: []PeriodReturn{}, Unpriced: []string{}}
+	tickerDays := make(map[string][]DailyValue)
+	unpriced := make(map[string]bool)
+	for i, snapshot := range history {
+		for _, holding := range snapshot.Holdings {
+			days := tickerDays[holding.Ticker]
+			if days == nil {
+				days = []DailyValue{}
+			} else {
+				// days the ticker wasn't held, from the snapshot after its last one
+				for _, missed := range history[len(days)+firstDay(history, days) : i] {
+					days = append(days, DailyValue{Date: missed.Date})
+				}
+			}
+			tickerDays[holding.Ticker] = append(days, DailyValue{Date: snapshot.Date, Value: holding.Value, Flow: holding.Flow})
+			if !holding.Priced && !holding.Qty.IsZero() {
+				unpriced[holding.Ticker] = true
+			}
+		}
+	}
+
+	report.Account = periodReturns("", report.Daily)
+	tickers := []string{}
+	for ticker := range tickerDays {
+		tickers = append(tickers, ticker)
+	}
+	sort.Strings(tickers)
+	for _, ticker := range tickers {
+		days := tickerDays[ticker]
+		// and the days since it was last held
+		for _, missed := range history[len(days)+firstDay(history, days):] {
+			days = append(days, DailyValue{Date: missed.Date})
+		}
+		report.Tickers = append(report.Tickers, periodReturns(ticker, days)...)
+	}
+	for ticker := range unpriced {
+		report.Unpriced = append(report.Unpriced, ticker)
+	}
+	sort.Strings(report.Unpriced)
+	return report
+}
+
+// index in history of the first of days, history has a snapshot every day
+func firstDay(history []PortfolioSnapshot, days []DailyValue) int {
+	return sort.Search(len(history), func(i int) bool {
+		return history[i].Date >= days[0].Date
+	})
+}
+
+/*
+Share and cash movements of every trade, income payment and transfer sorted by date
+*/
+func returnsLedger(source TransactionSource, stockMap map[string][]models.Transaction, optionMap map[string][]models.OptionTransaction, income []IncomeEvent, transfers []Transfer) ([]ledgerEntry, error) {
+	entries := []ledgerEntry{}
+	optionEvents, err := source.FetchOptionEvents()
+	if err != nil {
+		return nil, err
+	}
+	assignmentDates := newAssignmentDates(optionEvents)
+	// current symbol and splits of a ticker traded on date
+	resolve := func(ticker string, date string) (string, []Split, error) {
+		current, err := source.FetchCurrentTickerSymbol(ticker, date)
+		if err != nil {
+			return "", nil, err
+		}
+		splits, err := source.FetchStockSplits(current)
+		if err != nil {
+			return "", nil, err
+		}
+		return current, splits, nil
+	}
+
+	for _, stocks := range stockMap {
+		for _, stock := range stocks {
+			date := tradeDate(stock.CreatedAt)
+			ticker, splits, err := resolve(stock.Ticker, date)
+			if err != nil {
+				return nil, err
+			}
+			qty, price := QtyFromFloat(stock.Qty), PriceFromFloat(stock.UnitCost)
+			entry := ledgerEntry{date: date, ticker: ticker, cash: amountOf(qty, price)}
+			entry.qty, entry.price = GetStockSplitCorrection(splits, date, qty, price)
+			if stock.TransactionType == "buy" {
+				entry.cash = entry.cash.Neg()
+			} else {
+				entry.qty = entry.qty.Neg()
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	for _, tickerOptions := range optionMap {
+		// assignment events go to the oldest legs of a contract first, like the realized earnings
+		options := append([]models.OptionTransaction{}, tickerOptions...)
+		sort.SliceStable(options, func(i, j int) bool {
+			return options[i].CreatedAt < options[j].CreatedAt
+		})
+		for _, option := range options {
+			date := tradeDate(option.CreatedAt)
+			ticker, splits, err := resolve(option.Ticker, date)
+			if err != nil {
+				return nil, err
+			}
+			shares := QtyFromFloat(option.Qty).Mul(optionMultiplier)
+			premium := amountOf(shares, PriceFromFloat(option.UnitCost))
+			short := option.TransactionType == "STO" || option.TransactionType == "STC"
+			if !short {
+				premium = premium.Neg()
+			}
+			entries = append(entries, ledgerEntry{date: date, ticker: ticker, cash: premium})
+
+			opening := option.TransactionType == "STO" || option.TransactionType == "BTO"
+			if !opening || option.Status != "Assigned" {
+				continue
+			}
+			// shares change hands at the strike on the day the option is assigned or exercised
+			contract := ContractFor(ticker, option)
+			strike := PriceFromFloat(option.StrikePrice)
+			for _, part := range assignmentDates.take(option, QtyFromFloat(option.Qty)) {
+				partShares := part.qty.Mul(optionMultiplier)
+				assignment := ledgerEntry{date: part.date, ticker: ticker, cash: amountOf(partShares, strike)}
+				assignment.qty, assignment.price = GetStockSplitCorrection(splits, date, partShares, strike)
+				if (short && contract.Type == "put") || (!short && contract.Type == "call") {
+					assignment.cash = assignment.cash.Neg()
+				} else {
+					assignment.qty = assignment.qty.Neg()
+				}
+				entries = append(entries, assignment)
+			}
+		}
+	}
+
+	for _, event := range income {
+		ticker := event.Ticker
+		if ticker != "" {
+			current, splits, err := resolve(ticker, event.Date)
+			if err != nil {
+				return nil, err
+			}
+			ticker = current
+			if event.ReinvestedQty.IsPositive() {
+				reinvested := ledgerEntry{date: event.Date, ticker: ticker, cash: amountOf(event.ReinvestedQty, event.ReinvestedPrice).Neg()}
+				reinvested.qty, reinvested.price = GetStockSplitCorrection(splits, event.Date, event.ReinvestedQty, event.ReinvestedPrice)
+				entries = append(entries, reinvested)
+			}
+		}
+		entries = append(entries, ledgerEntry{date: event.Date, ticker: ticker, cash: event.Amount})
+	}
+
+	for _, transfer := range transfers {
+		entries = append(entries, ledgerEntry{date: transfer.Date, cash: transfer.Amount, transfer: true})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].date < entries[j].date
+	})
+	return entries, nil
+}
+
+/*
+Returns of days for every year and the whole history, years with nothing held and no flows are left out
+*/
+func periodReturns(ticker string, days []DailyValue) []PeriodReturn {
+	periods := splitPeriods(ticker, days, len("2006"))
+	if len(days) == 0 {
+		return periods
+	}
+	if period, ok := periodReturn(ticker, "all", days, 0, len(days)-1); ok {
+		periods = append(periods, period)
+	}
+	return periods
+}
+
+// returns of days split by the first keyLength characters of their date, a year or a month
+func splitPeriods(ticker string, days []DailyValue, keyLength int) []PeriodReturn {
+	periods := []PeriodReturn{}
+	first := 0
+	for i := range days {
+		if i+1 < len(days) && days[i+1].Date[:keyLength] == days[i].Date[:keyLength] {
+			continue
+		}
+		if period, ok := periodReturn(ticker, days[i].Date[:keyLength], days, first, i); ok {
+			periods = append(periods, period)
+		}
+		first = i + 1
+	}
+	return periods
+}
+
+func periodReturn(ticker string, name string, days []DailyValue, first int, last int) (PeriodReturn, bool) {
+	period := PeriodReturn{Period: name, Ticker: ticker, Start: days[first].Date, End: days[last].Date, EndValue: days[last].Value}
+	if first > 0 {
+		period.StartValue = days[first-1].Value
+	}
+	growth := 1.0
+	previous := period.StartValue
+	active := !period.StartValue.IsZero()
+	start, _ := time.Parse("2006-01-02", period.Start)
+	// cash flows as the investor sees them, the value going in, deposits are paid and the value at the end is received
+	flows := []cashFlow{{years: 0, amount: period.StartValue.Neg().InexactFloat64()}}
+	for _, day := range days[first : last+1] {
+		period.NetFlow = period.NetFlow.Add(day.Flow)
+		in, out := decimal.Zero, decimal.Zero
+		if day.Flow.IsPositive() {
+			in = day.Flow
+		} else {
+			out = day.Flow.Neg()
+		}
+		if invested := previous.Add(in); invested.IsPositive() {
+			growth *= day.Value.Add(out).Div(invested).InexactFloat64()
+		}
+		if !day.Flow.IsZero() || !day.Value.IsZero() {
+			active = true
+		}
+		if !day.Flow.IsZero() {
+			date, _ := time.Parse("2006-01-02", day.Date)
+			flows = append(flows, cashFlow{years: date.Sub(start).Hours() / 24 / 365, amount: day.Flow.Neg().InexactFloat64()})
+		}
+		previous = day.Value
+	}
+	if !active {
+		return period, false
+	}
+	end, _ := time.Parse("2006-01-02", period.End)
+	flows = append(flows, cashFlow{years: end.Sub(start).Hours() / 24 / 365, amount: period.EndValue.InexactFloat64()})
+	period.Gain = period.EndValue.Sub(period.StartValue).Sub(period.NetFlow)
+	period.TWR = percentFromRate(growth - 1)
+	if rate, ok := xirr(flows); ok {
+		period.XIRR = percentFromRate(rate)
+		period.HasXIRR = true
+	}
+	return period, true
+}
+
+// amount paid (negative) or received years after the start of a period
+type cashFlow struct {
+	years  float64
+	amount float64
+}
+
+func netPresentValue(flows []cashFlow, rate float64) float64 {
+	value := 0.0
+	for _, flow := range flows {
+		value += flow.amount / math.Pow(1+rate, flow.years)
+	}
+	return value
+}
+
+/*
+Yearly rate that brings the net present value of flows to zero, found by bisection. False when the flows have no
+rate, all paid or all received, or the rate is beyond any sensible range
+*/
+func xirr(flows []cashFlow) (float64, bool) {
+	low, high := -0.999999, 1.0
+	lowValue := netPresentValue(flows, low)
+	for math.Signbit(lowValue) == math.Signbit(netPresentValue(flows, high)) {
+		high *= 2
+		if high > 1e9 {
+			return 0, false
+		}
+	}
+	for i := 0; i < 200; i++ {
+		mid := (low + high) / 2
+		midValue := netPresentValue(flows, mid)
+		if math.Signbit(midValue) == math.Signbit(lowValue) {
+			low, lowValue = mid, midValue
+		} else {
+			high = mid
+		}
+	}
+	rate := (low + high) / 2
+	if math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return 0, false
+	}
+	return rate, true
+}
+
+func percentFromRate(rate float64) decimal.Decimal {
+	if math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return decimal.Zero
+	}
+	return decimal.NewFromFloat(rate * 100).Round(moneyPlaces)
+}
+
+/*
+convert period returns to dataframe
+*/
+func ConvertReturnDf(returns []PeriodReturn) *dataframe.DataFrame {
+	periods := series.New([]string{}, series.String, "Period")
+	tickers := series.New([]string{}, series.String, "Ticker")
+	starts := series.New([]string{}, series.String, "Start")
+	ends := series.New([]string{}, series.String, "End")
+	startValues := series.New([]float64{}, series.Float, "StartValue")
+	endValues := series.New([]float64{}, series.Float, "EndValue")
+	netFlows := series.New([]float64{}, series.Float, "NetFlow")
+	gains := series.New([]float64{}, series.Float, "Gain")
+	twrs := series.New([]float64{}, series.Float, "TWR")
+	xirrs := series.New([]float64{}, series.Float, "XIRR")
+	hasXIRR := series.New([]bool{}, series.Bool, "HasXIRR")
+
+	for _, period := range returns {
+		periods.Append(period.Period)
+		tickers.Append(period.Ticker)
+		starts.Append(period.Start)
+		ends.Append(period.End)
+		startValues.Append(period.StartValue.InexactFloat64())
+		endValues.Append(period.EndValue.InexactFloat64())
+		netFlows.Append(period.NetFlow.InexactFloat64())
+		gains.Append(period.Gain.InexactFloat64())
+		twrs.Append(period.TWR.InexactFloat64())
+		xirrs.Append(period.XIRR.InexactFloat64())
+		hasXIRR.Append(period.HasXIRR)
+	}
+
+	df := dataframe.New(
+		periods,
+		tickers,
+		starts,
+		ends,
+		startValues,
+		endValues,
+		netFlows,
+		gains,
+		twrs,
+		xirrs,
+		hasXIRR,
+	)
+	return &df
+}
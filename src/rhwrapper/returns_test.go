@@ -0,0 +1,208 @@
+package rhwrapper
+
+import (
+	"context"
+	"github.com/shopspring/decimal"
+	"math"
+	"strings"
+	"testing"
+)
+
+// closes by symbol, oldest first
+type fakePrices map[string][]closingPrice
+
+func (p fakePrices) Close(symbol string, date string) (decimal.Decimal, bool, error) {
+	price, ok := decimal.Zero, false
+	for _, close := range p[symbol] {
+		if close.date > date {
+			break
+		}
+		price, ok = close.price, true
+	}
+	return price, ok, nil
+}
+
+// period return the way a test expects it
+type wantReturn struct {
+	ticker     string
+	period     string
+	start      string
+	end        string
+	startValue string
+	endValue   string
+	netFlow    string
+	gain       string
+	twr        string
+	xirr       string // empty when there's none
+}
+
+func assertReturn(t *testing.T, returns []PeriodReturn, want wantReturn) {
+	t.Helper()
+	for _, got := range returns {
+		if got.Ticker != want.ticker || got.Period != want.period {
+			continue
+		}
+		name := want.ticker + " " + want.period
+		if got.Start != want.start || got.End != want.end {
+			t.Errorf("%s runs %s to %s, want %s to %s", name, got.Start, got.End, want.start, want.end)
+		}
+		assertDecimal(t, name+" start value", got.StartValue, want.startValue)
+		assertDecimal(t, name+" end value", got.EndValue, want.endValue)
+		assertDecimal(t, name+" net flow", got.NetFlow, want.netFlow)
+		assertDecimal(t, name+" gain", got.Gain, want.gain)
+		assertDecimal(t, name+" twr", got.TWR, want.twr)
+		if got.HasXIRR != (want.xirr != "") {
+			t.Errorf("%s has xirr %v, want %q", name, got.HasXIRR, want.xirr)
+		} else if got.HasXIRR {
+			assertDecimal(t, name+" xirr", got.XIRR, want.xirr)
+		}
+		return
+	}
+	t.Errorf("no %s %s return in %+v", want.ticker, want.period, returns)
+}
+
+func TestProcessReturns(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  func(source *FakeSource)
+		prices  fakePrices
+		account []wantReturn
+		tickers []wantReturn
+	}{
+		{
+			name: "bought and held",
+			script: func(source *FakeSource) {
+				source.AddTransfer("2023-01-02", 1000)
+				source.AddStockTrade("AAPL", "buy", 10, 100, "2023-01-03 10:00:00")
+			},
+			prices: fakePrices{"AAPL": {{date: "2023-01-03", price: dec("100")}, {date: "2023-12-29", price: dec("110")}}},
+			account: []wantReturn{
+				{period: "2023", start: "2023-01-02", end: "2023-12-31", startValue: "0", endValue: "1100", netFlow: "1000", gain: "100", twr: "10", xirr: "10.06"},
+				{period: "2024", start: "2024-01-01", end: "2024-01-02", startValue: "1100", endValue: "1100", netFlow: "0", gain: "0", twr: "0", xirr: "0"},
+				{period: "all", start: "2023-01-02", end: "2024-01-02", startValue: "0", endValue: "1100", netFlow: "1000", gain: "100", twr: "10", xirr: "10"},
+			},
+			tickers: []wantReturn{
+				{ticker: "AAPL", period: "all", start: "2023-01-03", end: "2024-01-02", startValue: "0", endValue: "1100", netFlow: "1000", gain: "100", twr: "10", xirr: "10.03"},
+			},
+		},
+		{
+			name: "deposit half way through",
+			script: func(source *FakeSource) {
+				source.AddTransfer("2023-01-02", 1000)
+				source.AddStockTrade("AAPL", "buy", 10, 100, "2023-01-03 10:00:00")
+				source.AddTransfer("2023-07-03", 1000)
+			},
+			prices: fakePrices{"AAPL": {{date: "2023-01-03", price: dec("100")}, {date: "2023-06-30", price: dec("120")}}},
+			account: []wantReturn{
+				// the deposit doesn't move the time weighted return, the money weighted one counts it for half a year
+				{period: "all", start: "2023-01-02", end: "2024-01-02", startValue: "0", endValue: "2200", netFlow: "2000", gain: "200", twr: "20", xirr: "13.46"},
+			},
+			tickers: []wantReturn{
+				{ticker: "AAPL", period: "all", start: "2023-01-03", end: "2024-01-02", startValue: "0", endValue: "1200", netFlow: "1000", gain: "200", twr: "20", xirr: "20.06"},
+			},
+		},
+		{
+			name: "sold and withdrawn",
+			script: func(source *FakeSource) {
+				source.AddTransfer("2023-01-02", 1000)
+				source.AddStockTrade("AAPL", "buy", 10, 100, "2023-01-03 10:00:00")
+				source.AddStockTrade("AAPL", "sell", 10, 110, "2023-07-03 10:00:00")
+				source.AddTransfer("2023-07-05", -1100)
+			},
+			prices: fakePrices{"AAPL": {{date: "2023-01-03", price: dec("100")}, {date: "2023-07-03", price: dec("110")}}},
+			account: []wantReturn{
+				{period: "all", start: "2023-01-02", end: "2024-01-02", startValue: "0", endValue: "0", netFlow: "-100", gain: "100", twr: "10", xirr: "20.81"},
+			},
+			tickers: []wantReturn{
+				{ticker: "AAPL", period: "all", start: "2023-01-03", end: "2024-01-02", startValue: "0", endValue: "0", netFlow: "-100", gain: "100", twr: "10", xirr: "21.19"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := NewFakeSource()
+			test.script(source)
+
+			report, err := ProcessReturns(context.Background(), source, test.prices, "2024-01-02")
+			if err != nil {
+				t.Fatalf("failing to process returns. ERR: %v", err)
+			}
+			if len(report.Unpriced) > 0 {
+				t.Errorf("unpriced %v", report.Unpriced)
+			}
+			for _, want := range test.account {
+				assertReturn(t, report.Account, want)
+			}
+			for _, want := range test.tickers {
+				assertReturn(t, report.Tickers, want)
+			}
+		})
+	}
+}
+
+func TestProcessReturnsAssignment(t *testing.T) {
+	source := NewFakeSource()
+	source.AddTransfer("2023-01-02", 5000)
+	source.AddOptionTrade("XYZ", "STO", "put", 1, 50, 2, "2023-01-10T15:00:00Z", "2023-02-17", "Assigned")
+	// assigned early, the shares are bought on the 8th and not at expiration
+	source.AddOptionEvent("XYZ", "put", 50, "2023-02-17", "2023-02-08", 1)
+	prices := fakePrices{"XYZ": {{date: "2023-02-08", price: dec("52")}, {date: "2023-12-29", price: dec("55")}}}
+
+	report, err := ProcessReturns(context.Background(), source, prices, "2024-01-02")
+	if err != nil {
+		t.Fatalf("failing to process returns. ERR: %v", err)
+	}
+	values := make(map[string]string)
+	for _, day := range report.Daily {
+		values[day.Date] = day.Value.String()
+	}
+	// the premium is cash, then 100 shares at the 52 close and what's left of the cash
+	for date, want := range map[string]string{"2023-01-10": "5200", "2023-02-07": "5200", "2023-02-08": "5400"} {
+		if values[date] != want {
+			t.Errorf("account value %s on %s, want %s", values[date], date, want)
+		}
+	}
+	assertReturn(t, report.Account, wantReturn{period: "all", start: "2023-01-02", end: "2024-01-02", startValue: "0", endValue: "5700", netFlow: "5000", gain: "700", twr: "14", xirr: "14"})
+}
+
+func TestParseActivityTransfers(t *testing.T) {
+	csv := testActivityHeader + `7/5/2023,7/5/2023,7/5/2023,,ACH Withdrawal,ACH,,,"($1,100.00)"
+3/1/2023,3/1/2023,3/1/2023,,ACH Withdrawal,ACH,,,$50.00
+1/3/2023,1/3/2023,1/5/2023,AAPL,Apple CUSIP: 037833100,Buy,10,$100.00,"($1,000.00)"
+1/2/2023,1/2/2023,1/2/2023,,ACH Deposit,ACH,,,"$1,000.00"
+`
+	transfers, err := ParseActivityTransfers(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("failing to parse transfers. ERR: %v", err)
+	}
+	got := []string{}
+	for _, transfer := range transfers {
+		got = append(got, transfer.Date+" "+transfer.Amount.String())
+	}
+	// oldest first, older exports leave the sign off withdrawals
+	if want := "2023-01-02 1000,2023-03-01 -50,2023-07-05 -1100"; strings.Join(got, ",") != want {
+		t.Errorf("transfers %v, want %s", got, want)
+	}
+}
+
+func TestXIRR(t *testing.T) {
+	tests := []struct {
+		name  string
+		flows []cashFlow
+		rate  float64
+		ok    bool
+	}{
+		{name: "ten percent in a year", flows: []cashFlow{{0, -1000}, {1, 1100}}, rate: 0.1, ok: true},
+		{name: "loss over two years", flows: []cashFlow{{0, -1000}, {2, 810}}, rate: -0.1, ok: true},
+		{name: "two deposits", flows: []cashFlow{{0, -1000}, {1, -1000}, {2, 2310}}, rate: 0.1, ok: true},
+		{name: "nothing paid in", flows: []cashFlow{{0, 1000}, {1, 1100}}, ok: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rate, ok := xirr(test.flows)
+			if ok != test.ok || (ok && math.Abs(rate-test.rate) > 1e-9) {
+				t.Errorf("xirr = %v %v, want %v %v", rate, ok, test.rate, test.ok)
+			}
+		})
+	}
+}
@@ -12,20 +12,33 @@ import (
 	"github.com/go-gota/gota/series"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-type HoodAPI interface {
-	Auth(username string, password string, mfa string) error
-	FetchOptionTrades(ctx context.Context) (map[string][]models.OptionTransaction, error)
-	FetchRegularTrades(ctx context.Context) (map[string][]models.Transaction, error)
-}
-
 type Hood struct {
-	Cli *robinhood.Client
+	Cli              *robinhood.Client
+	Splits           SplitProvider   // yahoo when nil
+	Symbols          *SymbolRegistry // DefaultSymbolChanges when nil
+	CorporateActions []CorporateAction
+	Store            *Store        // trades, income and splits are synced into it incrementally when set, see UseStore
+	mu               sync.Mutex    // guards optionEvents
+	optionEvents     []OptionEvent // fetched with the option trades
 }
 
-var SymbolChangeCache = make(map[string]string) // mapping of original symbol --> current symbol
+/*
+Hood for another login with cli, splits and corporate actions are shared. The symbol registry is copied, UseStore
+adds the renames of the login's store to it
+*/
+func (h *Hood) WithClient(cli *robinhood.Client) *Hood {
+	symbols := h.Symbols
+	if symbols != nil {
+		symbols = symbols.Clone()
+	}
+	return &Hood{Cli: cli, Splits: h.Splits, Symbols: symbols, CorporateActions: h.CorporateActions}
+}
 
 func (h *Hood) Auth(username string, password string, mfa string) (*robinhood.Client, error) {
 	if username == "" {
@@ -76,6 +89,36 @@ func (h *Hood) FetchOptionTrades(ctx context.Context) (map[string][]models.Optio
 			return optionMap, nil
 		}
 	}
+	if h.Store != nil {
+		return h.syncOptionTrades(ctx)
+	}
+	optionsOrderMap, err := h.fetchOptionTrades(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tickers := []string{}
+	for ticker := range optionsOrderMap {
+		tickers = append(tickers, ticker)
+	}
+	sort.Strings(tickers)
+	optionEvents, err := h.fetchOptionEvents(ctx, tickers)
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	h.optionEvents = optionEvents
+	h.mu.Unlock()
+	if os.Getenv("DEV") != "" {
+		err := CacheAPICall(cachedFile, optionsOrderMap)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return optionsOrderMap, nil
+}
+
+// every option order from robinhood by ticker
+func (h *Hood) fetchOptionTrades(ctx context.Context) (map[string][]models.OptionTransaction, error) {
 	optionsOrderMap := make(map[string][]models.OptionTransaction)
 	optionOrders, err := h.Cli.GetOptionsOrders(ctx)
 	if err != nil {
@@ -90,13 +133,59 @@ func (h *Hood) FetchOptionTrades(ctx context.Context) (map[string][]models.Optio
 		}
 		optionsOrderMap[order.Ticker] = append(optionsOrderMap[order.Ticker], order)
 	}
-	if os.Getenv("DEV") != "" {
-		err := CacheAPICall(cachedFile, optionsOrderMap)
+	return optionsOrderMap, nil
+}
+
+/*
+Assignments and exercises of the underlyings, robinhood only lists the events of one underlying at a time
+*/
+func (h *Hood) fetchOptionEvents(ctx context.Context, tickers []string) ([]OptionEvent, error) {
+	events := []OptionEvent{}
+	instruments := make(map[string]*models.OptionInstrument) // option url --> instrument
+	for _, ticker := range tickers {
+		tickerEvents, err := h.Cli.GetEvents(ticker)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failing to fetch option events of %s. ERR: %v", ticker, err)
+		}
+		if tickerEvents == nil {
+			continue
+		}
+		for _, event := range *tickerEvents {
+			if event.Type == nil || (*event.Type != "assignment" && *event.Type != "exercise") {
+				continue
+			}
+			if event.Option == nil || event.EventDate == nil || event.Quantity == nil || (event.State != nil && *event.State == "cancelled") {
+				continue
+			}
+			instrument, ok := instruments[*event.Option]
+			if !ok {
+				if instrument, err = h.Cli.GetHistoricalOptionsInstrument(ctx, *event.Option); err != nil {
+					return nil, fmt.Errorf("failing to fetch option instrument %s. ERR: %v", *event.Option, err)
+				}
+				instruments[*event.Option] = instrument
+			}
+			if instrument.StrikePrice == nil || instrument.ExpirationDate == nil || instrument.Type == nil {
+				continue
+			}
+			strike, err := strconv.ParseFloat(*instrument.StrikePrice, 64)
+			if err != nil {
+				return nil, fmt.Errorf("failing to parse strike of %s. ERR: %v", *event.Option, err)
+			}
+			qty, err := strconv.ParseFloat(*event.Quantity, 64)
+			if err != nil {
+				return nil, fmt.Errorf("failing to parse quantity of the %s %s. ERR: %v", ticker, *event.Type, err)
+			}
+			events = append(events, OptionEvent{
+				Ticker:     ticker,
+				Strike:     strike,
+				Expiration: *instrument.ExpirationDate,
+				Type:       *instrument.Type,
+				Date:       *event.EventDate,
+				Qty:        QtyFromFloat(qty),
+			})
 		}
 	}
-	return optionsOrderMap, nil
+	return events, nil
 }
 
 /*
@@ -125,6 +214,9 @@ func (h *Hood) FetchRegularTrades(ctx context.Context) (map[string][]models.Tran
 			return stockMap, nil
 		}
 	}
+	if h.Store != nil {
+		return h.syncRegularTrades(ctx)
+	}
 	// regenerate cache
 	stockOrderMap := make(map[string][]models.Transaction)
 	stockOrders, err := h.Cli.GetStockOrders()
@@ -147,32 +239,19 @@ func (h *Hood) FetchRegularTrades(ctx context.Context) (map[string][]models.Tran
 }
 
 /*
-Fetch current ticker symbol
+Symbol a trade of symbol on date (2006-01-02) trades under today, renames come from the Symbols registry
 */
-func (h *Hood) FetchCurrentTickerSymbol(symbol string) (string, error) {
-	if _, symbolInCache := SymbolChangeCache[symbol]; symbolInCache {
-		// cached value
-		return SymbolChangeCache[symbol], nil
-	}
-	_, symbolFound := h.Cli.GetInstrumentForSymbol(symbol)
-	if symbolFound != nil {
-		// this only occurs if the symbol is no longer found
-		newSymbol, err := FetchStockSymbolChange(symbol)
-		if err != nil {
-			SymbolChangeCache[symbol] = symbol
-			return "", nil
-		}
-		SymbolChangeCache[symbol] = newSymbol
-	} else {
-		SymbolChangeCache[symbol] = symbol
+func (h *Hood) FetchCurrentTickerSymbol(symbol string, date string) (string, error) {
+	if h.Symbols == nil {
+		h.Symbols = NewSymbolRegistry(DefaultSymbolChanges...)
 	}
-	return SymbolChangeCache[symbol], nil
+	return h.Symbols.CurrentSymbol(symbol, date), nil
 }
 
 /*
 convert profit to dataframe
 */
-func (h *Hood) ConvertProfitDf(profitList []Profit) *dataframe.DataFrame {
+func ConvertProfitDf(profitList []Profit) *dataframe.DataFrame {
 	// Create series for each field
 	years := series.New([]string{}, series.String, "Year")
 	dates := series.New([]string{}, series.String, "Date")
@@ -180,15 +259,21 @@ func (h *Hood) ConvertProfitDf(profitList []Profit) *dataframe.DataFrame {
 	lcaps := series.New([]bool{}, series.Bool, "Lcap")
 	tickers := series.New([]string{}, series.String, "Ticker")
 	tags := series.New([]string{}, series.String, "Tag")
+	methods := series.New([]string{}, series.String, "Method")
+	washSales := series.New([]bool{}, series.Bool, "WashSale")
+	disallowed := series.New([]float64{}, series.Float, "Disallowed")
 
 	// Populate series with data from Profit struct array
 	for _, profit := range profitList {
 		years.Append(strings.Split(profit.Date, "-")[0])
 		dates.Append(profit.Date)
-		amounts.Append(profit.Amount)
+		amounts.Append(profit.Amount.InexactFloat64())
 		lcaps.Append(profit.Lcap)
 		tickers.Append(profit.Ticker)
 		tags.Append(profit.Tag)
+		methods.Append(string(profit.Method))
+		washSales.Append(profit.WashSale)
+		disallowed.Append(profit.Disallowed.InexactFloat64())
 	}
 
 	// Create DataFrame
@@ -199,6 +284,9 @@ func (h *Hood) ConvertProfitDf(profitList []Profit) *dataframe.DataFrame {
 		lcaps,
 		tickers,
 		tags,
+		methods,
+		washSales,
+		disallowed,
 	)
 
 	return &df
@@ -207,7 +295,7 @@ func (h *Hood) ConvertProfitDf(profitList []Profit) *dataframe.DataFrame {
 /*
 convert bought stock to dataframe
 */
-func (h *Hood) ConvertUnrealizedProfitDf(unrealizedProfit map[string][]*models.Transaction) *dataframe.DataFrame {
+func ConvertUnrealizedProfitDf(unrealizedProfit map[string][]*Lot) *dataframe.DataFrame {
 	// Create series for each field
 	years := series.New([]string{}, series.String, "Year")
 	dates := series.New([]string{}, series.String, "Date")
@@ -221,8 +309,8 @@ func (h *Hood) ConvertUnrealizedProfitDf(unrealizedProfit map[string][]*models.T
 		for _, transaction := range transactionList {
 			years.Append(strings.Split(transaction.CreatedAt, "-")[0])
 			dates.Append(transaction.CreatedAt)
-			stockQty.Append(transaction.Qty)
-			price.Append(transaction.UnitCost)
+			stockQty.Append(transaction.Qty.InexactFloat64())
+			price.Append(transaction.UnitCost.InexactFloat64())
 			tickers.Append(ticker)
 			transactionType.Append(transaction.TransactionType)
 		}
@@ -242,17 +330,41 @@ func (h *Hood) ConvertUnrealizedProfitDf(unrealizedProfit map[string][]*models.T
 }
 
 // Return dataframe of profit, map of ticker --> purchase date
-func (h *Hood) ProcessRealizedEarnings(ctx context.Context) (*dataframe.DataFrame, *dataframe.DataFrame, error) {
-	stockMap, err := h.FetchRegularTrades(ctx)
+func (h *Hood) ProcessRealizedEarnings(ctx context.Context, costBasis CostBasisSelector) (*EarningsReport, error) {
+	return ProcessRealizedEarnings(ctx, h, costBasis)
+}
+
+/*
+Fetch every trade from source and calculate realized earnings
+*/
+func ProcessRealizedEarnings(ctx context.Context, source TransactionSource, costBasis CostBasisSelector) (*EarningsReport, error) {
+	stockMap, err := source.FetchRegularTrades(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	optionMap, err := source.FetchOptionTrades(ctx)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	optionMap, err := h.FetchOptionTrades(ctx)
+	income, err := source.FetchIncome(ctx)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	return CalculateRealizedEarnings(source, stockMap, optionMap, income, costBasis)
+}
+
+func (h *Hood) CalculateRealizedEarnings(stockMap map[string][]models.Transaction, optionMap map[string][]models.OptionTransaction, income []IncomeEvent, costBasis CostBasisSelector) (*EarningsReport, error) {
+	return CalculateRealizedEarnings(h, stockMap, optionMap, income, costBasis)
+}
+
+/*
+Calculate realized earnings and income from already fetched trades and income, source resolves symbol changes and splits
 
+Sells are matched against open lots using the cost basis method selected for each ticker
+*/
+func CalculateRealizedEarnings(source TransactionSource, stockMap map[string][]models.Transaction, optionMap map[string][]models.OptionTransaction, income []IncomeEvent, costBasis CostBasisSelector) (*EarningsReport, error) {
 	stockList := []models.Transaction{}
 	optionList := []models.OptionTransaction{}
 
@@ -276,9 +388,36 @@ func (h *Hood) ProcessRealizedEarnings(ctx context.Context) (*dataframe.DataFram
 	stockLen := len(stockList)
 	optionLen := len(optionList)
 
+	corporateActions, err := source.FetchCorporateActions()
+	if err != nil {
+		return nil, err
+	}
+	optionEvents, err := source.FetchOptionEvents()
+	if err != nil {
+		return nil, err
+	}
+	assignmentDates := newAssignmentDates(optionEvents)
+
 	stockIdx, optionIdx := 0, 0
-	profitList := []Profit{}
-	profitsMap := make(map[string][]*models.Transaction) // keep track of buy/sell
+	run := newEarningsRun(costBasis, corporateActions)
+	for _, event := range income {
+		if event.Ticker != "" {
+			ticker, err := source.FetchCurrentTickerSymbol(event.Ticker, event.Date)
+			if err != nil {
+				return nil, err
+			}
+			splits, err := source.FetchStockSplits(ticker)
+			if err != nil {
+				return nil, err
+			}
+			event.Ticker = ticker
+			event.ReinvestedQty, event.ReinvestedPrice = GetStockSplitCorrection(splits, event.Date, event.ReinvestedQty, event.ReinvestedPrice)
+		}
+		run.income = append(run.income, &pendingIncome{event: event})
+	}
+	sort.SliceStable(run.income, func(i, j int) bool {
+		return run.income[i].event.Date < run.income[j].event.Date
+	})
 	for {
 		// interweave stocks & options to ensure FIFO
 		if stockIdx >= stockLen && optionIdx >= optionLen {
@@ -298,158 +437,129 @@ func (h *Hood) ProcessRealizedEarnings(ctx context.Context) (*dataframe.DataFram
 				calcOption = false
 			}
 		}
+		eventDate := ""
+		if calcOption {
+			eventDate = strings.Split(optionList[optionIdx].CreatedAt, "T")[0]
+		} else {
+			eventDate = strings.Split(stockList[stockIdx].CreatedAt, " ")[0]
+		}
+		if err := run.applyCorporateActions(eventDate); err != nil {
+			return nil, err
+		}
+		run.applyIncome(eventDate)
+		if err := run.settleOptions(eventDate); err != nil {
+			return nil, err
+		}
+
 		if calcOption {
 			option := optionList[optionIdx]
+			traded := option
 			optionIdx += 1
-			optionTicker, err := h.FetchCurrentTickerSymbol(option.Ticker)
 			createdDate := strings.Split(option.CreatedAt, "T")[0]
-			splitAdjustedQty, splitAdjustedPrice, err := GetStockSplitCorrection(option.Ticker, createdDate, option.Qty, option.UnitCost)
+			optionTicker, err := source.FetchCurrentTickerSymbol(option.Ticker, createdDate)
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
-			option.StrikePrice = option.StrikePrice * (option.Qty / splitAdjustedQty)
-			option.Qty = splitAdjustedQty
-			optionQty := option.Qty
-			option.UnitCost = splitAdjustedPrice
-
+			// the old symbol may be gone from the split provider after a rename
+			splits, err := source.FetchStockSplits(optionTicker)
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
-			if option.Status != "Expired" && option.Status != "Assigned" {
-				continue
-			} else if option.Status == "Assigned" {
-				premium := 0.0
-				if option.TransactionType == "STO" {
-					premium = option.UnitCost * -1
-				} else {
-					premium = option.UnitCost
+			originalQty := QtyFromFloat(option.Qty)
+			splitAdjustedQty, splitAdjustedPrice := GetStockSplitCorrection(splits, createdDate, originalQty, PriceFromFloat(option.UnitCost))
+			if !splitAdjustedQty.IsZero() {
+				option.StrikePrice = PriceFromFloat(option.StrikePrice).Mul(originalQty).DivRound(splitAdjustedQty, pricePlaces).InexactFloat64()
+			}
+			// the contract identity and assignments only need the adjusted values as floats
+			option.Qty = splitAdjustedQty.InexactFloat64()
+			option.UnitCost = splitAdjustedPrice.InexactFloat64()
+			contract := ContractFor(optionTicker, option)
+
+			if option.TransactionType == "BTC" || option.TransactionType == "STC" {
+				closes, unmatchedQty, err := run.optionLedger.Close(contract, option, splitAdjustedQty, splitAdjustedPrice,
+					run.costBasis.MethodFor(optionTicker), run.costBasis.LotsFor(optionTicker, createdDate))
+				if err != nil {
+					return nil, err
 				}
-				stock := models.Transaction{
-					Ticker:          optionTicker,
-					TransactionType: "buy",
-					Qty:             100.00 * optionQty,
-					UnitCost:        option.StrikePrice + premium,
-					CreatedAt:       option.ExpirationDate,
-					Tag:             option.TransactionType + " assigned",
+				run.bookOptionCloses(closes)
+				if unmatchedQty.IsPositive() {
+					run.warn(OrphanOptionClose, optionTicker, createdDate, unmatchedQty, splitAdjustedPrice,
+						fmt.Sprintf("%s %s closed %s contracts with no opening leg", option.TransactionType, contract, unmatchedQty))
 				}
-				if profitsMap[optionTicker] == nil {
-					profitsMap[optionTicker] = []*models.Transaction{}
+			} else if option.Status == "Assigned" {
+				// shares change hands when the option is assigned or exercised, not when it was opened
+				for _, part := range assignmentDates.take(traded, splitAdjustedQty) {
+					assigned := option
+					assigned.Qty = part.qty.InexactFloat64()
+					run.assignments = append(run.assignments, optionAssignment{
+						ticker:   optionTicker,
+						contract: contract,
+						option:   assigned,
+						date:     part.date,
+					})
 				}
-				profitsMap[optionTicker] = append(profitsMap[optionTicker], &stock)
-			} else if option.Status == "Expired" {
-				if option.TransactionType == "STO" || option.TransactionType == "STC" {
-					profit := Profit{
-						Date:   createdDate,
-						Amount: optionQty * 100 * option.UnitCost,
-						Lcap:   false, // TODO calculate whether actual LTG
-						Ticker: optionTicker,
-						Tag:    option.Tag,
-					}
-					profitList = append(profitList, profit)
-				} else {
-					profit := Profit{
-						Date:   createdDate,
-						Amount: -optionQty * 100 * option.UnitCost,
-						Lcap:   false, // TODO calculate whether actual LTG
-						Ticker: optionTicker,
-						Tag:    option.Tag,
-					}
-					profitList = append(profitList, profit)
+			} else {
+				lot := run.optionLedger.Open(contract, option, splitAdjustedQty, splitAdjustedPrice)
+				// bought calls are substantially identical to the underlying for wash sales
+				if lot != nil && option.TransactionType == "BTO" && contract.Type == "call" {
+					run.washSales.optionPurchase(run.profitList, optionTicker, lot)
 				}
 			}
 
 		} else {
 			stock := stockList[stockIdx]
 			stockIdx += 1
-			stockTicker, err := h.FetchCurrentTickerSymbol(stock.Ticker)
+			createdDate := strings.Split(stock.CreatedAt, " ")[0]
+			stockTicker, err := source.FetchCurrentTickerSymbol(stock.Ticker, createdDate)
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
-			createdDate := strings.Split(stock.CreatedAt, " ")[0]
-			splitAdjustedQty, splitAdjustedPrice, err := GetStockSplitCorrection(stock.Ticker, createdDate, stock.Qty, stock.UnitCost)
+			// the old symbol may be gone from the split provider after a rename
+			splits, err := source.FetchStockSplits(stockTicker)
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
-			stock.UnitCost = splitAdjustedPrice
-			stock.Qty = splitAdjustedQty
-			stockQty := stock.Qty
-
-			if stock.TransactionType == "sell" {
-				qty := stock.Qty
-				indexToPop := -1
-				lcapGain := 0.0
-				scapGain := 0.0
-				for qty != 0.0 {
-					if len(profitsMap[stockTicker]) <= 0 {
-						profit := Profit{
-							Date:   createdDate,
-							Amount: stock.UnitCost * stockQty,
-							Lcap:   false,
-							Ticker: stockTicker,
-							Tag:    stock.Tag,
-						}
-						profitList = append(profitList, profit)
-						break
-					}
-					for i, boughtStock := range profitsMap[stockTicker] {
-						if profitsMap[stockTicker][i].Qty > qty {
-							gain := qty * (stock.UnitCost - boughtStock.UnitCost)
-							if OneYearApart(boughtStock.CreatedAt, stock.CreatedAt) {
-								lcapGain += gain
-							} else {
-								scapGain += gain
-							}
-							profitsMap[stockTicker][i].Qty -= qty
-							qty = 0
-							break
-						} else {
-							qty -= boughtStock.Qty
-							indexToPop = i
-							gain := boughtStock.Qty * (stock.UnitCost - boughtStock.UnitCost)
-							if OneYearApart(boughtStock.CreatedAt, stock.CreatedAt) {
-								lcapGain += gain
-							} else {
-								scapGain += gain
-							}
-						}
-						if qty == 0 {
-							break
-						}
-					}
-				}
-				if indexToPop != -1 {
-					profitsMap[stockTicker] = profitsMap[stockTicker][indexToPop+1:]
-				}
-				if lcapGain != 0.0 {
-					profit := Profit{
-						Date:   createdDate,
-						Amount: lcapGain,
-						Lcap:   true,
-						Ticker: stockTicker,
-						Tag:    stock.Tag,
-					}
-					profitList = append(profitList, profit)
-				}
-				if scapGain != 0.0 {
-					profit := Profit{
-						Date:   createdDate,
-						Amount: scapGain,
-						Lcap:   false,
-						Ticker: stockTicker,
-						Tag:    stock.Tag,
-					}
-					profitList = append(profitList, profit)
+			lot := NewLot(stock)
+			lot.Qty, lot.UnitCost = GetStockSplitCorrection(splits, createdDate, lot.Qty, lot.UnitCost)
+
+			if lot.TransactionType == "sell" {
+				if err := run.sell(stockTicker, lot); err != nil {
+					return nil, err
 				}
 			} else { // buy
-				if profitsMap[stockTicker] == nil {
-					profitsMap[stockTicker] = []*models.Transaction{}
-				}
-				profitsMap[stockTicker] = append(profitsMap[stockTicker], &stock)
+				run.buy(stockTicker, lot)
 			}
 		}
 	}
 
-	profitListDf := h.ConvertProfitDf(profitList)
-	unrealizedProfit := h.ConvertUnrealizedProfitDf(profitsMap)
-	return profitListDf, unrealizedProfit, nil
+	today := time.Now().Format("2006-01-02")
+	if err := run.applyCorporateActions(today); err != nil {
+		return nil, err
+	}
+	run.applyIncome(today)
+	if err := run.settleOptions(today); err != nil {
+		return nil, err
+	}
+	// apply the assignments dated today, a leg reported assigned ahead of its date waits for it
+	for _, assignment := range run.assignments {
+		if assignment.date > today {
+			continue
+		}
+		if err := run.assign(assignment); err != nil {
+			return nil, err
+		}
+	}
+	run.closeIncome(today)
+
+	run.checkBalances()
+
+	return &EarningsReport{
+		Profit:           ConvertProfitDf(run.profitList),
+		UnrealizedProfit: ConvertUnrealizedProfitDf(run.profitsMap),
+		OpenLots:         run.profitsMap,
+		WashSales:        ConvertWashSaleDf(run.washSales.adjustments),
+		Warnings:         ConvertWarningDf(run.warnings),
+		Income:           ConvertIncomeDf(run.incomeList),
+		RealizedLots:     run.realizedLots(),
+	}, nil
 }
@@ -0,0 +1,166 @@
+package rhwrapper
+
+// json snapshot of fetched trades, income and transfers, so reports can run again without logging in
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	models "github.com/Ryang20718/robinhood-client/models"
+	"os"
+	"time"
+)
+
+type Snapshot struct {
+	SyncedAt     time.Time                             `json:"syncedAt"`
+	Stocks       map[string][]models.Transaction       `json:"stocks"`
+	Options      map[string][]models.OptionTransaction `json:"options"`
+	OptionEvents []OptionEvent                         `json:"optionEvents"`
+	Income       []IncomeEvent                         `json:"income"`
+	Transfers    []Transfer                            `json:"transfers"`
+}
+
+/*
+Fetch everything source has into a snapshot, a robinhood client with a store saves what it synced
+*/
+func TakeSnapshot(ctx context.Context, source TransactionSource) (*Snapshot, error) {
+	stockMap, err := source.FetchRegularTrades(ctx)
+	if err != nil {
+		return nil, err
+	}
+	optionMap, err := source.FetchOptionTrades(ctx)
+	if err != nil {
+		return nil, err
+	}
+	optionEvents, err := source.FetchOptionEvents()
+	if err != nil {
+		return nil, err
+	}
+	income, err := source.FetchIncome(ctx)
+	if err != nil {
+		return nil, err
+	}
+	transfers, err := source.FetchTransfers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if hood, ok := source.(*Hood); ok && hood.Store != nil {
+		if err := hood.SaveStore(); err != nil {
+			return nil, err
+		}
+	}
+	return &Snapshot{SyncedAt: time.Now(), Stocks: stockMap, Options: optionMap, OptionEvents: optionEvents, Income: income, Transfers: transfers}, nil
+}
+
+func (s *Snapshot) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failing to encode snapshot. ERR: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failing to write snapshot. ERR: %v", err)
+	}
+	return nil
+}
+
+/*
+Load a snapshot written by Save, or everything in a store file (see OpenStore)
+*/
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failing to read snapshot. ERR: %v", err)
+	}
+	version := struct {
+		Version int `json:"version"`
+	}{}
+	if err := json.Unmarshal(data, &version); err == nil && version.Version > 0 {
+		store, err := OpenStore(path)
+		if err != nil {
+			return nil, err
+		}
+		return store.Snapshot(), nil
+	}
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("failing to parse snapshot. ERR: %v", err)
+	}
+	return snapshot, nil
+}
+
+// SnapshotSource serves trades and income from a snapshot file
+type SnapshotSource struct {
+	Path             string
+	Splits           SplitProvider   // yahoo when nil
+	Symbols          *SymbolRegistry // DefaultSymbolChanges when nil
+	CorporateActions []CorporateAction
+	snapshot         *Snapshot
+}
+
+var _ TransactionSource = &SnapshotSource{}
+
+func (s *SnapshotSource) load() (*Snapshot, error) {
+	if s.snapshot == nil {
+		snapshot, err := LoadSnapshot(s.Path)
+		if err != nil {
+			return nil, err
+		}
+		s.snapshot = snapshot
+	}
+	return s.snapshot, nil
+}
+
+func (s *SnapshotSource) FetchRegularTrades(ctx context.Context) (map[string][]models.Transaction, error) {
+	snapshot, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.Stocks, nil
+}
+
+func (s *SnapshotSource) FetchOptionTrades(ctx context.Context) (map[string][]models.OptionTransaction, error) {
+	snapshot, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.Options, nil
+}
+
+func (s *SnapshotSource) FetchOptionEvents() ([]OptionEvent, error) {
+	snapshot, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.OptionEvents, nil
+}
+
+func (s *SnapshotSource) FetchIncome(ctx context.Context) ([]IncomeEvent, error) {
+	snapshot, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.Income, nil
+}
+
+func (s *SnapshotSource) FetchTransfers(ctx context.Context) ([]Transfer, error) {
+	snapshot, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.Transfers, nil
+}
+
+func (s *SnapshotSource) FetchCurrentTickerSymbol(symbol string, date string) (string, error) {
+	if s.Symbols == nil {
+		s.Symbols = NewSymbolRegistry(DefaultSymbolChanges...)
+	}
+	return s.Symbols.CurrentSymbol(symbol, date), nil
+}
+
+func (s *SnapshotSource) FetchStockSplits(symbol string) ([]Split, error) {
+	return cachedSplits(s.Splits, symbol)
+}
+
+func (s *SnapshotSource) FetchCorporateActions() ([]CorporateAction, error) {
+	return s.CorporateActions, nil
+}
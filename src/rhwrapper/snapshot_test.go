@@ -0,0 +1,78 @@
+package rhwrapper
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotSource(t *testing.T) {
+	source := NewFakeSource()
+	source.AddStockTrade("AAPL", "buy", 10, 100, "2022-01-03 10:00:00")
+	source.AddStockTrade("AAPL", "sell", 4, 150, "2023-03-01 10:00:00")
+	// assigned before expiration
+	source.AddOptionTrade("XYZ", "STO", "put", 1, 50, 2, "2023-01-10T15:00:00Z", "2023-02-17", "Assigned")
+	source.AddOptionEvent("XYZ", "put", 50, "2023-02-17", "2023-02-08", 1)
+	source.Income = []IncomeEvent{{Date: "2023-02-16", ExDate: "2023-02-10", Ticker: "AAPL", Type: Dividend, Amount: dec("2.30")}}
+
+	snapshot, err := TakeSnapshot(context.Background(), source)
+	if err != nil {
+		t.Fatalf("failing to take snapshot. ERR: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := snapshot.Save(path); err != nil {
+		t.Fatalf("failing to save snapshot. ERR: %v", err)
+	}
+
+	// the report off the snapshot is the one off the source
+	want := realizedEarnings(t, source, CostBasisSelector{})
+	saved := &SnapshotSource{Path: path, Splits: &FileSplitProvider{Complete: true}}
+	got, err := ProcessRealizedEarnings(context.Background(), saved, CostBasisSelector{})
+	if err != nil {
+		t.Fatalf("failing to process the snapshot. ERR: %v", err)
+	}
+	assertNoWarnings(t, got)
+	assertRealizedLots(t, got.RealizedLots, []wantLot{
+		{acquired: "2022-01-03", sold: "2023-03-01", qty: "4", proceeds: "600", basis: "400", longTerm: true},
+	})
+	assertOpenLots(t, got, "AAPL", []wantOpenLot{{acquired: "2022-01-03", qty: "6", unitCost: "100"}})
+	// the put assigned on 2023-02-08 bought the shares at the strike less the premium
+	assertOpenLots(t, got, "XYZ", []wantOpenLot{{acquired: "2023-02-08", qty: "100", unitCost: "48"}})
+	for _, df := range []struct {
+		name      string
+		got, want string
+	}{
+		{"profit", amountTotal(t, got.Profit).String(), amountTotal(t, want.Profit).String()},
+		{"income", amountTotal(t, got.Income).String(), amountTotal(t, want.Income).String()},
+	} {
+		if df.got != df.want {
+			t.Errorf("%s %s off the snapshot, %s off the source", df.name, df.got, df.want)
+		}
+	}
+}
+
+func TestLoadSnapshotErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadSnapshot(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("loaded a missing snapshot")
+	}
+	path := filepath.Join(dir, "snapshot.json")
+	if err := os.WriteFile(path, []byte(`{"stocks": `), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSnapshot(path); err == nil {
+		t.Errorf("loaded a truncated snapshot")
+	}
+	source := &SnapshotSource{Path: path}
+	if _, err := source.FetchRegularTrades(context.Background()); err == nil {
+		t.Errorf("fetched trades off a truncated snapshot")
+	}
+
+	broken := NewFakeSource()
+	broken.Err = errors.New("robinhood is down")
+	if snapshot, err := TakeSnapshot(context.Background(), broken); !errors.Is(err, broken.Err) {
+		t.Errorf("snapshot %+v err %v, want %v", snapshot, err, broken.Err)
+	}
+}
@@ -0,0 +1,85 @@
+package rhwrapper
+
+// where the engine gets trades, symbols and splits from
+
+import (
+	"context"
+	"errors"
+	models "github.com/Ryang20718/robinhood-client/models"
+)
+
+// TransactionSource is everything the earnings engine needs from a broker
+type TransactionSource interface {
+	// stock trades per ticker
+	FetchRegularTrades(ctx context.Context) (map[string][]models.Transaction, error)
+	// option legs per underlying
+	FetchOptionTrades(ctx context.Context) (map[string][]models.OptionTransaction, error)
+	// symbol a trade of the ticker on date (2006-01-02) trades under today, for renamed tickers
+	FetchCurrentTickerSymbol(symbol string, date string) (string, error)
+	// every split of the ticker
+	FetchStockSplits(symbol string) ([]Split, error)
+	// dividends, stock lending and interest payments
+	FetchIncome(ctx context.Context) ([]IncomeEvent, error)
+	// deposits and withdrawals
+	FetchTransfers(ctx context.Context) ([]Transfer, error)
+	// mergers, spinoffs, cash in lieu and worthless securities, by current symbol
+	FetchCorporateActions() ([]CorporateAction, error)
+	// dates options were assigned or exercised on, none when the source doesn't know them
+	FetchOptionEvents() ([]OptionEvent, error)
+}
+
+var _ TransactionSource = &Hood{}
+
+/*
+Splits for symbol from the Splits provider (yahoo when unset), cached for the lifetime of the process
+
+A symbol none of the providers know about is treated as never split
+*/
+func (h *Hood) FetchStockSplits(symbol string) ([]Split, error) {
+	if h.Store != nil {
+		return h.storedSplits(symbol)
+	}
+	return cachedSplits(h.Splits, symbol)
+}
+
+func (h *Hood) FetchCorporateActions() ([]CorporateAction, error) {
+	return h.CorporateActions, nil
+}
+
+func cachedSplits(provider SplitProvider, symbol string) ([]Split, error) {
+	if splits, keyFound := CacheStockSplits.Get(symbol); keyFound {
+		return splits, nil
+	}
+	splits, err := fetchSplits(provider, symbol)
+	if err != nil {
+		return nil, err
+	}
+	CacheStockSplits.Put(symbol, splits)
+	return splits, nil
+}
+
+// splits of symbol from provider (yahoo when nil), none for a symbol it doesn't know
+func fetchSplits(provider SplitProvider, symbol string) ([]Split, error) {
+	if provider == nil {
+		provider = &YahooSplitProvider{}
+	}
+	splits, err := provider.Splits(symbol)
+	if errors.Is(err, ErrSplitsNotFound) {
+		return []Split{}, nil
+	}
+	return splits, err
+}
+
+/*
+Assignments and exercises of the underlyings traded, fetched along with the option trades
+*/
+func (h *Hood) FetchOptionEvents() ([]OptionEvent, error) {
+	if h.Store != nil {
+		h.Store.mu.Lock()
+		defer h.Store.mu.Unlock()
+		return h.Store.optionEvents(), nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.optionEvents, nil
+}
@@ -0,0 +1,199 @@
+package rhwrapper
+
+// split providers, yahoo, a curated file and a chain falling back from one to the next
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrSplitsNotFound is returned by a provider that knows nothing about a symbol, the chain moves on to the next one
+var ErrSplitsNotFound = errors.New("no split data for symbol")
+
+type SplitProvider interface {
+	Splits(symbol string) ([]Split, error)
+}
+
+const yahooBaseURL = "https://query2.finance.yahoo.com"
+
+// YahooSplitProvider reads splits from the yahoo chart endpoint
+type YahooSplitProvider struct {
+	BaseURL string       // defaults to query2.finance.yahoo.com, point it at a stand-in server in tests
+	Client  *http.Client // defaults to http.DefaultClient
+}
+
+func (y *YahooSplitProvider) Splits(symbol string) ([]Split, error) {
+	baseURL := y.BaseURL
+	if baseURL == "" {
+		baseURL = yahooBaseURL
+	}
+	client := y.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	url := fmt.Sprintf("%s/v8/finance/chart/%s?period1=0&period2=9999999999&interval=3mo&events=split", baseURL, symbol)
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(body) == "Will be right back" {
+		return nil, fmt.Errorf("*** YAHOO! FINANCE IS CURRENTLY DOWN! ***\nOur engineers are working quickly to resolve the issue. Thank you for your patience.")
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrSplitsNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		// 429 when we're rate limited
+		return nil, fmt.Errorf("yahoo splits for %s returned %s", symbol, resp.Status)
+	}
+
+	var data Data
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	splits := []Split{}
+	for _, result := range data.Chart.Result {
+		for _, v := range result.Events {
+			for _, val := range v {
+				timestamp := int64(val.Date)
+
+				// Convert the Unix timestamp to a time.Time value
+				t := time.Unix(timestamp, 0)
+				date := t.Format("2006-01-02")
+				split := Split{
+					Date:        date,
+					Numerator:   int(val.Numerator),
+					Denominator: int(val.Denominator),
+				}
+				splits = append(splits, split)
+			}
+		}
+	}
+	return splits, nil
+}
+
+// splitRecord is a row of a split file
+type splitRecord struct {
+	Symbol      string `json:"symbol"`
+	Date        string `json:"date"` // 2006-01-02
+	Numerator   int    `json:"numerator"`
+	Denominator int    `json:"denominator"`
+}
+
+// FileSplitProvider serves splits from a curated json or csv file
+type FileSplitProvider struct {
+	splits   map[string][]Split
+	Complete bool // the file lists every split, symbols missing from it never split
+}
+
+/*
+Load splits from a json or csv file
+
+json: [{"symbol": "TSLA", "date": "2022-08-25", "numerator": 3, "denominator": 1}]
+csv:  symbol,date,numerator,denominator with a header row
+*/
+func NewFileSplitProvider(path string) (*FileSplitProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failing to read splits. ERR: %v", err)
+	}
+	records := []splitRecord{}
+	if strings.ToLower(filepath.Ext(path)) != ".csv" {
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("failing to parse splits. ERR: %v", err)
+		}
+	} else {
+		rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("failing to parse splits. ERR: %v", err)
+		}
+		for i, row := range rows {
+			if i == 0 {
+				continue // header
+			}
+			if len(row) < 4 {
+				return nil, fmt.Errorf("split row %d needs symbol,date,numerator,denominator", i+1)
+			}
+			numerator, err := strconv.Atoi(strings.TrimSpace(row[2]))
+			if err != nil {
+				return nil, fmt.Errorf("split row %d has invalid numerator %s", i+1, row[2])
+			}
+			denominator, err := strconv.Atoi(strings.TrimSpace(row[3]))
+			if err != nil {
+				return nil, fmt.Errorf("split row %d has invalid denominator %s", i+1, row[3])
+			}
+			records = append(records, splitRecord{
+				Symbol:      strings.TrimSpace(row[0]),
+				Date:        strings.TrimSpace(row[1]),
+				Numerator:   numerator,
+				Denominator: denominator,
+			})
+		}
+	}
+
+	provider := &FileSplitProvider{splits: make(map[string][]Split)}
+	for i, record := range records {
+		if _, err := time.Parse("2006-01-02", record.Date); err != nil {
+			return nil, fmt.Errorf("split %d for %s has invalid date %s", i+1, record.Symbol, record.Date)
+		}
+		if record.Numerator <= 0 || record.Denominator <= 0 {
+			return nil, fmt.Errorf("split %d for %s needs a positive numerator and denominator", i+1, record.Symbol)
+		}
+		symbol := strings.ToUpper(record.Symbol)
+		provider.splits[symbol] = append(provider.splits[symbol], Split{
+			Date:        record.Date,
+			Numerator:   record.Numerator,
+			Denominator: record.Denominator,
+		})
+	}
+	return provider, nil
+}
+
+func (f *FileSplitProvider) Splits(symbol string) ([]Split, error) {
+	splits, ok := f.splits[strings.ToUpper(symbol)]
+	if !ok {
+		if f.Complete {
+			return []Split{}, nil
+		}
+		return nil, ErrSplitsNotFound
+	}
+	return splits, nil
+}
+
+// ChainedSplitProvider asks each provider in turn until one has the splits for a symbol
+type ChainedSplitProvider struct {
+	Providers []SplitProvider
+}
+
+func (c *ChainedSplitProvider) Splits(symbol string) ([]Split, error) {
+	err := ErrSplitsNotFound
+	for _, provider := range c.Providers {
+		splits, providerErr := provider.Splits(symbol)
+		if providerErr == nil {
+			return splits, nil
+		}
+		// keep the most useful error, a real failure beats not found
+		if !errors.Is(providerErr, ErrSplitsNotFound) || errors.Is(err, ErrSplitsNotFound) {
+			err = providerErr
+		}
+	}
+	return nil, fmt.Errorf("failing to fetch splits for %s. ERR: %w", symbol, err)
+}
@@ -0,0 +1,208 @@
+package rhwrapper
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TSLA's 5 for 1 on 2020-08-31 and 3 for 1 on 2022-08-25, dated at the open so any local zone keeps the day
+const testYahooSplits = `{"chart": {"result": [{"events": {"splits": {
+	"1598880600": {"date": 1598880600, "numerator": 5, "denominator": 1, "splitRatio": "5:1"},
+	"1661434200": {"date": 1661434200, "numerator": 3, "denominator": 1, "splitRatio": "3:1"}
+}}}], "error": null}}`
+
+func yahooServer(t *testing.T, status int, body string) *YahooSplitProvider {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v8/finance/chart/TSLA" || r.URL.Query().Get("events") != "split" {
+			t.Errorf("requested %s", r.URL)
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return &YahooSplitProvider{BaseURL: server.URL, Client: server.Client()}
+}
+
+func formatSplits(splits []Split) string {
+	formatted := []string{}
+	for _, split := range splits {
+		formatted = append(formatted, fmt.Sprintf("%s %d:%d", split.Date, split.Numerator, split.Denominator))
+	}
+	// map iteration order of the yahoo events isn't fixed
+	if len(formatted) == 2 && formatted[0] > formatted[1] {
+		formatted[0], formatted[1] = formatted[1], formatted[0]
+	}
+	return fmt.Sprint(formatted)
+}
+
+func TestYahooSplitProvider(t *testing.T) {
+	splits, err := yahooServer(t, http.StatusOK, testYahooSplits).Splits("TSLA")
+	if err != nil {
+		t.Fatalf("failing to fetch splits. ERR: %v", err)
+	}
+	if got := formatSplits(splits); got != "[2020-08-31 5:1 2022-08-25 3:1]" {
+		t.Errorf("splits %s", got)
+	}
+
+	splits, err = yahooServer(t, http.StatusOK, `{"chart": {"result": [{}], "error": null}}`).Splits("TSLA")
+	if err != nil || len(splits) != 0 {
+		t.Errorf("splits %+v err %v of a symbol that never split", splits, err)
+	}
+}
+
+func TestYahooSplitProviderErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		notFound bool
+	}{
+		{name: "unknown symbol", status: http.StatusNotFound, body: `{"chart": {"result": null}}`, notFound: true},
+		{name: "rate limited", status: http.StatusTooManyRequests, body: "Too Many Requests"},
+		{name: "down", status: http.StatusOK, body: "Will be right back"},
+		{name: "invalid json", status: http.StatusOK, body: `{"chart": `},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			splits, err := yahooServer(t, test.status, test.body).Splits("TSLA")
+			if err == nil {
+				t.Fatalf("fetched splits %+v", splits)
+			}
+			if errors.Is(err, ErrSplitsNotFound) != test.notFound {
+				t.Errorf("err %v, want not found %v", err, test.notFound)
+			}
+		})
+	}
+}
+
+func TestFileSplitProvider(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		data     string
+		complete bool
+		err      bool
+	}{
+		{name: "json", file: "splits.json", data: `[{"symbol": "tsla", "date": "2022-08-25", "numerator": 3, "denominator": 1}]`},
+		{name: "csv", file: "splits.csv", data: "symbol,date,numerator,denominator\nTSLA, 2022-08-25 ,3,1\n"},
+		{name: "complete", file: "splits.csv", data: "symbol,date,numerator,denominator\nTSLA,2022-08-25,3,1\n", complete: true},
+		{name: "csv missing a column", file: "splits.csv", data: "symbol,date,numerator\nTSLA,2022-08-25,3\n", err: true},
+		{name: "invalid date", file: "splits.json", data: `[{"symbol": "TSLA", "date": "08/25/2022", "numerator": 3, "denominator": 1}]`, err: true},
+		{name: "zero denominator", file: "splits.csv", data: "symbol,date,numerator,denominator\nTSLA,2022-08-25,3,0\n", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.file)
+			if err := os.WriteFile(path, []byte(test.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			provider, err := NewFileSplitProvider(path)
+			if test.err {
+				if err == nil {
+					t.Errorf("loaded %+v", provider)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failing to load splits. ERR: %v", err)
+			}
+			provider.Complete = test.complete
+
+			splits, err := provider.Splits("TSLA")
+			if err != nil || formatSplits(splits) != "[2022-08-25 3:1]" {
+				t.Errorf("TSLA splits %+v err %v", splits, err)
+			}
+			// symbols missing from the file never split only when it's complete
+			splits, err = provider.Splits("AAPL")
+			if test.complete {
+				if err != nil || len(splits) != 0 {
+					t.Errorf("AAPL splits %+v err %v, want none", splits, err)
+				}
+			} else if !errors.Is(err, ErrSplitsNotFound) {
+				t.Errorf("AAPL err %v, want %v", err, ErrSplitsNotFound)
+			}
+		})
+	}
+}
+
+// serves a fixed answer and counts the calls
+type stubSplitProvider struct {
+	splits []Split
+	err    error
+	calls  int
+}
+
+func (s *stubSplitProvider) Splits(symbol string) ([]Split, error) {
+	s.calls += 1
+	return s.splits, s.err
+}
+
+func TestChainedSplitProvider(t *testing.T) {
+	curated := []Split{{Date: "2022-08-25", Numerator: 3, Denominator: 1}}
+	down := errors.New("yahoo is down")
+	tests := []struct {
+		name      string
+		providers []*stubSplitProvider
+		splits    string
+		err       error
+		calls     []int
+	}{
+		{
+			name:      "falls back on not found",
+			providers: []*stubSplitProvider{{err: ErrSplitsNotFound}, {splits: curated}},
+			splits:    "[2022-08-25 3:1]",
+			calls:     []int{1, 1},
+		},
+		{
+			name:      "stops at the first answer",
+			providers: []*stubSplitProvider{{splits: curated}, {err: down}},
+			splits:    "[2022-08-25 3:1]",
+			calls:     []int{1, 0},
+		},
+		{
+			name:      "falls back on a failure",
+			providers: []*stubSplitProvider{{err: down}, {splits: []Split{}}},
+			splits:    "[]",
+			calls:     []int{1, 1},
+		},
+		{
+			name:      "nobody knows the symbol",
+			providers: []*stubSplitProvider{{err: ErrSplitsNotFound}, {err: ErrSplitsNotFound}},
+			err:       ErrSplitsNotFound,
+			calls:     []int{1, 1},
+		},
+		{
+			name:      "a failure beats not found",
+			providers: []*stubSplitProvider{{err: down}, {err: ErrSplitsNotFound}},
+			err:       down,
+			calls:     []int{1, 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain := &ChainedSplitProvider{}
+			for _, provider := range test.providers {
+				chain.Providers = append(chain.Providers, provider)
+			}
+			splits, err := chain.Splits("TSLA")
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Errorf("err %v, want %v", err, test.err)
+				}
+			} else if err != nil || formatSplits(splits) != test.splits {
+				t.Errorf("splits %+v err %v, want %s", splits, err, test.splits)
+			}
+			for i, provider := range test.providers {
+				if provider.calls != test.calls[i] {
+					t.Errorf("provider %d called %d times, want %d", i, provider.calls, test.calls[i])
+				}
+			}
+		})
+	}
+}
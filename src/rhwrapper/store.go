@@ -0,0 +1,701 @@
+package rhwrapper
+
+// on disk store of a user's trades, income, transfers, portfolio history, splits and symbol changes, synced incrementally from robinhood
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	robinhood "github.com/Ryang20718/robinhood-client/client"
+	models "github.com/Ryang20718/robinhood-client/models"
+	"github.com/shopspring/decimal"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StoreVersion is the layout of store files written by this build
+const StoreVersion = 5
+
+// storeData is the store file, bump StoreVersion and add a step to storeMigrations whenever it changes
+type storeData struct {
+	Version           int                                   `json:"version"`
+	StockOrders       map[string]models.Transaction         `json:"stockOrders"` // by robinhood order id
+	StocksSyncedAt    time.Time                             `json:"stocksSyncedAt"`
+	OptionOrders      map[string][]models.OptionTransaction `json:"optionOrders"` // legs by robinhood order id, as traded
+	OptionsSyncedAt   time.Time                             `json:"optionsSyncedAt"`
+	OptionEvents      []OptionEvent                         `json:"optionEvents"` // assignments and exercises, synced with the options
+	Income            []IncomeEvent                         `json:"income"`
+	IncomeSyncedAt    time.Time                             `json:"incomeSyncedAt"`
+	Transfers         []Transfer                            `json:"transfers"`
+	TransfersSyncedAt time.Time                             `json:"transfersSyncedAt"`
+	History           []PortfolioSnapshot                   `json:"history"` // the account every day, rebuilt by the history job
+	HistoryBuiltAt    time.Time                             `json:"historyBuiltAt"`
+	Splits            map[string][]Split                    `json:"splits"`
+	SplitsSyncedAt    map[string]time.Time                  `json:"splitsSyncedAt"`
+	Symbols           []SymbolChange                        `json:"symbols"`
+}
+
+// storeMigrations[v] upgrades a store file of version v to v+1
+var storeMigrations = []func(fields map[string]json.RawMessage) error{
+	migrateSnapshotStore,
+	migrateOptionEventsStore,
+	migrateOptionOrdersStore,
+	migrateTransfersStore,
+	migrateHistoryStore,
+}
+
+/*
+Version 0 is a snapshot written by the sync command. Its trades have no order ids and no sync times, so the next
+sync fetches everything again
+*/
+func migrateSnapshotStore(fields map[string]json.RawMessage) error {
+	stocks := map[string][]models.Transaction{}
+	if raw, ok := fields["stocks"]; ok {
+		if err := json.Unmarshal(raw, &stocks); err != nil {
+			return err
+		}
+	}
+	stockOrders := make(map[string]models.Transaction)
+	for ticker, transactions := range stocks {
+		for i, transaction := range transactions {
+			stockOrders[fmt.Sprintf("snapshot-%s-%d", ticker, i)] = transaction
+		}
+	}
+	raw, err := json.Marshal(stockOrders)
+	if err != nil {
+		return err
+	}
+	fields["stockOrders"] = raw
+	delete(fields, "stocks")
+	delete(fields, "syncedAt")
+	return nil
+}
+
+/*
+Version 1 has no assignment and exercise dates, the next sync fetches the options and their events again
+*/
+func migrateOptionEventsStore(fields map[string]json.RawMessage) error {
+	fields["optionEvents"] = json.RawMessage("[]")
+	delete(fields, "optionsSyncedAt")
+	return nil
+}
+
+/*
+Version 2 keeps the option legs by ticker with the statuses robinhood.Client.GetOptionsOrders worked out. They're kept
+under made up order ids until the next sync fetches every option order again, and the stored splits are fetched
+again the first time they're used
+*/
+func migrateOptionOrdersStore(fields map[string]json.RawMessage) error {
+	options := map[string][]models.OptionTransaction{}
+	if raw, ok := fields["options"]; ok {
+		if err := json.Unmarshal(raw, &options); err != nil {
+			return err
+		}
+	}
+	optionOrders := make(map[string][]models.OptionTransaction)
+	for ticker, legs := range options {
+		for i, leg := range legs {
+			optionOrders[fmt.Sprintf("snapshot-%s-%d", ticker, i)] = []models.OptionTransaction{leg}
+		}
+	}
+	raw, err := json.Marshal(optionOrders)
+	if err != nil {
+		return err
+	}
+	fields["optionOrders"] = raw
+	fields["splitsSyncedAt"] = json.RawMessage("{}")
+	delete(fields, "options")
+	delete(fields, "optionsMarker")
+	delete(fields, "optionsSyncedAt")
+	return nil
+}
+
+/*
+Version 3 has no deposits and withdrawals, they're fetched on the next sync
+*/
+func migrateTransfersStore(fields map[string]json.RawMessage) error {
+	fields["transfers"] = json.RawMessage("[]")
+	delete(fields, "transfersSyncedAt")
+	return nil
+}
+
+/*
+Version 4 has no portfolio history, the history job builds it
+*/
+func migrateHistoryStore(fields map[string]json.RawMessage) error {
+	fields["history"] = json.RawMessage("[]")
+	return nil
+}
+
+type Store struct {
+	Path  string
+	mu    sync.Mutex
+	data  *storeData
+	dirty bool // synced since the last save, see Hood.SaveStore
+}
+
+// stores are shared by path so two sessions of one user don't overwrite each other
+var openStores = struct {
+	sync.Mutex
+	byPath map[string]*Store
+}{byPath: make(map[string]*Store)}
+
+/*
+Store file of username in dir, named after a hash of the username so emails don't end up in file names
+*/
+func StorePath(dir string, username string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(username))))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".json")
+}
+
+/*
+Open the store at path, an empty one when the file doesn't exist yet. Older layouts are migrated on load
+*/
+func OpenStore(path string) (*Store, error) {
+	path = filepath.Clean(path)
+	openStores.Lock()
+	defer openStores.Unlock()
+	if store, ok := openStores.byPath[path]; ok {
+		return store, nil
+	}
+	store := &Store{Path: path}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	openStores.byPath[path] = store
+	return store, nil
+}
+
+func (s *Store) load() error {
+	s.data = &storeData{
+		Version:        StoreVersion,
+		StockOrders:    make(map[string]models.Transaction),
+		OptionOrders:   make(map[string][]models.OptionTransaction),
+		OptionEvents:   []OptionEvent{},
+		Income:         []IncomeEvent{},
+		Transfers:      []Transfer{},
+		History:        []PortfolioSnapshot{},
+		Splits:         make(map[string][]Split),
+		SplitsSyncedAt: make(map[string]time.Time),
+		Symbols:        []SymbolChange{},
+	}
+	data, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failing to read store. ERR: %v", err)
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("failing to parse store. ERR: %v", err)
+	}
+	version := 0
+	if raw, ok := fields["version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return fmt.Errorf("failing to parse store version. ERR: %v", err)
+		}
+	}
+	if version > StoreVersion {
+		return fmt.Errorf("store %s is version %d, this build reads up to version %d", s.Path, version, StoreVersion)
+	}
+	for ; version < StoreVersion; version++ {
+		if err := storeMigrations[version](fields); err != nil {
+			return fmt.Errorf("failing to migrate store to version %d. ERR: %v", version+1, err)
+		}
+	}
+	if data, err = json.Marshal(fields); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, s.data); err != nil {
+		return fmt.Errorf("failing to parse store. ERR: %v", err)
+	}
+	s.data.Version = StoreVersion
+	return nil
+}
+
+// write to a temporary file first, a crash mid write shouldn't lose the history. Callers hold mu
+func (s *Store) save() error {
+	data, err := json.Marshal(s.data)
+	if err != nil {
+		return fmt.Errorf("failing to encode store. ERR: %v", err)
+	}
+	dir := filepath.Dir(s.Path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failing to create store directory. ERR: %v", err)
+	}
+	file, err := os.CreateTemp(dir, filepath.Base(s.Path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failing to write store. ERR: %v", err)
+	}
+	defer os.Remove(file.Name()) //nolint:errcheck
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failing to write store. ERR: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failing to write store. ERR: %v", err)
+	}
+	if err := os.Rename(file.Name(), s.Path); err != nil {
+		return fmt.Errorf("failing to write store. ERR: %v", err)
+	}
+	return nil
+}
+
+// StoreStatus is what a store holds and when each part was last synced
+type StoreStatus struct {
+	Version           int       `json:"version"`
+	StockOrders       int       `json:"stockOrders"`
+	StocksSyncedAt    time.Time `json:"stocksSyncedAt"`
+	OptionsSyncedAt   time.Time `json:"optionsSyncedAt"`
+	IncomeSyncedAt    time.Time `json:"incomeSyncedAt"`
+	TransfersSyncedAt time.Time `json:"transfersSyncedAt"`
+	HistoryBuiltAt    time.Time `json:"historyBuiltAt"`
+}
+
+func (s *Store) Status() StoreStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return StoreStatus{
+		Version:           s.data.Version,
+		StockOrders:       len(s.data.StockOrders),
+		StocksSyncedAt:    s.data.StocksSyncedAt,
+		OptionsSyncedAt:   s.data.OptionsSyncedAt,
+		IncomeSyncedAt:    s.data.IncomeSyncedAt,
+		TransfersSyncedAt: s.data.TransfersSyncedAt,
+		HistoryBuiltAt:    s.data.HistoryBuiltAt,
+	}
+}
+
+/*
+Everything in the store as a snapshot, trades sorted by created datetime
+*/
+func (s *Store) Snapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	syncedAt := s.data.StocksSyncedAt
+	if s.data.OptionsSyncedAt.Before(syncedAt) {
+		syncedAt = s.data.OptionsSyncedAt
+	}
+	return &Snapshot{SyncedAt: syncedAt, Stocks: s.stockMap(), Options: s.optionMap(), OptionEvents: s.optionEvents(), Income: s.income(), Transfers: s.transfers()}
+}
+
+// callers hold mu, the maps are copies so the engine can't change the store
+func (s *Store) stockMap() map[string][]models.Transaction {
+	ids := make([]string, 0, len(s.data.StockOrders))
+	for id := range s.data.StockOrders {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := s.data.StockOrders[ids[i]], s.data.StockOrders[ids[j]]
+		if a.CreatedAt != b.CreatedAt {
+			return a.CreatedAt < b.CreatedAt
+		}
+		return ids[i] < ids[j]
+	})
+	stockMap := make(map[string][]models.Transaction)
+	for _, id := range ids {
+		transaction := s.data.StockOrders[id]
+		stockMap[transaction.Ticker] = append(stockMap[transaction.Ticker], transaction)
+	}
+	return stockMap
+}
+
+/*
+Option legs by ticker sorted by created datetime. Statuses are worked out from the whole history every time: openers
+past their expiration are expired and the stored events mark the assigned ones
+*/
+func (s *Store) optionMap() map[string][]models.OptionTransaction {
+	ids := make([]string, 0, len(s.data.OptionOrders))
+	for id := range s.data.OptionOrders {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	today := time.Now().Format("2006-01-02")
+	optionMap := make(map[string][]models.OptionTransaction)
+	for _, id := range ids {
+		for _, leg := range s.data.OptionOrders[id] {
+			if leg.Status == "Open" && leg.ExpirationDate < today {
+				leg.Status = "Expired"
+			}
+			optionMap[leg.Ticker] = append(optionMap[leg.Ticker], leg)
+		}
+	}
+	markAssignedLegs(optionMap, s.data.OptionEvents)
+	return optionMap
+}
+
+func (s *Store) optionEvents() []OptionEvent {
+	return append([]OptionEvent{}, s.data.OptionEvents...)
+}
+
+func (s *Store) income() []IncomeEvent {
+	return append([]IncomeEvent{}, s.data.Income...)
+}
+
+func (s *Store) transfers() []Transfer {
+	return append([]Transfer{}, s.data.Transfers...)
+}
+
+/*
+Portfolio history saved by SaveHistory and when it was built, zero when it never was
+*/
+func (s *Store) History() ([]PortfolioSnapshot, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]PortfolioSnapshot{}, s.data.History...), s.data.HistoryBuiltAt
+}
+
+/*
+Replace the stored portfolio history, it's rebuilt from the whole trade history every time
+*/
+func (s *Store) SaveHistory(history []PortfolioSnapshot, builtAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.History = history
+	s.data.HistoryBuiltAt = builtAt
+	return s.save()
+}
+
+/*
+Sync trades, income, transfers and splits into store from now on. Stored symbol changes are added to the Symbols registry
+*/
+func (h *Hood) UseStore(store *Store) {
+	if h.Symbols == nil {
+		h.Symbols = NewSymbolRegistry(DefaultSymbolChanges...)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for _, change := range store.data.Symbols {
+		h.Symbols.Add(change)
+	}
+	h.Store = store
+}
+
+/*
+Write everything synced into the store since the last save. A sync fetches trades, income, transfers and splits one
+after the other, they're written together once it's done rather than rewriting the file after each of them
+*/
+func (h *Hood) SaveStore() error {
+	store := h.Store
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if !store.dirty {
+		return nil
+	}
+	if h.Symbols != nil {
+		store.data.Symbols = h.Symbols.Changes()
+	}
+	if err := store.save(); err != nil {
+		return err
+	}
+	store.dirty = false
+	return nil
+}
+
+/*
+Stock orders updated since the last sync are fetched and merged by order id, the first sync fetches them all
+*/
+func (h *Hood) syncRegularTrades(ctx context.Context) (map[string][]models.Transaction, error) {
+	store := h.Store
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	startedAt := time.Now()
+	ordersURL := robinhood.EPOrders
+	if !store.data.StocksSyncedAt.IsZero() {
+		// a minute of overlap for clock skew, refetching an order just replaces it
+		since := store.data.StocksSyncedAt.Add(-time.Minute).UTC().Format(time.RFC3339)
+		ordersURL += "?updated_at[gte]=" + url.QueryEscape(since)
+	}
+	orders, err := h.fetchStockOrders(ordersURL)
+	if err != nil {
+		return nil, err
+	}
+	if store.data.StocksSyncedAt.IsZero() {
+		store.data.StockOrders = make(map[string]models.Transaction)
+	}
+	for id, transaction := range orders {
+		store.data.StockOrders[id] = transaction
+	}
+	store.data.StocksSyncedAt = startedAt
+	store.dirty = true
+	return store.stockMap(), nil
+}
+
+/*
+Filled stock orders by order id, the same transactions robinhood.Client.GetStockOrders builds
+*/
+func (h *Hood) fetchStockOrders(ordersURL string) (map[string]models.Transaction, error) {
+	orders := make(map[string]models.Transaction)
+	symbols := make(map[string]string) // instrument url --> symbol
+	for {
+		var page models.PaginatedOrder
+		if err := h.Cli.GetAndDecode(ordersURL, &page); err != nil {
+			return nil, fmt.Errorf("failing to fetch stock orders. ERR: %v", err)
+		}
+		for _, order := range page.Results {
+			if order.Id == nil || order.State == nil || order.Instrument == nil || order.CreatedAt == nil || order.Side == nil {
+				continue
+			}
+			// partially filled orders that were cancelled still bought or sold shares
+			if *order.State != "filled" && !(*order.State == "cancelled" && len(order.Executions) > 0) {
+				continue
+			}
+			symbol, ok := symbols[*order.Instrument]
+			if !ok {
+				instrumentData, err := h.Cli.GetInstrument(*order.Instrument)
+				if err != nil {
+					return nil, fmt.Errorf("failing to fetch instrument %s. ERR: %v", *order.Instrument, err)
+				}
+				if instrumentData.Symbol == nil {
+					return nil, fmt.Errorf("instrument %s has no symbol", *order.Instrument)
+				}
+				symbol = *instrumentData.Symbol
+				symbols[*order.Instrument] = symbol
+			}
+			unitCost := 0.0
+			if order.AveragePrice != nil {
+				unitCost = parseMoney(*order.AveragePrice).InexactFloat64()
+			}
+			side := fmt.Sprintf("%s", *order.Side)
+			orders[*order.Id] = models.Transaction{
+				Ticker:          symbol,
+				TransactionType: side,
+				Qty:             filledQty(order).InexactFloat64(),
+				UnitCost:        unitCost,
+				CreatedAt:       order.CreatedAt.Format("2006-01-02 15:04:05"),
+				Tag:             side,
+			}
+		}
+		if page.Next == nil || *page.Next == "" {
+			return orders, nil
+		}
+		ordersURL = *page.Next
+	}
+}
+
+/*
+Shares an order bought or sold, the quantity asked for is more than that when it was cancelled part way. The
+cumulative quantity is the sum of the executions
+*/
+func filledQty(order models.Order) decimal.Decimal {
+	if order.CumulativeQuantity != nil {
+		return parseMoney(*order.CumulativeQuantity)
+	}
+	if len(order.Executions) > 0 {
+		qty := decimal.Zero
+		for _, execution := range order.Executions {
+			if execution.Quantity != nil {
+				qty = qty.Add(parseMoney(*execution.Quantity))
+			}
+		}
+		return qty
+	}
+	if order.Quantity != nil {
+		return parseMoney(*order.Quantity)
+	}
+	return decimal.Zero
+}
+
+/*
+Option orders updated since the last sync are fetched and merged by order id like the stock orders, the first sync
+fetches them all. An assignment doesn't update any order, so the events of the underlyings with contracts that could
+have been assigned since the last sync are fetched again every time
+*/
+func (h *Hood) syncOptionTrades(ctx context.Context) (map[string][]models.OptionTransaction, error) {
+	store := h.Store
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	startedAt := time.Now()
+	ordersURL := robinhood.EPOptions + "orders/"
+	if !store.data.OptionsSyncedAt.IsZero() {
+		since := store.data.OptionsSyncedAt.Add(-time.Minute).UTC().Format(time.RFC3339)
+		ordersURL += "?updated_at[gte]=" + url.QueryEscape(since)
+	}
+	orders, err := h.fetchOptionOrders(ctx, ordersURL)
+	if err != nil {
+		return nil, err
+	}
+	eventsSince := ""
+	if store.data.OptionsSyncedAt.IsZero() {
+		store.data.OptionOrders = make(map[string][]models.OptionTransaction)
+		store.data.OptionEvents = []OptionEvent{}
+	} else {
+		eventsSince = store.data.OptionsSyncedAt.AddDate(0, 0, -optionEventDays).Format("2006-01-02")
+	}
+	for id, legs := range orders {
+		store.data.OptionOrders[id] = legs
+	}
+
+	refetch := make(map[string]bool)
+	for _, legs := range store.data.OptionOrders {
+		for _, leg := range legs {
+			if (leg.TransactionType == "STO" || leg.TransactionType == "BTO") && leg.ExpirationDate >= eventsSince {
+				refetch[leg.Ticker] = true
+			}
+		}
+	}
+	tickers := []string{}
+	for ticker := range refetch {
+		tickers = append(tickers, ticker)
+	}
+	sort.Strings(tickers)
+	events, err := h.fetchOptionEvents(ctx, tickers)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range store.data.OptionEvents {
+		if !refetch[event.Ticker] {
+			events = append(events, event)
+		}
+	}
+	store.data.OptionEvents = events
+	store.data.OptionsSyncedAt = startedAt
+	store.dirty = true
+	return store.optionMap(), nil
+}
+
+// days after its expiration the assignment of a contract may still show up
+const optionEventDays = 7
+
+/*
+Filled option orders by order id, one transaction per leg like robinhood.Client.GetOptionsOrders builds. Openers are
+Open and closing legs Expired, the statuses that depend on the rest of the history are left to Store.optionMap
+*/
+func (h *Hood) fetchOptionOrders(ctx context.Context, ordersURL string) (map[string][]models.OptionTransaction, error) {
+	orders := make(map[string][]models.OptionTransaction)
+	instruments := make(map[string]*models.OptionInstrument) // option url --> instrument
+	for {
+		var page models.GetOptionOrdersResponse
+		if err := h.Cli.GetAndDecode(ordersURL, &page); err != nil {
+			return nil, fmt.Errorf("failing to fetch option orders. ERR: %v", err)
+		}
+		for _, order := range page.Results {
+			if order.Id == nil || order.State == nil || order.ChainSymbol == nil || order.CreatedAt == nil || order.ProcessedQuantity == nil {
+				continue
+			}
+			qty := parseMoney(*order.ProcessedQuantity)
+			// partially filled orders that were cancelled still opened or closed contracts
+			if *order.State != "filled" && !(*order.State == "cancelled" && qty.IsPositive()) {
+				continue
+			}
+			legs := []models.OptionTransaction{}
+			for _, leg := range order.Legs {
+				if leg.Option == nil || leg.Side == nil || leg.PositionEffect == nil || len(leg.Executions) == 0 || leg.Executions[0].Price == nil {
+					continue
+				}
+				instrument, ok := instruments[*leg.Option]
+				if !ok {
+					var err error
+					if instrument, err = h.Cli.GetHistoricalOptionsInstrument(ctx, *leg.Option); err != nil {
+						return nil, fmt.Errorf("failing to fetch option instrument %s. ERR: %v", *leg.Option, err)
+					}
+					instruments[*leg.Option] = instrument
+				}
+				if instrument.StrikePrice == nil || instrument.ExpirationDate == nil || instrument.Type == nil {
+					return nil, fmt.Errorf("option instrument %s has no strike, expiration or type", *leg.Option)
+				}
+				side := fmt.Sprintf("%s", *leg.Side)
+				transactionType, status := "BTO", "Open"
+				if side == "sell" {
+					transactionType = "STO"
+				}
+				if fmt.Sprintf("%s", *leg.PositionEffect) != "open" {
+					transactionType, status = transactionType[:2]+"C", "Expired"
+				}
+				legs = append(legs, models.OptionTransaction{
+					Ticker:          *order.ChainSymbol,
+					TransactionType: transactionType,
+					Qty:             qty.InexactFloat64(),
+					StrikePrice:     parseMoney(*instrument.StrikePrice).InexactFloat64(),
+					UnitCost:        parseMoney(*leg.Executions[0].Price).InexactFloat64(),
+					CreatedAt:       *order.CreatedAt,
+					ExpirationDate:  *instrument.ExpirationDate,
+					Status:          status,
+					Tag:             fmt.Sprintf("%s %s", side, *instrument.Type),
+				})
+			}
+			orders[*order.Id] = legs
+		}
+		if page.Next == nil || *page.Next == "" {
+			return orders, nil
+		}
+		ordersURL = *page.Next
+	}
+}
+
+/*
+Income is paid once a day at most, it's fetched again on the first sync of a day
+*/
+func (h *Hood) syncIncome(ctx context.Context) ([]IncomeEvent, error) {
+	store := h.Store
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	today := time.Now().Format("2006-01-02")
+	if store.data.IncomeSyncedAt.IsZero() || store.data.IncomeSyncedAt.Format("2006-01-02") != today {
+		startedAt := time.Now()
+		events, err := h.fetchIncome(ctx)
+		if err != nil {
+			return nil, err
+		}
+		store.data.Income = events
+		store.data.IncomeSyncedAt = startedAt
+		store.dirty = true
+	}
+	return store.income(), nil
+}
+
+/*
+Transfers land once a day at most, like income they're fetched again on the first sync of a day
+*/
+func (h *Hood) syncTransfers(ctx context.Context) ([]Transfer, error) {
+	store := h.Store
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	today := time.Now().Format("2006-01-02")
+	if store.data.TransfersSyncedAt.IsZero() || store.data.TransfersSyncedAt.Format("2006-01-02") != today {
+		startedAt := time.Now()
+		transfers, err := h.fetchTransfers(ctx)
+		if err != nil {
+			return nil, err
+		}
+		store.data.Transfers = transfers
+		store.data.TransfersSyncedAt = startedAt
+		store.dirty = true
+	}
+	return store.transfers(), nil
+}
+
+/*
+Splits of symbol from the store. They're fetched from the Splits provider the first time and again on the first use of
+a day, so a split after the last fetch is picked up. Stored splits stay in use while the provider fails. Splits
+fetched while calculating are written by the next SaveStore
+*/
+func (h *Hood) storedSplits(symbol string) ([]Split, error) {
+	store := h.Store
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	splits, stored := store.data.Splits[symbol]
+	today := time.Now().Format("2006-01-02")
+	if stored && store.data.SplitsSyncedAt[symbol].Format("2006-01-02") == today {
+		return splits, nil
+	}
+	fetched, err := fetchSplits(h.Splits, symbol)
+	if err != nil {
+		if stored {
+			return splits, nil
+		}
+		return nil, err
+	}
+	store.data.Splits[symbol] = fetched
+	store.data.SplitsSyncedAt[symbol] = time.Now()
+	store.dirty = true
+	return fetched, nil
+}
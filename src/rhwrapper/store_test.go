@@ -0,0 +1,327 @@
+package rhwrapper
+
+import (
+	"context"
+	"encoding/json"
+	robinhood "github.com/Ryang20718/robinhood-client/client"
+	models "github.com/Ryang20718/robinhood-client/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writes contents to a store file of its own and opens it
+func openTestStore(t *testing.T, contents string) *Store {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "store.json")
+	if contents != "" {
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("failing to open store. ERR: %v", err)
+	}
+	return store
+}
+
+// serves a robinhood client's requests off handler, no network
+type robinhoodStub http.HandlerFunc
+
+func (stub robinhoodStub) RoundTrip(r *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	stub(recorder, r)
+	return recorder.Result(), nil
+}
+
+func stubClient(handler http.HandlerFunc) *robinhood.Client {
+	return &robinhood.Client{Client: &http.Client{Transport: robinhoodStub(handler)}}
+}
+
+func TestStoreMigrations(t *testing.T) {
+	aapl := `{"Ticker":"AAPL","TransactionType":"buy","Qty":10,"UnitCost":100,"CreatedAt":"2022-01-03 10:00:00","Tag":"buy"}`
+	put := `{"Ticker":"XYZ","TransactionType":"STO","Qty":1,"StrikePrice":50,"UnitCost":2,"CreatedAt":"2023-01-10T15:00:00Z","ExpirationDate":"2023-02-17","Status":"Open","Tag":"sell put"}`
+	income := `[{"date":"2023-02-16","exDate":"2023-02-10","ticker":"AAPL","type":"dividend","amount":"2.3"}]`
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{
+			name:     "version 0 snapshot",
+			contents: `{"syncedAt":"2023-03-01T00:00:00Z","stocks":{"AAPL":[` + aapl + `]},"options":{"XYZ":[` + put + `]},"income":` + income + `}`,
+		},
+		{
+			name:     "version 1",
+			contents: `{"version":1,"stockOrders":{"a1":` + aapl + `},"stocksSyncedAt":"2023-03-01T00:00:00Z","options":{"XYZ":[` + put + `]},"optionsSyncedAt":"2023-03-01T00:00:00Z","income":` + income + `}`,
+		},
+		{
+			name:     "version 2",
+			contents: `{"version":2,"stockOrders":{"a1":` + aapl + `},"stocksSyncedAt":"2023-03-01T00:00:00Z","options":{"XYZ":[` + put + `]},"optionsMarker":"2023-03-01","optionsSyncedAt":"2023-03-01T00:00:00Z","optionEvents":[],"income":` + income + `,"splits":{"AAPL":[]}}`,
+		},
+		{
+			name:     "version 3",
+			contents: `{"version":3,"stockOrders":{"a1":` + aapl + `},"stocksSyncedAt":"2023-03-01T00:00:00Z","optionOrders":{"o1":[` + put + `]},"optionEvents":[],"income":` + income + `,"transfersSyncedAt":"2023-03-01T00:00:00Z"}`,
+		},
+		{
+			name:     "version 4",
+			contents: `{"version":4,"stockOrders":{"a1":` + aapl + `},"stocksSyncedAt":"2023-03-01T00:00:00Z","optionOrders":{"o1":[` + put + `]},"optionEvents":[],"income":` + income + `,"transfers":[]}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := openTestStore(t, test.contents)
+			if store.data.Version != StoreVersion {
+				t.Errorf("version %d, want %d", store.data.Version, StoreVersion)
+			}
+			// the options are fetched again on the next sync
+			if !store.data.OptionsSyncedAt.IsZero() {
+				t.Errorf("options synced at %v, want them fetched again", store.data.OptionsSyncedAt)
+			}
+			snapshot := store.Snapshot()
+			if got := snapshot.Stocks["AAPL"]; len(got) != 1 || got[0].Qty != 10 || got[0].CreatedAt != "2022-01-03 10:00:00" {
+				t.Errorf("AAPL trades %+v", got)
+			}
+			// the put expired unassigned
+			if got := snapshot.Options["XYZ"]; len(got) != 1 || got[0].Status != "Expired" || got[0].StrikePrice != 50 {
+				t.Errorf("XYZ legs %+v", got)
+			}
+			if len(snapshot.Income) != 1 || !snapshot.Income[0].Amount.Equal(dec("2.30")) {
+				t.Errorf("income %+v", snapshot.Income)
+			}
+			if snapshot.OptionEvents == nil || len(snapshot.OptionEvents) != 0 {
+				t.Errorf("option events %+v, want none", snapshot.OptionEvents)
+			}
+			// and the transfers
+			if snapshot.Transfers == nil || len(snapshot.Transfers) != 0 || !store.data.TransfersSyncedAt.IsZero() {
+				t.Errorf("transfers %+v synced at %v, want them fetched again", snapshot.Transfers, store.data.TransfersSyncedAt)
+			}
+			// the history job builds the history
+			if history, builtAt := store.History(); history == nil || len(history) != 0 || !builtAt.IsZero() {
+				t.Errorf("history %+v built at %v, want none", history, builtAt)
+			}
+			if err := store.save(); err != nil {
+				t.Fatalf("failing to save store. ERR: %v", err)
+			}
+			data, err := os.ReadFile(store.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fields := map[string]json.RawMessage{}
+			if err := json.Unmarshal(data, &fields); err != nil {
+				t.Fatal(err)
+			}
+			for _, gone := range []string{"stocks", "options", "optionsMarker", "syncedAt"} {
+				if _, ok := fields[gone]; ok {
+					t.Errorf("migrated store still has %s", gone)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenStoreErrors(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"future.json":    `{"version":99}`,
+		"truncated.json": `{"version":`,
+		"version.json":   `{"version":"three"}`,
+		"stocks.json":    `{"stocks":{"AAPL":"buy"}}`,
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenStore(path); err == nil {
+			t.Errorf("opened %s", name)
+		}
+	}
+}
+
+func TestOpenStoreShared(t *testing.T) {
+	dir := t.TempDir()
+	path := StorePath(dir, " Alice@Example.com")
+	if path != StorePath(dir, "alice@example.com") {
+		t.Errorf("store paths differ by the case of the username")
+	}
+	if strings.Contains(path, "alice") || filepath.Dir(path) != dir {
+		t.Errorf("store path %s", path)
+	}
+	if path == StorePath(dir, "bob@example.com") {
+		t.Errorf("alice and bob share a store")
+	}
+
+	first, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("failing to open store. ERR: %v", err)
+	}
+	second, err := OpenStore(filepath.Join(dir, ".", filepath.Base(path)))
+	if err != nil {
+		t.Fatalf("failing to open store. ERR: %v", err)
+	}
+	if first != second {
+		t.Errorf("two sessions opened two stores of one file")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("opening an empty store wrote %s", path)
+	}
+}
+
+func TestHoodWithClientSymbols(t *testing.T) {
+	store := openTestStore(t, `{"version": 1, "symbols": [{"from": "TWTR", "to": "X", "date": "2023-07-24"}]}`)
+	hood := &Hood{Symbols: NewSymbolRegistry(DefaultSymbolChanges...)}
+	alice, bob := hood.WithClient(stubClient(http.NotFound)), hood.WithClient(stubClient(http.NotFound))
+	alice.UseStore(store)
+	if got, _ := alice.FetchCurrentTickerSymbol("TWTR", "2022-01-03"); got != "X" {
+		t.Errorf("alice resolved TWTR to %s, want X from her store", got)
+	}
+	// the renames of alice's store stay hers
+	for name, other := range map[string]*Hood{"hood": hood, "bob": bob} {
+		if got, _ := other.FetchCurrentTickerSymbol("TWTR", "2022-01-03"); got != "TWTR" {
+			t.Errorf("%s resolved TWTR to %s", name, got)
+		}
+		if got, _ := other.FetchCurrentTickerSymbol("FB", "2021-01-04"); got != "META" {
+			t.Errorf("%s resolved FB to %s, want META", name, got)
+		}
+	}
+}
+
+func TestStoreOptionMap(t *testing.T) {
+	store := openTestStore(t, "")
+	tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	store.data.OptionOrders = map[string][]models.OptionTransaction{
+		"o1": {{Ticker: "XYZ", TransactionType: "STO", Qty: 1, StrikePrice: 50, UnitCost: 2, CreatedAt: "2023-01-10T15:00:00Z", ExpirationDate: "2023-02-17", Status: "Open", Tag: "sell put"}},
+		"o2": {{Ticker: "XYZ", TransactionType: "STO", Qty: 1, StrikePrice: 45, UnitCost: 1, CreatedAt: "2023-01-11T15:00:00Z", ExpirationDate: "2023-02-17", Status: "Open", Tag: "sell put"}},
+		"o3": {{Ticker: "XYZ", TransactionType: "BTO", Qty: 1, StrikePrice: 60, UnitCost: 3, CreatedAt: "2023-01-12T15:00:00Z", ExpirationDate: tomorrow, Status: "Open", Tag: "buy call"}},
+	}
+	store.data.OptionEvents = []OptionEvent{{Ticker: "XYZ", Strike: 50, Expiration: "2023-02-17", Type: "put", Date: "2023-02-08", Qty: dec("1")}}
+
+	got := store.Snapshot()
+	statuses := []string{}
+	for _, leg := range got.Options["XYZ"] {
+		statuses = append(statuses, leg.Status)
+	}
+	if want := "Assigned,Expired,Open"; strings.Join(statuses, ",") != want {
+		t.Errorf("statuses %v, want %s", statuses, want)
+	}
+	if len(got.OptionEvents) != 1 || got.OptionEvents[0].Date != "2023-02-08" {
+		t.Errorf("option events %+v", got.OptionEvents)
+	}
+	// the snapshot is a copy, the stored legs keep the statuses robinhood's orders had
+	if status := store.data.OptionOrders["o1"][0].Status; status != "Open" {
+		t.Errorf("stored leg status %s", status)
+	}
+
+	// the events come from the store once it's in use
+	hood := &Hood{}
+	hood.UseStore(store)
+	events, err := hood.FetchOptionEvents()
+	if err != nil {
+		t.Fatalf("failing to fetch option events. ERR: %v", err)
+	}
+	if len(events) != 1 || events[0].Strike != 50 {
+		t.Errorf("option events %+v", events)
+	}
+}
+
+func TestSyncRegularTradesIncremental(t *testing.T) {
+	orders := []string{}
+	requests := []string{}
+	client := stubClient(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.String())
+		switch {
+		case strings.HasPrefix(r.URL.String(), robinhood.EPInstruments):
+			w.Write([]byte(`{"symbol":"AAPL"}`)) //nolint:errcheck
+		case strings.HasPrefix(r.URL.String(), robinhood.EPOrders):
+			w.Write([]byte(`{"results":[` + strings.Join(orders, ",") + `]}`)) //nolint:errcheck
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	order := func(id string, side string, state string, qty string, createdAt string) string {
+		return `{"id":"` + id + `","state":"` + state + `","side":"` + side + `","instrument":"` + robinhood.EPInstruments + `aapl/",` +
+			`"quantity":"` + qty + `","average_price":"100.00","created_at":"` + createdAt + `"}`
+	}
+	store := openTestStore(t, "")
+	hood := &Hood{Cli: client}
+	hood.UseStore(store)
+
+	// the first sync fetches every order
+	orders = []string{
+		order("a1", "buy", "filled", "10.00000000", "2022-01-03T10:00:00Z"),
+		order("a2", "buy", "rejected", "5.00000000", "2022-01-04T10:00:00Z"),
+	}
+	stockMap, err := hood.syncRegularTrades(context.Background())
+	if err != nil {
+		t.Fatalf("failing to sync trades. ERR: %v", err)
+	}
+	if strings.Contains(requests[0], "updated_at") {
+		t.Errorf("first sync fetched %s, want every order", requests[0])
+	}
+	if got := stockMap["AAPL"]; len(got) != 1 || got[0].Qty != 10 || got[0].CreatedAt != "2022-01-03 10:00:00" {
+		t.Fatalf("AAPL trades %+v after the first sync", got)
+	}
+	syncedAt := store.data.StocksSyncedAt
+
+	// later syncs fetch the orders updated since, with a minute of overlap
+	requests = nil
+	orders = []string{
+		order("a1", "buy", "filled", "10.00000000", "2022-01-03T10:00:00Z"),
+		order("a3", "sell", "filled", "4.00000000", "2023-03-01T10:00:00Z"),
+		// cancelled after 2 of the 5 shares were sold
+		`{"id":"a4","state":"cancelled","side":"sell","instrument":"` + robinhood.EPInstruments + `aapl/","quantity":"5.00000000",` +
+			`"cumulative_quantity":"2.00000000","executions":[{"quantity":"2.00000000"}],"average_price":"110.00","created_at":"2023-03-02T10:00:00Z"}`,
+	}
+	if stockMap, err = hood.syncRegularTrades(context.Background()); err != nil {
+		t.Fatalf("failing to sync trades. ERR: %v", err)
+	}
+	wantSince := "updated_at[gte]=" + syncedAt.Add(-time.Minute).UTC().Format(time.RFC3339)
+	if len(requests) == 0 || !strings.Contains(requests[0], strings.ReplaceAll(wantSince, ":", "%3A")) {
+		t.Errorf("second sync fetched %v, want orders %s", requests, wantSince)
+	}
+	if got := stockMap["AAPL"]; len(got) != 3 || got[0].TransactionType != "buy" || got[1].TransactionType != "sell" || got[2].Qty != 2 {
+		t.Errorf("AAPL trades %+v after the second sync, want the buy once, the sell and the 2 shares sold before the cancel", got)
+	}
+	if !store.data.StocksSyncedAt.After(syncedAt) {
+		t.Errorf("sync time stayed at %v", store.data.StocksSyncedAt)
+	}
+
+	// nothing is written until the sync is done
+	if _, err := os.Stat(store.Path); !os.IsNotExist(err) {
+		t.Errorf("store written before SaveStore. ERR: %v", err)
+	}
+	if err := hood.SaveStore(); err != nil {
+		t.Fatalf("failing to save store. ERR: %v", err)
+	}
+	if _, err := os.Stat(store.Path); err != nil {
+		t.Errorf("store not written by SaveStore. ERR: %v", err)
+	}
+
+	// so is the portfolio history
+	builtAt := time.Now()
+	history := []PortfolioSnapshot{{Date: "2023-03-01", Cash: dec("600"), Value: dec("1200"), Holdings: []HoldingSnapshot{{Ticker: "AAPL", Qty: dec("6"), Price: dec("100"), Value: dec("600")}}}}
+	if err := store.SaveHistory(history, builtAt); err != nil {
+		t.Fatalf("failing to save history. ERR: %v", err)
+	}
+
+	// the merged history is on disk
+	delete(openStores.byPath, store.Path)
+	reopened, err := OpenStore(store.Path)
+	if err != nil {
+		t.Fatalf("failing to reopen store. ERR: %v", err)
+	}
+	if got := reopened.Snapshot().Stocks["AAPL"]; len(got) != 3 {
+		t.Errorf("reopened store has AAPL trades %+v", got)
+	}
+	if !reopened.data.StocksSyncedAt.Equal(store.data.StocksSyncedAt) {
+		t.Errorf("reopened store synced at %v, want %v", reopened.data.StocksSyncedAt, store.data.StocksSyncedAt)
+	}
+	if got, gotBuiltAt := reopened.History(); len(got) != 1 || !got[0].Value.Equal(dec("1200")) || len(got[0].Holdings) != 1 || !gotBuiltAt.Equal(builtAt) {
+		t.Errorf("reopened store has history %+v built at %v", got, gotBuiltAt)
+	}
+}
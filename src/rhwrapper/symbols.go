@@ -0,0 +1,236 @@
+package rhwrapper
+
+// symbol history registry, dated ticker renames so lots bought under an old symbol merge with the new one
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SymbolChange renames From to To, trades dated before Date under From belong to To
+type SymbolChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Date string `json:"date"` // 2006-01-02, first day trading as To
+}
+
+// renames every registry starts with
+var DefaultSymbolChanges = []SymbolChange{
+	{From: "FB", To: "META", Date: "2022-06-09"},
+	{From: "ANTM", To: "ELV", Date: "2022-06-28"},
+	{From: "SQ", To: "XYZ", Date: "2025-01-21"},
+}
+
+// SymbolLookup finds renames of a symbol the registry doesn't know about, e.g. from an online service
+type SymbolLookup interface {
+	LookupSymbolChanges(symbol string) ([]SymbolChange, error)
+}
+
+// SymbolRegistry is safe to share between requests
+type SymbolRegistry struct {
+	mu      sync.Mutex
+	changes map[string][]SymbolChange // by From, sorted by Date
+	lookups map[string]bool           // symbols already passed to Lookup
+	Lookup  SymbolLookup              // optional
+}
+
+func NewSymbolRegistry(changes ...SymbolChange) *SymbolRegistry {
+	registry := &SymbolRegistry{
+		changes: make(map[string][]SymbolChange),
+		lookups: make(map[string]bool),
+	}
+	for _, change := range changes {
+		registry.Add(change)
+	}
+	return registry
+}
+
+func (r *SymbolRegistry) Add(change SymbolChange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.add(change)
+}
+
+func (r *SymbolRegistry) add(change SymbolChange) {
+	change.From = strings.ToUpper(strings.TrimSpace(change.From))
+	change.To = strings.ToUpper(strings.TrimSpace(change.To))
+	for _, known := range r.changes[change.From] {
+		if known == change {
+			return
+		}
+	}
+	changes := append(r.changes[change.From], change)
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Date < changes[j].Date
+	})
+	r.changes[change.From] = changes
+}
+
+/*
+Copy of the registry with the same renames and Lookup, renames added to either afterwards stay with that one
+*/
+func (r *SymbolRegistry) Clone() *SymbolRegistry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clone := &SymbolRegistry{
+		changes: make(map[string][]SymbolChange),
+		lookups: make(map[string]bool),
+		Lookup:  r.Lookup,
+	}
+	for symbol, changes := range r.changes {
+		clone.changes[symbol] = append([]SymbolChange{}, changes...)
+	}
+	for symbol := range r.lookups {
+		clone.lookups[symbol] = true
+	}
+	return clone
+}
+
+/*
+Every known rename, sorted by date
+*/
+func (r *SymbolRegistry) Changes() []SymbolChange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changes := []SymbolChange{}
+	for _, symbolChanges := range r.changes {
+		changes = append(changes, symbolChanges...)
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Date != changes[j].Date {
+			return changes[i].Date < changes[j].Date
+		}
+		return changes[i].From < changes[j].From
+	})
+	return changes
+}
+
+/*
+Load renames from a json or csv file on top of the registry
+
+json: [{"from": "FB", "to": "META", "date": "2022-06-09"}]
+csv:  from,to,date with a header row
+*/
+func (r *SymbolRegistry) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failing to read symbol history. ERR: %v", err)
+	}
+	changes := []SymbolChange{}
+	if strings.ToLower(filepath.Ext(path)) != ".csv" {
+		if err := json.Unmarshal(data, &changes); err != nil {
+			return fmt.Errorf("failing to parse symbol history. ERR: %v", err)
+		}
+	} else {
+		rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+		if err != nil {
+			return fmt.Errorf("failing to parse symbol history. ERR: %v", err)
+		}
+		for i, row := range rows {
+			if i == 0 {
+				continue // header
+			}
+			if len(row) < 3 {
+				return fmt.Errorf("symbol history row %d needs from,to,date", i+1)
+			}
+			changes = append(changes, SymbolChange{From: row[0], To: row[1], Date: strings.TrimSpace(row[2])})
+		}
+	}
+	for i, change := range changes {
+		if change.From == "" || change.To == "" {
+			return fmt.Errorf("symbol change %d needs a from and to symbol", i+1)
+		}
+		if _, err := time.Parse("2006-01-02", change.Date); err != nil {
+			return fmt.Errorf("symbol change %s -> %s has invalid date %s", change.From, change.To, change.Date)
+		}
+		r.Add(change)
+	}
+	return nil
+}
+
+/*
+Symbol that a trade of symbol on date (2006-01-02) trades under today, following chained renames
+
+A rename only applies to trades before its date, a symbol reused by another company afterwards is left alone.
+A symbol the Lookup fails on is taken as never renamed and isn't looked up again
+*/
+func (r *SymbolRegistry) CurrentSymbol(symbol string, date string) string {
+	symbol = strings.ToUpper(symbol)
+	seen := make(map[string]bool)
+	for !seen[symbol] {
+		seen[symbol] = true
+		r.lookup(symbol)
+		renamed := false
+		r.mu.Lock()
+		for _, change := range r.changes[symbol] {
+			if date < change.Date {
+				symbol, date = change.To, change.Date
+				renamed = true
+				break
+			}
+		}
+		r.mu.Unlock()
+		if !renamed {
+			break
+		}
+	}
+	return symbol
+}
+
+// ask Lookup about symbol once, without holding the lock so a slow lookup doesn't hold up other symbols
+func (r *SymbolRegistry) lookup(symbol string) {
+	r.mu.Lock()
+	known := r.Lookup == nil || r.lookups[symbol] || len(r.changes[symbol]) > 0
+	r.mu.Unlock()
+	if known {
+		return
+	}
+	changes, err := r.Lookup.LookupSymbolChanges(symbol)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups[symbol] = true
+	if err != nil {
+		return
+	}
+	for _, change := range changes {
+		r.add(change)
+	}
+}
+
+// HTTPSymbolLookup asks a json endpoint for renames, URL is a format string with a %s for the symbol
+// e.g. https://example.com/symbols/%s/changes returning [{"from": "FB", "to": "META", "date": "2022-06-09"}]
+type HTTPSymbolLookup struct {
+	URL    string
+	Client *http.Client // defaults to http.DefaultClient
+}
+
+func (l *HTTPSymbolLookup) LookupSymbolChanges(symbol string) ([]SymbolChange, error) {
+	client := l.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(fmt.Sprintf(l.URL, symbol))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return []SymbolChange{}, nil
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("symbol lookup for %s returned %s", symbol, resp.Status)
+	}
+	changes := []SymbolChange{}
+	if err := json.NewDecoder(resp.Body).Decode(&changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
@@ -0,0 +1,228 @@
+package rhwrapper
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymbolRegistryCurrentSymbol(t *testing.T) {
+	registry := NewSymbolRegistry(
+		SymbolChange{From: "FB", To: "META", Date: "2022-06-09"},
+		// chained, OLD became MID which became NEW
+		SymbolChange{From: "old", To: "mid", Date: "2020-01-02"},
+		SymbolChange{From: "MID", To: "NEW", Date: "2021-01-04"},
+		// SQ was renamed and XYZ belonged to another company before
+		SymbolChange{From: "SQ", To: "XYZ", Date: "2025-01-21"},
+	)
+	tests := []struct {
+		symbol string
+		date   string
+		want   string
+	}{
+		{symbol: "FB", date: "2021-03-01", want: "META"},
+		{symbol: "fb", date: "2022-06-08", want: "META"},
+		{symbol: "FB", date: "2022-06-09", want: "FB"}, // traded after the rename, another company
+		{symbol: "META", date: "2023-01-03", want: "META"},
+		{symbol: "OLD", date: "2019-06-03", want: "NEW"},
+		{symbol: "OLD", date: "2020-06-01", want: "OLD"},
+		{symbol: "MID", date: "2020-06-01", want: "NEW"},
+		{symbol: "MID", date: "2021-01-04", want: "MID"},
+		{symbol: "SQ", date: "2024-01-03", want: "XYZ"},
+		{symbol: "XYZ", date: "2023-01-03", want: "XYZ"}, // XYZ before the rename isn't SQ
+		{symbol: "AAPL", date: "2023-01-03", want: "AAPL"},
+	}
+	for _, test := range tests {
+		if got := registry.CurrentSymbol(test.symbol, test.date); got != test.want {
+			t.Errorf("CurrentSymbol(%s, %s) = %s, want %s", test.symbol, test.date, got, test.want)
+		}
+	}
+}
+
+func TestSymbolRegistryRenameCycle(t *testing.T) {
+	registry := NewSymbolRegistry(
+		SymbolChange{From: "AAA", To: "BBB", Date: "2020-01-02"},
+		SymbolChange{From: "BBB", To: "AAA", Date: "2021-01-04"},
+	)
+	// AAA -> BBB -> AAA back again, the same symbol isn't renamed twice
+	if got := registry.CurrentSymbol("AAA", "2019-06-03"); got != "AAA" {
+		t.Errorf("CurrentSymbol = %s, want AAA", got)
+	}
+}
+
+func TestSymbolRegistryLoadFile(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		err  bool
+	}{
+		{name: "json", file: "symbols.json", data: `[{"from": "twtr", "to": "X", "date": "2023-07-24"}]`},
+		{name: "csv", file: "symbols.csv", data: "from,to,date\nTWTR,X, 2023-07-24 \n"},
+		{name: "csv missing a column", file: "symbols.csv", data: "from,to\nTWTR,X\n", err: true},
+		{name: "invalid date", file: "symbols.json", data: `[{"from": "TWTR", "to": "X", "date": "07/24/2023"}]`, err: true},
+		{name: "missing to", file: "symbols.json", data: `[{"from": "TWTR", "date": "2023-07-24"}]`, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.file)
+			if err := os.WriteFile(path, []byte(test.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			registry := NewSymbolRegistry(DefaultSymbolChanges...)
+			err := registry.LoadFile(path)
+			if test.err {
+				if err == nil {
+					t.Errorf("loaded %+v", registry.Changes())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failing to load symbol history. ERR: %v", err)
+			}
+			if got := registry.CurrentSymbol("TWTR", "2022-01-03"); got != "X" {
+				t.Errorf("TWTR resolved to %s, want X", got)
+			}
+			// on top of the defaults
+			if got := len(registry.Changes()); got != len(DefaultSymbolChanges)+1 {
+				t.Errorf("%d changes, want %d", got, len(DefaultSymbolChanges)+1)
+			}
+		})
+	}
+}
+
+// answers from a fixed table and counts the calls
+type stubSymbolLookup struct {
+	changes map[string][]SymbolChange
+	err     error
+	calls   map[string]int
+}
+
+func (s *stubSymbolLookup) LookupSymbolChanges(symbol string) ([]SymbolChange, error) {
+	s.calls[symbol] += 1
+	return s.changes[symbol], s.err
+}
+
+func TestSymbolRegistryLookup(t *testing.T) {
+	lookup := &stubSymbolLookup{
+		changes: map[string][]SymbolChange{
+			"TWTR": {{From: "TWTR", To: "X", Date: "2023-07-24"}},
+			// chained, GOLD became MID which became NEW, and GOLD was reused afterwards
+			"GOLD": {{From: "GOLD", To: "MID", Date: "2020-01-02"}},
+			"MID":  {{From: "MID", To: "NEW", Date: "2021-01-04"}},
+		},
+		calls: make(map[string]int),
+	}
+	registry := NewSymbolRegistry(DefaultSymbolChanges...)
+	registry.Lookup = lookup
+	tests := []struct {
+		symbol string
+		date   string
+		want   string
+	}{
+		{symbol: "TWTR", date: "2022-01-03", want: "X"},
+		{symbol: "AAPL", date: "2022-01-03", want: "AAPL"},
+		{symbol: "GOLD", date: "2019-06-03", want: "NEW"},
+		{symbol: "GOLD", date: "2020-01-02", want: "GOLD"},
+		{symbol: "MID", date: "2020-06-01", want: "NEW"},
+	}
+	for i := 0; i < 2; i++ {
+		for _, test := range tests {
+			if got := registry.CurrentSymbol(test.symbol, test.date); got != test.want {
+				t.Errorf("CurrentSymbol(%s, %s) = %s, want %s", test.symbol, test.date, got, test.want)
+			}
+		}
+	}
+	// known renames aren't looked up, the symbols they lead to are for further renames, each only once
+	registry.CurrentSymbol("FB", "2021-01-04")
+	if fmt.Sprint(lookup.calls) != "map[AAPL:1 GOLD:1 META:1 MID:1 NEW:1 TWTR:1 X:1]" {
+		t.Errorf("lookups %v", lookup.calls)
+	}
+}
+
+func TestSymbolRegistryLookupError(t *testing.T) {
+	lookup := &stubSymbolLookup{err: fmt.Errorf("lookup down"), calls: make(map[string]int)}
+	registry := NewSymbolRegistry(DefaultSymbolChanges...)
+	registry.Lookup = lookup
+	// taken as never renamed, known renames still apply
+	for i := 0; i < 2; i++ {
+		if got := registry.CurrentSymbol("TWTR", "2022-01-03"); got != "TWTR" {
+			t.Errorf("TWTR resolved to %s with the lookup down, want TWTR", got)
+		}
+		if got := registry.CurrentSymbol("FB", "2021-01-04"); got != "META" {
+			t.Errorf("FB resolved to %s with the lookup down, want META", got)
+		}
+	}
+	// a failed lookup isn't retried
+	if fmt.Sprint(lookup.calls) != "map[META:1 TWTR:1]" {
+		t.Errorf("lookups %v", lookup.calls)
+	}
+}
+
+// blocks LookupSymbolChanges of SLOW until release is closed
+type slowSymbolLookup struct {
+	started chan bool
+	release chan bool
+}
+
+func (s *slowSymbolLookup) LookupSymbolChanges(symbol string) ([]SymbolChange, error) {
+	if symbol == "SLOW" {
+		s.started <- true
+		<-s.release
+	}
+	return nil, nil
+}
+
+func TestSymbolRegistryLookupUnlocked(t *testing.T) {
+	lookup := &slowSymbolLookup{started: make(chan bool), release: make(chan bool)}
+	registry := NewSymbolRegistry(DefaultSymbolChanges...)
+	registry.Lookup = lookup
+	done := make(chan string)
+	go func() {
+		done <- registry.CurrentSymbol("SLOW", "2022-01-03")
+	}()
+	<-lookup.started
+	// resolved while SLOW is still being looked up
+	if got := registry.CurrentSymbol("FB", "2021-01-04"); got != "META" {
+		t.Errorf("FB resolved to %s, want META", got)
+	}
+	close(lookup.release)
+	if got := <-done; got != "SLOW" {
+		t.Errorf("SLOW resolved to %s", got)
+	}
+}
+
+func TestHTTPSymbolLookup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/symbols/TWTR/changes":
+			fmt.Fprint(w, `[{"from": "TWTR", "to": "X", "date": "2023-07-24"}]`)
+		case "/symbols/DOWN/changes":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/symbols/JUNK/changes":
+			fmt.Fprint(w, `{"from": `)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	lookup := &HTTPSymbolLookup{URL: server.URL + "/symbols/%s/changes", Client: server.Client()}
+
+	changes, err := lookup.LookupSymbolChanges("TWTR")
+	if err != nil || len(changes) != 1 || changes[0] != (SymbolChange{From: "TWTR", To: "X", Date: "2023-07-24"}) {
+		t.Errorf("TWTR changes %+v err %v", changes, err)
+	}
+	// not found means never renamed
+	changes, err = lookup.LookupSymbolChanges("AAPL")
+	if err != nil || len(changes) != 0 {
+		t.Errorf("AAPL changes %+v err %v, want none", changes, err)
+	}
+	for _, symbol := range []string{"DOWN", "JUNK"} {
+		if changes, err := lookup.LookupSymbolChanges(symbol); err == nil {
+			t.Errorf("%s changes %+v, want an error", symbol, changes)
+		}
+	}
+}
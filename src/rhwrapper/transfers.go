@@ -0,0 +1,103 @@
+package rhwrapper
+
+// deposits and withdrawals, the cash flows returns are measured against
+
+import (
+	"context"
+	"fmt"
+	robinhood "github.com/Ryang20718/robinhood-client/client"
+	"github.com/shopspring/decimal"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Transfer is money moved into (positive) or out of (negative) the account
+type Transfer struct {
+	Date   string // 2006-01-02
+	Amount decimal.Decimal
+}
+
+type rhTransfer struct {
+	Amount              string `json:"amount"`
+	Direction           string `json:"direction"` // deposit or withdraw
+	State               string `json:"state"`     // completed, pending, cancelled, failed, reversed
+	CreatedAt           string `json:"created_at"`
+	ExpectedLandingDate string `json:"expected_landing_date"`
+}
+
+type rhTransferPage struct {
+	Next    *string      `json:"next"`
+	Results []rhTransfer `json:"results"`
+}
+
+/*
+Returns deposits and withdrawals sorted by date
+*/
+func (h *Hood) FetchTransfers(ctx context.Context) ([]Transfer, error) {
+	if h.Store != nil {
+		return h.syncTransfers(ctx)
+	}
+	return h.fetchTransfers(ctx)
+}
+
+// every completed bank transfer from robinhood
+func (h *Hood) fetchTransfers(ctx context.Context) ([]Transfer, error) {
+	transfers := []Transfer{}
+	url := robinhood.EPBase + "ach/transfers/"
+	for {
+		var page rhTransferPage
+		if err := h.Cli.GetAndDecode(url, &page); err != nil {
+			return nil, fmt.Errorf("failing to fetch transfers. ERR: %v", err)
+		}
+		for _, transfer := range page.Results {
+			if transfer.State != "completed" {
+				continue
+			}
+			date := transfer.ExpectedLandingDate
+			if date == "" && len(transfer.CreatedAt) >= 10 {
+				date = transfer.CreatedAt[:10]
+			}
+			amount := parseMoney(transfer.Amount)
+			if transfer.Direction == "withdraw" {
+				amount = amount.Neg()
+			}
+			transfers = append(transfers, Transfer{Date: date, Amount: amount})
+		}
+		if page.Next == nil || *page.Next == "" {
+			break
+		}
+		url = *page.Next
+	}
+	sort.SliceStable(transfers, func(i, j int) bool {
+		return transfers[i].Date < transfers[j].Date
+	})
+	return transfers, nil
+}
+
+/*
+Parse the ACH rows of robinhood's account activity csv, deposits are positive and withdrawals negative
+*/
+func ParseActivityTransfers(r io.Reader) ([]Transfer, error) {
+	rows, err := readActivityRows(r)
+	if err != nil {
+		return nil, err
+	}
+	return activityTransfers(rows), nil
+}
+
+func activityTransfers(rows []activityRow) []Transfer {
+	transfers := []Transfer{}
+	for _, row := range rows {
+		if row.code != "ACH" {
+			continue
+		}
+		amount := decimal.NewFromFloat(row.amount).Round(moneyPlaces)
+		// older exports leave the sign off withdrawals
+		if amount.IsPositive() && strings.Contains(strings.ToLower(row.description), "withdraw") {
+			amount = amount.Neg()
+		}
+		transfers = append(transfers, Transfer{Date: row.date, Amount: amount})
+	}
+	return transfers
+}
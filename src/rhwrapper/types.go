@@ -1,6 +1,8 @@
 package rhwrapper
 
 import (
+	"github.com/go-gota/gota/dataframe"
+	"github.com/shopspring/decimal"
 	"time"
 )
 
@@ -19,23 +21,38 @@ type StockData struct {
 
 type Profit struct {
 	Date   string
-	Amount float64
+	Amount decimal.Decimal // rounded to cents
 	Lcap   bool
 	Ticker string
 	Tag    string
+	Method CostBasisMethod
+	// loss deferred into a replacement lot, already added back into Amount
+	WashSale   bool
+	Disallowed decimal.Decimal
+}
+
+// EarningsReport holds every table produced by a realized earnings run
+type EarningsReport struct {
+	Profit           *dataframe.DataFrame
+	UnrealizedProfit *dataframe.DataFrame
+	OpenLots         map[string][]*Lot // by ticker, for ValueOpenLots
+	WashSales        *dataframe.DataFrame
+	Warnings         *dataframe.DataFrame
+	Income           *dataframe.DataFrame // dividends, stock lending and interest
+	RealizedLots     []RealizedLot        // per lot disposals for Form 8949
 }
 
 type Gains struct {
-	LcapAmount float64
-	ScapAmount float64
+	LcapAmount decimal.Decimal
+	ScapAmount decimal.Decimal
 }
 
 type Stock struct {
 	Action            string
-	Qty               float64
-	UnitCost          float64
+	Qty               decimal.Decimal
+	UnitCost          decimal.Decimal
 	Datetime          time.Time
 	Description       string
-	OptionStrikePrice float64
+	OptionStrikePrice decimal.Decimal
 	OptionExpiryDate  time.Time
 }
@@ -0,0 +1,218 @@
+package rhwrapper
+
+// unrealized gains of the open lots at market prices
+
+import (
+	"github.com/go-gota/gota/dataframe"
+	"github.com/go-gota/gota/series"
+	"github.com/shopspring/decimal"
+	"sort"
+)
+
+// LotValue is an open lot at market price, the market columns are zero when the quote provider has no price
+type LotValue struct {
+	Ticker      string
+	Acquired    string // 2006-01-02
+	Qty         decimal.Decimal
+	UnitCost    decimal.Decimal
+	Basis       decimal.Decimal
+	Price       decimal.Decimal
+	MarketValue decimal.Decimal
+	Gain        decimal.Decimal
+	Return      decimal.Decimal // percent of basis
+	Priced      bool
+	LongTerm    bool // if sold on the valuation date
+}
+
+// Holding sums the open lots of a ticker
+type Holding struct {
+	Ticker        string
+	Qty           decimal.Decimal
+	Basis         decimal.Decimal
+	MarketValue   decimal.Decimal
+	Gain          decimal.Decimal
+	Return        decimal.Decimal // percent of basis
+	ShortTermGain decimal.Decimal
+	LongTermGain  decimal.Decimal
+	Priced        bool
+}
+
+func percentOf(gain decimal.Decimal, basis decimal.Decimal) decimal.Decimal {
+	if basis.IsZero() {
+		return decimal.Zero
+	}
+	return gain.Mul(decimal.NewFromInt(100)).DivRound(basis, moneyPlaces)
+}
+
+/*
+Value the open lots with quotes on asOf (2006-01-02), quotes may be nil to only show basis
+*/
+func ValueOpenLots(openLots map[string][]*Lot, quotes QuoteProvider, asOf string) ([]LotValue, error) {
+	tickers := []string{}
+	for ticker, lots := range openLots {
+		if len(lots) > 0 {
+			tickers = append(tickers, ticker)
+		}
+	}
+	sort.Strings(tickers)
+
+	prices := make(map[string]decimal.Decimal)
+	if quotes != nil {
+		var err error
+		if prices, err = quotes.Quotes(tickers); err != nil {
+			return nil, err
+		}
+	}
+
+	values := []LotValue{}
+	for _, ticker := range tickers {
+		for _, lot := range openLots[ticker] {
+			longTerm, err := IsLongTerm(holdingStart(lot), asOf)
+			if err != nil {
+				return nil, err
+			}
+			value := LotValue{
+				Ticker:   ticker,
+				Acquired: lotDate(lot),
+				Qty:      lot.Qty,
+				UnitCost: lot.UnitCost,
+				Basis:    amountOf(lot.Qty, lot.UnitCost),
+				LongTerm: longTerm,
+			}
+			if price, ok := prices[ticker]; ok {
+				value.Priced = true
+				value.Price = price
+				value.MarketValue = amountOf(lot.Qty, price)
+				value.Gain = value.MarketValue.Sub(value.Basis)
+				value.Return = percentOf(value.Gain, value.Basis)
+			}
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+/*
+Sum valued lots per ticker, a ticker is only priced when all its lots are
+*/
+func SummarizeHoldings(values []LotValue) []Holding {
+	holdings := []Holding{}
+	index := make(map[string]int)
+	for _, value := range values {
+		i, ok := index[value.Ticker]
+		if !ok {
+			holdings = append(holdings, Holding{Ticker: value.Ticker, Priced: true})
+			i = len(holdings) - 1
+			index[value.Ticker] = i
+		}
+		holding := &holdings[i]
+		holding.Qty = holding.Qty.Add(value.Qty)
+		holding.Basis = holding.Basis.Add(value.Basis)
+		holding.MarketValue = holding.MarketValue.Add(value.MarketValue)
+		holding.Gain = holding.Gain.Add(value.Gain)
+		holding.Priced = holding.Priced && value.Priced
+		if value.LongTerm {
+			holding.LongTermGain = holding.LongTermGain.Add(value.Gain)
+		} else {
+			holding.ShortTermGain = holding.ShortTermGain.Add(value.Gain)
+		}
+	}
+	for i := range holdings {
+		holdings[i].Return = percentOf(holdings[i].Gain, holdings[i].Basis)
+	}
+	return holdings
+}
+
+// "long term" or "short term", the label the tables and gauges use
+func TermOf(longTerm bool) string {
+	if longTerm {
+		return "long term"
+	}
+	return "short term"
+}
+
+/*
+convert valued open lots to dataframe
+*/
+func ConvertLotValueDf(values []LotValue) *dataframe.DataFrame {
+	tickers := series.New([]string{}, series.String, "Ticker")
+	acquired := series.New([]string{}, series.String, "Acquired")
+	qtys := series.New([]float64{}, series.Float, "Qty")
+	unitCosts := series.New([]float64{}, series.Float, "UnitCost")
+	basis := series.New([]float64{}, series.Float, "Basis")
+	prices := series.New([]float64{}, series.Float, "Price")
+	marketValues := series.New([]float64{}, series.Float, "MarketValue")
+	gains := series.New([]float64{}, series.Float, "Gain")
+	returns := series.New([]float64{}, series.Float, "Return")
+	priced := series.New([]bool{}, series.Bool, "Priced")
+	terms := series.New([]string{}, series.String, "Term")
+
+	for _, value := range values {
+		tickers.Append(value.Ticker)
+		acquired.Append(value.Acquired)
+		qtys.Append(value.Qty.InexactFloat64())
+		unitCosts.Append(value.UnitCost.InexactFloat64())
+		basis.Append(value.Basis.InexactFloat64())
+		prices.Append(value.Price.InexactFloat64())
+		marketValues.Append(value.MarketValue.InexactFloat64())
+		gains.Append(value.Gain.InexactFloat64())
+		returns.Append(value.Return.InexactFloat64())
+		priced.Append(value.Priced)
+		terms.Append(TermOf(value.LongTerm))
+	}
+
+	df := dataframe.New(
+		tickers,
+		acquired,
+		qtys,
+		unitCosts,
+		basis,
+		prices,
+		marketValues,
+		gains,
+		returns,
+		priced,
+		terms,
+	)
+	return &df
+}
+
+/*
+convert holdings to dataframe
+*/
+func ConvertHoldingDf(holdings []Holding) *dataframe.DataFrame {
+	tickers := series.New([]string{}, series.String, "Ticker")
+	qtys := series.New([]float64{}, series.Float, "Qty")
+	basis := series.New([]float64{}, series.Float, "Basis")
+	marketValues := series.New([]float64{}, series.Float, "MarketValue")
+	gains := series.New([]float64{}, series.Float, "Gain")
+	returns := series.New([]float64{}, series.Float, "Return")
+	shortTermGains := series.New([]float64{}, series.Float, "ShortTermGain")
+	longTermGains := series.New([]float64{}, series.Float, "LongTermGain")
+	priced := series.New([]bool{}, series.Bool, "Priced")
+
+	for _, holding := range holdings {
+		tickers.Append(holding.Ticker)
+		qtys.Append(holding.Qty.InexactFloat64())
+		basis.Append(holding.Basis.InexactFloat64())
+		marketValues.Append(holding.MarketValue.InexactFloat64())
+		gains.Append(holding.Gain.InexactFloat64())
+		returns.Append(holding.Return.InexactFloat64())
+		shortTermGains.Append(holding.ShortTermGain.InexactFloat64())
+		longTermGains.Append(holding.LongTermGain.InexactFloat64())
+		priced.Append(holding.Priced)
+	}
+
+	df := dataframe.New(
+		tickers,
+		qtys,
+		basis,
+		marketValues,
+		gains,
+		returns,
+		shortTermGains,
+		longTermGains,
+		priced,
+	)
+	return &df
+}
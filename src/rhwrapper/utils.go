@@ -2,114 +2,76 @@ package rhwrapper
 
 import (
 	"encoding/gob"
-	"encoding/json"
 	"fmt"
-	"github.com/PuerkitoBio/goquery"
-	"io"
-	"net/http"
+	"github.com/shopspring/decimal"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
-func BeforeDate(originalDate string, dateToCompare string) bool {
-	date1, _ := time.Parse("2006-01-02", originalDate)
-	date2, _ := time.Parse("2006-01-02", dateToCompare)
-
-	// Calculate the duration between the two dates
-	duration := date2.Sub(date1)
-
-	// Check if the dateToCompare is before the originalDate
-	return duration.Hours() < 0
+// layouts the robinhood client uses for CreatedAt and ExpirationDate
+// stock orders "2006-01-02 15:04:05", option orders RFC3339 with optional fractional seconds, expirations "2006-01-02"
+var tradeDateLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05.999999999",
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999-0700",
+	"2006-01-02",
 }
 
-func OneYearApart(dateStr1 string, dateStr2 string) bool {
-	date1, _ := time.Parse("2006-01-02", dateStr1)
-	date2, _ := time.Parse("2006-01-02", dateStr2)
-
-	// Calculate the duration between the two dates
-	duration := date2.Sub(date1)
-
-	// Check if the duration is exactly 365 days
-	if duration.Hours() == 365*24 {
-		return true
+func ParseTradeDate(date string) (time.Time, error) {
+	date = strings.TrimSpace(date)
+	for _, layout := range tradeDateLayouts {
+		t, err := time.Parse(layout, date)
+		if err == nil {
+			return t, nil
+		}
 	}
-	return false
+	return time.Time{}, fmt.Errorf("unknown date format %s", date)
 }
 
-type Capture struct {
-	UrlKey     string
-	Timestamp  string
-	Original   string
-	MimeType   string
-	StatusCode string
-	Digest     string
-	Length     string
-}
-
-func FetchStockSymbolChange(symbol string) (string, error) {
-	// input: symbol potentially delisted and return current symbol
-	url := fmt.Sprintf("http://web.archive.org/cdx/search/cdx?url=https://www.bloomberg.com/quote/%s:US&output=json&limit=10", symbol)
-	resp, err := http.Get(url)
+// calendar day of a trade, the time component is dropped
+func tradeDay(date string) (time.Time, error) {
+	t, err := ParseTradeDate(date)
 	if err != nil {
-		return "", fmt.Errorf("failing to get stock symbol change. url: %s ping @ryang", url)
+		return time.Time{}, err
 	}
-	defer resp.Body.Close()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+}
 
-	var result [][]string
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		return "", fmt.Errorf("failing to get stock symbol change. url: %s ping @ryang", url)
-	}
-	var capture Capture
-	if len(result) == 0 {
-		return "", fmt.Errorf("empty result")
-	}
-	for _, item := range result[len(result)-1:] { // Skip the first item because it's the field names
-		capture = Capture{
-			UrlKey:     item[0],
-			Timestamp:  item[1],
-			Original:   item[2],
-			MimeType:   item[3],
-			StatusCode: item[4],
-			Digest:     item[5],
-			Length:     item[6],
-		}
-	}
-	webArchiveURL := fmt.Sprintf("http://web.archive.org/web/%s/%s", capture.Timestamp, capture.Original)
-	resp, err = http.Get(webArchiveURL)
+/*
+Whether a position acquired on acquiredDate and disposed of on disposedDate is long term
+
+The holding period starts the day after acquisition and has to be more than one year, so selling on the
+anniversary is still short term. Positions acquired on Feb 29 hit their anniversary on Feb 28 in non leap years.
+*/
+func IsLongTerm(acquiredDate string, disposedDate string) (bool, error) {
+	acquired, err := tradeDay(acquiredDate)
 	if err != nil {
-		return "", fmt.Errorf("failing to get stock symbol change. url: %s ping @ryang", webArchiveURL)
+		return false, err
 	}
-	defer resp.Body.Close()
-	document, err := goquery.NewDocumentFromReader(resp.Body)
+	disposed, err := tradeDay(disposedDate)
 	if err != nil {
-		return "", fmt.Errorf("failing to get stock symbol change. url: %s ping @ryang", webArchiveURL)
+		return false, err
 	}
-	htmlBody, err := document.Html()
-	if err != nil {
-		return "", fmt.Errorf("failing to get stock symbol change. url: %s ping @ryang", webArchiveURL)
+	anniversary := acquired.AddDate(1, 0, 0)
+	if acquired.Month() == time.February && acquired.Day() == 29 && anniversary.Month() == time.March {
+		anniversary = anniversary.AddDate(0, 0, -1)
 	}
+	return disposed.After(anniversary), nil
+}
 
-	currentSymbol := ""
-	// TODO make this less hacky
-	document.Find(".detailMessage__f82c6a6079").Each(func(index int, element *goquery.Selection) {
-		if len(strings.Split(element.Text(), ":")) >= 3 {
-			currentSymbol = strings.TrimSpace(strings.Split(element.Text(), ":")[2])
-		}
-	})
-	document.Find(".TickerStatusMessage_tickerMessage__A8272").Each(func(index int, element *goquery.Selection) {
-		if len(strings.Split(element.Text(), ":")) >= 3 {
-			currentSymbol = strings.TrimSpace(strings.Split(element.Text(), ":")[2])
-		}
-	})
-	if strings.Contains(htmlBody, "Ticker Change") && currentSymbol == "" {
-		return "", fmt.Errorf("failing to get stock symbol change. url: %s ping @ryang", webArchiveURL)
-	}
-	if currentSymbol == "" {
-		return symbol, nil
-	}
-	return currentSymbol, nil
+func BeforeDate(originalDate string, dateToCompare string) bool {
+	date1, _ := time.Parse("2006-01-02", originalDate)
+	date2, _ := time.Parse("2006-01-02", dateToCompare)
+
+	// Calculate the duration between the two dates
+	duration := date2.Sub(date1)
+
+	// Check if the dateToCompare is before the originalDate
+	return duration.Hours() < 0
 }
 
 type Event struct {
@@ -139,78 +101,58 @@ type Split struct {
 	Denominator int
 }
 
-var CacheStockSplits = make(map[string][]Split)
+// SplitCache holds the splits of every symbol fetched so far, safe to share between requests
+type SplitCache struct {
+	mu     sync.RWMutex
+	splits map[string][]Split
+}
 
-func FetchStockSplits(symbol string) ([]Split, error) {
-	url := fmt.Sprintf("%s/v8/finance/chart/%s?period1=0&period2=9999999999&interval=3mo&events=split", "https://query2.finance.yahoo.com", symbol)
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+func NewSplitCache() *SplitCache {
+	return &SplitCache{splits: make(map[string][]Split)}
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+func (c *SplitCache) Get(symbol string) ([]Split, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	splits, ok := c.splits[symbol]
+	return splits, ok
+}
 
-	if string(body) == "Will be right back" {
-		return nil, fmt.Errorf("*** YAHOO! FINANCE IS CURRENTLY DOWN! ***\nOur engineers are working quickly to resolve the issue. Thank you for your patience.")
-	}
+func (c *SplitCache) Put(symbol string, splits []Split) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.splits[symbol] = splits
+}
 
-	var data Data
-	err = json.Unmarshal(body, &data)
-	if err != nil {
-		return nil, err
-	}
+var CacheStockSplits = NewSplitCache()
 
-	splits := []Split{}
-	for _, result := range data.Chart.Result {
-		for _, v := range result.Events {
-			for _, val := range v {
-				timestamp := int64(val.Date)
-
-				// Convert the Unix timestamp to a time.Time value
-				t := time.Unix(timestamp, 0)
-				date := t.Format("2006-01-02")
-				split := Split{
-					Date:        date,
-					Numerator:   int(val.Numerator),
-					Denominator: int(val.Denominator),
-				}
-				splits = append(splits, split)
-			}
-		}
-	}
-	return splits, nil
+/*
+Splits for symbol from yahoo
+*/
+func FetchStockSplits(symbol string) ([]Split, error) {
+	return (&YahooSplitProvider{}).Splits(symbol)
 }
 
-func GetStockSplitCorrection(symbol string, date string, qty float64, price float64) (float64, float64, error) {
+func GetStockSplitCorrection(splits []Split, date string, qty decimal.Decimal, price decimal.Decimal) (decimal.Decimal, decimal.Decimal) {
 	// returns stock split corrected amount based on present day
-	if _, keyFound := CacheStockSplits[symbol]; !keyFound {
-		stockSplits, err := FetchStockSplits(symbol)
-		if err != nil {
-			return 0.0, 0.0, err
-		}
-		CacheStockSplits[symbol] = stockSplits
-	}
-	qtyMultiplier := 1.0
-	priceMultiplier := 1.0
-	for _, split := range CacheStockSplits[symbol] {
+	// keep the ratio as a fraction so a 1:3 reverse split doesn't pick up a repeating decimal
+	numerator := decimal.NewFromInt(1)
+	denominator := decimal.NewFromInt(1)
+	for _, split := range splits {
 		if BeforeDate(split.Date, date) {
 			if split.Numerator != 1 {
 				// Numerator, so we need to divide cost and multiply count
-				qtyMultiplier *= float64(split.Numerator)
-				priceMultiplier /= float64(split.Numerator)
+				numerator = numerator.Mul(decimal.NewFromInt(int64(split.Numerator)))
 			} else {
 				// Denominator, so we need to multiply cost and divide count
 				// reverse split
-				qtyMultiplier /= float64(split.Denominator)
-				priceMultiplier *= float64(split.Denominator)
+				denominator = denominator.Mul(decimal.NewFromInt(int64(split.Denominator)))
 			}
 		}
 	}
-	return (qty * qtyMultiplier), (price * priceMultiplier), nil
+	correctedQty := qty.Mul(numerator).DivRound(denominator, qtyPlaces)
+	correctedPrice := price.Mul(denominator).DivRound(numerator, pricePlaces)
+	return correctedQty, correctedPrice
 }
 
 func CacheAPICall(cacheFilePath string, dataToEncode interface{}) error {
@@ -0,0 +1,73 @@
+package rhwrapper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsLongTerm(t *testing.T) {
+	tests := []struct {
+		name     string
+		acquired string
+		disposed string
+		longTerm bool
+	}{
+		{name: "exactly one year", acquired: "2022-03-15", disposed: "2023-03-15", longTerm: false},
+		{name: "one year and a day", acquired: "2022-03-15", disposed: "2023-03-16", longTerm: true},
+		{name: "times of day are ignored", acquired: "2022-03-15 20:00:00", disposed: "2023-03-16T09:30:00Z", longTerm: true},
+		{name: "anniversary with times of day", acquired: "2022-03-15 09:00:00", disposed: "2023-03-15 20:00:00", longTerm: false},
+		{name: "over a leap day", acquired: "2023-03-01", disposed: "2024-03-01", longTerm: false},
+		{name: "over a leap day and a day", acquired: "2023-03-01", disposed: "2024-03-02", longTerm: true},
+		{name: "bought Feb 29, anniversary is Feb 28", acquired: "2020-02-29", disposed: "2021-02-28", longTerm: false},
+		{name: "bought Feb 29, sold Mar 1", acquired: "2020-02-29", disposed: "2021-03-01", longTerm: true},
+		{name: "bought Feb 28 of a leap year", acquired: "2020-02-28", disposed: "2021-03-01", longTerm: true},
+		{name: "bought Feb 29, sold Feb 29 four years later", acquired: "2020-02-29", disposed: "2024-02-29", longTerm: true},
+		{name: "a few days", acquired: "2023-01-03", disposed: "2023-01-10", longTerm: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			longTerm, err := IsLongTerm(test.acquired, test.disposed)
+			if err != nil {
+				t.Fatalf("failing to compare holding period. ERR: %v", err)
+			}
+			if longTerm != test.longTerm {
+				t.Errorf("IsLongTerm(%s, %s) = %v, want %v", test.acquired, test.disposed, longTerm, test.longTerm)
+			}
+		})
+	}
+
+	if _, err := IsLongTerm("01/03/2023", "2024-01-03"); err == nil {
+		t.Error("compared a date in an unknown layout")
+	}
+}
+
+func TestParseTradeDate(t *testing.T) {
+	tests := []struct {
+		date string
+		want time.Time
+	}{
+		{date: "2023-06-15T14:30:05Z", want: time.Date(2023, 6, 15, 14, 30, 5, 0, time.UTC)},
+		{date: "2023-06-15T14:30:05.123456Z", want: time.Date(2023, 6, 15, 14, 30, 5, 123456000, time.UTC)},
+		{date: "2023-06-15T14:30:05-04:00", want: time.Date(2023, 6, 15, 18, 30, 5, 0, time.UTC)},
+		{date: "2023-06-15T14:30:05.5", want: time.Date(2023, 6, 15, 14, 30, 5, 500000000, time.UTC)},
+		{date: "2023-06-15 14:30:05", want: time.Date(2023, 6, 15, 14, 30, 5, 0, time.UTC)},
+		{date: "2023-06-15 14:30:05.25", want: time.Date(2023, 6, 15, 14, 30, 5, 250000000, time.UTC)},
+		{date: " 2023-06-15 ", want: time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		got, err := ParseTradeDate(test.date)
+		if err != nil {
+			t.Errorf("failing to parse %q. ERR: %v", test.date, err)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("ParseTradeDate(%q) = %s, want %s", test.date, got, test.want)
+		}
+	}
+
+	for _, date := range []string{"", "06/15/2023", "2023-06-15 14:30"} {
+		if _, err := ParseTradeDate(date); err == nil {
+			t.Errorf("parsed %q", date)
+		}
+	}
+}
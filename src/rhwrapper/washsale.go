@@ -0,0 +1,307 @@
+package rhwrapper
+
+// wash sale detection, losses are deferred into the replacement lot
+
+import (
+	"github.com/go-gota/gota/dataframe"
+	"github.com/go-gota/gota/series"
+	"github.com/shopspring/decimal"
+	"strings"
+)
+
+const washSaleWindowDays = 30
+
+type WashSale struct {
+	Ticker          string
+	SaleDate        string
+	ReplacementDate string
+	Replacement     string // stock or the option tag e.g. "buy call"
+	Qty             decimal.Decimal
+	DisallowedLoss  decimal.Decimal
+	HoldingDays     int // holding period carried over to the replacement
+}
+
+// sold shares of one losing lot, how long that lot was held and the loss on them
+type washPortion struct {
+	qty         decimal.Decimal
+	holdingDays int
+	loss        decimal.Decimal // positive
+}
+
+// loss still waiting for a replacement purchase within 30 days after the sale
+type washCandidate struct {
+	profitIdx int
+	saleDate  string
+	qty       decimal.Decimal
+	portions  []washPortion // sold shares not replaced yet, in the order the sale matched them
+}
+
+/*
+Take qty shares off the front of the candidate's portions. A portion taken in part takes its share of the loss, the
+last shares take whatever cents rounding left over
+*/
+func (c *washCandidate) takePortions(qty decimal.Decimal) []washPortion {
+	taken := []washPortion{}
+	for qty.IsPositive() && len(c.portions) > 0 {
+		portion := c.portions[0]
+		if portion.qty.GreaterThan(qty) {
+			loss := decimal.Min(amountOf(qty, portion.loss.DivRound(portion.qty, pricePlaces)), portion.loss)
+			c.portions[0].qty = portion.qty.Sub(qty)
+			c.portions[0].loss = portion.loss.Sub(loss)
+			portion.qty, portion.loss = qty, loss
+		} else {
+			c.portions = c.portions[1:]
+		}
+		taken = append(taken, portion)
+		qty = qty.Sub(portion.qty)
+	}
+	return taken
+}
+
+// option bought before a loss sale that can still act as the replacement
+type optionReplacement struct {
+	lot  *OptionLot
+	date string
+	qty  decimal.Decimal // share equivalent, 100 per contract
+}
+
+type washSaleTracker struct {
+	candidates  map[string][]*washCandidate
+	options     map[string][]*optionReplacement
+	washedLots  map[*Lot]bool
+	adjustments []WashSale
+}
+
+func newWashSaleTracker() *washSaleTracker {
+	return &washSaleTracker{
+		candidates: make(map[string][]*washCandidate),
+		options:    make(map[string][]*optionReplacement),
+		washedLots: make(map[*Lot]bool),
+	}
+}
+
+func daysBetween(from string, to string) int {
+	fromDay, err := tradeDay(from)
+	if err != nil {
+		return 0
+	}
+	toDay, err := tradeDay(to)
+	if err != nil {
+		return 0
+	}
+	return int(toDay.Sub(fromDay).Hours() / 24)
+}
+
+func withinWashWindow(saleDate string, purchaseDate string) bool {
+	days := daysBetween(saleDate, purchaseDate)
+	return days >= -washSaleWindowDays && days <= washSaleWindowDays
+}
+
+/*
+Start of a replacement lot's holding period, its acquisition date (2006-01-02) moved back by the holding period of
+the sold shares
+*/
+func tackHoldingPeriod(acquired string, holdingDays int) string {
+	t, err := ParseTradeDate(acquired)
+	if err != nil {
+		return acquired
+	}
+	return t.AddDate(0, 0, -holdingDays).Format("2006-01-02")
+}
+
+/*
+Disallow the loss on a portion of the sold shares taken off candidate
+*/
+func (w *washSaleTracker) disallow(profitList []Profit, candidate *washCandidate, portion washPortion) decimal.Decimal {
+	amount := portion.loss
+	candidate.qty = candidate.qty.Sub(portion.qty)
+	profitList[candidate.profitIdx].Amount = profitList[candidate.profitIdx].Amount.Add(amount)
+	profitList[candidate.profitIdx].WashSale = true
+	profitList[candidate.profitIdx].Disallowed = profitList[candidate.profitIdx].Disallowed.Add(amount)
+	return amount
+}
+
+/*
+Apply a disallowed loss to qty shares of a stock lot
+
+Returns the lots replacing the original. The washed shares are split off when only part of the lot replaces the sale,
+and once more for every sold lot with a different holding period. The lot keeps its acquisition date, HoldingFrom
+carries the tacked on holding period
+*/
+func (w *washSaleTracker) washLot(profitList []Profit, ticker string, lot *Lot, candidate *washCandidate, qty decimal.Decimal) []*Lot {
+	lots := []*Lot{lot}
+	for _, portion := range candidate.takePortions(qty) {
+		washed := lot
+		if lot.Qty.GreaterThan(portion.qty) {
+			split := *lot
+			split.Qty = portion.qty
+			lot.Qty = lot.Qty.Sub(portion.qty)
+			washed = &split
+			lots = append(lots, washed)
+		}
+		disallowed := w.disallow(profitList, candidate, portion)
+		washed.UnitCost = washed.UnitCost.Add(disallowed.DivRound(portion.qty, pricePlaces))
+		washed.HoldingFrom = tackHoldingPeriod(holdingStart(washed), portion.holdingDays)
+		w.washedLots[washed] = true
+		w.adjustments = append(w.adjustments, WashSale{
+			Ticker:          ticker,
+			SaleDate:        candidate.saleDate,
+			ReplacementDate: lotDate(washed),
+			Replacement:     "stock",
+			Qty:             portion.qty,
+			DisallowedLoss:  disallowed,
+			HoldingDays:     portion.holdingDays,
+		})
+	}
+	return lots
+}
+
+func (w *washSaleTracker) washOption(profitList []Profit, ticker string, option *optionReplacement, candidate *washCandidate) {
+	for _, portion := range candidate.takePortions(decimal.Min(option.qty, candidate.qty)) {
+		disallowed := w.disallow(profitList, candidate, portion)
+		// booked against the option once it is closed or expires
+		option.lot.Deferred = option.lot.Deferred.Add(disallowed)
+		option.qty = option.qty.Sub(portion.qty)
+		w.adjustments = append(w.adjustments, WashSale{
+			Ticker:          ticker,
+			SaleDate:        candidate.saleDate,
+			ReplacementDate: option.date,
+			Replacement:     option.lot.Tag,
+			Qty:             portion.qty,
+			DisallowedLoss:  disallowed,
+			HoldingDays:     portion.holdingDays,
+		})
+	}
+}
+
+/*
+Record the losing lots of a sale, portions are the sold shares of each lot sold at a loss. Lots sold at a gain in the
+same sale don't offset them, every losing lot can be washed on its own
+
+Lots bought within the 30 days before the sale are washed immediately, whatever loss remains waits for a purchase
+within the 30 days after. Returns the open lots for the ticker after any basis adjustment.
+*/
+func (w *washSaleTracker) recordLoss(profitList []Profit, ticker string, profitIdx int, saleDate string, portions []washPortion, openLots []*Lot, soldLots []LotMatch) []*Lot {
+	candidate := &washCandidate{
+		profitIdx: profitIdx,
+		saleDate:  saleDate,
+		qty:       decimal.Zero,
+		portions:  []washPortion{},
+	}
+	for _, portion := range portions {
+		if portion.qty.IsPositive() && portion.loss.IsPositive() {
+			candidate.qty = candidate.qty.Add(portion.qty)
+			candidate.portions = append(candidate.portions, portion)
+		}
+	}
+	if !candidate.qty.IsPositive() {
+		return openLots
+	}
+	sold := make(map[*Lot]bool)
+	for _, match := range soldLots {
+		sold[match.Lot] = true
+	}
+	lots := []*Lot{}
+	for _, lot := range openLots {
+		if !candidate.qty.IsPositive() || sold[lot] || w.washedLots[lot] || !withinWashWindow(saleDate, lotDate(lot)) || daysBetween(saleDate, lotDate(lot)) > 0 {
+			lots = append(lots, lot)
+			continue
+		}
+		lots = append(lots, w.washLot(profitList, ticker, lot, candidate, decimal.Min(lot.Qty, candidate.qty))...)
+	}
+	for _, option := range w.options[ticker] {
+		if !candidate.qty.IsPositive() {
+			break
+		}
+		if !option.qty.IsPositive() || !option.lot.Qty.IsPositive() || !withinWashWindow(saleDate, option.date) {
+			continue
+		}
+		w.washOption(profitList, ticker, option, candidate)
+	}
+	if candidate.qty.IsPositive() {
+		w.candidates[ticker] = append(w.candidates[ticker], candidate)
+	}
+	return lots
+}
+
+func (w *washSaleTracker) pendingCandidates(ticker string, purchaseDate string) []*washCandidate {
+	pending := []*washCandidate{}
+	for _, candidate := range w.candidates[ticker] {
+		if candidate.qty.IsPositive() && withinWashWindow(candidate.saleDate, purchaseDate) {
+			pending = append(pending, candidate)
+		}
+	}
+	w.candidates[ticker] = pending
+	return pending
+}
+
+/*
+Record a stock purchase, returns the lots to add to the open lots for the ticker
+*/
+func (w *washSaleTracker) stockPurchase(profitList []Profit, ticker string, lot *Lot) []*Lot {
+	lots := []*Lot{lot}
+	for _, candidate := range w.pendingCandidates(ticker, lotDate(lot)) {
+		unwashed := lots[0]
+		washed := w.washLot(profitList, ticker, unwashed, candidate, decimal.Min(unwashed.Qty, candidate.qty))
+		lots = append(lots, washed[1:]...)
+		if w.washedLots[unwashed] {
+			// whole lot was washed
+			break
+		}
+	}
+	return lots
+}
+
+/*
+Record an option purchase on the underlying, options are substantially identical to the stock
+*/
+func (w *washSaleTracker) optionPurchase(profitList []Profit, ticker string, lot *OptionLot) {
+	option := &optionReplacement{
+		lot:  lot,
+		date: strings.Split(lot.OpenedAt, "T")[0],
+		qty:  optionMultiplier.Mul(lot.Qty),
+	}
+	for _, candidate := range w.pendingCandidates(ticker, option.date) {
+		if !option.qty.IsPositive() {
+			break
+		}
+		w.washOption(profitList, ticker, option, candidate)
+	}
+	if option.qty.IsPositive() {
+		w.options[ticker] = append(w.options[ticker], option)
+	}
+}
+
+/*
+convert wash sale adjustments to dataframe
+*/
+func ConvertWashSaleDf(washSales []WashSale) *dataframe.DataFrame {
+	tickers := series.New([]string{}, series.String, "Ticker")
+	saleDates := series.New([]string{}, series.String, "SaleDate")
+	replacementDates := series.New([]string{}, series.String, "ReplacementDate")
+	replacements := series.New([]string{}, series.String, "Replacement")
+	qtys := series.New([]float64{}, series.Float, "Qty")
+	disallowed := series.New([]float64{}, series.Float, "DisallowedLoss")
+	holdingDays := series.New([]int{}, series.Int, "HoldingDays")
+
+	for _, washSale := range washSales {
+		tickers.Append(washSale.Ticker)
+		saleDates.Append(washSale.SaleDate)
+		replacementDates.Append(washSale.ReplacementDate)
+		replacements.Append(washSale.Replacement)
+		qtys.Append(washSale.Qty.InexactFloat64())
+		disallowed.Append(washSale.DisallowedLoss.InexactFloat64())
+		holdingDays.Append(washSale.HoldingDays)
+	}
+
+	df := dataframe.New(
+		tickers,
+		saleDates,
+		replacementDates,
+		replacements,
+		qtys,
+		disallowed,
+		holdingDays,
+	)
+	return &df
+}
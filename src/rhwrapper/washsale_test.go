@@ -0,0 +1,130 @@
+package rhwrapper
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func TestWithinWashWindow(t *testing.T) {
+	tests := []struct {
+		purchase string
+		within   bool
+	}{
+		{purchase: "2023-01-31", within: true},
+		{purchase: "2023-03-02 10:00:00", within: true}, // 30 days after
+		{purchase: "2023-03-03", within: false},
+		{purchase: "2023-01-01T15:00:00Z", within: true}, // 30 days before
+		{purchase: "2022-12-31", within: false},
+	}
+	for _, test := range tests {
+		if within := withinWashWindow("2023-01-31", test.purchase); within != test.within {
+			t.Errorf("withinWashWindow(2023-01-31, %s) = %v, want %v", test.purchase, within, test.within)
+		}
+	}
+}
+
+func TestTackHoldingPeriod(t *testing.T) {
+	if got := tackHoldingPeriod("2023-01-20", 7); got != "2023-01-13" {
+		t.Errorf("tacked %s, want 2023-01-13", got)
+	}
+	if got := tackHoldingPeriod("2023-03-01", 30); got != "2023-01-30" {
+		t.Errorf("tacked %s, want 2023-01-30", got)
+	}
+}
+
+func TestWashSaleTracker(t *testing.T) {
+	tests := []struct {
+		name string
+		// 10 XYZ sold at a 200 loss on 2023-01-10 after holding them 7 days, then the replacement
+		openLots    []*Lot // open when the loss is recorded
+		bought      *Lot   // bought after the loss
+		profit      string // of the loss after the wash sale
+		disallowed  string
+		replacement Lot    // the washed lot, on the day it was bought
+		holdingFrom string // its holding period with the sold shares' tacked on
+	}{
+		{
+			name:        "bought back after the loss",
+			bought:      &Lot{Ticker: "XYZ", Qty: dec("10"), UnitCost: dec("85"), CreatedAt: "2023-01-20 10:00:00"},
+			profit:      "0",
+			disallowed:  "200",
+			replacement: Lot{Ticker: "XYZ", Qty: dec("10"), UnitCost: dec("105"), CreatedAt: "2023-01-20 10:00:00"},
+			holdingFrom: "2023-01-13",
+		},
+		{
+			name:        "part bought back",
+			bought:      &Lot{Ticker: "XYZ", Qty: dec("4"), UnitCost: dec("85"), CreatedAt: "2023-01-20 10:00:00"},
+			profit:      "-120",
+			disallowed:  "80",
+			replacement: Lot{Ticker: "XYZ", Qty: dec("4"), UnitCost: dec("105"), CreatedAt: "2023-01-20 10:00:00"},
+			holdingFrom: "2023-01-13",
+		},
+		{
+			name:        "bought before the loss",
+			openLots:    []*Lot{{Ticker: "XYZ", Qty: dec("5"), UnitCost: dec("90"), CreatedAt: "2023-01-05 10:00:00"}},
+			profit:      "-100",
+			disallowed:  "100",
+			replacement: Lot{Ticker: "XYZ", Qty: dec("5"), UnitCost: dec("110"), CreatedAt: "2023-01-05 10:00:00"},
+			holdingFrom: "2022-12-29",
+		},
+		{
+			name:       "bought back too late",
+			bought:     &Lot{Ticker: "XYZ", Qty: dec("10"), UnitCost: dec("85"), CreatedAt: "2023-02-10 10:00:00"},
+			profit:     "-200",
+			disallowed: "0",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := newWashSaleTracker()
+			profitList := []Profit{{Date: "2023-01-10", Amount: dec("-200"), Ticker: "XYZ"}}
+			lots := tracker.recordLoss(profitList, "XYZ", 0, "2023-01-10", []washPortion{{qty: dec("10"), holdingDays: 7, loss: dec("200")}}, test.openLots, nil)
+			if test.bought != nil {
+				lots = append(lots, tracker.stockPurchase(profitList, "XYZ", test.bought)...)
+			}
+
+			disallowed := dec(test.disallowed)
+			if !profitList[0].Amount.Equal(dec(test.profit)) || !profitList[0].Disallowed.Equal(disallowed) || profitList[0].WashSale != !disallowed.IsZero() {
+				t.Errorf("profit %s disallowed %s wash sale %v, want %s %s", profitList[0].Amount, profitList[0].Disallowed, profitList[0].WashSale, test.profit, test.disallowed)
+			}
+			if disallowed.IsZero() {
+				if len(tracker.adjustments) != 0 {
+					t.Errorf("adjustments %+v, want none", tracker.adjustments)
+				}
+				return
+			}
+			if len(tracker.adjustments) != 1 || !tracker.adjustments[0].DisallowedLoss.Equal(disallowed) || tracker.adjustments[0].HoldingDays != 7 {
+				t.Errorf("adjustments %+v, want one of %v", tracker.adjustments, test.disallowed)
+			}
+			for _, lot := range lots {
+				if tracker.washedLots[lot] {
+					if !lot.Qty.Equal(test.replacement.Qty) || !lot.UnitCost.Equal(test.replacement.UnitCost) || lot.CreatedAt != test.replacement.CreatedAt {
+						t.Errorf("replacement %v x %v from %s, want %v x %v from %s", lot.Qty, lot.UnitCost, lot.CreatedAt, test.replacement.Qty, test.replacement.UnitCost, test.replacement.CreatedAt)
+					}
+					if holdingStart(lot) != test.holdingFrom {
+						t.Errorf("replacement held from %s, want %s", holdingStart(lot), test.holdingFrom)
+					}
+					return
+				}
+			}
+			t.Errorf("no washed lot in %+v", lots)
+		})
+	}
+}
+
+func TestWashSaleTrackerOption(t *testing.T) {
+	tracker := newWashSaleTracker()
+	profitList := []Profit{{Date: "2023-01-10", Amount: dec("-200"), Ticker: "XYZ"}}
+	tracker.recordLoss(profitList, "XYZ", 0, "2023-01-10", []washPortion{{qty: dec("10"), holdingDays: 7, loss: dec("200")}}, nil, nil)
+	call := &OptionLot{Qty: dec("1"), Premium: dec("3"), OpenedAt: "2023-01-15T15:00:00Z", Tag: "buy call"}
+	tracker.optionPurchase(profitList, "XYZ", call)
+
+	if !profitList[0].Amount.IsZero() || !profitList[0].Disallowed.Equal(decimal.NewFromInt(200)) {
+		t.Errorf("loss %s disallowed %s, want 0 200", profitList[0].Amount, profitList[0].Disallowed)
+	}
+	// the call carries the deferred loss until it is closed
+	assertDecimal(t, "call deferred", call.Deferred, "200")
+	if len(tracker.adjustments) != 1 || tracker.adjustments[0].Replacement != "buy call" || !tracker.adjustments[0].Qty.Equal(dec("10")) {
+		t.Errorf("adjustments %+v", tracker.adjustments)
+	}
+}
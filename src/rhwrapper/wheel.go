@@ -0,0 +1,444 @@
+package rhwrapper
+
+// wheel campaigns, the puts sold on a ticker, the shares they were assigned and the covered calls sold until the
+// shares are called away, tracked as one trade
+
+import (
+	"context"
+	models "github.com/Ryang20718/robinhood-client/models"
+	"github.com/go-gota/gota/dataframe"
+	"github.com/go-gota/gota/series"
+	"github.com/shopspring/decimal"
+	"sort"
+)
+
+// days after a put closes or expires a new put still continues its campaign
+const wheelRollDays = 7
+
+// WheelCampaign is one turn of the wheel on a ticker
+type WheelCampaign struct {
+	Ticker     string
+	Start      string // 2006-01-02 the first put was sold
+	End        string // last expiration, close, assignment or sale, empty while open
+	Status     string // open, expired (never assigned), called away or sold
+	Puts       int    // put legs sold
+	Calls      int    // covered call legs sold
+	Premium    decimal.Decimal
+	Shares     decimal.Decimal // assigned, split adjusted to today
+	StockCost  decimal.Decimal // paid for the assigned shares at the strike
+	NetBasis   decimal.Decimal // per share, StockCost less Premium over Shares. Zero when nothing was assigned
+	Proceeds   decimal.Decimal // shares called away or sold
+	Held       decimal.Decimal // shares still held
+	Collateral decimal.Decimal // most cash tied up at once, in strikes of open puts and cost of held shares
+	Profit     decimal.Decimal // Premium and Proceeds less the cost of the shares gone, held shares aren't marked to market
+	DaysHeld   int             // Start to End, or to asOf while open
+	Annualized decimal.Decimal // percent a year, Profit over Collateral scaled by DaysHeld without compounding
+}
+
+// option leg or stock sale of a ticker, split adjusted
+type wheelEvent struct {
+	date     string
+	option   *models.OptionTransaction // nil for stock sales
+	contract OptionContract
+	qty      decimal.Decimal  // contracts, or shares sold
+	price    decimal.Decimal  // premium per share, or sale price
+	assigned []assignmentPart // of an assigned leg
+}
+
+// campaign state of a ticker while its trades are walked
+type wheelTracker struct {
+	ledger      *OptionLedger // short legs of the open campaign
+	assignments []optionAssignment
+	campaign    *WheelCampaign
+	campaigns   []WheelCampaign
+	disposed    decimal.Decimal // cost of the shares called away or sold
+	exit        string          // how the last shares left, called away or sold
+	pendingPuts decimal.Decimal // strikes of puts waiting to be assigned
+}
+
+/*
+Fetch trades from source and track wheel campaigns through asOf (2006-01-02)
+*/
+func ProcessWheelCampaigns(ctx context.Context, source TransactionSource, asOf string) ([]WheelCampaign, error) {
+	stockMap, err := source.FetchRegularTrades(ctx)
+	if err != nil {
+		return nil, err
+	}
+	optionMap, err := source.FetchOptionTrades(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return CalculateWheelCampaigns(source, stockMap, optionMap, asOf)
+}
+
+/*
+Link the puts sold on a ticker, the shares they're assigned, the covered calls sold on them and the call-away into
+campaigns, source resolves symbol changes and splits
+
+A campaign starts with a sold put. Puts sold within wheelRollDays of the last one closing or expiring stay in it, one
+that's never assigned ends as expired once that long passes without a new put. After an assignment it ends when the
+shares are called away or sold and no short options or assignments are left. Premium is what the puts and calls were sold for
+less what was paid to buy them back, including the premium of assigned legs the realized earnings fold into the
+stock's basis. Calls sold and shares sold with no campaign open on the ticker, and bought options, aren't part of one.
+Shares bought outright don't join a campaign, sales come out of the campaign's shares first
+*/
+func CalculateWheelCampaigns(source TransactionSource, stockMap map[string][]models.Transaction, optionMap map[string][]models.OptionTransaction, asOf string) ([]WheelCampaign, error) {
+	events := make(map[string][]wheelEvent)
+	optionEvents, err := source.FetchOptionEvents()
+	if err != nil {
+		return nil, err
+	}
+	assignmentDates := newAssignmentDates(optionEvents)
+
+	optionList := []models.OptionTransaction{}
+	for _, options := range optionMap {
+		optionList = append(optionList, options...)
+	}
+	sort.SliceStable(optionList, func(i, j int) bool {
+		return optionList[i].CreatedAt < optionList[j].CreatedAt
+	})
+	for _, option := range optionList {
+		if option.TransactionType != "STO" && option.TransactionType != "BTC" {
+			continue
+		}
+		date := tradeDate(option.CreatedAt)
+		ticker, err := source.FetchCurrentTickerSymbol(option.Ticker, date)
+		if err != nil {
+			return nil, err
+		}
+		splits, err := source.FetchStockSplits(ticker)
+		if err != nil {
+			return nil, err
+		}
+		// same adjustment as the realized earnings, so contracts match up across a split
+		traded := option
+		originalQty := QtyFromFloat(option.Qty)
+		qty, price := GetStockSplitCorrection(splits, date, originalQty, PriceFromFloat(option.UnitCost))
+		if !qty.IsZero() {
+			option.StrikePrice = PriceFromFloat(option.StrikePrice).Mul(originalQty).DivRound(qty, pricePlaces).InexactFloat64()
+		}
+		option.Qty = qty.InexactFloat64()
+		option.UnitCost = price.InexactFloat64()
+		leg := option
+		event := wheelEvent{date: date, option: &leg, contract: ContractFor(ticker, option), qty: qty, price: price}
+		if option.Status == "Assigned" {
+			event.assigned = assignmentDates.take(traded, qty)
+		}
+		events[ticker] = append(events[ticker], event)
+	}
+
+	stockList := []models.Transaction{}
+	for _, stocks := range stockMap {
+		stockList = append(stockList, stocks...)
+	}
+	sort.SliceStable(stockList, func(i, j int) bool {
+		return stockList[i].CreatedAt < stockList[j].CreatedAt
+	})
+	for _, stock := range stockList {
+		if stock.TransactionType != "sell" {
+			continue
+		}
+		date := tradeDate(stock.CreatedAt)
+		ticker, err := source.FetchCurrentTickerSymbol(stock.Ticker, date)
+		if err != nil {
+			return nil, err
+		}
+		// only tickers with options can have a campaign
+		if _, ok := events[ticker]; !ok {
+			continue
+		}
+		splits, err := source.FetchStockSplits(ticker)
+		if err != nil {
+			return nil, err
+		}
+		qty, price := GetStockSplitCorrection(splits, date, QtyFromFloat(stock.Qty), PriceFromFloat(stock.UnitCost))
+		events[ticker] = append(events[ticker], wheelEvent{date: date, qty: qty, price: price})
+	}
+
+	tickers := []string{}
+	for ticker := range events {
+		tickers = append(tickers, ticker)
+	}
+	sort.Strings(tickers)
+	campaigns := []WheelCampaign{}
+	for _, ticker := range tickers {
+		// options go first on a day, a call is sold before the shares it covers are
+		sort.SliceStable(events[ticker], func(i, j int) bool {
+			return events[ticker][i].date < events[ticker][j].date
+		})
+		tracker := &wheelTracker{ledger: NewOptionLedger()}
+		for _, event := range events[ticker] {
+			if err := tracker.settle(event.date); err != nil {
+				return nil, err
+			}
+			if err := tracker.apply(ticker, event); err != nil {
+				return nil, err
+			}
+			tracker.updateCollateral()
+		}
+		if err := tracker.settle(asOf); err != nil {
+			return nil, err
+		}
+		// apply the assignments dated asOf, later ones keep the campaign open
+		pending := []optionAssignment{}
+		for _, assignment := range tracker.assignments {
+			if assignment.date > asOf {
+				pending = append(pending, assignment)
+				continue
+			}
+			tracker.assign(assignment)
+		}
+		tracker.assignments = pending
+		tracker.finish()
+		if tracker.campaign != nil {
+			tracker.campaign.DaysHeld = daysBetween(tracker.campaign.Start, asOf)
+			tracker.close()
+		}
+		campaigns = append(campaigns, tracker.campaigns...)
+	}
+	sort.SliceStable(campaigns, func(i, j int) bool {
+		return campaigns[i].Start < campaigns[j].Start
+	})
+	return campaigns, nil
+}
+
+/*
+Expire the short legs that expired and apply the assignments dated before date, then end the campaign if nothing is
+left in it
+*/
+func (t *wheelTracker) settle(date string) error {
+	expired, err := t.ledger.Expire(date)
+	if err != nil {
+		return err
+	}
+	for _, closed := range expired {
+		t.touch(closed.ClosedAt)
+	}
+	sort.SliceStable(t.assignments, func(i, j int) bool {
+		return t.assignments[i].date < t.assignments[j].date
+	})
+	pending := []optionAssignment{}
+	for _, assignment := range t.assignments {
+		if assignment.date >= date {
+			pending = append(pending, assignment)
+			continue
+		}
+		t.assign(assignment)
+	}
+	t.assignments = pending
+	t.updateCollateral()
+	// puts are sold again after the last one expires, until one is assigned
+	if t.campaign != nil && t.campaign.End < date && (t.campaign.Shares.IsPositive() || daysBetween(t.campaign.End, date) > wheelRollDays) {
+		t.finish()
+	}
+	return nil
+}
+
+func (t *wheelTracker) apply(ticker string, event wheelEvent) error {
+	if event.option == nil {
+		if t.campaign != nil && t.campaign.Held.IsPositive() {
+			t.dispose(decimal.Min(event.qty, t.campaign.Held), event.price, event.date, "sold")
+		}
+		return nil
+	}
+	option := *event.option
+	if option.TransactionType == "BTC" {
+		if t.campaign == nil {
+			return nil
+		}
+		closes, _, err := t.ledger.Close(event.contract, option, event.qty, event.price, FIFO, nil)
+		if err != nil {
+			return err
+		}
+		for _, closed := range closes {
+			// a short close's basis is what was paid to buy it back
+			t.campaign.Premium = t.campaign.Premium.Sub(closed.Basis)
+		}
+		t.touch(event.date)
+		return nil
+	}
+
+	if t.campaign == nil {
+		if event.contract.Type != "put" {
+			return nil
+		}
+		t.campaign = &WheelCampaign{Ticker: ticker, Start: event.date, Status: "open"}
+	}
+	if event.contract.Type == "put" {
+		t.campaign.Puts += 1
+	} else {
+		t.campaign.Calls += 1
+	}
+	t.campaign.Premium = t.campaign.Premium.Add(amountOf(event.qty.Mul(optionMultiplier), event.price))
+	t.touch(event.date)
+	if option.Status == "Assigned" {
+		// shares change hands when the option is assigned, not when it was opened
+		for _, part := range event.assigned {
+			assigned := option
+			assigned.Qty = part.qty.InexactFloat64()
+			t.assignments = append(t.assignments, optionAssignment{ticker: ticker, contract: event.contract, option: assigned, date: part.date})
+		}
+		if event.contract.Type == "put" {
+			t.pendingPuts = t.pendingPuts.Add(amountOf(event.qty.Mul(optionMultiplier), PriceFromFloat(event.contract.Strike)))
+		}
+		return nil
+	}
+	t.ledger.Open(event.contract, option, event.qty, event.price)
+	return nil
+}
+
+// shares put to the campaign at the strike, or called away from it
+func (t *wheelTracker) assign(assignment optionAssignment) {
+	shares := QtyFromFloat(assignment.option.Qty).Mul(optionMultiplier)
+	strike := PriceFromFloat(assignment.contract.Strike)
+	t.touch(assignment.date)
+	if assignment.contract.Type == "put" {
+		cost := amountOf(shares, strike)
+		t.pendingPuts = t.pendingPuts.Sub(cost)
+		t.campaign.Shares = t.campaign.Shares.Add(shares)
+		t.campaign.Held = t.campaign.Held.Add(shares)
+		t.campaign.StockCost = t.campaign.StockCost.Add(cost)
+		t.updateCollateral()
+		return
+	}
+	// calls written on shares bought outright only take the campaign's shares
+	t.dispose(decimal.Min(shares, t.campaign.Held), strike, assignment.date, "called away")
+}
+
+// shares leaving the campaign at price, their cost is the average of the assigned shares
+func (t *wheelTracker) dispose(qty decimal.Decimal, price decimal.Decimal, date string, status string) {
+	if !qty.IsPositive() {
+		return
+	}
+	t.updateCollateral()
+	t.disposed = t.disposed.Add(Cents(t.campaign.StockCost.Mul(qty).DivRound(t.campaign.Shares, pricePlaces)))
+	t.campaign.Proceeds = t.campaign.Proceeds.Add(amountOf(qty, price))
+	t.campaign.Held = t.campaign.Held.Sub(qty)
+	if t.campaign.Held.IsZero() {
+		t.exit = status
+	}
+	t.touch(date)
+}
+
+// date (or RFC3339 created at) something happened in the campaign
+func (t *wheelTracker) touch(date string) {
+	date = tradeDate(date)
+	if t.campaign != nil && date > t.campaign.End {
+		t.campaign.End = date
+	}
+}
+
+func (t *wheelTracker) updateCollateral() {
+	if t.campaign == nil {
+		return
+	}
+	collateral := t.pendingPuts
+	for _, lot := range t.ledger.OpenLots() {
+		if lot.Short && lot.Contract.Type == "put" {
+			collateral = collateral.Add(amountOf(lot.Qty.Mul(optionMultiplier), PriceFromFloat(lot.Contract.Strike)))
+		}
+	}
+	if t.campaign.Held.IsPositive() {
+		collateral = collateral.Add(Cents(t.campaign.StockCost.Mul(t.campaign.Held).DivRound(t.campaign.Shares, pricePlaces)))
+	}
+	if collateral.GreaterThan(t.campaign.Collateral) {
+		t.campaign.Collateral = collateral
+	}
+}
+
+// end the campaign once it holds nothing and has nothing to come
+func (t *wheelTracker) finish() {
+	if t.campaign == nil || t.campaign.Held.IsPositive() || len(t.assignments) > 0 || len(t.ledger.OpenLots()) > 0 {
+		return
+	}
+	t.campaign.Status = t.exit
+	if t.campaign.Shares.IsZero() {
+		t.campaign.Status = "expired"
+	}
+	t.campaign.DaysHeld = daysBetween(t.campaign.Start, t.campaign.End)
+	t.close()
+}
+
+// work out the totals of the campaign and start over
+func (t *wheelTracker) close() {
+	campaign := t.campaign
+	if campaign.Status == "open" {
+		campaign.End = ""
+	}
+	campaign.Premium = Cents(campaign.Premium)
+	if campaign.Shares.IsPositive() {
+		campaign.NetBasis = campaign.StockCost.Sub(campaign.Premium).DivRound(campaign.Shares, moneyPlaces)
+	}
+	campaign.Profit = Cents(campaign.Premium.Add(campaign.Proceeds).Sub(t.disposed))
+	if campaign.Collateral.IsPositive() {
+		// a campaign over within the day counts as one day
+		days := campaign.DaysHeld
+		if days < 1 {
+			days = 1
+		}
+		campaign.Annualized = percentFromRate(campaign.Profit.Div(campaign.Collateral).InexactFloat64() * 365 / float64(days))
+	}
+	t.campaigns = append(t.campaigns, *campaign)
+	t.campaign = nil
+	t.disposed = decimal.Zero
+	t.pendingPuts = decimal.Zero
+	t.exit = ""
+}
+
+/*
+convert wheel campaigns to dataframe
+*/
+func ConvertWheelCampaignDf(campaigns []WheelCampaign) *dataframe.DataFrame {
+	tickers := series.New([]string{}, series.String, "Ticker")
+	starts := series.New([]string{}, series.String, "Start")
+	ends := series.New([]string{}, series.String, "End")
+	statuses := series.New([]string{}, series.String, "Status")
+	puts := series.New([]int{}, series.Int, "Puts")
+	calls := series.New([]int{}, series.Int, "Calls")
+	premiums := series.New([]float64{}, series.Float, "Premium")
+	shares := series.New([]float64{}, series.Float, "Shares")
+	netBasis := series.New([]float64{}, series.Float, "NetBasis")
+	proceeds := series.New([]float64{}, series.Float, "Proceeds")
+	held := series.New([]float64{}, series.Float, "Held")
+	collateral := series.New([]float64{}, series.Float, "Collateral")
+	profits := series.New([]float64{}, series.Float, "Profit")
+	days := series.New([]int{}, series.Int, "DaysHeld")
+	annualized := series.New([]float64{}, series.Float, "Annualized")
+
+	for _, campaign := range campaigns {
+		tickers.Append(campaign.Ticker)
+		starts.Append(campaign.Start)
+		ends.Append(campaign.End)
+		statuses.Append(campaign.Status)
+		puts.Append(campaign.Puts)
+		calls.Append(campaign.Calls)
+		premiums.Append(campaign.Premium.InexactFloat64())
+		shares.Append(campaign.Shares.InexactFloat64())
+		netBasis.Append(campaign.NetBasis.InexactFloat64())
+		proceeds.Append(campaign.Proceeds.InexactFloat64())
+		held.Append(campaign.Held.InexactFloat64())
+		collateral.Append(campaign.Collateral.InexactFloat64())
+		profits.Append(campaign.Profit.InexactFloat64())
+		days.Append(campaign.DaysHeld)
+		annualized.Append(campaign.Annualized.InexactFloat64())
+	}
+
+	df := dataframe.New(
+		tickers,
+		starts,
+		ends,
+		statuses,
+		puts,
+		calls,
+		premiums,
+		shares,
+		netBasis,
+		proceeds,
+		held,
+		collateral,
+		profits,
+		days,
+		annualized,
+	)
+	return &df
+}
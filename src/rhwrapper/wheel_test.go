@@ -0,0 +1,119 @@
+package rhwrapper
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// campaign the way a test expects it
+type wantCampaign struct {
+	start      string
+	end        string
+	status     string
+	puts       int
+	calls      int
+	daysHeld   int
+	premium    string
+	shares     string
+	stockCost  string
+	netBasis   string
+	proceeds   string
+	held       string
+	collateral string
+	profit     string
+	annualized string
+}
+
+func TestProcessWheelCampaigns(t *testing.T) {
+	tests := []struct {
+		name      string
+		script    func(source *FakeSource)
+		asOf      string
+		campaigns []wantCampaign
+	}{
+		{
+			name: "put expires, then a put is assigned and called away",
+			script: func(source *FakeSource) {
+				source.AddOptionTrade("XYZ", "STO", "put", 1, 50, 2, "2023-01-03T15:00:00Z", "2023-01-20", "Expired")
+				source.AddOptionTrade("XYZ", "STO", "put", 1, 45, 1.5, "2023-02-01T15:00:00Z", "2023-02-17", "Assigned")
+				source.AddOptionTrade("XYZ", "STO", "call", 1, 50, 1, "2023-02-21T15:00:00Z", "2023-03-17", "Assigned")
+			},
+			asOf: "2023-12-31",
+			campaigns: []wantCampaign{
+				{start: "2023-01-03", end: "2023-01-20", status: "expired", puts: 1, daysHeld: 17, premium: "200", shares: "0", stockCost: "0", netBasis: "0",
+					proceeds: "0", held: "0", collateral: "5000", profit: "200", annualized: "85.88"},
+				{start: "2023-02-01", end: "2023-03-17", status: "called away", puts: 1, calls: 1, daysHeld: 44, premium: "250", shares: "100", stockCost: "4500", netBasis: "42.5",
+					proceeds: "5000", held: "0", collateral: "4500", profit: "750", annualized: "138.26"},
+			},
+		},
+		{
+			name: "put rolled",
+			script: func(source *FakeSource) {
+				source.AddOptionTrade("XYZ", "STO", "put", 1, 50, 2, "2023-01-03T15:00:00Z", "2023-01-20", "Open")
+				source.AddOptionTrade("XYZ", "BTC", "put", 1, 50, 3, "2023-01-18T15:00:00Z", "2023-01-20", "Open")
+				source.AddOptionTrade("XYZ", "STO", "put", 1, 48, 2.5, "2023-01-19T15:00:00Z", "2023-02-17", "Expired")
+			},
+			asOf: "2023-12-31",
+			campaigns: []wantCampaign{
+				{start: "2023-01-03", end: "2023-02-17", status: "expired", puts: 2, daysHeld: 45, premium: "150", shares: "0", stockCost: "0", netBasis: "0",
+					proceeds: "0", held: "0", collateral: "5000", profit: "150", annualized: "24.33"},
+			},
+		},
+		{
+			name: "assigned shares still held",
+			script: func(source *FakeSource) {
+				source.AddOptionTrade("XYZ", "STO", "put", 1, 45, 1.5, "2023-02-01T15:00:00Z", "2023-02-17", "Assigned")
+			},
+			asOf: "2023-03-01",
+			campaigns: []wantCampaign{
+				{start: "2023-02-01", end: "", status: "open", puts: 1, daysHeld: 28, premium: "150", shares: "100", stockCost: "4500", netBasis: "43.5",
+					proceeds: "0", held: "100", collateral: "4500", profit: "150", annualized: "43.45"},
+			},
+		},
+		{
+			name: "assignment after asOf",
+			script: func(source *FakeSource) {
+				source.AddOptionTrade("XYZ", "STO", "put", 1, 45, 1.5, "2023-02-01T15:00:00Z", "2023-02-17", "Assigned")
+			},
+			asOf: "2023-02-10",
+			campaigns: []wantCampaign{
+				{start: "2023-02-01", end: "", status: "open", puts: 1, daysHeld: 9, premium: "150", shares: "0", stockCost: "0", netBasis: "0",
+					proceeds: "0", held: "0", collateral: "4500", profit: "150", annualized: "135.19"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := NewFakeSource()
+			test.script(source)
+
+			campaigns, err := ProcessWheelCampaigns(context.Background(), source, test.asOf)
+			if err != nil {
+				t.Fatalf("failing to process wheel campaigns. ERR: %v", err)
+			}
+			if len(campaigns) != len(test.campaigns) {
+				t.Fatalf("got %d campaigns, want %d: %+v", len(campaigns), len(test.campaigns), campaigns)
+			}
+			for i, want := range test.campaigns {
+				got := campaigns[i]
+				name := fmt.Sprintf("campaign %d", i)
+				if got.Ticker != "XYZ" || got.Start != want.start || got.End != want.end || got.Status != want.status {
+					t.Errorf("%s %s %s to %s %s, want XYZ %s to %s %s", name, got.Ticker, got.Start, got.End, got.Status, want.start, want.end, want.status)
+				}
+				if got.Puts != want.puts || got.Calls != want.calls || got.DaysHeld != want.daysHeld {
+					t.Errorf("%s %d puts %d calls %d days, want %d %d %d", name, got.Puts, got.Calls, got.DaysHeld, want.puts, want.calls, want.daysHeld)
+				}
+				assertDecimal(t, name+" premium", got.Premium, want.premium)
+				assertDecimal(t, name+" shares", got.Shares, want.shares)
+				assertDecimal(t, name+" stock cost", got.StockCost, want.stockCost)
+				assertDecimal(t, name+" net basis", got.NetBasis, want.netBasis)
+				assertDecimal(t, name+" proceeds", got.Proceeds, want.proceeds)
+				assertDecimal(t, name+" held", got.Held, want.held)
+				assertDecimal(t, name+" collateral", got.Collateral, want.collateral)
+				assertDecimal(t, name+" profit", got.Profit, want.profit)
+				assertDecimal(t, name+" annualized", got.Annualized, want.annualized)
+			}
+		})
+	}
+}